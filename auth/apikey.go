@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// API keys have the form ak_<prefix>_<secret>. The prefix is stored in clear
+// to look the key up; only the SHA-256 hash of the whole key is persisted.
+const (
+	apiKeyScheme      = "ak"
+	apiKeyPrefixBytes = 6
+)
+
+// NewAPIKey generates a key and returns it with its lookup prefix.
+func NewAPIKey() (string, string, error) {
+	buf := make([]byte, apiKeyPrefixBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("generate api key prefix: %w", err)
+	}
+	prefix := hex.EncodeToString(buf)
+
+	secret, err := NewOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	return apiKeyScheme + "_" + prefix + "_" + secret, prefix, nil
+}
+
+// ParseAPIKey extracts the lookup prefix of a well-formed key.
+func ParseAPIKey(key string) (string, bool) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyScheme || len(parts[1]) != 2*apiKeyPrefixBytes || parts[2] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// APIKeySubject is the principal recorded in context for requests made with an API key.
+func APIKeySubject(id string) string {
+	return "apikey:" + id
+}
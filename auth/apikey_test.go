@@ -0,0 +1,20 @@
+package auth
+
+import "testing"
+
+func TestAPIKeyRoundTrip(t *testing.T) {
+	key, prefix, err := NewAPIKey()
+	if err != nil {
+		t.Fatalf("new api key: %v", err)
+	}
+	parsed, ok := ParseAPIKey(key)
+	if !ok || parsed != prefix {
+		t.Fatalf("expected prefix %s, got %s %v", prefix, parsed, ok)
+	}
+
+	for _, bad := range []string{"", "ak_", "ak_" + prefix, "xx_" + prefix + "_secret", "ak_short_secret"} {
+		if _, ok := ParseAPIKey(bad); ok {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
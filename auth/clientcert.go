@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"liangxiong/demo/internal/config"
+)
+
+// ClientCertSubject is the principal of a service authenticated by client certificate.
+func ClientCertSubject(service string) string {
+	return "cert:" + service
+}
+
+// ClientCertMapper resolves verified client certificates to configured identities.
+type ClientCertMapper struct {
+	rules []config.ClientIdentityConfig
+}
+
+// NewClientCertMapper validates the service scopes of the rules.
+func NewClientCertMapper(rules []config.ClientIdentityConfig) (*ClientCertMapper, error) {
+	for _, rule := range rules {
+		for _, scope := range rule.Scopes {
+			if !IsValidPermission(scope) {
+				return nil, fmt.Errorf("client identity %q: unknown scope %q", rule.Service, scope)
+			}
+		}
+	}
+	return &ClientCertMapper{rules: rules}, nil
+}
+
+// Match returns the first rule matching the certificate.
+func (m *ClientCertMapper) Match(cert *x509.Certificate) (config.ClientIdentityConfig, bool) {
+	subject := cert.Subject.String()
+	for _, rule := range m.rules {
+		if rule.Subject != "" && !strings.EqualFold(rule.Subject, subject) {
+			continue
+		}
+		if rule.SAN != "" && !hasSAN(cert, rule.SAN) {
+			continue
+		}
+		return rule, true
+	}
+	return config.ClientIdentityConfig{}, false
+}
+
+// Service returns the first rule authenticating as the named service. A nil
+// mapper maps nothing.
+func (m *ClientCertMapper) Service(name string) (config.ClientIdentityConfig, bool) {
+	if m == nil {
+		return config.ClientIdentityConfig{}, false
+	}
+	for _, rule := range m.rules {
+		if rule.Service != "" && rule.Service == name {
+			return rule, true
+		}
+	}
+	return config.ClientIdentityConfig{}, false
+}
+
+func hasSAN(cert *x509.Certificate, san string) bool {
+	for _, name := range cert.DNSNames {
+		if strings.EqualFold(name, san) {
+			return true
+		}
+	}
+	for _, email := range cert.EmailAddresses {
+		if strings.EqualFold(email, san) {
+			return true
+		}
+	}
+	for _, uri := range cert.URIs {
+		if uri.String() == san {
+			return true
+		}
+	}
+	for _, ip := range cert.IPAddresses {
+		if ip.String() == san {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+
+	"liangxiong/demo/internal/config"
+)
+
+func TestClientCertMapperMatch(t *testing.T) {
+	mapper, err := NewClientCertMapper([]config.ClientIdentityConfig{
+		{Subject: "CN=exporter,O=DA", SAN: "spiffe://da/exporter", Service: "exporter", Scopes: []string{PermExchangesRead}},
+		{SAN: "ops.example.org", Username: "ops"},
+	})
+	if err != nil {
+		t.Fatalf("new mapper: %v", err)
+	}
+
+	spiffe, _ := url.Parse("spiffe://da/exporter")
+	cases := []struct {
+		cert  *x509.Certificate
+		match string
+	}{
+		{&x509.Certificate{Subject: pkix.Name{CommonName: "exporter", Organization: []string{"DA"}}, URIs: []*url.URL{spiffe}}, "exporter"},
+		// Both subject and SAN must match when both are configured.
+		{&x509.Certificate{Subject: pkix.Name{CommonName: "exporter", Organization: []string{"DA"}}}, ""},
+		{&x509.Certificate{Subject: pkix.Name{CommonName: "anything"}, DNSNames: []string{"OPS.example.org"}}, "ops"},
+		{&x509.Certificate{Subject: pkix.Name{CommonName: "ops.example.org"}}, ""},
+	}
+	for i, tc := range cases {
+		rule, ok := mapper.Match(tc.cert)
+		if got := rule.Service + rule.Username; ok != (tc.match != "") || got != tc.match {
+			t.Fatalf("case %d: expected %q, got %q (%v)", i, tc.match, got, ok)
+		}
+	}
+
+	if rule, ok := mapper.Service("exporter"); !ok || rule.Scopes[0] != PermExchangesRead {
+		t.Fatalf("expected the exporter rule, got %+v (%v)", rule, ok)
+	}
+	if _, ok := mapper.Service("ops"); ok {
+		t.Fatalf("expected user rules not to match a service name")
+	}
+
+	if _, err := NewClientCertMapper([]config.ClientIdentityConfig{{Service: "x", Scopes: []string{"everything"}}}); err == nil {
+		t.Fatalf("expected unknown scope to be rejected")
+	}
+}
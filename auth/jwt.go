@@ -1,90 +1,240 @@
 package auth
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 
 	"liangxiong/demo/internal/config"
+	"liangxiong/demo/utils"
 )
 
-// JWTManager encapsulates signing and verification logic.
+// defaultKeyID names the key built from the legacy single-key settings.
+const defaultKeyID = "default"
+
+// Token purposes. Access tokens carry no purpose.
+const (
+	// PurposeMFAChallenge marks tokens that only prove the first login factor.
+	PurposeMFAChallenge = "mfa_challenge"
+	// PurposeOIDCState marks the state parameter of an OIDC authorization request.
+	PurposeOIDCState = "oidc_state"
+)
+
+// JWTManager encapsulates signing and verification logic over a key ring.
 type JWTManager struct {
 	issuer   string
 	audience string
 	ttl      time.Duration
-	method   jwt.SigningMethod
-	secret   []byte
-	privKey  *rsa.PrivateKey
-	pubKey   *rsa.PublicKey
+	keys     map[string]*signingKey
+	active   *signingKey
+}
+
+// signingKey is one key ring entry. signKey is nil for verification-only keys.
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// Claims are the JWT claims issued by the API.
+// Purpose is empty for access tokens. MFAPending marks access tokens of users
+// who must still enrol in MFA; they are limited to self-service endpoints.
+// Generation is the subject's token revocation generation at issue time.
+type Claims struct {
+	Role       string `json:"role,omitempty"`
+	Purpose    string `json:"purpose,omitempty"`
+	MFAPending bool   `json:"mfa_pending,omitempty"`
+	Generation int64  `json:"gen,omitempty"`
+	Nonce      string `json:"nonce,omitempty"`
+	Binding    string `json:"bnd,omitempty"`
+	Actor      *Actor `json:"act,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Actor names the party acting on behalf of the token subject (RFC 8693
+// "act" claim). It is set on impersonation tokens only.
+// Generation is the actor's token revocation generation at issue time.
+type Actor struct {
+	Subject    string `json:"sub"`
+	Generation int64  `json:"gen,omitempty"`
+}
+
+// Identity describes the subject of an access token.
+type Identity struct {
+	UserID     string
+	Role       string
+	MFAPending bool
+	Generation int64
 }
 
 // NewJWTManager builds a manager using the provided configuration.
+// When auth.keys is empty the legacy single-key settings form a ring with
+// one key identified as "default".
 func NewJWTManager(cfg config.AuthConfig) (*JWTManager, error) {
 	manager := &JWTManager{
 		issuer:   cfg.Issuer,
 		audience: cfg.Audience,
 		ttl:      cfg.AccessTokenTTL,
+		keys:     make(map[string]*signingKey),
 	}
 
-	switch strings.ToUpper(cfg.Algorithm) {
-	case "HS256":
-		manager.method = jwt.SigningMethodHS256
-		manager.secret = []byte(cfg.JWTSecret)
-	case "RS256":
-		manager.method = jwt.SigningMethodRS256
-		priv, err := readPrivateKey(cfg.PrivateKeyPath)
-		if err != nil {
-			return nil, err
+	keyConfigs := cfg.Keys
+	activeID := cfg.ActiveKeyID
+	if len(keyConfigs) == 0 {
+		keyConfigs = []config.KeyConfig{{
+			ID:             defaultKeyID,
+			Algorithm:      cfg.Algorithm,
+			Secret:         cfg.JWTSecret,
+			PrivateKeyPath: cfg.PrivateKeyPath,
+			PublicKeyPath:  cfg.PublicKeyPath,
+		}}
+		activeID = defaultKeyID
+	}
+
+	for _, kc := range keyConfigs {
+		if kc.Algorithm == "" {
+			kc.Algorithm = cfg.Algorithm
 		}
-		pub, err := readPublicKey(cfg.PublicKeyPath)
+		key, err := loadSigningKey(kc)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("load key %q: %w", kc.ID, err)
 		}
-		manager.privKey = priv
-		manager.pubKey = pub
-	default:
-		return nil, fmt.Errorf("unsupported algorithm %s", cfg.Algorithm)
+		if _, exists := manager.keys[key.id]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", key.id)
+		}
+		manager.keys[key.id] = key
 	}
 
+	active, ok := manager.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("active key %q not found", activeID)
+	}
+	if active.signKey == nil {
+		return nil, fmt.Errorf("active key %q has no private key", activeID)
+	}
+	manager.active = active
+
 	return manager, nil
 }
 
-// Generate issues a signed JWT for the subject.
-func (m *JWTManager) Generate(userID string) (string, time.Time, error) {
+// Generate issues a signed access token for the identity.
+func (m *JWTManager) Generate(identity Identity) (string, time.Time, error) {
+	return m.sign(Claims{Role: NormalizeRole(identity.Role), MFAPending: identity.MFAPending, Generation: identity.Generation}, identity.UserID, m.ttl)
+}
+
+// GenerateImpersonation issues a non-refreshable access token for the
+// identity on behalf of actor. jti identifies the token in the audit trail.
+func (m *JWTManager) GenerateImpersonation(identity Identity, actor Actor, jti string, ttl time.Duration) (string, time.Time, error) {
+	claims := Claims{Role: NormalizeRole(identity.Role), Generation: identity.Generation, Actor: &actor}
+	claims.ID = jti
+	return m.sign(claims, identity.UserID, ttl)
+}
+
+// GenerateChallenge issues a short-lived token proving the password step of
+// a login that still requires a second factor. It is not an access token.
+// generation is the user's token revocation generation.
+func (m *JWTManager) GenerateChallenge(userID string, generation int64, ttl time.Duration) (string, time.Time, error) {
+	return m.sign(Claims{Purpose: PurposeMFAChallenge, Generation: generation}, userID, ttl)
+}
+
+// Validate parses an access token and returns claims if valid.
+// The verification key is selected by the kid header; tokens without kid
+// are verified with the active key.
+func (m *JWTManager) Validate(tokenString string) (*Claims, error) {
+	claims, err := m.parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, fmt.Errorf("unexpected token purpose %s", claims.Purpose)
+	}
+	return claims, nil
+}
+
+// ValidateChallenge parses an MFA challenge token.
+func (m *JWTManager) ValidateChallenge(tokenString string) (*Claims, error) {
+	return m.validatePurpose(tokenString, PurposeMFAChallenge)
+}
+
+// GenerateState issues the signed state of an OIDC authorization request,
+// binding the provider name, the nonce and the hash of a secret held by the
+// client that started the flow, so the API needs no session storage.
+func (m *JWTManager) GenerateState(provider, nonce, bindingHash string, ttl time.Duration) (string, time.Time, error) {
+	return m.sign(Claims{Purpose: PurposeOIDCState, Nonce: nonce, Binding: bindingHash}, provider, ttl)
+}
+
+// ValidateState parses an OIDC state token.
+func (m *JWTManager) ValidateState(tokenString string) (*Claims, error) {
+	return m.validatePurpose(tokenString, PurposeOIDCState)
+}
+
+func (m *JWTManager) validatePurpose(tokenString, purpose string) (*Claims, error) {
+	claims, err := m.parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose {
+		return nil, fmt.Errorf("not a %s token", purpose)
+	}
+	return claims, nil
+}
+
+func (m *JWTManager) sign(claims Claims, subject string, ttl time.Duration) (string, time.Time, error) {
 	if m == nil {
 		return "", time.Time{}, errors.New("jwt manager is nil")
 	}
 
-	expiresAt := time.Now().Add(m.ttl)
-	claims := jwt.RegisteredClaims{
+	id := claims.ID
+	if id == "" {
+		id = utils.NewID()
+	}
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        id,
 		Issuer:    m.issuer,
 		Audience:  jwt.ClaimStrings{m.audience},
-		Subject:   userID,
+		Subject:   subject,
 		ExpiresAt: jwt.NewNumericDate(expiresAt),
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		IssuedAt:  jwt.NewNumericDate(now),
 	}
 
-	token := jwt.NewWithClaims(m.method, claims)
-	signed, err := token.SignedString(m.signingKey())
+	token := jwt.NewWithClaims(m.active.method, claims)
+	token.Header["kid"] = m.active.id
+	signed, err := token.SignedString(m.active.signKey)
 	return signed, expiresAt, err
 }
 
-// Validate parses the token string and returns claims if valid.
-func (m *JWTManager) Validate(tokenString string) (*jwt.RegisteredClaims, error) {
-	claims := &jwt.RegisteredClaims{}
+func (m *JWTManager) parse(tokenString string) (*Claims, error) {
+	claims := &Claims{}
 	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if token.Method.Alg() != m.method.Alg() {
+		key := m.active
+		if kid, ok := token.Header["kid"].(string); ok {
+			key, ok = m.keys[kid]
+			if !ok {
+				return nil, fmt.Errorf("unknown jwt key id %s", kid)
+			}
+		}
+		if token.Method.Alg() != key.method.Alg() {
 			return nil, fmt.Errorf("unexpected jwt signing method %s", token.Header["alg"])
 		}
-		return m.verificationKey(), nil
+		return key.verifyKey, nil
 	}, jwt.WithAudience(m.audience), jwt.WithIssuer(m.issuer))
 	if err != nil {
 		return nil, err
@@ -92,21 +242,122 @@ func (m *JWTManager) Validate(tokenString string) (*jwt.RegisteredClaims, error)
 	return claims, nil
 }
 
-func (m *JWTManager) signingKey() interface{} {
-	if m.method == jwt.SigningMethodHS256 {
-		return m.secret
+// ActiveKeyID returns the kid stamped on newly issued tokens.
+func (m *JWTManager) ActiveKeyID() string {
+	return m.active.id
+}
+
+// DeriveSecret derives a secret for another purpose from the active signing
+// key, so instances sharing the key ring agree on it. It changes when the
+// active key is rotated.
+func (m *JWTManager) DeriveSecret(purpose string) (string, error) {
+	var material []byte
+	switch key := m.active.signKey.(type) {
+	case []byte:
+		material = key
+	default:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return "", err
+		}
+		material = der
+	}
+	mac := hmac.New(sha256.New, material)
+	mac.Write([]byte(purpose))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// sortedKeys returns ring entries ordered by kid for stable output.
+func (m *JWTManager) sortedKeys() []*signingKey {
+	keys := make([]*signingKey, 0, len(m.keys))
+	for _, key := range m.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].id < keys[j].id })
+	return keys
+}
+
+func loadSigningKey(kc config.KeyConfig) (*signingKey, error) {
+	key := &signingKey{id: kc.ID}
+
+	alg := strings.ToUpper(kc.Algorithm)
+	if alg == "HS256" {
+		if kc.Secret == "" {
+			return nil, errors.New("hmac secret is empty")
+		}
+		key.method = jwt.SigningMethodHS256
+		key.signKey = []byte(kc.Secret)
+		key.verifyKey = []byte(kc.Secret)
+		return key, nil
+	}
+
+	switch alg {
+	case "RS256":
+		key.method = jwt.SigningMethodRS256
+	case "ES256":
+		key.method = jwt.SigningMethodES256
+	case "EDDSA":
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s", kc.Algorithm)
+	}
+
+	if kc.PrivateKeyPath != "" {
+		priv, err := readPrivateKey(kc.PrivateKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkKeyType(key.method, priv.Public()); err != nil {
+			return nil, err
+		}
+		key.signKey = priv
+		key.verifyKey = priv.Public()
+	}
+	if kc.PublicKeyPath != "" {
+		pub, err := readPublicKey(kc.PublicKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkKeyType(key.method, pub); err != nil {
+			return nil, err
+		}
+		if derived, ok := key.verifyKey.(interface{ Equal(crypto.PublicKey) bool }); ok && !derived.Equal(pub) {
+			return nil, errors.New("public key does not match private key")
+		}
+		key.verifyKey = pub
 	}
-	return m.privKey
+	if key.verifyKey == nil {
+		return nil, errors.New("no key material configured")
+	}
+
+	return key, nil
 }
 
-func (m *JWTManager) verificationKey() interface{} {
-	if m.method == jwt.SigningMethodHS256 {
-		return m.secret
+// checkKeyType ensures the key material matches the configured algorithm so a
+// key can never be used under a different algorithm family.
+func checkKeyType(method jwt.SigningMethod, pub crypto.PublicKey) error {
+	switch method {
+	case jwt.SigningMethodRS256:
+		if _, ok := pub.(*rsa.PublicKey); ok {
+			return nil
+		}
+	case jwt.SigningMethodES256:
+		if ecPub, ok := pub.(*ecdsa.PublicKey); ok {
+			if ecPub.Curve != elliptic.P256() {
+				return errors.New("ES256 requires a P-256 key")
+			}
+			return nil
+		}
+	case jwt.SigningMethodEdDSA:
+		if _, ok := pub.(ed25519.PublicKey); ok {
+			return nil
+		}
 	}
-	return m.pubKey
+	return fmt.Errorf("key type %T does not match algorithm %s", pub, method.Alg())
 }
 
-func readPrivateKey(path string) (*rsa.PrivateKey, error) {
+// readPrivateKey loads a PEM private key in PKCS1 (RSA), SEC1 (EC) or PKCS8 form.
+func readPrivateKey(path string) (crypto.Signer, error) {
 	pemBytes, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("read private key: %w", err)
@@ -115,21 +366,40 @@ func readPrivateKey(path string) (*rsa.PrivateKey, error) {
 	if block == nil {
 		return nil, errors.New("invalid private key pem")
 	}
-	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
-	if err != nil {
-		parsed, err2 := x509.ParsePKCS8PrivateKey(block.Bytes)
-		if err2 != nil {
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
 			return nil, fmt.Errorf("parse private key: %w", err)
 		}
-		if pk, ok := parsed.(*rsa.PrivateKey); ok {
-			return pk, nil
+		return key, nil
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse private key: %w", err)
 		}
-		return nil, errors.New("pkcs8 key is not rsa")
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+	switch pk := parsed.(type) {
+	case *rsa.PrivateKey:
+		return pk, nil
+	case *ecdsa.PrivateKey:
+		return pk, nil
+	case ed25519.PrivateKey:
+		return pk, nil
+	default:
+		return nil, fmt.Errorf("unsupported pkcs8 key type %T", parsed)
 	}
-	return key, nil
 }
 
-func readPublicKey(path string) (*rsa.PublicKey, error) {
+// readPublicKey loads a PEM PKIX public key (RSA, EC or Ed25519).
+func readPublicKey(path string) (crypto.PublicKey, error) {
 	pemBytes, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("read public key: %w", err)
@@ -138,13 +408,16 @@ func readPublicKey(path string) (*rsa.PublicKey, error) {
 	if block == nil {
 		return nil, errors.New("invalid public key pem")
 	}
+	if block.Type == "RSA PUBLIC KEY" {
+		pub, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse public key: %w", err)
+		}
+		return pub, nil
+	}
 	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
 	if err != nil {
 		return nil, fmt.Errorf("parse public key: %w", err)
 	}
-	rsaPub, ok := pub.(*rsa.PublicKey)
-	if !ok {
-		return nil, errors.New("public key is not rsa")
-	}
-	return rsaPub, nil
+	return pub, nil
 }
@@ -0,0 +1,437 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"liangxiong/demo/internal/config"
+)
+
+func baseAuthConfig() config.AuthConfig {
+	return config.AuthConfig{
+		Issuer:         "test-issuer",
+		Audience:       "test-audience",
+		AccessTokenTTL: time.Minute,
+		Algorithm:      "RS256",
+	}
+}
+
+// writeRSAKeyPair writes a PKCS1 private key and PKIX public key into dir.
+func writeRSAKeyPair(t *testing.T, dir, name string) config.KeyConfig {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+
+	privPath := filepath.Join(dir, name+".pem")
+	pubPath := filepath.Join(dir, name+".pub.pem")
+	writePEM(t, privPath, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(priv))
+	writePEM(t, pubPath, "PUBLIC KEY", pubDER)
+	return config.KeyConfig{ID: name, PrivateKeyPath: privPath, PublicKeyPath: pubPath}
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func TestKeyRotationKeepsTokensInFlightValid(t *testing.T) {
+	dir := t.TempDir()
+	oldKey := writeRSAKeyPair(t, dir, "2026-04")
+	newKey := writeRSAKeyPair(t, dir, "2026-10")
+
+	cfg := baseAuthConfig()
+	cfg.Keys = []config.KeyConfig{oldKey}
+	cfg.ActiveKeyID = oldKey.ID
+	before, err := NewJWTManager(cfg)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	oldToken, _, err := before.Generate(Identity{UserID: "user-1", Role: RoleAdmin})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	// Rotate: the new key signs, the old one is kept for verification only.
+	oldKey.PrivateKeyPath = ""
+	cfg.Keys = []config.KeyConfig{oldKey, newKey}
+	cfg.ActiveKeyID = newKey.ID
+	after, err := NewJWTManager(cfg)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+
+	claims, err := after.Validate(oldToken)
+	if err != nil {
+		t.Fatalf("old token rejected after rotation: %v", err)
+	}
+	if claims.Subject != "user-1" || claims.Role != RoleAdmin {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	newToken, _, err := after.Generate(Identity{UserID: "user-2", Role: RoleViewer})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, &Claims{})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if parsed.Header["kid"] != newKey.ID {
+		t.Fatalf("expected kid %s got %v", newKey.ID, parsed.Header["kid"])
+	}
+
+	if _, err := before.Validate(newToken); err == nil {
+		t.Fatal("expected token signed with unknown kid to be rejected")
+	}
+
+	jwks := after.JWKS()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].Kid != oldKey.ID || jwks.Keys[1].Kid != newKey.ID {
+		t.Fatalf("unexpected jwks %+v", jwks)
+	}
+}
+
+func TestActiveKeyRequiresPrivateKey(t *testing.T) {
+	key := writeRSAKeyPair(t, t.TempDir(), "verify-only")
+	key.PrivateKeyPath = ""
+
+	cfg := baseAuthConfig()
+	cfg.Keys = []config.KeyConfig{key}
+	cfg.ActiveKeyID = key.ID
+	if _, err := NewJWTManager(cfg); err == nil {
+		t.Fatal("expected error for verification-only active key")
+	}
+}
+
+func TestJWKSOmitsHMACKeys(t *testing.T) {
+	cfg := baseAuthConfig()
+	cfg.Algorithm = "HS256"
+	cfg.JWTSecret = "secret"
+	manager, err := NewJWTManager(cfg)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	if keys := manager.JWKS().Keys; len(keys) != 0 {
+		t.Fatalf("expected no published keys, got %+v", keys)
+	}
+}
+
+// writeKeyPair writes a key pair for alg, using PKCS8 for the private key when pkcs8 is set
+// and the algorithm's traditional encoding (PKCS1/SEC1) otherwise.
+func writeKeyPair(t *testing.T, dir, name, alg string, pkcs8 bool) config.KeyConfig {
+	t.Helper()
+	var (
+		signer    crypto.Signer
+		der       []byte
+		blockType string
+		err       error
+	)
+	switch alg {
+	case "RS256":
+		key, genErr := rsa.GenerateKey(rand.Reader, 2048)
+		signer, err = key, genErr
+		der, blockType = x509.MarshalPKCS1PrivateKey(key), "RSA PRIVATE KEY"
+	case "ES256":
+		key, genErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if genErr != nil {
+			t.Fatalf("generate key: %v", genErr)
+		}
+		signer = key
+		der, err = x509.MarshalECPrivateKey(key)
+		blockType = "EC PRIVATE KEY"
+	case "EdDSA":
+		_, key, genErr := ed25519.GenerateKey(rand.Reader)
+		signer, err = key, genErr
+		pkcs8 = true
+	}
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	if pkcs8 {
+		der, err = x509.MarshalPKCS8PrivateKey(signer)
+		if err != nil {
+			t.Fatalf("marshal pkcs8: %v", err)
+		}
+		blockType = "PRIVATE KEY"
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+
+	privPath := filepath.Join(dir, name+".pem")
+	pubPath := filepath.Join(dir, name+".pub.pem")
+	writePEM(t, privPath, blockType, der)
+	writePEM(t, pubPath, "PUBLIC KEY", pubDER)
+	return config.KeyConfig{ID: name, Algorithm: alg, PrivateKeyPath: privPath, PublicKeyPath: pubPath}
+}
+
+func TestSigningAlgorithms(t *testing.T) {
+	cases := []struct {
+		alg   string
+		pkcs8 bool
+		kty   string
+	}{
+		{"RS256", false, "RSA"},
+		{"RS256", true, "RSA"},
+		{"ES256", false, "EC"},
+		{"ES256", true, "EC"},
+		{"EdDSA", true, "OKP"},
+	}
+
+	for _, tc := range cases {
+		key := writeKeyPair(t, t.TempDir(), "k1", tc.alg, tc.pkcs8)
+		cfg := baseAuthConfig()
+		cfg.Keys = []config.KeyConfig{key}
+		cfg.ActiveKeyID = key.ID
+
+		manager, err := NewJWTManager(cfg)
+		if err != nil {
+			t.Fatalf("%s (pkcs8=%v): new manager: %v", tc.alg, tc.pkcs8, err)
+		}
+		token, _, err := manager.Generate(Identity{UserID: "user-1", Role: RoleOperator})
+		if err != nil {
+			t.Fatalf("%s: generate: %v", tc.alg, err)
+		}
+		claims, err := manager.Validate(token)
+		if err != nil {
+			t.Fatalf("%s: validate: %v", tc.alg, err)
+		}
+		if claims.Subject != "user-1" || claims.Role != RoleOperator {
+			t.Fatalf("%s: unexpected claims %+v", tc.alg, claims)
+		}
+
+		jwks := manager.JWKS()
+		if len(jwks.Keys) != 1 || jwks.Keys[0].Kty != tc.kty || jwks.Keys[0].Alg != manager.active.method.Alg() {
+			t.Fatalf("%s: unexpected jwks %+v", tc.alg, jwks)
+		}
+	}
+}
+
+func TestLegacyConfigSupportsEllipticAlgorithms(t *testing.T) {
+	for _, alg := range []string{"ES256", "EdDSA"} {
+		key := writeKeyPair(t, t.TempDir(), "legacy", alg, false)
+		cfg := baseAuthConfig()
+		cfg.Algorithm = alg
+		cfg.PrivateKeyPath = key.PrivateKeyPath
+		cfg.PublicKeyPath = key.PublicKeyPath
+
+		manager, err := NewJWTManager(cfg)
+		if err != nil {
+			t.Fatalf("%s: new manager: %v", alg, err)
+		}
+		token, _, err := manager.Generate(Identity{UserID: "user-1", Role: RoleViewer})
+		if err != nil {
+			t.Fatalf("%s: generate: %v", alg, err)
+		}
+		if _, err := manager.Validate(token); err != nil {
+			t.Fatalf("%s: validate: %v", alg, err)
+		}
+	}
+}
+
+func TestKeyTypeMustMatchAlgorithm(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey := writeKeyPair(t, dir, "rsa", "RS256", false)
+	ecKey := writeKeyPair(t, dir, "ec", "ES256", false)
+	edKey := writeKeyPair(t, dir, "ed", "EdDSA", true)
+
+	mismatches := []config.KeyConfig{
+		{ID: "x", Algorithm: "ES256", PrivateKeyPath: rsaKey.PrivateKeyPath},
+		{ID: "x", Algorithm: "EdDSA", PublicKeyPath: ecKey.PublicKeyPath},
+		{ID: "x", Algorithm: "RS256", PrivateKeyPath: edKey.PrivateKeyPath},
+		{ID: "x", Algorithm: "ES256", PrivateKeyPath: ecKey.PrivateKeyPath, PublicKeyPath: rsaKey.PublicKeyPath},
+	}
+	for _, kc := range mismatches {
+		cfg := baseAuthConfig()
+		cfg.Keys = []config.KeyConfig{kc}
+		cfg.ActiveKeyID = kc.ID
+		if _, err := NewJWTManager(cfg); err == nil {
+			t.Fatalf("expected %s with %s/%s to be rejected", kc.Algorithm, kc.PrivateKeyPath, kc.PublicKeyPath)
+		}
+	}
+}
+
+func TestRejectsAlgorithmConfusion(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey := writeKeyPair(t, dir, "rsa", "RS256", false)
+	ecKey := writeKeyPair(t, dir, "ec", "ES256", false)
+	edKey := writeKeyPair(t, dir, "ed", "EdDSA", true)
+
+	cfg := baseAuthConfig()
+	cfg.Keys = []config.KeyConfig{rsaKey, ecKey, edKey}
+	cfg.ActiveKeyID = rsaKey.ID
+	manager, err := NewJWTManager(cfg)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+
+	claims := Claims{Role: RoleAdmin, RegisteredClaims: jwt.RegisteredClaims{
+		Issuer:    "test-issuer",
+		Audience:  jwt.ClaimStrings{"test-audience"},
+		Subject:   "attacker",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}}
+	sign := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("sign %s: %v", method.Alg(), err)
+		}
+		return signed
+	}
+
+	rsaPublicPEM, err := os.ReadFile(rsaKey.PublicKeyPath)
+	if err != nil {
+		t.Fatalf("read public key: %v", err)
+	}
+	ecSigner := manager.keys[ecKey.ID].signKey
+	edSigner := manager.keys[edKey.ID].signKey
+
+	forged := map[string]string{
+		"HS256 keyed with RSA public key": sign(jwt.SigningMethodHS256, rsaKey.ID, rsaPublicPEM),
+		"ES256 under RSA kid":             sign(jwt.SigningMethodES256, rsaKey.ID, ecSigner),
+		"EdDSA under EC kid":              sign(jwt.SigningMethodEdDSA, ecKey.ID, edSigner),
+		"ES256 under EdDSA kid":           sign(jwt.SigningMethodES256, edKey.ID, ecSigner),
+		"ES256 without kid":               sign(jwt.SigningMethodES256, "", ecSigner),
+	}
+	for name, token := range forged {
+		if _, err := manager.Validate(token); err == nil {
+			t.Fatalf("%s: expected token to be rejected", name)
+		}
+	}
+
+	// Sanity check: the same EC key is accepted under its own kid and algorithm.
+	if _, err := manager.Validate(sign(jwt.SigningMethodES256, ecKey.ID, ecSigner)); err != nil {
+		t.Fatalf("expected genuine ES256 token to validate: %v", err)
+	}
+}
+
+func TestChallengeTokensAreNotAccessTokens(t *testing.T) {
+	cfg := baseAuthConfig()
+	cfg.Algorithm = "HS256"
+	cfg.JWTSecret = "secret"
+	manager, err := NewJWTManager(cfg)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+
+	challenge, _, err := manager.GenerateChallenge("user-1", 3, time.Minute)
+	if err != nil {
+		t.Fatalf("generate challenge: %v", err)
+	}
+	if _, err := manager.Validate(challenge); err == nil {
+		t.Fatal("challenge token must not be accepted as an access token")
+	}
+	if claims, err := manager.ValidateChallenge(challenge); err != nil || claims.Subject != "user-1" || claims.Generation != 3 {
+		t.Fatalf("expected challenge to validate, got %v %v", claims, err)
+	}
+
+	access, _, err := manager.Generate(Identity{UserID: "user-1", Role: RoleAdmin, MFAPending: true})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if _, err := manager.ValidateChallenge(access); err == nil {
+		t.Fatal("access token must not be accepted as a challenge token")
+	}
+	claims, err := manager.Validate(access)
+	if err != nil || !claims.MFAPending {
+		t.Fatalf("expected pending access token, got %v %v", claims, err)
+	}
+}
+
+func TestImpersonationTokensCarryActor(t *testing.T) {
+	cfg := baseAuthConfig()
+	cfg.Algorithm = "HS256"
+	cfg.JWTSecret = "secret"
+	manager, err := NewJWTManager(cfg)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+
+	token, expiresAt, err := manager.GenerateImpersonation(Identity{UserID: "user-1", Role: RoleViewer, Generation: 2}, Actor{Subject: "admin-1", Generation: 5}, "jti-1", 5*time.Minute)
+	if err != nil {
+		t.Fatalf("generate impersonation: %v", err)
+	}
+	if time.Until(expiresAt) > 5*time.Minute {
+		t.Fatalf("expected ttl override, got expiry %v", expiresAt)
+	}
+	claims, err := manager.Validate(token)
+	if err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if claims.Subject != "user-1" || claims.ID != "jti-1" || claims.Actor == nil || claims.Actor.Subject != "admin-1" ||
+		claims.Generation != 2 || claims.Actor.Generation != 5 {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	access, _, err := manager.Generate(Identity{UserID: "user-1", Role: RoleViewer})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if claims, err := manager.Validate(access); err != nil || claims.Actor != nil {
+		t.Fatalf("expected regular token without actor, got %v %v", claims, err)
+	}
+}
+
+func TestDeriveSecretFollowsActiveKey(t *testing.T) {
+	hmacCfg := baseAuthConfig()
+	hmacCfg.Algorithm = "HS256"
+	hmacCfg.JWTSecret = "secret"
+	rsaCfg := baseAuthConfig()
+	rsaCfg.Keys = []config.KeyConfig{writeKeyPair(t, t.TempDir(), "k1", "RS256", true)}
+	rsaCfg.ActiveKeyID = "k1"
+	edCfg := baseAuthConfig()
+	edCfg.Keys = []config.KeyConfig{writeKeyPair(t, t.TempDir(), "k1", "EdDSA", true)}
+	edCfg.ActiveKeyID = "k1"
+
+	seen := make(map[string]bool)
+	for _, cfg := range []config.AuthConfig{hmacCfg, rsaCfg, edCfg} {
+		first, err := NewJWTManager(cfg)
+		if err != nil {
+			t.Fatalf("new manager: %v", err)
+		}
+		second, err := NewJWTManager(cfg)
+		if err != nil {
+			t.Fatalf("new manager: %v", err)
+		}
+		a, err := first.DeriveSecret("cursor")
+		if err != nil {
+			t.Fatalf("derive: %v", err)
+		}
+		b, err := second.DeriveSecret("cursor")
+		if err != nil {
+			t.Fatalf("derive: %v", err)
+		}
+		other, err := first.DeriveSecret("other")
+		if err != nil {
+			t.Fatalf("derive: %v", err)
+		}
+		if a != b || a == other || seen[a] {
+			t.Fatalf("expected a stable secret per key and purpose, got %s, %s and %s", a, b, other)
+		}
+		seen[a] = true
+	}
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"liangxiong/demo/internal/config"
+)
+
+const (
+	defaultMaxFailedAttempts = 5
+	defaultLockoutDuration   = 15 * time.Minute
+	defaultBaseBackoff       = time.Second
+	defaultMaxBackoff        = 30 * time.Second
+	defaultFailureWindow     = 15 * time.Minute
+)
+
+type attemptState struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// LoginAttemptTracker counts failed logins per key (username or client IP)
+// and enforces an exponential backoff between attempts. State is kept
+// in-process; persistent lockouts are stored on the user row by the caller.
+type LoginAttemptTracker struct {
+	maxFailures   int
+	lockout       time.Duration
+	baseBackoff   time.Duration
+	maxBackoff    time.Duration
+	failureWindow time.Duration
+	now           func() time.Time
+
+	mu        sync.Mutex
+	attempts  map[string]*attemptState
+	lastPrune time.Time
+}
+
+// NewLoginAttemptTracker builds a tracker, falling back to defaults for unset values.
+func NewLoginAttemptTracker(cfg config.LockoutConfig) *LoginAttemptTracker {
+	t := &LoginAttemptTracker{
+		maxFailures:   cfg.MaxFailedAttempts,
+		lockout:       cfg.LockoutDuration,
+		baseBackoff:   cfg.BaseBackoff,
+		maxBackoff:    cfg.MaxBackoff,
+		failureWindow: cfg.FailureWindow,
+		now:           time.Now,
+		attempts:      make(map[string]*attemptState),
+	}
+	if t.maxFailures <= 0 {
+		t.maxFailures = defaultMaxFailedAttempts
+	}
+	if t.lockout <= 0 {
+		t.lockout = defaultLockoutDuration
+	}
+	if t.baseBackoff <= 0 {
+		t.baseBackoff = defaultBaseBackoff
+	}
+	if t.maxBackoff <= 0 {
+		t.maxBackoff = defaultMaxBackoff
+	}
+	if t.failureWindow <= 0 {
+		t.failureWindow = defaultFailureWindow
+	}
+	return t
+}
+
+// UsernameKey builds the tracker key for a username.
+func UsernameKey(username string) string {
+	return "user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+// MFAKey builds the tracker key for second-factor attempts of a user.
+func MFAKey(userID string) string {
+	return "mfa:" + userID
+}
+
+// PasswordKey builds the tracker key for current-password checks of a
+// signed-in user.
+func PasswordKey(userID string) string {
+	return "password:" + userID
+}
+
+// IPKey builds the tracker key for a client IP.
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// MaxFailedAttempts is the number of consecutive failures that locks an account.
+func (t *LoginAttemptTracker) MaxFailedAttempts() int {
+	return t.maxFailures
+}
+
+// LockoutDuration is how long an account stays locked.
+func (t *LoginAttemptTracker) LockoutDuration() time.Duration {
+	return t.lockout
+}
+
+// RetryAfter returns the longest remaining backoff across keys, or zero.
+func (t *LoginAttemptTracker) RetryAfter(keys ...string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	var wait time.Duration
+	for _, key := range keys {
+		state, ok := t.attempts[key]
+		if !ok {
+			continue
+		}
+		if remaining := state.blockedUntil.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait
+}
+
+// RecordFailure registers a failed attempt for key, starts its backoff and
+// returns the number of consecutive failures within the failure window.
+func (t *LoginAttemptTracker) RecordFailure(key string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.pruneLocked(now)
+
+	state, ok := t.attempts[key]
+	if !ok || now.Sub(state.lastFailure) > t.failureWindow {
+		state = &attemptState{}
+		t.attempts[key] = state
+	}
+	state.failures++
+	state.lastFailure = now
+	state.blockedUntil = now.Add(t.backoff(state.failures))
+	return state.failures
+}
+
+// Reset forgets failures recorded for key.
+func (t *LoginAttemptTracker) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.attempts, key)
+}
+
+// backoff doubles from baseBackoff with each failure, capped at maxBackoff.
+func (t *LoginAttemptTracker) backoff(failures int) time.Duration {
+	wait := t.baseBackoff
+	for i := 1; i < failures; i++ {
+		wait *= 2
+		if wait >= t.maxBackoff {
+			return t.maxBackoff
+		}
+	}
+	return wait
+}
+
+// pruneLocked drops stale entries at most once per failure window.
+func (t *LoginAttemptTracker) pruneLocked(now time.Time) {
+	if now.Sub(t.lastPrune) < t.failureWindow {
+		return
+	}
+	t.lastPrune = now
+	for key, state := range t.attempts {
+		if now.Sub(state.lastFailure) > t.failureWindow && !now.Before(state.blockedUntil) {
+			delete(t.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"liangxiong/demo/internal/config"
+)
+
+func TestLoginAttemptTrackerBackoff(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker := NewLoginAttemptTracker(config.LockoutConfig{
+		MaxFailedAttempts: 3,
+		BaseBackoff:       time.Second,
+		MaxBackoff:        3 * time.Second,
+		FailureWindow:     time.Minute,
+	})
+	tracker.now = func() time.Time { return now }
+
+	key := UsernameKey(" Alice ")
+	expected := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}
+	for i, wait := range expected {
+		if failures := tracker.RecordFailure(key); failures != i+1 {
+			t.Fatalf("expected %d failures got %d", i+1, failures)
+		}
+		if got := tracker.RetryAfter(key, IPKey("10.0.0.1")); got != wait {
+			t.Fatalf("failure %d: expected backoff %s got %s", i+1, wait, got)
+		}
+	}
+
+	if tracker.RetryAfter(UsernameKey("alice")) == 0 {
+		t.Fatal("expected username keys to be case-insensitive")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if got := tracker.RecordFailure(key); got != 1 {
+		t.Fatalf("expected failures to reset after the window, got %d", got)
+	}
+
+	tracker.Reset(key)
+	if got := tracker.RetryAfter(key); got != 0 {
+		t.Fatalf("expected no backoff after reset, got %s", got)
+	}
+}
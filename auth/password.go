@@ -1,17 +1,190 @@
 package auth
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
 
-// HashPassword generates a bcrypt hash.
-func HashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"liangxiong/demo/internal/config"
+)
+
+// Password hash algorithms. Hashes are self-describing: bcrypt uses its
+// modular crypt format ($2a$<cost>$...), argon2id the PHC string format
+// ($argon2id$v=19$m=<KiB>,t=<passes>,p=<threads>$<salt>$<key>).
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+// Defaults follow the OWASP password storage recommendations.
+const (
+	defaultArgon2Memory     = 19 * 1024
+	defaultArgon2Time       = 2
+	defaultArgon2Threads    = 1
+	defaultArgon2SaltLength = 16
+	defaultArgon2KeyLength  = 32
+)
+
+// PasswordHasher hashes new passwords with the configured algorithm and
+// parameters, verifies hashes of any supported version, and reports hashes
+// that should be upgraded.
+type PasswordHasher struct {
+	algorithm  string
+	bcryptCost int
+	argon2     argon2Params
+}
+
+type argon2Params struct {
+	memory     uint32
+	time       uint32
+	threads    uint8
+	saltLength uint32
+	keyLength  uint32
+}
+
+// NewPasswordHasher validates the configuration and fills in defaults.
+func NewPasswordHasher(cfg config.PasswordHashConfig) (*PasswordHasher, error) {
+	h := &PasswordHasher{
+		algorithm:  strings.ToLower(cfg.Algorithm),
+		bcryptCost: cfg.BcryptCost,
+		argon2: argon2Params{
+			memory:     cfg.Argon2.Memory,
+			time:       cfg.Argon2.Time,
+			threads:    cfg.Argon2.Threads,
+			saltLength: cfg.Argon2.SaltLength,
+			keyLength:  cfg.Argon2.KeyLength,
+		},
+	}
+	if h.algorithm == "" {
+		h.algorithm = HashBcrypt
+	}
+	if h.bcryptCost == 0 {
+		h.bcryptCost = bcrypt.DefaultCost
+	}
+	if h.argon2.memory == 0 {
+		h.argon2.memory = defaultArgon2Memory
+	}
+	if h.argon2.time == 0 {
+		h.argon2.time = defaultArgon2Time
+	}
+	if h.argon2.threads == 0 {
+		h.argon2.threads = defaultArgon2Threads
+	}
+	if h.argon2.saltLength == 0 {
+		h.argon2.saltLength = defaultArgon2SaltLength
+	}
+	if h.argon2.keyLength == 0 {
+		h.argon2.keyLength = defaultArgon2KeyLength
+	}
+
+	switch h.algorithm {
+	case HashBcrypt:
+		if h.bcryptCost < bcrypt.MinCost || h.bcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case HashArgon2id:
+		if h.argon2.memory < 8*uint32(h.argon2.threads) {
+			return nil, errors.New("argon2 memory must be at least 8 KiB per thread")
+		}
+		if h.argon2.saltLength < 8 || h.argon2.keyLength < 16 {
+			return nil, errors.New("argon2 salt must be at least 8 bytes and key at least 16 bytes")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported password hash algorithm %q", cfg.Algorithm)
+	}
+	return h, nil
+}
+
+// bcryptMaxPasswordBytes is the longest password bcrypt hashes.
+const bcryptMaxPasswordBytes = 72
+
+// MaxPasswordBytes returns the length limit in bytes of the passwords the
+// configured algorithm hashes, or 0 when there is none.
+func (h *PasswordHasher) MaxPasswordBytes() int {
+	if h.algorithm == HashBcrypt {
+		return bcryptMaxPasswordBytes
+	}
+	return 0
+}
+
+// Hash hashes a password with the configured algorithm.
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	if h.algorithm == HashArgon2id {
+		return hashArgon2id(password, h.argon2)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
 	if err != nil {
 		return "", err
 	}
 	return string(hash), nil
 }
 
-// VerifyPassword compares plain text password with hash.
+// NeedsRehash reports whether a hash was made with another algorithm or
+// other parameters than the configured ones. Unrecognised values (such as
+// the marker of externally authenticated users) never need a rehash.
+func (h *PasswordHasher) NeedsRehash(hash string) bool {
+	if params, _, _, ok := parseArgon2id(hash); ok {
+		return h.algorithm != HashArgon2id ||
+			params.memory != h.argon2.memory || params.time != h.argon2.time ||
+			params.threads != h.argon2.threads || params.keyLength != h.argon2.keyLength ||
+			params.saltLength != h.argon2.saltLength
+	}
+	if cost, err := bcrypt.Cost([]byte(hash)); err == nil {
+		return h.algorithm != HashBcrypt || cost != h.bcryptCost
+	}
+	return false
+}
+
+// VerifyPassword compares a plain text password with a bcrypt or argon2id hash.
 func VerifyPassword(hash, password string) bool {
+	if params, salt, key, ok := parseArgon2id(hash); ok {
+		computed := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, params.keyLength)
+		return subtle.ConstantTimeCompare(computed, key) == 1
+	}
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
 }
+
+func hashArgon2id(password string, p argon2Params) (string, error) {
+	salt := make([]byte, p.saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("generate salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, p.keyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// parseArgon2id decodes a PHC argon2id string of the supported version.
+func parseArgon2id(hash string) (argon2Params, []byte, []byte, bool) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != HashArgon2id {
+		return argon2Params{}, nil, nil, false
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return argon2Params{}, nil, nil, false
+	}
+	var p argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil || p.time == 0 || p.threads == 0 {
+		return argon2Params{}, nil, nil, false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return argon2Params{}, nil, nil, false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return argon2Params{}, nil, nil, false
+	}
+	p.saltLength, p.keyLength = uint32(len(salt)), uint32(len(key))
+	return p, salt, key, true
+}
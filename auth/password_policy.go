@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"liangxiong/demo/internal/config"
+)
+
+const defaultMinPasswordLength = 8
+
+// PasswordPolicy validates new passwords against length, character class
+// and breached-password rules.
+type PasswordPolicy struct {
+	minLength     int
+	maxBytes      int
+	requireUpper  bool
+	requireLower  bool
+	requireDigit  bool
+	requireSymbol bool
+	breached      map[string]struct{}
+}
+
+// NewPasswordPolicy builds a policy and loads the breached list if configured.
+// The list holds one entry per line: either a plain-text password or a
+// SHA-1 hex digest (optionally followed by ":count", as in HIBP dumps).
+// Passwords are also limited to the length the hasher accepts, if any.
+func NewPasswordPolicy(cfg config.PasswordPolicyConfig, hasher *PasswordHasher) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{
+		minLength:     cfg.MinLength,
+		requireUpper:  cfg.RequireUpper,
+		requireLower:  cfg.RequireLower,
+		requireDigit:  cfg.RequireDigit,
+		requireSymbol: cfg.RequireSymbol,
+		breached:      make(map[string]struct{}),
+	}
+	if policy.minLength <= 0 {
+		policy.minLength = defaultMinPasswordLength
+	}
+	if hasher != nil {
+		policy.maxBytes = hasher.MaxPasswordBytes()
+	}
+
+	if cfg.BreachedListPath == "" {
+		return policy, nil
+	}
+
+	file, err := os.Open(cfg.BreachedListPath)
+	if err != nil {
+		return nil, fmt.Errorf("open breached password list: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if digest, _, _ := strings.Cut(line, ":"); isSHA1Hex(digest) {
+			policy.breached[strings.ToUpper(digest)] = struct{}{}
+			continue
+		}
+		policy.breached[line] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read breached password list: %w", err)
+	}
+	return policy, nil
+}
+
+// Check returns the violated rules, or nil when the password is acceptable.
+func (p *PasswordPolicy) Check(password string) []string {
+	var violations []string
+	if len([]rune(password)) < p.minLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters", p.minLength))
+	}
+	if p.maxBytes > 0 && len(password) > p.maxBytes {
+		violations = append(violations, fmt.Sprintf("must be at most %d bytes", p.maxBytes))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.requireUpper && !upper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.requireLower && !lower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if p.requireDigit && !digit {
+		violations = append(violations, "must contain a digit")
+	}
+	if p.requireSymbol && !symbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	if p.isBreached(password) {
+		violations = append(violations, "appears in a list of breached passwords")
+	}
+	return violations
+}
+
+func (p *PasswordPolicy) isBreached(password string) bool {
+	if len(p.breached) == 0 {
+		return false
+	}
+	if _, ok := p.breached[password]; ok {
+		return true
+	}
+	sum := sha1.Sum([]byte(password))
+	_, ok := p.breached[strings.ToUpper(hex.EncodeToString(sum[:]))]
+	return ok
+}
+
+func isSHA1Hex(value string) bool {
+	if len(value) != sha1.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
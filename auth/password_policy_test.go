@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"liangxiong/demo/internal/config"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "breached.txt")
+	// "Password123!" as plain text and "Summer2024!Ab" as a HIBP-style SHA-1 entry.
+	content := "Password123!\nBD4CABB4019FAEDC4C00875D5BBE37A66134BBB6:3\n"
+	if err := os.WriteFile(list, []byte(content), 0o600); err != nil {
+		t.Fatalf("write list: %v", err)
+	}
+
+	policy, err := NewPasswordPolicy(config.PasswordPolicyConfig{
+		MinLength:        10,
+		RequireUpper:     true,
+		RequireLower:     true,
+		RequireDigit:     true,
+		RequireSymbol:    true,
+		BreachedListPath: list,
+	}, nil)
+	if err != nil {
+		t.Fatalf("new policy: %v", err)
+	}
+
+	cases := map[string]int{
+		"Tr0ub4dor&3x":  0,
+		"short1!A":      1,
+		"alllowercase1": 2,
+		"NoDigitsHere!": 1,
+		"Password123!":  1,
+		"Summer2024!Ab": 1,
+	}
+	for password, violations := range cases {
+		if got := policy.Check(password); len(got) != violations {
+			t.Fatalf("%q: expected %d violations got %v", password, violations, got)
+		}
+	}
+}
+
+func TestPasswordPolicyLimitsBcryptPasswordsInBytes(t *testing.T) {
+	bcryptHasher, err := NewPasswordHasher(config.PasswordHashConfig{})
+	if err != nil {
+		t.Fatalf("bcrypt hasher: %v", err)
+	}
+	argon2Hasher, err := NewPasswordHasher(config.PasswordHashConfig{Algorithm: HashArgon2id})
+	if err != nil {
+		t.Fatalf("argon2 hasher: %v", err)
+	}
+	bcryptPolicy, err := NewPasswordPolicy(config.PasswordPolicyConfig{}, bcryptHasher)
+	if err != nil {
+		t.Fatalf("new policy: %v", err)
+	}
+	argon2Policy, err := NewPasswordPolicy(config.PasswordPolicyConfig{}, argon2Hasher)
+	if err != nil {
+		t.Fatalf("new policy: %v", err)
+	}
+
+	// 37 characters but 73 bytes.
+	long := strings.Repeat("é", 36) + "a"
+	if got := bcryptPolicy.Check(long); len(got) != 1 {
+		t.Fatalf("expected the bcrypt policy to refuse 73 bytes, got %v", got)
+	}
+	if got := bcryptPolicy.Check(long[:72]); len(got) != 0 {
+		t.Fatalf("expected the bcrypt policy to accept 72 bytes, got %v", got)
+	}
+	if got := argon2Policy.Check(long); len(got) != 0 {
+		t.Fatalf("expected the argon2id policy to accept 73 bytes, got %v", got)
+	}
+	if _, err := bcryptHasher.Hash(long[:72]); err != nil {
+		t.Fatalf("expected bcrypt to hash 72 bytes, got %v", err)
+	}
+}
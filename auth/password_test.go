@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"liangxiong/demo/internal/config"
+)
+
+func TestPasswordHasher(t *testing.T) {
+	bcryptHasher, err := NewPasswordHasher(config.PasswordHashConfig{BcryptCost: bcrypt.MinCost})
+	if err != nil {
+		t.Fatalf("bcrypt hasher: %v", err)
+	}
+	argonHasher, err := NewPasswordHasher(config.PasswordHashConfig{Algorithm: HashArgon2id, Argon2: config.Argon2Config{Memory: 64, Time: 1}})
+	if err != nil {
+		t.Fatalf("argon2id hasher: %v", err)
+	}
+	saltHasher, err := NewPasswordHasher(config.PasswordHashConfig{Algorithm: HashArgon2id, Argon2: config.Argon2Config{Memory: 64, Time: 1, SaltLength: 32}})
+	if err != nil {
+		t.Fatalf("argon2id hasher: %v", err)
+	}
+
+	bcryptHash, err := bcryptHasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("bcrypt hash: %v", err)
+	}
+	argonHash, err := argonHasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("argon2id hash: %v", err)
+	}
+	if !strings.HasPrefix(argonHash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("unexpected argon2id encoding %s", argonHash)
+	}
+
+	// Either hasher verifies hashes of both algorithms.
+	for _, hash := range []string{bcryptHash, argonHash} {
+		if !VerifyPassword(hash, "correct horse") || VerifyPassword(hash, "wrong horse") {
+			t.Fatalf("verify failed for %s", hash)
+		}
+	}
+
+	cases := []struct {
+		hasher *PasswordHasher
+		hash   string
+		rehash bool
+	}{
+		{bcryptHasher, bcryptHash, false},
+		{bcryptHasher, argonHash, true},
+		{argonHasher, argonHash, false},
+		{argonHasher, bcryptHash, true},
+		{argonHasher, strings.Replace(argonHash, "t=1", "t=2", 1), true},
+		{saltHasher, argonHash, true},
+		{argonHasher, "!external", false},
+	}
+	for i, tc := range cases {
+		if got := tc.hasher.NeedsRehash(tc.hash); got != tc.rehash {
+			t.Fatalf("case %d: expected rehash %v, got %v", i, tc.rehash, got)
+		}
+	}
+
+	if _, err := NewPasswordHasher(config.PasswordHashConfig{Algorithm: "md5"}); err == nil {
+		t.Fatalf("expected unsupported algorithm to be rejected")
+	}
+	if _, err := NewPasswordHasher(config.PasswordHashConfig{BcryptCost: 40}); err == nil {
+		t.Fatalf("expected out of range cost to be rejected")
+	}
+}
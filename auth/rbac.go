@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"liangxiong/demo/utils"
+)
+
+// Role catalogue. Roles are stored verbatim in users.role and embedded in JWT claims.
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleViewer   = "viewer"
+)
+
+// Permission catalogue used by route guards.
+const (
+	PermUsersRead        = "users:read"
+	PermUsersAdmin       = "users:admin"
+	PermExchangesRead    = "exchanges:read"
+	PermExchangesWrite   = "exchanges:write"
+	PermAPIKeysAdmin     = "apikeys:admin"
+	PermTokensIntrospect = "tokens:introspect"
+	PermUsersImpersonate = "users:impersonate"
+)
+
+// rolePermissions is the permission matrix per role.
+var rolePermissions = map[string][]string{
+	RoleAdmin: {
+		PermUsersRead,
+		PermUsersAdmin,
+		PermExchangesRead,
+		PermExchangesWrite,
+		PermAPIKeysAdmin,
+		PermTokensIntrospect,
+		PermUsersImpersonate,
+	},
+	RoleOperator: {
+		PermUsersRead,
+		PermExchangesRead,
+		PermExchangesWrite,
+	},
+	RoleViewer: {
+		PermExchangesRead,
+	},
+}
+
+// roleRanks orders the roles by privilege; each role holds the permissions
+// of the roles ranked below it.
+var roleRanks = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// MorePrivileged reports whether role a ranks above role b. Roles outside the
+// catalogue rank below every role.
+func MorePrivileged(a, b string) bool {
+	return roleRanks[NormalizeRole(a)] > roleRanks[NormalizeRole(b)]
+}
+
+// NormalizeRole lowercases and trims a role name.
+func NormalizeRole(role string) string {
+	return strings.ToLower(strings.TrimSpace(role))
+}
+
+// IsValidRole reports whether the role belongs to the catalogue.
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[NormalizeRole(role)]
+	return ok
+}
+
+// Roles lists the catalogue in a stable order.
+func Roles() []string {
+	roles := make([]string, 0, len(rolePermissions))
+	for role := range rolePermissions {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// PermissionsForRole returns a copy of the permissions granted to a role.
+func PermissionsForRole(role string) []string {
+	perms := rolePermissions[NormalizeRole(role)]
+	out := make([]string, len(perms))
+	copy(out, perms)
+	return out
+}
+
+// IsValidPermission reports whether the permission belongs to the catalogue.
+func IsValidPermission(permission string) bool {
+	for _, perms := range rolePermissions {
+		for _, p := range perms {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasPermission checks the matrix for role/permission pairs.
+func HasPermission(role, permission string) bool {
+	for _, p := range rolePermissions[NormalizeRole(role)] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether the caller in ctx holds the permission, through the
+// scopes of an API key or else the role of a user token.
+func Allowed(ctx context.Context, permission string) bool {
+	if scopes, ok := utils.ScopesFromContext(ctx); ok {
+		for _, scope := range scopes {
+			if scope == permission {
+				return true
+			}
+		}
+		return false
+	}
+	return HasPermission(utils.RoleFromContext(ctx), permission)
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// SecretBox encrypts small secrets at rest (e.g. TOTP seeds) with AES-256-GCM.
+// The key is derived from the configured passphrase with SHA-256.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox builds a box from a passphrase.
+func NewSecretBox(passphrase string) (*SecretBox, error) {
+	if passphrase == "" {
+		return nil, errors.New("encryption key is empty")
+	}
+	key := sha256.Sum256([]byte(passphrase))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBox{aead: aead}, nil
+}
+
+// Seal encrypts plaintext and returns base64(nonce || ciphertext).
+func (b *SecretBox) Seal(plaintext string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value produced by Seal.
+func (b *SecretBox) Open(encoded string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("decode sealed secret: %w", err)
+	}
+	size := b.aead.NonceSize()
+	if len(raw) < size {
+		return "", errors.New("sealed secret too short")
+	}
+	plain, err := b.aead.Open(nil, raw[:size], raw[size:], nil)
+	if err != nil {
+		return "", fmt.Errorf("open sealed secret: %w", err)
+	}
+	return string(plain), nil
+}
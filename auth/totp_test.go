@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 seed "12345678901234567890" from RFC 6238 appendix B.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeMatchesRFCVectors(t *testing.T) {
+	// RFC 6238 lists 8-digit values; 6-digit codes are their last six digits.
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range cases {
+		got, err := TOTPCode(rfc6238Secret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatalf("code at %d: %v", unix, err)
+		}
+		if got != want {
+			t.Fatalf("code at %d: expected %s got %s", unix, want, got)
+		}
+	}
+}
+
+func TestValidateTOTPAllowsOneStepOfSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, _ := TOTPCode(rfc6238Secret, now)
+
+	if step, ok, err := ValidateTOTP(rfc6238Secret, code, now.Add(totpPeriod*time.Second)); err != nil || !ok || step != now.Unix()/totpPeriod {
+		t.Fatalf("expected code from previous step to validate, got %d %v %v", step, ok, err)
+	}
+	if _, ok, _ := ValidateTOTP(rfc6238Secret, code, now.Add(3*totpPeriod*time.Second)); ok {
+		t.Fatal("expected stale code to be rejected")
+	}
+}
+
+func TestRecoveryCodeHashIgnoresFormatting(t *testing.T) {
+	code, err := NewRecoveryCode()
+	if err != nil {
+		t.Fatalf("new recovery code: %v", err)
+	}
+	if IsTOTPCode(code) {
+		t.Fatalf("recovery code %s must not look like a totp code", code)
+	}
+	if HashRecoveryCode(code) != HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(code, "-", " "))) {
+		t.Fatal("expected hash to ignore case and separators")
+	}
+}
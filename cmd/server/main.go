@@ -8,6 +8,9 @@
 // @securityDefinitions.apikey BearerAuth
 // @in header
 // @name Authorization
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
 package main
 
 import (
@@ -20,6 +23,8 @@ import (
 	"go.uber.org/zap"
 
 	"liangxiong/demo/internal/config"
+	"liangxiong/demo/internal/migrate"
+	"liangxiong/demo/migrations"
 	"liangxiong/demo/server"
 )
 
@@ -48,6 +53,16 @@ func main() {
 		}
 	}()
 
+	if cfg.Database.MigrateOnStart {
+		migrator, err := migrate.New(db, migrations.FS, logger)
+		if err != nil {
+			logger.Fatal("load migrations failed", zap.Error(err))
+		}
+		if err := migrator.Up(ctx); err != nil {
+			logger.Fatal("database migration failed", zap.Error(err))
+		}
+	}
+
 	srv, err := server.New(cfg, logger, db)
 	if err != nil {
 		logger.Fatal("server init failed", zap.Error(err))
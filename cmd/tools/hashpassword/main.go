@@ -8,13 +8,30 @@ import (
 	"strings"
 
 	"golang.org/x/crypto/bcrypt"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/internal/config"
 )
 
 func main() {
 	passwordFlag := flag.String("password", "", "Plain text password to hash (omit to read from stdin)")
+	algorithm := flag.String("algorithm", auth.HashBcrypt, "hash algorithm: bcrypt or argon2id (match auth.passwordHash in the config)")
 	cost := flag.Int("cost", bcrypt.DefaultCost, "bcrypt cost (10-16 recommended)")
+	memory := flag.Uint("memory", 0, "argon2id memory in KiB (0 uses the default)")
+	passes := flag.Uint("time", 0, "argon2id passes (0 uses the default)")
+	threads := flag.Uint("threads", 0, "argon2id parallelism (0 uses the default)")
 	flag.Parse()
 
+	hasher, err := auth.NewPasswordHasher(config.PasswordHashConfig{
+		Algorithm:  *algorithm,
+		BcryptCost: *cost,
+		Argon2:     config.Argon2Config{Memory: uint32(*memory), Time: uint32(*passes), Threads: uint8(*threads)},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid hash settings: %v\n", err)
+		os.Exit(1)
+	}
+
 	password := strings.TrimSpace(*passwordFlag)
 	if password == "" {
 		fmt.Print("Enter password: ")
@@ -31,11 +48,11 @@ func main() {
 		os.Exit(1)
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), *cost)
+	hash, err := hasher.Hash(password)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to hash password: %v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("Password hash: %s\n", string(hash))
+	fmt.Printf("Password hash: %s\n", hash)
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"liangxiong/demo/internal/config"
+	"liangxiong/demo/internal/migrate"
+	"liangxiong/demo/migrations"
+)
+
+const usage = `Usage: migrate <command>
+
+Applies the embedded schema migrations to the database of the config selected
+by APP_ENV.
+
+Commands:
+  up              apply every pending migration
+  down [n]        roll back the n most recent migrations (default 1)
+  status          list migrations and whether they are applied
+  goto <version>  migrate up or down to version (0 rolls back everything)
+`
+
+func main() {
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "migrate %s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, command string, args []string) error {
+	cfg, err := config.Load(config.ConfigPath())
+	if err != nil {
+		return err
+	}
+	logger, err := config.NewLogger(cfg.App.LogLevel, cfg.Logging.FilePath)
+	if err != nil {
+		return err
+	}
+	defer logger.Sync()
+
+	db, err := config.NewDatabase(ctx, cfg.Database, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrate.New(db, migrations.FS, logger)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 0 {
+			if steps, err = strconv.Atoi(args[0]); err != nil {
+				return fmt.Errorf("invalid step count %q", args[0])
+			}
+		}
+		return migrator.Down(ctx, steps)
+	case "goto":
+		if len(args) != 1 {
+			return errors.New("goto needs a version")
+		}
+		version, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[0])
+		}
+		return migrator.Goto(ctx, version)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT\tNOTE")
+		for _, s := range statuses {
+			appliedAt, note := "pending", ""
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			switch {
+			case s.Missing:
+				note = "file missing"
+			case s.Modified:
+				note = "modified after apply"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, s.Name, appliedAt, note)
+		}
+		return w.Flush()
+	default:
+		flag.Usage()
+		return errors.New("unknown command")
+	}
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"liangxiong/demo/dto"
+	"liangxiong/demo/service"
+	"liangxiong/demo/utils"
+)
+
+// APIKeyController exposes API key management endpoints.
+type APIKeyController struct {
+	service *service.APIKeyService
+	logger  *zap.Logger
+}
+
+// NewAPIKeyController builds the controller.
+func NewAPIKeyController(service *service.APIKeyService, logger *zap.Logger) *APIKeyController {
+	return &APIKeyController{service: service, logger: logger}
+}
+
+// List handles GET /api-keys.
+// @Summary List API keys
+// @Description Paginated list of API keys (secrets are never returned)
+// @Tags APIKeys
+// @Security BearerAuth
+// @Produce json
+// @Param page query int false "Page number" default(1)
+// @Param size query int false "Page size" default(20)
+// @Success 200 {object} APIResponse{data=dto.APIKeyListResponse}
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Router /api/v1/api-keys [get]
+func (ctl *APIKeyController) List(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "20"))
+
+	resp, err := ctl.service.ListKeys(c.Request.Context(), page, size)
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	RespondSuccess(c, resp)
+}
+
+// Get handles GET /api-keys/:id.
+// @Summary Get API key
+// @Description Retrieve API key metadata by ID
+// @Tags APIKeys
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "API key ID"
+// @Success 200 {object} APIResponse{data=dto.APIKeyResponse}
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 404 {object} APIResponse
+// @Router /api/v1/api-keys/{id} [get]
+func (ctl *APIKeyController) Get(c *gin.Context) {
+	resp, err := ctl.service.GetKey(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	RespondSuccess(c, resp)
+}
+
+// Create handles POST /api-keys.
+// @Summary Issue API key
+// @Description Issue an API key scoped to permissions the caller holds. The key is shown only in this response.
+// @Tags APIKeys
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.APIKeyCreateRequest true "API key payload"
+// @Success 201 {object} APIResponse{data=dto.APIKeyCreatedResponse}
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Router /api/v1/api-keys [post]
+func (ctl *APIKeyController) Create(c *gin.Context) {
+	var req dto.APIKeyCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, ctl.logger, NewBindingError(err))
+		return
+	}
+
+	resp, err := ctl.service.CreateKey(c.Request.Context(), req)
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	c.JSON(http.StatusCreated, APIResponse{Code: 0, Message: "Created", Data: resp, TraceID: c.GetString(utils.GinKeyTraceID)})
+}
+
+// Revoke handles DELETE /api-keys/:id.
+// @Summary Revoke API key
+// @Description Revoke an API key immediately
+// @Tags APIKeys
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "API key ID"
+// @Success 200 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 404 {object} APIResponse
+// @Router /api/v1/api-keys/{id} [delete]
+func (ctl *APIKeyController) Revoke(c *gin.Context) {
+	if err := ctl.service.RevokeKey(c.Request.Context(), c.Param("id")); err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	RespondMessage(c, http.StatusOK, "Revoked")
+}
@@ -1,6 +1,11 @@
 package controller
 
 import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
@@ -21,7 +26,7 @@ func NewAuthController(service *service.AuthService, logger *zap.Logger) *AuthCo
 
 // Login exchanges credentials for a JWT.
 // @Summary Login
-// @Description Authenticate a user and return JWT
+// @Description Authenticate a user against a password identity provider (the default one unless provider is set) and return JWT. Users with MFA enabled get mfaRequired and an mfaToken to redeem at /auth/mfa/verify instead.
 // @Tags Auth
 // @Accept json
 // @Produce json
@@ -29,6 +34,8 @@ func NewAuthController(service *service.AuthService, logger *zap.Logger) *AuthCo
 // @Success 200 {object} APIResponse{data=dto.LoginResponse}
 // @Failure 400 {object} APIResponse
 // @Failure 401 {object} APIResponse
+// @Failure 423 {object} APIResponse "Account locked (code 423001)"
+// @Failure 429 {object} APIResponse "Login attempts throttled (code 429002)"
 // @Router /api/v1/auth/login [post]
 func (ctl *AuthController) Login(c *gin.Context) {
 	var req dto.LoginRequest
@@ -37,7 +44,146 @@ func (ctl *AuthController) Login(c *gin.Context) {
 		return
 	}
 
-	resp, err := ctl.service.Login(c.Request.Context(), req.Username, req.Password)
+	resp, err := ctl.service.Login(c.Request.Context(), req.Provider, req.Username, req.Password, c.ClientIP())
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+
+	RespondSuccess(c, resp)
+}
+
+// Providers lists login identity providers.
+// @Summary List identity providers
+// @Description Identity providers accepted at login. Password providers (local, ldap) are used through /auth/login, oidc providers through /auth/oidc/{provider}/authorize.
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} APIResponse{data=[]dto.ProviderResponse}
+// @Router /api/v1/auth/providers [get]
+func (ctl *AuthController) Providers(c *gin.Context) {
+	RespondSuccess(c, ctl.service.Providers())
+}
+
+// AuthorizeOIDC starts an OIDC login.
+// @Summary Start OIDC login
+// @Description Return the provider authorization URL to redirect the browser to, and the state the callback must echo. The response also sets the short-lived HttpOnly oidc_binding cookie, which the callback must carry: the state is only accepted from the client that started the login.
+// @Tags Auth
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Success 200 {object} APIResponse{data=dto.OIDCAuthorizeResponse}
+// @Failure 400 {object} APIResponse
+// @Failure 404 {object} APIResponse
+// @Router /api/v1/auth/oidc/{provider}/authorize [get]
+func (ctl *AuthController) AuthorizeOIDC(c *gin.Context) {
+	resp, binding, err := ctl.service.BeginOIDC(c.Request.Context(), c.Param("provider"))
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+
+	setOIDCBinding(c, binding, int(time.Until(resp.ExpiresAt).Seconds()))
+	RespondSuccess(c, resp)
+}
+
+// CallbackOIDC completes an OIDC login.
+// @Summary Complete OIDC login
+// @Description Redeem the authorization code and state returned by the provider. The request must carry the oidc_binding cookie set by the authorize call; a state presented without it is rejected with 401. Users are provisioned on first login. The response matches /auth/login.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Param request body dto.OIDCCallbackRequest true "Callback payload"
+// @Success 200 {object} APIResponse{data=dto.LoginResponse}
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 404 {object} APIResponse
+// @Failure 423 {object} APIResponse "Account locked (code 423001)"
+// @Router /api/v1/auth/oidc/{provider}/callback [post]
+func (ctl *AuthController) CallbackOIDC(c *gin.Context) {
+	var req dto.OIDCCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, ctl.logger, NewBindingError(err))
+		return
+	}
+
+	binding, _ := c.Cookie(oidcBindingCookie)
+	setOIDCBinding(c, "", -1)
+	resp, err := ctl.service.CompleteOIDC(c.Request.Context(), c.Param("provider"), req.Code, req.State, binding)
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+
+	RespondSuccess(c, resp)
+}
+
+// oidcBindingCookie holds the secret tying an OIDC state to the browser that
+// started the login.
+const oidcBindingCookie = "oidc_binding"
+
+// setOIDCBinding sets the binding cookie, or clears it when maxAge is negative.
+func setOIDCBinding(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcBindingCookie,
+		Value:    value,
+		Path:     "/api/v1/auth/oidc",
+		MaxAge:   maxAge,
+		Secure:   c.Request.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// VerifyMFA completes a two-step login.
+// @Summary Verify MFA
+// @Description Exchange the MFA challenge token from login plus a TOTP or recovery code for a token pair
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body dto.MFAVerifyRequest true "MFA payload"
+// @Success 200 {object} APIResponse{data=dto.LoginResponse}
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 423 {object} APIResponse "Account locked (code 423001)"
+// @Failure 429 {object} APIResponse "Attempts throttled (code 429002)"
+// @Failure 503 {object} APIResponse "MFA disabled on this server (code 503001)"
+// @Router /api/v1/auth/mfa/verify [post]
+func (ctl *AuthController) VerifyMFA(c *gin.Context) {
+	var req dto.MFAVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, ctl.logger, NewBindingError(err))
+		return
+	}
+
+	resp, err := ctl.service.VerifyMFA(c.Request.Context(), req.MFAToken, req.Code)
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+
+	RespondSuccess(c, resp)
+}
+
+// Refresh rotates a refresh token.
+// @Summary Refresh tokens
+// @Description Exchange a refresh token for a new access/refresh token pair. Reusing a rotated token revokes its whole family.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body dto.RefreshRequest true "Refresh payload"
+// @Success 200 {object} APIResponse{data=dto.LoginResponse}
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Router /api/v1/auth/refresh [post]
+func (ctl *AuthController) Refresh(c *gin.Context) {
+	var req dto.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, ctl.logger, NewBindingError(err))
+		return
+	}
+
+	resp, err := ctl.service.Refresh(c.Request.Context(), req.RefreshToken)
 	if err != nil {
 		RespondError(c, ctl.logger, err)
 		return
@@ -45,3 +191,115 @@ func (ctl *AuthController) Login(c *gin.Context) {
 
 	RespondSuccess(c, resp)
 }
+
+// Logout revokes the current access token.
+// @Summary Logout
+// @Description Revoke the presented access token and, optionally, its refresh token family
+// @Tags Auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.LogoutRequest false "Logout payload"
+// @Success 200 {object} APIResponse
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Router /api/v1/auth/logout [post]
+func (ctl *AuthController) Logout(c *gin.Context) {
+	var req dto.LogoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		RespondError(c, ctl.logger, NewBindingError(err))
+		return
+	}
+
+	if err := ctl.service.Logout(c.Request.Context(), req.RefreshToken); err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+
+	RespondMessage(c, http.StatusOK, "Logged out")
+}
+
+// RevokeUserTokens revokes all tokens of a user.
+// @Summary Revoke user tokens
+// @Description Revoke every access and refresh token issued to the user
+// @Tags Auth
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 404 {object} APIResponse
+// @Router /api/v1/users/{id}/tokens/revoke [post]
+func (ctl *AuthController) RevokeUserTokens(c *gin.Context) {
+	if err := ctl.service.RevokeUserTokens(c.Request.Context(), c.Param("id")); err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+
+	RespondMessage(c, http.StatusOK, "Revoked")
+}
+
+// Introspect reports the state of a token (RFC 7662).
+// @Summary Token introspection
+// @Description Validate an access or refresh token and report whether it is active, its subject, expiry and scope. The caller must hold tokens:introspect (typically an API key). The body is the bare RFC 7662 object, not the API envelope.
+// @Tags Auth
+// @Security BearerAuth || ApiKeyAuth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Token to introspect"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Success 200 {object} dto.IntrospectionResponse
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Router /api/v1/auth/introspect [post]
+func (ctl *AuthController) Introspect(c *gin.Context) {
+	var req dto.IntrospectionRequest
+	if err := c.ShouldBind(&req); err != nil {
+		RespondError(c, ctl.logger, NewBindingError(err))
+		return
+	}
+
+	resp, err := ctl.service.Introspect(c.Request.Context(), req.Token, req.TokenTypeHint)
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, resp)
+}
+
+// JWKS publishes token verification keys.
+// @Summary JSON Web Key Set
+// @Description Public keys used to verify access tokens, selected by the kid header
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} auth.JSONWebKeySet
+// @Router /.well-known/jwks.json [get]
+func (ctl *AuthController) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, ctl.service.JWKS())
+}
+
+// UnlockUser clears a login lockout.
+// @Summary Unlock user
+// @Description Clear the failed-login lockout of a user
+// @Tags Auth
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 404 {object} APIResponse
+// @Router /api/v1/users/{id}/unlock [post]
+func (ctl *AuthController) UnlockUser(c *gin.Context) {
+	if err := ctl.service.UnlockUser(c.Request.Context(), c.Param("id")); err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+
+	RespondMessage(c, http.StatusOK, "Unlocked")
+}
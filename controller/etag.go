@@ -0,0 +1,30 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"liangxiong/demo/utils"
+)
+
+// requireIfMatch returns the If-Match header of a write request, which must
+// be present so clients cannot overwrite changes they have not seen.
+func requireIfMatch(c *gin.Context) (string, error) {
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		return "", utils.Clone(utils.ErrIfMatch, map[string]string{"If-Match": "header is required"}, nil)
+	}
+	return ifMatch, nil
+}
+
+// respondNotModified sets the ETag header and, when the If-None-Match header
+// matches it, writes a bodiless 304 and reports true.
+func respondNotModified(c *gin.Context, etag string) bool {
+	c.Header("ETag", etag)
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" && utils.ETagMatches(ifNoneMatch, etag, true) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
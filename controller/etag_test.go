@@ -0,0 +1,236 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/repository"
+	"liangxiong/demo/service"
+	"liangxiong/demo/utils"
+)
+
+// conditionalCase is one request against a conditional endpoint. expect
+// registers the statements the request runs, if any.
+type conditionalCase struct {
+	name    string
+	method  string
+	path    string
+	headers map[string]string
+	body    string
+	expect  func()
+	status  int
+	etag    string
+}
+
+// runConditionalCases serves each case in order and checks its status and
+// ETag; 304 responses must have no body.
+func runConditionalCases(t *testing.T, engine *gin.Engine, mock sqlmock.Sqlmock, cases []conditionalCase) {
+	t.Helper()
+	for _, tc := range cases {
+		if tc.expect != nil {
+			tc.expect()
+		}
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		for name, value := range tc.headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+
+		if rec.Code != tc.status {
+			t.Fatalf("%s: expected %d, got %d: %s", tc.name, tc.status, rec.Code, rec.Body)
+		}
+		if etag := rec.Header().Get("ETag"); etag != tc.etag {
+			t.Fatalf("%s: expected ETag %q, got %q", tc.name, tc.etag, etag)
+		}
+		if rec.Code == http.StatusNotModified && rec.Body.Len() != 0 {
+			t.Fatalf("%s: expected an empty 304, got %s", tc.name, rec.Body)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+// withHeader returns headers plus name.
+func withHeader(headers map[string]string, name, value string) map[string]string {
+	all := map[string]string{name: value}
+	for k, v := range headers {
+		all[k] = v
+	}
+	return all
+}
+
+// asAdmin runs the request as user admin-1.
+func asAdmin(c *gin.Context) {
+	ctx := utils.WithRole(utils.WithUserID(c.Request.Context(), "admin-1"), auth.RoleAdmin)
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
+
+func TestUserConditionalRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	revocations := service.NewRevocationService(db, repository.NewRevocationRepository(db), repository.NewRefreshTokenRepository(db), time.Minute)
+	ctl := NewUserController(service.NewUserService(db, repository.NewUserRepository(db), repository.NewPasswordResetRepository(db), nil, nil, nil, revocations), zap.NewNop())
+	engine := gin.New()
+	users := engine.Group("/users", asAdmin)
+	users.GET("/:id", ctl.Get)
+	users.PUT("/:id", ctl.Update)
+	users.PATCH("/:id", ctl.Patch)
+	users.DELETE("/:id", ctl.Delete)
+
+	columns := []string{"id", "username", "email", "password_hash", "first_name", "last_name", "role", "created_at", "updated_at", "locked_until", "status", "deleted_at", "deleted_by", "row_version"}
+	userRow := func(version byte) *sqlmock.Rows {
+		now := time.Now()
+		return sqlmock.NewRows(columns).AddRow("user-1", "alice", "alice@example.org", "hash", "Alice", "L", auth.RoleViewer, now, now, nil, "active", nil, nil, []byte{version})
+	}
+	expectUser := func() {
+		mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow(1))
+	}
+	expectStale := func() {
+		mock.ExpectBegin()
+		expectUser()
+		mock.ExpectRollback()
+	}
+	expectPatch := func() {
+		mock.ExpectBegin()
+		expectUser()
+		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE users SET first_name = @p1`)).WillReturnRows(sqlmock.NewRows([]string{"row_version"}).AddRow([]byte{2}))
+		mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow(2))
+		mock.ExpectCommit()
+	}
+	update := `{"email":"alice@example.org","firstName":"Alicia","lastName":"L","role":"viewer"}`
+	merge := map[string]string{"Content-Type": "application/merge-patch+json"}
+
+	runConditionalCases(t, engine, mock, []conditionalCase{
+		{name: "get", method: http.MethodGet, path: "/users/user-1", expect: expectUser, status: http.StatusOK, etag: `"01"`},
+		{name: "get cached", method: http.MethodGet, path: "/users/user-1", headers: map[string]string{"If-None-Match": `"01"`}, expect: expectUser, status: http.StatusNotModified, etag: `"01"`},
+		{name: "get weak cached", method: http.MethodGet, path: "/users/user-1", headers: map[string]string{"If-None-Match": `"00", W/"01"`}, expect: expectUser, status: http.StatusNotModified, etag: `"01"`},
+		{name: "get outdated", method: http.MethodGet, path: "/users/user-1", headers: map[string]string{"If-None-Match": `"00"`}, expect: expectUser, status: http.StatusOK, etag: `"01"`},
+
+		{name: "put without If-Match", method: http.MethodPut, path: "/users/user-1", body: update, status: http.StatusPreconditionRequired},
+		{name: "put stale", method: http.MethodPut, path: "/users/user-1", headers: map[string]string{"If-Match": `"00"`}, body: update, expect: expectStale, status: http.StatusPreconditionFailed},
+		{name: "put", method: http.MethodPut, path: "/users/user-1", headers: map[string]string{"If-Match": `"01"`}, body: update, expect: func() {
+			mock.ExpectBegin()
+			expectUser()
+			mock.ExpectQuery(regexp.QuoteMeta(`UPDATE users SET email = @p1`)).
+				WithArgs("alice@example.org", "Alicia", "L", auth.RoleViewer, sqlmock.AnyArg(), "user-1", []byte{1}).
+				WillReturnRows(sqlmock.NewRows([]string{"row_version"}).AddRow([]byte{2}))
+			mock.ExpectCommit()
+		}, status: http.StatusOK, etag: `"02"`},
+
+		// If-Match is optional on PATCH.
+		{name: "patch without If-Match", method: http.MethodPatch, path: "/users/user-1", headers: merge, body: `{"firstName":"Alicia"}`, expect: expectPatch, status: http.StatusOK, etag: `"02"`},
+		{name: "patch stale", method: http.MethodPatch, path: "/users/user-1", headers: withHeader(merge, "If-Match", `"00"`), body: `{"firstName":"Alicia"}`, expect: expectStale, status: http.StatusPreconditionFailed},
+		{name: "patch", method: http.MethodPatch, path: "/users/user-1", headers: withHeader(merge, "If-Match", `"01"`), body: `{"firstName":"Alicia"}`, expect: expectPatch, status: http.StatusOK, etag: `"02"`},
+
+		{name: "delete without If-Match", method: http.MethodDelete, path: "/users/user-1", status: http.StatusPreconditionRequired},
+		{name: "delete stale", method: http.MethodDelete, path: "/users/user-1", headers: map[string]string{"If-Match": `"00"`}, expect: expectStale, status: http.StatusPreconditionFailed},
+		{name: "delete", method: http.MethodDelete, path: "/users/user-1", headers: map[string]string{"If-Match": `"01"`}, expect: func() {
+			mock.ExpectBegin()
+			expectUser()
+			mock.ExpectQuery(regexp.QuoteMeta(`UPDATE users SET status = @p1`)).WillReturnRows(sqlmock.NewRows([]string{"row_version"}).AddRow([]byte{2}))
+			mock.ExpectExec(`UPDATE password_reset_tokens SET used_at`).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`SAVE TRANSACTION sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`MERGE user_token_revocations`).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery(`FROM user_token_revocations`).
+				WillReturnRows(sqlmock.NewRows([]string{"user_id", "revoked_before", "generation"}).AddRow("user-1", time.Now(), 1))
+			mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at`).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}, status: http.StatusOK},
+	})
+}
+
+func TestExchangeConditionalRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	ctl := NewExchangeController(service.NewExchangeService(db, repository.NewExchangeRepository(db), nil), zap.NewNop())
+	engine := gin.New()
+	exchanges := engine.Group("/exchanges", asAdmin)
+	exchanges.GET("/:code", ctl.Get)
+	exchanges.PUT("/:code", ctl.Update)
+	exchanges.PATCH("/:code", ctl.Patch)
+	exchanges.DELETE("/:code", ctl.Delete)
+
+	columns := []string{"MQMExchangeCode", "ClearExchangeCode", "GlobexExchangeCode", "Description", "SegType", "RowVersion"}
+	exchangeRow := func(version byte) *sqlmock.Rows {
+		return sqlmock.NewRows(columns).AddRow("CME", "CME", "XCME", nil, "F", []byte{version})
+	}
+	expectExchange := func() {
+		mock.ExpectQuery(`FROM TExchange WHERE MQMExchangeCode = @p1`).WithArgs("CME").WillReturnRows(exchangeRow(1))
+	}
+	expectStale := func() {
+		mock.ExpectBegin()
+		expectExchange()
+		mock.ExpectRollback()
+	}
+	expectPatch := func() {
+		mock.ExpectBegin()
+		expectExchange()
+		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE TExchange SET Description = @p1`)).WillReturnRows(sqlmock.NewRows([]string{"RowVersion"}).AddRow([]byte{2}))
+		mock.ExpectQuery(`FROM TExchange WHERE MQMExchangeCode = @p1`).WithArgs("CME").WillReturnRows(exchangeRow(2))
+		mock.ExpectCommit()
+	}
+	update := `{"clearExchangeCode":"CME","globexExchangeCode":"XCME","description":"CME Globex","segType":"F"}`
+	patch := map[string]string{"Content-Type": "application/json-patch+json"}
+	patchBody := `[{"op":"add","path":"/description","value":"CME Globex"}]`
+
+	runConditionalCases(t, engine, mock, []conditionalCase{
+		{name: "get", method: http.MethodGet, path: "/exchanges/CME", expect: expectExchange, status: http.StatusOK, etag: `"01"`},
+		{name: "get cached", method: http.MethodGet, path: "/exchanges/CME", headers: map[string]string{"If-None-Match": `"01"`}, expect: expectExchange, status: http.StatusNotModified, etag: `"01"`},
+		{name: "get any", method: http.MethodGet, path: "/exchanges/CME", headers: map[string]string{"If-None-Match": `*`}, expect: expectExchange, status: http.StatusNotModified, etag: `"01"`},
+		{name: "get outdated", method: http.MethodGet, path: "/exchanges/CME", headers: map[string]string{"If-None-Match": `"00"`}, expect: expectExchange, status: http.StatusOK, etag: `"01"`},
+
+		{name: "put without If-Match", method: http.MethodPut, path: "/exchanges/CME", body: update, status: http.StatusPreconditionRequired},
+		{name: "put stale", method: http.MethodPut, path: "/exchanges/CME", headers: map[string]string{"If-Match": `"00"`}, body: update, expect: expectStale, status: http.StatusPreconditionFailed},
+		{name: "put", method: http.MethodPut, path: "/exchanges/CME", headers: map[string]string{"If-Match": `"01"`}, body: update, expect: func() {
+			mock.ExpectBegin()
+			expectExchange()
+			mock.ExpectQuery(regexp.QuoteMeta(`UPDATE TExchange SET ClearExchangeCode = @p1`)).
+				WithArgs("CME", "XCME", "CME Globex", "F", "CME", []byte{1}).
+				WillReturnRows(sqlmock.NewRows([]string{"RowVersion"}).AddRow([]byte{2}))
+			mock.ExpectCommit()
+		}, status: http.StatusOK, etag: `"02"`},
+
+		// If-Match is optional on PATCH.
+		{name: "patch without If-Match", method: http.MethodPatch, path: "/exchanges/CME", headers: patch, body: patchBody, expect: expectPatch, status: http.StatusOK, etag: `"02"`},
+		{name: "patch stale", method: http.MethodPatch, path: "/exchanges/CME", headers: withHeader(patch, "If-Match", `"00"`), body: patchBody, expect: expectStale, status: http.StatusPreconditionFailed},
+		{name: "patch", method: http.MethodPatch, path: "/exchanges/CME", headers: withHeader(patch, "If-Match", `"01"`), body: patchBody, expect: expectPatch, status: http.StatusOK, etag: `"02"`},
+
+		{name: "delete without If-Match", method: http.MethodDelete, path: "/exchanges/CME", status: http.StatusPreconditionRequired},
+		{name: "delete stale", method: http.MethodDelete, path: "/exchanges/CME", headers: map[string]string{"If-Match": `"00"`}, expect: expectStale, status: http.StatusPreconditionFailed},
+		// The row changing between the read and the DELETE is caught too.
+		{name: "delete raced", method: http.MethodDelete, path: "/exchanges/CME", headers: map[string]string{"If-Match": `"01"`}, expect: func() {
+			mock.ExpectBegin()
+			expectExchange()
+			mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM TExchange WHERE MQMExchangeCode = @p1 AND RowVersion = @p2`)).WithArgs("CME", []byte{1}).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectRollback()
+		}, status: http.StatusPreconditionFailed},
+		{name: "delete", method: http.MethodDelete, path: "/exchanges/CME", headers: map[string]string{"If-Match": `"01"`}, expect: func() {
+			mock.ExpectBegin()
+			expectExchange()
+			mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM TExchange WHERE MQMExchangeCode = @p1 AND RowVersion = @p2`)).WithArgs("CME", []byte{1}).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}, status: http.StatusOK},
+	})
+}
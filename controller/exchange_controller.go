@@ -2,7 +2,6 @@ package controller
 
 import (
 	"net/http"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -25,20 +24,60 @@ func NewExchangeController(service *service.ExchangeService, logger *zap.Logger)
 
 // List handles GET /exchanges.
 // @Summary List exchanges
-// @Description Paginated list of exchanges
+// @Description Paginated list of exchanges, optionally filtered. The total counts all matching exchanges; nextCursor continues with keyset pagination.
 // @Tags Exchanges
-// @Security BearerAuth
+// @Security BearerAuth || ApiKeyAuth
 // @Produce json
 // @Param page query int false "Page number" default(1)
 // @Param size query int false "Page size" default(20)
+// @Param clearCode query string false "Exact clearing exchange code"
+// @Param globexCode query string false "Exact Globex exchange code"
+// @Param segType query string false "Exact segment type"
+// @Param search query string false "Substring of the description"
+// @Param cursor query string false "nextCursor of the previous page; replaces page"
+// @Param skipTotal query bool false "Omit the total count" default(false)
 // @Success 200 {object} APIResponse{data=dto.ExchangeListResponse}
+// @Failure 400 {object} APIResponse
 // @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
 // @Router /api/v1/exchanges [get]
 func (ctl *ExchangeController) List(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	size, _ := strconv.Atoi(c.DefaultQuery("size", "20"))
+	var query dto.ExchangeListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		RespondError(c, ctl.logger, NewBindingError(err))
+		return
+	}
+
+	resp, err := ctl.service.ListExchanges(c.Request.Context(), query)
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	RespondSuccess(c, resp)
+}
+
+// Translate handles GET /exchanges/translate.
+// @Summary Translate exchange code
+// @Description Map an MQM, clearing or Globex code to the codes of every exchange it identifies
+// @Tags Exchanges
+// @Security BearerAuth || ApiKeyAuth
+// @Produce json
+// @Param from query string true "Scheme of the code" Enums(mqm, clear, globex)
+// @Param code query string true "Exchange code"
+// @Success 200 {object} APIResponse{data=dto.ExchangeTranslationResponse}
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 404 {object} APIResponse
+// @Router /api/v1/exchanges/translate [get]
+func (ctl *ExchangeController) Translate(c *gin.Context) {
+	var query dto.ExchangeTranslateQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		RespondError(c, ctl.logger, NewBindingError(err))
+		return
+	}
 
-	resp, err := ctl.service.ListExchanges(c.Request.Context(), page, size)
+	resp, err := ctl.service.TranslateCode(c.Request.Context(), query)
 	if err != nil {
 		RespondError(c, ctl.logger, err)
 		return
@@ -50,11 +89,15 @@ func (ctl *ExchangeController) List(c *gin.Context) {
 // @Summary Get exchange
 // @Description Retrieve an exchange by MQM code
 // @Tags Exchanges
-// @Security BearerAuth
+// @Security BearerAuth || ApiKeyAuth
 // @Produce json
 // @Param code path string true "MQM Exchange Code"
+// @Param If-None-Match header string false "ETag of a cached copy"
 // @Success 200 {object} APIResponse{data=dto.ExchangeResponse}
+// @Header 200 {string} ETag "Current version"
+// @Success 304 "Cached copy is current"
 // @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
 // @Failure 404 {object} APIResponse
 // @Router /api/v1/exchanges/{code} [get]
 func (ctl *ExchangeController) Get(c *gin.Context) {
@@ -64,6 +107,9 @@ func (ctl *ExchangeController) Get(c *gin.Context) {
 		RespondError(c, ctl.logger, err)
 		return
 	}
+	if respondNotModified(c, resp.ETag) {
+		return
+	}
 	RespondSuccess(c, resp)
 }
 
@@ -71,13 +117,16 @@ func (ctl *ExchangeController) Get(c *gin.Context) {
 // @Summary Create exchange
 // @Description Create a new exchange
 // @Tags Exchanges
-// @Security BearerAuth
+// @Security BearerAuth || ApiKeyAuth
 // @Accept json
 // @Produce json
 // @Param request body dto.ExchangeCreateRequest true "Exchange payload"
 // @Success 201 {object} APIResponse{data=dto.ExchangeResponse}
+// @Header 201 {string} ETag "Current version"
 // @Failure 400 {object} APIResponse
 // @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 409 {object} APIResponse
 // @Router /api/v1/exchanges [post]
 func (ctl *ExchangeController) Create(c *gin.Context) {
 	var req dto.ExchangeCreateRequest
@@ -90,6 +139,7 @@ func (ctl *ExchangeController) Create(c *gin.Context) {
 		RespondError(c, ctl.logger, err)
 		return
 	}
+	c.Header("ETag", resp.ETag)
 	c.JSON(http.StatusCreated, APIResponse{Code: 0, Message: "Created", Data: resp, TraceID: c.GetString(utils.GinKeyTraceID)})
 }
 
@@ -97,28 +147,74 @@ func (ctl *ExchangeController) Create(c *gin.Context) {
 // @Summary Update exchange
 // @Description Update an exchange record
 // @Tags Exchanges
-// @Security BearerAuth
+// @Security BearerAuth || ApiKeyAuth
 // @Accept json
 // @Produce json
 // @Param code path string true "MQM Exchange Code"
+// @Param If-Match header string true "ETag the update is based on"
 // @Param request body dto.ExchangeUpdateRequest true "Exchange payload"
 // @Success 200 {object} APIResponse{data=dto.ExchangeResponse}
+// @Header 200 {string} ETag "New version"
 // @Failure 400 {object} APIResponse
 // @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
 // @Failure 404 {object} APIResponse
+// @Failure 412 {object} APIResponse
+// @Failure 428 {object} APIResponse
 // @Router /api/v1/exchanges/{code} [put]
 func (ctl *ExchangeController) Update(c *gin.Context) {
 	code := c.Param("code")
+	ifMatch, err := requireIfMatch(c)
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
 	var req dto.ExchangeUpdateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		RespondError(c, ctl.logger, NewBindingError(err))
 		return
 	}
-	resp, err := ctl.service.UpdateExchange(c.Request.Context(), code, req)
+	resp, err := ctl.service.UpdateExchange(c.Request.Context(), code, ifMatch, req)
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	c.Header("ETag", resp.ETag)
+	RespondSuccess(c, resp)
+}
+
+// Patch handles PATCH /exchanges/:code.
+// @Summary Patch exchange
+// @Description Change some fields of an exchange with a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) applied to the update payload. The result must be a valid update payload; only changed fields are written.
+// @Tags Exchanges
+// @Security BearerAuth || ApiKeyAuth
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
+// @Produce json
+// @Param code path string true "MQM Exchange Code"
+// @Param If-Match header string false "ETag the patch is based on"
+// @Param request body dto.ExchangeUpdateRequest true "Merge patch with the fields to change, or a list of JSON Patch operations"
+// @Success 200 {object} APIResponse{data=dto.ExchangeResponse}
+// @Header 200 {string} ETag "New version"
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 404 {object} APIResponse
+// @Failure 412 {object} APIResponse
+// @Failure 415 {object} APIResponse
+// @Router /api/v1/exchanges/{code} [patch]
+func (ctl *ExchangeController) Patch(c *gin.Context) {
+	patch, err := c.GetRawData()
+	if err != nil {
+		RespondError(c, ctl.logger, NewBindingError(err))
+		return
+	}
+	resp, err := ctl.service.PatchExchange(c.Request.Context(), c.Param("code"), c.GetHeader("If-Match"), c.ContentType(), patch)
 	if err != nil {
 		RespondError(c, ctl.logger, err)
 		return
 	}
+	c.Header("ETag", resp.ETag)
 	RespondSuccess(c, resp)
 }
 
@@ -126,16 +222,25 @@ func (ctl *ExchangeController) Update(c *gin.Context) {
 // @Summary Delete exchange
 // @Description Delete an exchange by MQM code
 // @Tags Exchanges
-// @Security BearerAuth
+// @Security BearerAuth || ApiKeyAuth
 // @Produce json
 // @Param code path string true "MQM Exchange Code"
+// @Param If-Match header string true "ETag the deletion is based on"
 // @Success 200 {object} APIResponse
 // @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
 // @Failure 404 {object} APIResponse
+// @Failure 412 {object} APIResponse
+// @Failure 428 {object} APIResponse
 // @Router /api/v1/exchanges/{code} [delete]
 func (ctl *ExchangeController) Delete(c *gin.Context) {
 	code := c.Param("code")
-	if err := ctl.service.DeleteExchange(c.Request.Context(), code); err != nil {
+	ifMatch, err := requireIfMatch(c)
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	if err := ctl.service.DeleteExchange(c.Request.Context(), code, ifMatch); err != nil {
 		RespondError(c, ctl.logger, err)
 		return
 	}
@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"liangxiong/demo/dto"
+	"liangxiong/demo/service"
+	"liangxiong/demo/utils"
+)
+
+// ImpersonationController exposes support impersonation endpoints.
+type ImpersonationController struct {
+	service *service.ImpersonationService
+	logger  *zap.Logger
+}
+
+// NewImpersonationController builds the controller.
+func NewImpersonationController(service *service.ImpersonationService, logger *zap.Logger) *ImpersonationController {
+	return &ImpersonationController{service: service, logger: logger}
+}
+
+// Impersonate handles POST /users/:id/impersonate.
+// @Summary Impersonate user
+// @Description Issue a short-lived, non-refreshable access token for the user. The token carries the caller in its act claim; credential, MFA and API key endpoints reject it, and every request made with it is logged.
+// @Tags Users
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body dto.ImpersonateRequest true "Impersonation payload"
+// @Success 201 {object} APIResponse{data=dto.ImpersonationTokenResponse}
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse "Forbidden, or already impersonating (code 403003)"
+// @Failure 404 {object} APIResponse
+// @Router /api/v1/users/{id}/impersonate [post]
+func (ctl *ImpersonationController) Impersonate(c *gin.Context) {
+	var req dto.ImpersonateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, ctl.logger, NewBindingError(err))
+		return
+	}
+
+	resp, err := ctl.service.Impersonate(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, APIResponse{Code: 0, Message: "Created", Data: resp, TraceID: c.GetString(utils.GinKeyTraceID)})
+}
+
+// List handles GET /users/:id/impersonations.
+// @Summary List impersonations
+// @Description Audit trail of impersonation tokens issued for the user, newest first
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "User ID"
+// @Param page query int false "Page number" default(1)
+// @Param size query int false "Page size" default(20)
+// @Success 200 {object} APIResponse{data=dto.ImpersonationListResponse}
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Router /api/v1/users/{id}/impersonations [get]
+func (ctl *ImpersonationController) List(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "20"))
+
+	resp, err := ctl.service.ListImpersonations(c.Request.Context(), c.Param("id"), page, size)
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	RespondSuccess(c, resp)
+}
@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"liangxiong/demo/dto"
+	"liangxiong/demo/service"
+)
+
+// MFAController exposes TOTP enrolment and management endpoints.
+type MFAController struct {
+	service *service.MFAService
+	logger  *zap.Logger
+}
+
+// NewMFAController builds the controller.
+func NewMFAController(service *service.MFAService, logger *zap.Logger) *MFAController {
+	return &MFAController{service: service, logger: logger}
+}
+
+// BeginEnrollment handles POST /users/me/mfa/totp.
+// @Summary Start TOTP enrollment
+// @Description Generate a TOTP secret and otpauth URI for the caller. The enrollment is inactive until confirmed.
+// @Tags MFA
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} APIResponse{data=dto.TOTPEnrollmentResponse}
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 503 {object} APIResponse "MFA disabled on this server (code 503001)"
+// @Router /api/v1/users/me/mfa/totp [post]
+func (ctl *MFAController) BeginEnrollment(c *gin.Context) {
+	resp, err := ctl.service.BeginEnrollment(c.Request.Context())
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	RespondSuccess(c, resp)
+}
+
+// ConfirmEnrollment handles POST /users/me/mfa/totp/confirm.
+// @Summary Confirm TOTP enrollment
+// @Description Activate the pending enrollment with a TOTP code and return one-time recovery codes. Log in again to obtain an unrestricted token.
+// @Tags MFA
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.MFACodeRequest true "TOTP code"
+// @Success 200 {object} APIResponse{data=dto.RecoveryCodesResponse}
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 429 {object} APIResponse
+// @Failure 503 {object} APIResponse "MFA disabled on this server (code 503001)"
+// @Router /api/v1/users/me/mfa/totp/confirm [post]
+func (ctl *MFAController) ConfirmEnrollment(c *gin.Context) {
+	var req dto.MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, ctl.logger, NewBindingError(err))
+		return
+	}
+	resp, err := ctl.service.ConfirmEnrollment(c.Request.Context(), req)
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	RespondSuccess(c, resp)
+}
+
+// RegenerateRecoveryCodes handles POST /users/me/mfa/recovery-codes.
+// @Summary Regenerate recovery codes
+// @Description Replace the caller's recovery codes after checking a TOTP code
+// @Tags MFA
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.MFACodeRequest true "TOTP code"
+// @Success 200 {object} APIResponse{data=dto.RecoveryCodesResponse}
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 429 {object} APIResponse
+// @Failure 503 {object} APIResponse "MFA disabled on this server (code 503001)"
+// @Router /api/v1/users/me/mfa/recovery-codes [post]
+func (ctl *MFAController) RegenerateRecoveryCodes(c *gin.Context) {
+	var req dto.MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, ctl.logger, NewBindingError(err))
+		return
+	}
+	resp, err := ctl.service.RegenerateRecoveryCodes(c.Request.Context(), req)
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	RespondSuccess(c, resp)
+}
+
+// Disable handles DELETE /users/me/mfa.
+// @Summary Disable MFA
+// @Description Remove the caller's enrollment using a TOTP or recovery code. Not allowed for roles that require MFA.
+// @Tags MFA
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.MFACodeRequest true "TOTP or recovery code"
+// @Success 200 {object} APIResponse
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 429 {object} APIResponse
+// @Failure 503 {object} APIResponse "MFA disabled on this server (code 503001)"
+// @Router /api/v1/users/me/mfa [delete]
+func (ctl *MFAController) Disable(c *gin.Context) {
+	var req dto.MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, ctl.logger, NewBindingError(err))
+		return
+	}
+	if err := ctl.service.Disable(c.Request.Context(), req); err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	RespondMessage(c, http.StatusOK, "MFA disabled")
+}
+
+// Reset handles DELETE /users/:id/mfa.
+// @Summary Reset user MFA
+// @Description Remove the MFA enrollment of a user who lost their authenticator
+// @Tags MFA
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 404 {object} APIResponse
+// @Router /api/v1/users/{id}/mfa [delete]
+func (ctl *MFAController) Reset(c *gin.Context) {
+	if err := ctl.service.Reset(c.Request.Context(), c.Param("id")); err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	RespondMessage(c, http.StatusOK, "MFA reset")
+}
@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"liangxiong/demo/dto"
+	"liangxiong/demo/service"
+	"liangxiong/demo/utils"
+)
+
+// PasswordController exposes password change and reset endpoints.
+type PasswordController struct {
+	service *service.PasswordService
+	logger  *zap.Logger
+}
+
+// NewPasswordController builds the controller.
+func NewPasswordController(service *service.PasswordService, logger *zap.Logger) *PasswordController {
+	return &PasswordController{service: service, logger: logger}
+}
+
+// ChangePassword handles PUT /users/me/password.
+// @Summary Change own password
+// @Description Replace the caller's password. Existing tokens are revoked, so the client must log in again. Wrong current passwords count towards the account lockout.
+// @Tags Users
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.ChangePasswordRequest true "Password payload"
+// @Success 200 {object} APIResponse
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 423 {object} APIResponse "Account locked (code 423001)"
+// @Failure 429 {object} APIResponse "Attempts throttled (code 429002)"
+// @Router /api/v1/users/me/password [put]
+func (ctl *PasswordController) ChangePassword(c *gin.Context) {
+	var req dto.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, ctl.logger, NewBindingError(err))
+		return
+	}
+
+	if err := ctl.service.ChangePassword(c.Request.Context(), req); err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+
+	RespondMessage(c, http.StatusOK, "Password changed")
+}
+
+// IssueReset handles POST /users/:id/password-reset.
+// @Summary Issue password reset
+// @Description Issue a one-time password reset token for an active user
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 201 {object} APIResponse{data=dto.PasswordResetResponse}
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 404 {object} APIResponse
+// @Router /api/v1/users/{id}/password-reset [post]
+func (ctl *PasswordController) IssueReset(c *gin.Context) {
+	resp, err := ctl.service.IssueReset(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	c.JSON(http.StatusCreated, APIResponse{Code: 0, Message: "Created", Data: resp, TraceID: c.GetString(utils.GinKeyTraceID)})
+}
+
+// ResetPassword handles POST /auth/password/reset.
+// @Summary Reset password
+// @Description Set a new password using a one-time reset token. Tokens of disabled or deleted users are refused.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body dto.ResetPasswordRequest true "Reset payload"
+// @Success 200 {object} APIResponse
+// @Failure 400 {object} APIResponse
+// @Router /api/v1/auth/password/reset [post]
+func (ctl *PasswordController) ResetPassword(c *gin.Context) {
+	var req dto.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, ctl.logger, NewBindingError(err))
+		return
+	}
+
+	if err := ctl.service.ResetPassword(c.Request.Context(), req); err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+
+	RespondMessage(c, http.StatusOK, "Password reset")
+}
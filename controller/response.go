@@ -6,6 +6,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
+	"liangxiong/demo/internal/dialect"
 	"liangxiong/demo/utils"
 )
 
@@ -30,7 +31,9 @@ func RespondMessage(c *gin.Context, status int, message string) {
 	c.JSON(status, APIResponse{Code: 0, Message: message, TraceID: traceID})
 }
 
-// RespondError rewrites application errors with code + message.
+// RespondError rewrites application errors with code + message. Database
+// errors left unmapped by the services answer 409 for constraint violations
+// and 503 for transient failures that outlasted the retries.
 func RespondError(c *gin.Context, logger *zap.Logger, err error) {
 	traceID := c.GetString(utils.GinKeyTraceID)
 	if appErr, ok := utils.IsAppError(err); ok {
@@ -38,6 +41,16 @@ func RespondError(c *gin.Context, logger *zap.Logger, err error) {
 		return
 	}
 
+	switch kind := dialect.Classify(err); {
+	case kind == dialect.ErrorUniqueViolation || kind == dialect.ErrorForeignKeyViolation:
+		c.JSON(http.StatusConflict, APIResponse{Code: utils.ErrConflict.Code, Message: utils.ErrConflict.Message, TraceID: traceID})
+		return
+	case kind.Transient():
+		logger.Warn("transient database error", zap.Error(err), zap.String("traceId", traceID))
+		c.JSON(http.StatusServiceUnavailable, APIResponse{Code: utils.ErrUnavailable.Code, Message: utils.ErrUnavailable.Message, TraceID: traceID})
+		return
+	}
+
 	logger.Error("unhandled error", zap.Error(err), zap.String("traceId", traceID))
 	c.JSON(http.StatusInternalServerError, APIResponse{Code: utils.ErrInternal.Code, Message: utils.ErrInternal.Message, TraceID: traceID})
 }
@@ -2,7 +2,6 @@ package controller
 
 import (
 	"net/http"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -25,20 +24,35 @@ func NewUserController(service *service.UserService, logger *zap.Logger) *UserCo
 
 // List handles GET /users.
 // @Summary List users
-// @Description Paginated list of users
+// @Description Paginated list of users, optionally filtered and sorted. The total counts all matching users; nextCursor continues with keyset pagination.
 // @Tags Users
 // @Security BearerAuth
 // @Produce json
 // @Param page query int false "Page number" default(1)
 // @Param size query int false "Page size" default(20)
+// @Param status query string false "Account status; all includes disabled and deleted users" Enums(active, disabled, deleted, all) default(active)
+// @Param role query string false "Exact role"
+// @Param username query string false "Username prefix"
+// @Param email query string false "Email prefix"
+// @Param name query string false "Substring of first or last name"
+// @Param createdFrom query string false "Created at or after (RFC 3339)"
+// @Param createdTo query string false "Created before (RFC 3339)"
+// @Param sort query string false "Comma-separated sort fields, '-' for descending: username, email, firstName, lastName, role, createdAt, updatedAt" default(-createdAt)
+// @Param cursor query string false "nextCursor of the previous page; replaces page"
+// @Param skipTotal query bool false "Omit the total count" default(false)
 // @Success 200 {object} APIResponse{data=dto.UserListResponse}
+// @Failure 400 {object} APIResponse
 // @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
 // @Router /api/v1/users [get]
 func (ctl *UserController) List(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	size, _ := strconv.Atoi(c.DefaultQuery("size", "20"))
+	var query dto.UserListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		RespondError(c, ctl.logger, NewBindingError(err))
+		return
+	}
 
-	resp, err := ctl.service.ListUsers(c.Request.Context(), page, size)
+	resp, err := ctl.service.ListUsers(c.Request.Context(), query)
 	if err != nil {
 		RespondError(c, ctl.logger, err)
 		return
@@ -53,8 +67,12 @@ func (ctl *UserController) List(c *gin.Context) {
 // @Security BearerAuth
 // @Produce json
 // @Param id path string true "User ID"
+// @Param If-None-Match header string false "ETag of a cached copy"
 // @Success 200 {object} APIResponse{data=dto.UserResponse}
+// @Header 200 {string} ETag "Current version"
+// @Success 304 "Cached copy is current"
 // @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
 // @Failure 404 {object} APIResponse
 // @Router /api/v1/users/{id} [get]
 func (ctl *UserController) Get(c *gin.Context) {
@@ -64,6 +82,9 @@ func (ctl *UserController) Get(c *gin.Context) {
 		RespondError(c, ctl.logger, err)
 		return
 	}
+	if respondNotModified(c, resp.ETag) {
+		return
+	}
 	RespondSuccess(c, resp)
 }
 
@@ -76,8 +97,11 @@ func (ctl *UserController) Get(c *gin.Context) {
 // @Produce json
 // @Param request body dto.UserCreateRequest true "User payload"
 // @Success 201 {object} APIResponse{data=dto.UserResponse}
+// @Header 201 {string} ETag "Current version"
 // @Failure 400 {object} APIResponse
 // @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 409 {object} APIResponse
 // @Router /api/v1/users [post]
 func (ctl *UserController) Create(c *gin.Context) {
 	var req dto.UserCreateRequest
@@ -90,6 +114,7 @@ func (ctl *UserController) Create(c *gin.Context) {
 		RespondError(c, ctl.logger, err)
 		return
 	}
+	c.Header("ETag", resp.ETag)
 	c.JSON(http.StatusCreated, APIResponse{Code: 0, Message: "Created", Data: resp, TraceID: c.GetString(utils.GinKeyTraceID)})
 }
 
@@ -101,43 +126,213 @@ func (ctl *UserController) Create(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param id path string true "User ID"
+// @Param If-Match header string true "ETag the update is based on"
 // @Param request body dto.UserUpdateRequest true "User payload"
 // @Success 200 {object} APIResponse{data=dto.UserResponse}
+// @Header 200 {string} ETag "New version"
 // @Failure 400 {object} APIResponse
 // @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
 // @Failure 404 {object} APIResponse
+// @Failure 412 {object} APIResponse
+// @Failure 428 {object} APIResponse
 // @Router /api/v1/users/{id} [put]
 func (ctl *UserController) Update(c *gin.Context) {
 	id := c.Param("id")
+	ifMatch, err := requireIfMatch(c)
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
 	var req dto.UserUpdateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		RespondError(c, ctl.logger, NewBindingError(err))
 		return
 	}
-	resp, err := ctl.service.UpdateUser(c.Request.Context(), id, req)
+	resp, err := ctl.service.UpdateUser(c.Request.Context(), id, ifMatch, req)
 	if err != nil {
 		RespondError(c, ctl.logger, err)
 		return
 	}
+	c.Header("ETag", resp.ETag)
+	RespondSuccess(c, resp)
+}
+
+// Patch handles PATCH /users/:id.
+// @Summary Patch user
+// @Description Change some fields of a user with a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) applied to the update payload. The result must be a valid update payload; only changed fields are written.
+// @Tags Users
+// @Security BearerAuth
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param If-Match header string false "ETag the patch is based on"
+// @Param request body dto.UserUpdateRequest true "Merge patch with the fields to change, or a list of JSON Patch operations"
+// @Success 200 {object} APIResponse{data=dto.UserResponse}
+// @Header 200 {string} ETag "New version"
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 404 {object} APIResponse
+// @Failure 412 {object} APIResponse
+// @Failure 415 {object} APIResponse
+// @Router /api/v1/users/{id} [patch]
+func (ctl *UserController) Patch(c *gin.Context) {
+	patch, err := c.GetRawData()
+	if err != nil {
+		RespondError(c, ctl.logger, NewBindingError(err))
+		return
+	}
+	resp, err := ctl.service.PatchUser(c.Request.Context(), c.Param("id"), c.GetHeader("If-Match"), c.ContentType(), patch)
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	c.Header("ETag", resp.ETag)
 	RespondSuccess(c, resp)
 }
 
 // Delete handles DELETE /users/:id.
 // @Summary Delete user
-// @Description Delete a user by ID
+// @Description Soft-delete a user by ID: the account is kept for history, can no longer sign in and can be restored. Its tokens are revoked.
 // @Tags Users
 // @Security BearerAuth
 // @Produce json
 // @Param id path string true "User ID"
+// @Param If-Match header string true "ETag the deletion is based on"
 // @Success 200 {object} APIResponse
+// @Failure 400 {object} APIResponse
 // @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
 // @Failure 404 {object} APIResponse
+// @Failure 412 {object} APIResponse
+// @Failure 428 {object} APIResponse
 // @Router /api/v1/users/{id} [delete]
 func (ctl *UserController) Delete(c *gin.Context) {
 	id := c.Param("id")
-	if err := ctl.service.DeleteUser(c.Request.Context(), id); err != nil {
+	ifMatch, err := requireIfMatch(c)
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	if err := ctl.service.DeleteUser(c.Request.Context(), id, ifMatch); err != nil {
 		RespondError(c, ctl.logger, err)
 		return
 	}
 	RespondMessage(c, http.StatusOK, "Deleted")
 }
+
+// Disable handles POST /users/:id/disable.
+// @Summary Disable user
+// @Description Block an active user from signing in and revoke their tokens
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} APIResponse{data=dto.UserResponse}
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 404 {object} APIResponse
+// @Router /api/v1/users/{id}/disable [post]
+func (ctl *UserController) Disable(c *gin.Context) {
+	resp, err := ctl.service.DisableUser(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	RespondSuccess(c, resp)
+}
+
+// Enable handles POST /users/:id/enable.
+// @Summary Enable user
+// @Description Allow a disabled user to sign in again
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} APIResponse{data=dto.UserResponse}
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 404 {object} APIResponse
+// @Router /api/v1/users/{id}/enable [post]
+func (ctl *UserController) Enable(c *gin.Context) {
+	resp, err := ctl.service.EnableUser(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	RespondSuccess(c, resp)
+}
+
+// Restore handles POST /users/:id/restore.
+// @Summary Restore user
+// @Description Reactivate a soft-deleted user
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} APIResponse{data=dto.UserResponse}
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 404 {object} APIResponse
+// @Router /api/v1/users/{id}/restore [post]
+func (ctl *UserController) Restore(c *gin.Context) {
+	resp, err := ctl.service.RestoreUser(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	RespondSuccess(c, resp)
+}
+
+// Me handles GET /users/me.
+// @Summary Current user
+// @Description Return the authenticated caller with their effective role and permissions
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} APIResponse{data=dto.ProfileResponse}
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 404 {object} APIResponse
+// @Router /api/v1/users/me [get]
+func (ctl *UserController) Me(c *gin.Context) {
+	resp, err := ctl.service.GetProfile(c.Request.Context())
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	RespondSuccess(c, resp)
+}
+
+// UpdateMe handles PATCH /users/me.
+// @Summary Update current user
+// @Description Edit the caller's own email and names without admin rights
+// @Tags Users
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.ProfileUpdateRequest true "Profile payload"
+// @Success 200 {object} APIResponse{data=dto.ProfileResponse}
+// @Failure 400 {object} APIResponse
+// @Failure 401 {object} APIResponse
+// @Failure 403 {object} APIResponse
+// @Failure 404 {object} APIResponse
+// @Router /api/v1/users/me [patch]
+func (ctl *UserController) UpdateMe(c *gin.Context) {
+	var req dto.ProfileUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		RespondError(c, ctl.logger, NewBindingError(err))
+		return
+	}
+	resp, err := ctl.service.UpdateProfile(c.Request.Context(), req)
+	if err != nil {
+		RespondError(c, ctl.logger, err)
+		return
+	}
+	RespondSuccess(c, resp)
+}
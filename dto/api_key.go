@@ -0,0 +1,35 @@
+package dto
+
+import "time"
+
+// APIKeyCreateRequest issues a machine credential scoped to permissions.
+type APIKeyCreateRequest struct {
+	Name      string     `json:"name" binding:"required,max=100"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1,dive,required,max=50"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// APIKeyResponse describes an API key without its secret.
+type APIKeyResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  string     `json:"createdBy"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// APIKeyCreatedResponse carries the full key. It is returned only once.
+type APIKeyCreatedResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
+
+// APIKeyListResponse wraps a paginated list.
+type APIKeyListResponse struct {
+	Total int64            `json:"total"`
+	Items []APIKeyResponse `json:"items"`
+}
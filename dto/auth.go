@@ -2,14 +2,99 @@ package dto
 
 import "time"
 
-// LoginRequest is provided by the caller to exchange for a JWT.
+// LoginRequest is provided by the caller to exchange for a JWT. Provider
+// names a password identity provider; empty selects the default one.
 type LoginRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
+	Provider string `json:"provider"`
 }
 
-// LoginResponse contains the issued JWT.
+// LoginResponse contains the issued JWT and its refresh token. When the user
+// has MFA enabled, only the MFA challenge fields are set and the client must
+// complete the login through /auth/mfa/verify.
 type LoginResponse struct {
-	AccessToken string    `json:"accessToken"`
-	ExpiresAt   time.Time `json:"expiresAt"`
+	AccessToken      string    `json:"accessToken,omitempty"`
+	ExpiresAt        time.Time `json:"expiresAt,omitzero"`
+	RefreshToken     string    `json:"refreshToken,omitempty"`
+	RefreshExpiresAt time.Time `json:"refreshExpiresAt,omitzero"`
+	MFARequired      bool      `json:"mfaRequired,omitempty"`
+	MFAToken         string    `json:"mfaToken,omitempty"`
+	MFAExpiresAt     time.Time `json:"mfaExpiresAt,omitzero"`
+}
+
+// ProviderResponse describes a configured login identity provider.
+type ProviderResponse struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// OIDCAuthorizeResponse carries the URL the browser must visit to sign in
+// with an OIDC provider, and the state the callback must echo.
+type OIDCAuthorizeResponse struct {
+	AuthorizationURL string    `json:"authorizationUrl"`
+	State            string    `json:"state"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+}
+
+// OIDCCallbackRequest completes an OIDC login with the code and state the
+// provider redirected back with.
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+// RefreshRequest exchanges a refresh token for a new token pair.
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// LogoutRequest optionally names the refresh token to revoke alongside the access token.
+type LogoutRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// ChangePasswordRequest replaces the caller's password.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+}
+
+// PasswordResetResponse carries a one-time reset token issued by an admin.
+type PasswordResetResponse struct {
+	ResetToken string    `json:"resetToken"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// ResetPasswordRequest sets a new password using a one-time reset token.
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"newPassword" binding:"required"`
+}
+
+// IntrospectionRequest is the RFC 7662 request, sent form-encoded (JSON is also accepted).
+type IntrospectionRequest struct {
+	Token         string `form:"token" json:"token" binding:"required"`
+	TokenTypeHint string `form:"token_type_hint" json:"token_type_hint"`
+}
+
+// IntrospectionResponse is the RFC 7662 response. Inactive tokens carry only Active.
+type IntrospectionResponse struct {
+	Active     bool     `json:"active"`
+	Scope      string   `json:"scope,omitempty"`
+	TokenType  string   `json:"token_type,omitempty"`
+	Exp        int64    `json:"exp,omitempty"`
+	Iat        int64    `json:"iat,omitempty"`
+	Sub        string   `json:"sub,omitempty"`
+	Aud        []string `json:"aud,omitempty"`
+	Iss        string   `json:"iss,omitempty"`
+	Jti        string   `json:"jti,omitempty"`
+	Role       string   `json:"role,omitempty"`
+	MFAPending bool     `json:"mfa_pending,omitempty"`
+	Act        *Actor   `json:"act,omitempty"`
+}
+
+// Actor is the RFC 8693 act claim naming the real caller of an impersonation token.
+type Actor struct {
+	Sub string `json:"sub"`
 }
@@ -24,10 +24,51 @@ type ExchangeResponse struct {
 	GlobexExchangeCode string  `json:"globexExchangeCode"`
 	Description        *string `json:"description,omitempty"`
 	SegType            string  `json:"segType"`
+	// ETag is sent as the ETag header rather than in the body.
+	ETag string `json:"-"`
 }
 
-// ExchangeListResponse wraps paginated exchanges.
+// ExchangeListQuery holds the query parameters of the exchange list. Codes
+// and segType match exactly, search is a substring of the description.
+// Cursor continues from the nextCursor of a previous page instead of using
+// page; SkipTotal omits the total count.
+type ExchangeListQuery struct {
+	Page       int    `form:"page"`
+	Size       int    `form:"size"`
+	ClearCode  string `form:"clearCode" binding:"max=10"`
+	GlobexCode string `form:"globexCode" binding:"max=10"`
+	SegType    string `form:"segType" binding:"max=10"`
+	Search     string `form:"search" binding:"max=100"`
+	Cursor     string `form:"cursor" binding:"max=1024"`
+	SkipTotal  bool   `form:"skipTotal"`
+}
+
+// ExchangeTranslateQuery asks for the codes matching a code of one scheme.
+type ExchangeTranslateQuery struct {
+	From string `form:"from" binding:"required,oneof=mqm clear globex"`
+	Code string `form:"code" binding:"required,max=10"`
+}
+
+// ExchangeCodes holds an exchange's code in every scheme.
+type ExchangeCodes struct {
+	MQMExchangeCode    string `json:"mqmExchangeCode"`
+	ClearExchangeCode  string `json:"clearExchangeCode"`
+	GlobexExchangeCode string `json:"globexExchangeCode"`
+	SegType            string `json:"segType"`
+}
+
+// ExchangeTranslationResponse lists the exchanges a code maps to. Clearing
+// and Globex codes may be shared by several exchanges.
+type ExchangeTranslationResponse struct {
+	From    string          `json:"from"`
+	Code    string          `json:"code"`
+	Matches []ExchangeCodes `json:"matches"`
+}
+
+// ExchangeListResponse wraps paginated exchanges. Total is omitted when
+// skipped; NextCursor is set while more exchanges follow.
 type ExchangeListResponse struct {
-	Total int64              `json:"total"`
-	Items []ExchangeResponse `json:"items"`
+	Total      *int64             `json:"total,omitempty"`
+	Items      []ExchangeResponse `json:"items"`
+	NextCursor string             `json:"nextCursor,omitempty"`
 }
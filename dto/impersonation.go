@@ -0,0 +1,34 @@
+package dto
+
+import "time"
+
+// ImpersonateRequest starts an impersonation. The reason is kept in the audit trail.
+type ImpersonateRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// ImpersonationTokenResponse carries the short-lived access token issued for
+// the impersonated user. It cannot be refreshed.
+type ImpersonationTokenResponse struct {
+	AccessToken string    `json:"accessToken"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	UserID      string    `json:"userId"`
+	ActorID     string    `json:"actorId"`
+}
+
+// ImpersonationResponse is one entry of the impersonation audit trail.
+type ImpersonationResponse struct {
+	ID        string    `json:"id"`
+	ActorID   string    `json:"actorId"`
+	UserID    string    `json:"userId"`
+	Reason    string    `json:"reason"`
+	TokenID   string    `json:"tokenId"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ImpersonationListResponse wraps a paginated list.
+type ImpersonationListResponse struct {
+	Total int64                   `json:"total"`
+	Items []ImpersonationResponse `json:"items"`
+}
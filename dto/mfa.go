@@ -0,0 +1,23 @@
+package dto
+
+// TOTPEnrollmentResponse carries the shared secret of a pending TOTP enrolment.
+type TOTPEnrollmentResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauthUri"`
+}
+
+// MFACodeRequest carries a TOTP code or, where accepted, a recovery code.
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required,max=32"`
+}
+
+// RecoveryCodesResponse lists one-time recovery codes. They are shown only once.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// MFAVerifyRequest completes a login with the second factor.
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfaToken" binding:"required"`
+	Code     string `json:"code" binding:"required,max=32"`
+}
@@ -6,7 +6,7 @@ import "time"
 type UserCreateRequest struct {
 	Username  string `json:"username" binding:"required,min=3,max=50"`
 	Email     string `json:"email" binding:"required,email,max=255"`
-	Password  string `json:"password" binding:"required,min=8,max=72"`
+	Password  string `json:"password" binding:"required,min=8"`
 	FirstName string `json:"firstName" binding:"required,max=100"`
 	LastName  string `json:"lastName" binding:"required,max=100"`
 	Role      string `json:"role" binding:"required,max=50"`
@@ -22,18 +22,62 @@ type UserUpdateRequest struct {
 
 // UserResponse is returned back to clients.
 type UserResponse struct {
-	ID        string    `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	FirstName string    `json:"firstName"`
-	LastName  string    `json:"lastName"`
-	Role      string    `json:"role"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID          string     `json:"id"`
+	Username    string     `json:"username"`
+	Email       string     `json:"email"`
+	FirstName   string     `json:"firstName"`
+	LastName    string     `json:"lastName"`
+	Role        string     `json:"role"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	LockedUntil *time.Time `json:"lockedUntil,omitempty"`
+	Status      string     `json:"status"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty"`
+	DeletedBy   string     `json:"deletedBy,omitempty"`
+	// ETag is sent as the ETag header rather than in the body.
+	ETag string `json:"-"`
 }
 
-// UserListResponse wraps a paginated list.
+// UserListQuery holds the query parameters of the user list. Status defaults
+// to active; "all" includes disabled and deleted users. Username and
+// email match by prefix, name as a substring of the first or last name;
+// createdFrom is inclusive and createdTo exclusive (RFC 3339). Sort is a
+// comma-separated field list, "-" prefixed for descending order. Cursor
+// continues from the nextCursor of a previous page instead of using page;
+// SkipTotal omits the total count.
+type UserListQuery struct {
+	Page        int        `form:"page"`
+	Size        int        `form:"size"`
+	Status      string     `form:"status" binding:"omitempty,oneof=active disabled deleted all"`
+	Role        string     `form:"role" binding:"max=50"`
+	Username    string     `form:"username" binding:"max=50"`
+	Email       string     `form:"email" binding:"max=255"`
+	Name        string     `form:"name" binding:"max=100"`
+	CreatedFrom *time.Time `form:"createdFrom"`
+	CreatedTo   *time.Time `form:"createdTo"`
+	Sort        string     `form:"sort" binding:"max=200"`
+	Cursor      string     `form:"cursor" binding:"max=1024"`
+	SkipTotal   bool       `form:"skipTotal"`
+}
+
+// UserListResponse wraps a paginated list. Total is omitted when skipped;
+// NextCursor is set while more users follow.
 type UserListResponse struct {
-	Total int64          `json:"total"`
-	Items []UserResponse `json:"items"`
+	Total      *int64         `json:"total,omitempty"`
+	Items      []UserResponse `json:"items"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// ProfileResponse describes the authenticated caller.
+type ProfileResponse struct {
+	UserResponse
+	EffectiveRole string   `json:"effectiveRole"`
+	Permissions   []string `json:"permissions"`
+}
+
+// ProfileUpdateRequest edits the caller's own profile. Omitted fields are left unchanged.
+type ProfileUpdateRequest struct {
+	Email     *string `json:"email" binding:"omitempty,email,max=255"`
+	FirstName *string `json:"firstName" binding:"omitempty,min=1,max=100"`
+	LastName  *string `json:"lastName" binding:"omitempty,min=1,max=100"`
 }
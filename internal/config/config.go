@@ -8,19 +8,22 @@ import (
 	"time"
 
 	"github.com/spf13/viper"
+
+	"liangxiong/demo/internal/dialect"
 )
 
 const defaultEnv = "dev"
 
 // Config represents the full application configuration tree.
 type Config struct {
-	App       AppConfig       `mapstructure:"app"`
-	Server    ServerConfig    `mapstructure:"server"`
-	CORS      CORSConfig      `mapstructure:"cors"`
-	Database  DatabaseConfig  `mapstructure:"db"`
-	Auth      AuthConfig      `mapstructure:"auth"`
-	Logging   LoggingConfig   `mapstructure:"logging"`
-	RateLimit RateLimitConfig `mapstructure:"rateLimit"`
+	App        AppConfig        `mapstructure:"app"`
+	Server     ServerConfig     `mapstructure:"server"`
+	CORS       CORSConfig       `mapstructure:"cors"`
+	Database   DatabaseConfig   `mapstructure:"db"`
+	Auth       AuthConfig       `mapstructure:"auth"`
+	Logging    LoggingConfig    `mapstructure:"logging"`
+	RateLimit  RateLimitConfig  `mapstructure:"rateLimit"`
+	Pagination PaginationConfig `mapstructure:"pagination"`
 }
 
 // AppConfig captures high-level application information.
@@ -37,6 +40,36 @@ type ServerConfig struct {
 	ReadTimeout  time.Duration `mapstructure:"readTimeout"`
 	WriteTimeout time.Duration `mapstructure:"writeTimeout"`
 	MaxBodyBytes int64         `mapstructure:"maxBodyBytes"`
+	TLS          TLSConfig     `mapstructure:"tls"`
+}
+
+// TLSConfig enables HTTPS. ClientAuth is none, optional or required; the
+// latter two verify client certificates against ClientCAFile. MinVersion is
+// 1.2 or 1.3 and CipherPolicy is default or modern (ECDHE with AEAD only).
+// Certificate and CA files are re-read when they change, checked at most
+// once per ReloadInterval.
+type TLSConfig struct {
+	Enabled          bool                   `mapstructure:"enabled"`
+	CertFile         string                 `mapstructure:"certFile"`
+	KeyFile          string                 `mapstructure:"keyFile"`
+	ClientCAFile     string                 `mapstructure:"clientCAFile"`
+	ClientAuth       string                 `mapstructure:"clientAuth"`
+	MinVersion       string                 `mapstructure:"minVersion"`
+	CipherPolicy     string                 `mapstructure:"cipherPolicy"`
+	ReloadInterval   time.Duration          `mapstructure:"reloadInterval"`
+	ClientIdentities []ClientIdentityConfig `mapstructure:"clientIdentities"`
+}
+
+// ClientIdentityConfig maps client certificates to a principal. A certificate
+// matches when its subject DN equals Subject or one of its DNS, URI, email or
+// IP SANs equals SAN (both must match when both are set). It then
+// authenticates as the user Username, or as service Service with Scopes.
+type ClientIdentityConfig struct {
+	Subject  string   `mapstructure:"subject"`
+	SAN      string   `mapstructure:"san"`
+	Username string   `mapstructure:"username"`
+	Service  string   `mapstructure:"service"`
+	Scopes   []string `mapstructure:"scopes"`
 }
 
 // CORSConfig enumerates allowed CORS options.
@@ -47,23 +80,136 @@ type CORSConfig struct {
 	AllowCredentials bool     `mapstructure:"allowCredentials"`
 }
 
-// DatabaseConfig contains SQL Server connection values.
+// DatabaseConfig contains database connection values. Driver is sqlserver
+// (the default), postgres or sqlite. MigrateOnStart applies pending schema
+// migrations before the server starts.
 type DatabaseConfig struct {
+	Driver          string        `mapstructure:"driver"`
 	DSN             string        `mapstructure:"dsn"`
 	MaxOpenConns    int           `mapstructure:"maxOpenConns"`
 	MaxIdleConns    int           `mapstructure:"maxIdleConns"`
 	ConnMaxLifetime time.Duration `mapstructure:"connMaxLifetime"`
+	MigrateOnStart  bool          `mapstructure:"migrateOnStart"`
 }
 
 // AuthConfig glues JWT settings.
 type AuthConfig struct {
-	JWTSecret      string        `mapstructure:"jwtSecret"`
-	Issuer         string        `mapstructure:"issuer"`
-	Audience       string        `mapstructure:"audience"`
-	AccessTokenTTL time.Duration `mapstructure:"accessTokenTTL"`
-	Algorithm      string        `mapstructure:"algorithm"`
-	PrivateKeyPath string        `mapstructure:"privateKeyPath"`
-	PublicKeyPath  string        `mapstructure:"publicKeyPath"`
+	JWTSecret          string               `mapstructure:"jwtSecret"`
+	Issuer             string               `mapstructure:"issuer"`
+	Audience           string               `mapstructure:"audience"`
+	AccessTokenTTL     time.Duration        `mapstructure:"accessTokenTTL"`
+	RefreshTokenTTL    time.Duration        `mapstructure:"refreshTokenTTL"`
+	RevocationCacheTTL time.Duration        `mapstructure:"revocationCacheTTL"`
+	Algorithm          string               `mapstructure:"algorithm"`
+	PrivateKeyPath     string               `mapstructure:"privateKeyPath"`
+	PublicKeyPath      string               `mapstructure:"publicKeyPath"`
+	ActiveKeyID        string               `mapstructure:"activeKeyId"`
+	Keys               []KeyConfig          `mapstructure:"keys"`
+	Lockout            LockoutConfig        `mapstructure:"lockout"`
+	PasswordPolicy     PasswordPolicyConfig `mapstructure:"passwordPolicy"`
+	PasswordHash       PasswordHashConfig   `mapstructure:"passwordHash"`
+	PasswordResetTTL   time.Duration        `mapstructure:"passwordResetTTL"`
+	ImpersonationTTL   time.Duration        `mapstructure:"impersonationTTL"`
+	MFA                MFAConfig            `mapstructure:"mfa"`
+	Providers          []ProviderConfig     `mapstructure:"providers"`
+}
+
+// ProviderConfig describes one login identity provider. Type is local, ldap
+// or oidc; DefaultRole is given to users provisioned on first login.
+type ProviderConfig struct {
+	Name        string     `mapstructure:"name"`
+	Type        string     `mapstructure:"type"`
+	DefaultRole string     `mapstructure:"defaultRole"`
+	LDAP        LDAPConfig `mapstructure:"ldap"`
+	OIDC        OIDCConfig `mapstructure:"oidc"`
+}
+
+// LDAPConfig configures search-then-bind authentication against a directory.
+// UserFilter must contain one %s, replaced by the escaped username. GroupRoles
+// maps group DNs found in GroupAttribute to roles.
+type LDAPConfig struct {
+	URL                string            `mapstructure:"url"`
+	StartTLS           bool              `mapstructure:"startTLS"`
+	InsecureSkipVerify bool              `mapstructure:"insecureSkipVerify"`
+	BindDN             string            `mapstructure:"bindDN"`
+	BindPassword       string            `mapstructure:"bindPassword"`
+	BaseDN             string            `mapstructure:"baseDN"`
+	UserFilter         string            `mapstructure:"userFilter"`
+	UsernameAttribute  string            `mapstructure:"usernameAttribute"`
+	EmailAttribute     string            `mapstructure:"emailAttribute"`
+	FirstNameAttribute string            `mapstructure:"firstNameAttribute"`
+	LastNameAttribute  string            `mapstructure:"lastNameAttribute"`
+	GroupAttribute     string            `mapstructure:"groupAttribute"`
+	GroupRoles         map[string]string `mapstructure:"groupRoles"`
+	Timeout            time.Duration     `mapstructure:"timeout"`
+}
+
+// OIDCConfig configures the OpenID Connect authorization code flow.
+type OIDCConfig struct {
+	IssuerURL    string        `mapstructure:"issuerUrl"`
+	ClientID     string        `mapstructure:"clientId"`
+	ClientSecret string        `mapstructure:"clientSecret"`
+	RedirectURL  string        `mapstructure:"redirectUrl"`
+	Scopes       []string      `mapstructure:"scopes"`
+	Timeout      time.Duration `mapstructure:"timeout"`
+}
+
+// MFAConfig controls TOTP two-factor authentication. Issuer and
+// EncryptionKey are only needed when Enabled is set.
+type MFAConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	Issuer        string        `mapstructure:"issuer"`
+	EncryptionKey string        `mapstructure:"encryptionKey"`
+	ChallengeTTL  time.Duration `mapstructure:"challengeTTL"`
+	RecoveryCodes int           `mapstructure:"recoveryCodes"`
+	RequiredRoles []string      `mapstructure:"requiredRoles"`
+}
+
+// PasswordPolicyConfig defines the rules new passwords must satisfy.
+type PasswordPolicyConfig struct {
+	MinLength        int    `mapstructure:"minLength"`
+	RequireUpper     bool   `mapstructure:"requireUpper"`
+	RequireLower     bool   `mapstructure:"requireLower"`
+	RequireDigit     bool   `mapstructure:"requireDigit"`
+	RequireSymbol    bool   `mapstructure:"requireSymbol"`
+	BreachedListPath string `mapstructure:"breachedListPath"`
+}
+
+// PasswordHashConfig selects the algorithm for new password hashes: bcrypt
+// or argon2id. Stored hashes with other parameters still verify and are
+// upgraded on the next successful login. Zero values fall back to defaults.
+type PasswordHashConfig struct {
+	Algorithm  string       `mapstructure:"algorithm"`
+	BcryptCost int          `mapstructure:"bcryptCost"`
+	Argon2     Argon2Config `mapstructure:"argon2"`
+}
+
+// Argon2Config tunes argon2id. Memory is in KiB.
+type Argon2Config struct {
+	Memory     uint32 `mapstructure:"memory"`
+	Time       uint32 `mapstructure:"time"`
+	Threads    uint8  `mapstructure:"threads"`
+	SaltLength uint32 `mapstructure:"saltLength"`
+	KeyLength  uint32 `mapstructure:"keyLength"`
+}
+
+// LockoutConfig tunes brute-force protection on login. Zero values fall back to defaults.
+type LockoutConfig struct {
+	MaxFailedAttempts int           `mapstructure:"maxFailedAttempts"`
+	LockoutDuration   time.Duration `mapstructure:"lockoutDuration"`
+	BaseBackoff       time.Duration `mapstructure:"baseBackoff"`
+	MaxBackoff        time.Duration `mapstructure:"maxBackoff"`
+	FailureWindow     time.Duration `mapstructure:"failureWindow"`
+}
+
+// KeyConfig describes one entry of the JWT key ring. Entries without
+// private material (or secret) are verification-only.
+type KeyConfig struct {
+	ID             string `mapstructure:"id"`
+	Algorithm      string `mapstructure:"algorithm"`
+	Secret         string `mapstructure:"secret"`
+	PrivateKeyPath string `mapstructure:"privateKeyPath"`
+	PublicKeyPath  string `mapstructure:"publicKeyPath"`
 }
 
 // LoggingConfig controls log output destinations.
@@ -76,6 +222,18 @@ type RateLimitConfig struct {
 	RPS int `mapstructure:"rps"`
 }
 
+// PaginationConfig controls list pagination. CursorSecret signs the
+// continuation tokens of keyset pages; instances behind one load balancer
+// must share it. When empty it is derived from the active JWT signing key.
+type PaginationConfig struct {
+	CursorSecret string `mapstructure:"cursorSecret"`
+}
+
+// secretKeys are settings the production config leaves out, to be supplied
+// as APP_* environment variables. AutomaticEnv alone only overrides keys a
+// config file names, so they are bound explicitly.
+var secretKeys = []string{"auth.mfa.encryptionKey", "pagination.cursorSecret"}
+
 // Load reads configuration for the current environment.
 func Load(basePath string) (*Config, error) {
 	cfg := Config{}
@@ -92,6 +250,11 @@ func Load(basePath string) (*Config, error) {
 	v.SetEnvPrefix("APP")
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	v.AutomaticEnv()
+	for _, key := range secretKeys {
+		if err := v.BindEnv(key); err != nil {
+			return nil, fmt.Errorf("bind %s: %w", key, err)
+		}
+	}
 
 	if err := v.ReadInConfig(); err != nil {
 		return nil, fmt.Errorf("read config: %w", err)
@@ -127,6 +290,9 @@ func (c *Config) Validate() error {
 	if c.Server.MaxBodyBytes == 0 {
 		missing = append(missing, "server.maxBodyBytes")
 	}
+	if _, err := dialect.New(c.Database.Driver); err != nil {
+		missing = append(missing, "db.driver")
+	}
 	if c.Database.DSN == "" {
 		missing = append(missing, "db.dsn")
 	}
@@ -142,9 +308,22 @@ func (c *Config) Validate() error {
 	if c.Auth.AccessTokenTTL == 0 {
 		missing = append(missing, "auth.accessTokenTTL")
 	}
+	if c.Auth.RefreshTokenTTL == 0 {
+		missing = append(missing, "auth.refreshTokenTTL")
+	}
 	if c.Auth.Algorithm == "" {
 		missing = append(missing, "auth.algorithm")
 	}
+	if c.Auth.MFA.Enabled {
+		if c.Auth.MFA.Issuer == "" {
+			missing = append(missing, "auth.mfa.issuer")
+		}
+		if c.Auth.MFA.EncryptionKey == "" {
+			missing = append(missing, "auth.mfa.encryptionKey")
+		}
+	} else if len(c.Auth.MFA.RequiredRoles) > 0 {
+		missing = append(missing, "auth.mfa.enabled")
+	}
 	if c.Logging.FilePath == "" {
 		missing = append(missing, "logging.filePath")
 	}
@@ -152,18 +331,29 @@ func (c *Config) Validate() error {
 		missing = append(missing, "rateLimit.rps")
 	}
 
-	switch strings.ToUpper(c.Auth.Algorithm) {
-	case "HS256":
-		if c.Auth.JWTSecret == "" {
-			missing = append(missing, "auth.jwtSecret")
-		}
-	case "RS256":
-		if c.Auth.PrivateKeyPath == "" || c.Auth.PublicKeyPath == "" {
-			missing = append(missing, "auth.privateKeyPath", "auth.publicKeyPath")
+	if len(c.Auth.Keys) == 0 {
+		switch strings.ToUpper(c.Auth.Algorithm) {
+		case "HS256":
+			if c.Auth.JWTSecret == "" {
+				missing = append(missing, "auth.jwtSecret")
+			}
+		case "RS256", "ES256", "EDDSA":
+			if c.Auth.PrivateKeyPath == "" || c.Auth.PublicKeyPath == "" {
+				missing = append(missing, "auth.privateKeyPath", "auth.publicKeyPath")
+			}
+		default:
+			missing = append(missing, "auth.algorithm")
 		}
+	} else {
+		missing = append(missing, c.Auth.validateKeys()...)
+	}
+	switch strings.ToLower(c.Auth.PasswordHash.Algorithm) {
+	case "", "bcrypt", "argon2id":
 	default:
-		missing = append(missing, "auth.algorithm")
+		missing = append(missing, "auth.passwordHash.algorithm")
 	}
+	missing = append(missing, c.Auth.validateProviders()...)
+	missing = append(missing, c.Server.TLS.validate()...)
 
 	if len(missing) > 0 {
 		return fmt.Errorf("missing required configuration: %s", strings.Join(missing, ", "))
@@ -171,6 +361,133 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// validate checks the TLS listener settings and returns the offending keys.
+func (t TLSConfig) validate() []string {
+	if !t.Enabled {
+		return nil
+	}
+
+	var missing []string
+	if t.CertFile == "" {
+		missing = append(missing, "server.tls.certFile")
+	}
+	if t.KeyFile == "" {
+		missing = append(missing, "server.tls.keyFile")
+	}
+	switch strings.ToLower(t.ClientAuth) {
+	case "", "none":
+	case "optional", "required":
+		if t.ClientCAFile == "" {
+			missing = append(missing, "server.tls.clientCAFile")
+		}
+	default:
+		missing = append(missing, "server.tls.clientAuth")
+	}
+	switch t.MinVersion {
+	case "", "1.2", "1.3":
+	default:
+		missing = append(missing, "server.tls.minVersion")
+	}
+	switch strings.ToLower(t.CipherPolicy) {
+	case "", "default", "modern":
+	default:
+		missing = append(missing, "server.tls.cipherPolicy")
+	}
+
+	for i, id := range t.ClientIdentities {
+		prefix := fmt.Sprintf("server.tls.clientIdentities[%d]", i)
+		if id.Subject == "" && id.SAN == "" {
+			missing = append(missing, prefix+".subject")
+		}
+		if (id.Username == "") == (id.Service == "") {
+			missing = append(missing, prefix+".username")
+		}
+	}
+	return missing
+}
+
+// validateProviders checks login identity providers and returns the offending keys.
+func (a AuthConfig) validateProviders() []string {
+	var missing []string
+	seen := make(map[string]bool)
+
+	for i, p := range a.Providers {
+		prefix := fmt.Sprintf("auth.providers[%d]", i)
+		if p.Name == "" || seen[p.Name] {
+			missing = append(missing, prefix+".name")
+		}
+		seen[p.Name] = true
+
+		switch strings.ToLower(p.Type) {
+		case "local":
+		case "ldap":
+			if p.LDAP.URL == "" {
+				missing = append(missing, prefix+".ldap.url")
+			}
+			if p.LDAP.BaseDN == "" {
+				missing = append(missing, prefix+".ldap.baseDN")
+			}
+		case "oidc":
+			if p.OIDC.IssuerURL == "" {
+				missing = append(missing, prefix+".oidc.issuerUrl")
+			}
+			if p.OIDC.ClientID == "" {
+				missing = append(missing, prefix+".oidc.clientId")
+			}
+			if p.OIDC.RedirectURL == "" {
+				missing = append(missing, prefix+".oidc.redirectUrl")
+			}
+		default:
+			missing = append(missing, prefix+".type")
+		}
+	}
+	return missing
+}
+
+// validateKeys checks the key ring and returns the offending keys.
+func (a AuthConfig) validateKeys() []string {
+	var missing []string
+	activeFound := false
+	seen := make(map[string]bool)
+
+	for i, key := range a.Keys {
+		prefix := fmt.Sprintf("auth.keys[%d]", i)
+		if key.ID == "" || seen[key.ID] {
+			missing = append(missing, prefix+".id")
+		}
+		seen[key.ID] = true
+
+		alg := key.Algorithm
+		if alg == "" {
+			alg = a.Algorithm
+		}
+		switch strings.ToUpper(alg) {
+		case "HS256":
+			if key.Secret == "" {
+				missing = append(missing, prefix+".secret")
+			}
+		case "RS256", "ES256", "EDDSA":
+			if key.PrivateKeyPath == "" && key.PublicKeyPath == "" {
+				missing = append(missing, prefix+".publicKeyPath")
+			}
+		default:
+			missing = append(missing, prefix+".algorithm")
+		}
+
+		if key.ID == a.ActiveKeyID {
+			activeFound = true
+			if strings.ToUpper(alg) != "HS256" && key.PrivateKeyPath == "" {
+				missing = append(missing, prefix+".privateKeyPath")
+			}
+		}
+	}
+
+	if !activeFound {
+		missing = append(missing, "auth.activeKeyId")
+	}
+	return missing
+}
+
 // ConfigPath resolves base path relative to executable.
 func ConfigPath() string {
 	cwd, err := os.Getwd()
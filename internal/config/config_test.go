@@ -0,0 +1,55 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func validConfig() *Config {
+	return &Config{
+		App:      AppConfig{Name: "test", Env: "test", LogLevel: "info"},
+		Server:   ServerConfig{Address: "127.0.0.1", Port: 8080, MaxBodyBytes: 1 << 20},
+		Database: DatabaseConfig{Driver: "sqlserver", DSN: "sqlserver://localhost", ConnMaxLifetime: time.Minute},
+		Auth: AuthConfig{
+			JWTSecret:       "secret",
+			Issuer:          "test",
+			Audience:        "test",
+			AccessTokenTTL:  time.Minute,
+			RefreshTokenTTL: time.Hour,
+			Algorithm:       "HS256",
+		},
+		Logging:   LoggingConfig{FilePath: "app.log"},
+		RateLimit: RateLimitConfig{RPS: 100},
+	}
+}
+
+func TestValidateOptionalMFAAndCursorSecret(t *testing.T) {
+	if err := validConfig().Validate(); err != nil {
+		t.Fatalf("expected MFA and the cursor secret to be optional, got %v", err)
+	}
+
+	cases := map[string]struct {
+		mfa     MFAConfig
+		missing string
+	}{
+		"enabled without issuer": {MFAConfig{Enabled: true, EncryptionKey: "key"}, "auth.mfa.issuer"},
+		"enabled without key":    {MFAConfig{Enabled: true, Issuer: "test"}, "auth.mfa.encryptionKey"},
+		"roles while disabled":   {MFAConfig{RequiredRoles: []string{"admin"}}, "auth.mfa.enabled"},
+		"enabled":                {MFAConfig{Enabled: true, Issuer: "test", EncryptionKey: "key", RequiredRoles: []string{"admin"}}, ""},
+	}
+	for name, tc := range cases {
+		cfg := validConfig()
+		cfg.Auth.MFA = tc.mfa
+		err := cfg.Validate()
+		if tc.missing == "" {
+			if err != nil {
+				t.Fatalf("%s: unexpected error %v", name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.missing) {
+			t.Fatalf("%s: expected %s to be reported, got %v", name, tc.missing, err)
+		}
+	}
+}
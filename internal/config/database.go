@@ -6,13 +6,18 @@ import (
 	"fmt"
 	"time"
 
-	_ "github.com/microsoft/go-mssqldb"
 	"go.uber.org/zap"
+
+	"liangxiong/demo/internal/dialect"
 )
 
-// NewDatabase creates a SQL Server connection pool.
+// NewDatabase creates a connection pool for the configured driver.
 func NewDatabase(ctx context.Context, cfg DatabaseConfig, logger *zap.Logger) (*sql.DB, error) {
-	db, err := sql.Open("sqlserver", cfg.DSN)
+	d, err := dialect.New(cfg.Driver)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(d.DriverName(), cfg.DSN)
 	if err != nil {
 		return nil, fmt.Errorf("open database: %w", err)
 	}
@@ -28,6 +33,6 @@ func NewDatabase(ctx context.Context, cfg DatabaseConfig, logger *zap.Logger) (*
 		return nil, fmt.Errorf("ping database: %w", err)
 	}
 
-	logger.Info("database connection established")
+	logger.Info("database connection established", zap.String("driver", d.Name()))
 	return db, nil
 }
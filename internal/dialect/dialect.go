@@ -0,0 +1,168 @@
+// Package dialect renders the vendor-specific parts of the SQL run by the
+// repositories so that they work on SQL Server, PostgreSQL and SQLite.
+//
+// Queries are written once with SQL Server's numbered @pN placeholders and
+// rebound by the executor; everything a dialect cannot rewrite textually
+// (paging, upserts, returning written values, row versions) goes through the
+// builders below, which emit @pN placeholders as well.
+package dialect
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/jackc/pgx/v5/stdlib"
+	"modernc.org/sqlite"
+)
+
+// ErrorKind classifies database errors independently of the driver.
+type ErrorKind int
+
+const (
+	// ErrorOther is any error not classified below.
+	ErrorOther ErrorKind = iota
+	// ErrorUniqueViolation reports a duplicate primary or unique key.
+	ErrorUniqueViolation
+	// ErrorForeignKeyViolation reports a missing or still referenced row.
+	ErrorForeignKeyViolation
+	// ErrorDeadlock reports a transaction aborted by a lock conflict, which
+	// may succeed when retried as a whole.
+	ErrorDeadlock
+	// ErrorLockTimeout reports a statement that waited too long for a lock.
+	ErrorLockTimeout
+	// ErrorConnection reports a connection lost or reset while in use.
+	ErrorConnection
+)
+
+// Transient reports whether an error of kind k may go away by itself, so
+// that running the failed transaction again may succeed.
+func (k ErrorKind) Transient() bool {
+	return k == ErrorDeadlock || k == ErrorLockTimeout || k == ErrorConnection
+}
+
+// Dialect is a supported database.
+type Dialect interface {
+	// Name is the db.driver configuration value, also the directory of the
+	// dialect's schema migrations.
+	Name() string
+	// DriverName is the database/sql driver to open.
+	DriverName() string
+	// Rebind rewrites the @pN placeholders of query into the native syntax.
+	Rebind(query string) string
+	// Paginate renders the clause skipping offset rows and returning at most
+	// limit rows, both given as placeholders. It follows ORDER BY.
+	Paginate(offset, limit string) string
+	// Returning renders how an INSERT or UPDATE returns the written value of
+	// column: output goes before VALUES or WHERE, returning at the end of
+	// the statement. One of them is empty.
+	Returning(column string) (output, returning string)
+	// SetRowVersion renders the assignment, with a leading comma, giving the
+	// row version column of an updated row a new value. SQL Server maintains
+	// ROWVERSION columns itself and renders nothing.
+	SetRowVersion(column string) string
+	// Upsert renders an insert of columns into table, bound to @p1 to @pN in
+	// order, that runs set instead when a row with the same keys exists. An
+	// empty set leaves the existing row alone.
+	Upsert(table string, keys, columns []string, set string) string
+	// Savepoint, RollbackTo and ReleaseSavepoint render the statements that
+	// mark, roll back to and discard a savepoint of the running transaction.
+	// SQL Server cannot discard savepoints and renders no release.
+	Savepoint(name string) string
+	RollbackTo(name string) string
+	ReleaseSavepoint(name string) string
+	// Classify reports the kind of a driver error.
+	Classify(err error) ErrorKind
+}
+
+// Supported dialects.
+var (
+	SQLServer Dialect = sqlServer{}
+	Postgres  Dialect = postgres{}
+	SQLite    Dialect = sqliteDialect{}
+)
+
+// New returns the dialect configured by name. The empty name selects SQL Server.
+func New(name string) (Dialect, error) {
+	switch strings.ToLower(name) {
+	case "", SQLServer.Name():
+		return SQLServer, nil
+	case Postgres.Name():
+		return Postgres, nil
+	case SQLite.Name():
+		return SQLite, nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", name)
+	}
+}
+
+// Classify reports the kind of an error of any supported driver.
+func Classify(err error) ErrorKind {
+	for _, d := range []Dialect{SQLServer, Postgres, SQLite} {
+		if kind := d.Classify(err); kind != ErrorOther {
+			return kind
+		}
+	}
+	return ErrorOther
+}
+
+// Of returns the dialect of the driver behind db. Unknown drivers, such as
+// the mocks of unit tests, are taken for SQL Server.
+func Of(db *sql.DB) Dialect {
+	switch db.Driver().(type) {
+	case *stdlib.Driver:
+		return Postgres
+	case *sqlite.Driver:
+		return SQLite
+	default:
+		return SQLServer
+	}
+}
+
+var placeholder = regexp.MustCompile(`@p(\d+)\b`)
+
+// standardSavepoints implements the savepoint statements of PostgreSQL and SQLite.
+type standardSavepoints struct{}
+
+func (standardSavepoints) Savepoint(name string) string { return `SAVEPOINT ` + name }
+
+func (standardSavepoints) RollbackTo(name string) string { return `ROLLBACK TO SAVEPOINT ` + name }
+
+func (standardSavepoints) ReleaseSavepoint(name string) string { return `RELEASE SAVEPOINT ` + name }
+
+// connectionLost reports the driver-independent signs of a broken
+// connection. Cancelled and timed out contexts are not among them.
+func connectionLost(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.As(err, &netErr)
+}
+
+// dollarPlaceholders rewrites @pN as $N, understood by PostgreSQL and SQLite.
+func dollarPlaceholders(query string) string {
+	return placeholder.ReplaceAllString(query, `$$$1`)
+}
+
+// onConflict renders the INSERT ... ON CONFLICT upsert shared by PostgreSQL
+// and SQLite.
+func onConflict(table string, keys, columns []string, set string) string {
+	values := make([]string, len(columns))
+	for i := range columns {
+		values[i] = fmt.Sprintf("@p%d", i+1)
+	}
+	action := "DO NOTHING"
+	if set != "" {
+		action = "DO UPDATE SET " + set
+	}
+	return `INSERT INTO ` + table + ` (` + strings.Join(columns, ", ") + `) VALUES (` + strings.Join(values, ", ") + `) ON CONFLICT (` + strings.Join(keys, ", ") + `) ` + action
+}
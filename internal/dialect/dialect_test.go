@@ -0,0 +1,90 @@
+package dialect
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+func TestNew(t *testing.T) {
+	for name, want := range map[string]Dialect{"": SQLServer, "sqlserver": SQLServer, "Postgres": Postgres, "sqlite": SQLite} {
+		if got, err := New(name); err != nil || got != want {
+			t.Fatalf("New(%q) = %v, %v", name, got, err)
+		}
+	}
+	if _, err := New("mysql"); err == nil {
+		t.Fatal("expected an unsupported driver to be rejected")
+	}
+}
+
+func TestRendering(t *testing.T) {
+	query := `SELECT id FROM users WHERE id = @p1 OR role = @p12`
+	if got := Postgres.Rebind(query); got != `SELECT id FROM users WHERE id = $1 OR role = $12` {
+		t.Fatalf("unexpected rebind %q", got)
+	}
+	if got := SQLServer.Rebind(query); got != query {
+		t.Fatalf("expected SQL Server queries to be left alone, got %q", got)
+	}
+	if got := SQLite.Paginate("@p1", "@p2"); got != ` LIMIT @p2 OFFSET @p1` {
+		t.Fatalf("unexpected paging %q", got)
+	}
+
+	keys, columns := []string{"user_id"}, []string{"user_id", "revoked_before"}
+	tests := []struct {
+		dialect Dialect
+		set     string
+		want    string
+	}{
+		{SQLServer, "revoked_before = @p2", `MERGE user_token_revocations AS target USING (SELECT @p1 AS user_id, @p2 AS revoked_before) AS source ON target.user_id = source.user_id WHEN MATCHED THEN UPDATE SET revoked_before = @p2 WHEN NOT MATCHED THEN INSERT (user_id, revoked_before) VALUES (source.user_id, source.revoked_before);`},
+		{SQLServer, "", `MERGE user_token_revocations AS target USING (SELECT @p1 AS user_id, @p2 AS revoked_before) AS source ON target.user_id = source.user_id WHEN NOT MATCHED THEN INSERT (user_id, revoked_before) VALUES (source.user_id, source.revoked_before);`},
+		{Postgres, "revoked_before = @p2", `INSERT INTO user_token_revocations (user_id, revoked_before) VALUES (@p1, @p2) ON CONFLICT (user_id) DO UPDATE SET revoked_before = @p2`},
+		{SQLite, "", `INSERT INTO user_token_revocations (user_id, revoked_before) VALUES (@p1, @p2) ON CONFLICT (user_id) DO NOTHING`},
+	}
+	for _, tt := range tests {
+		if got := tt.dialect.Upsert("user_token_revocations", keys, columns, tt.set); got != tt.want {
+			t.Fatalf("%s upsert:\n got %s\nwant %s", tt.dialect.Name(), got, tt.want)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		err     error
+		want    ErrorKind
+	}{
+		{SQLServer, fmt.Errorf("insert: %w", mssql.Error{Number: 2627}), ErrorUniqueViolation},
+		{SQLServer, mssql.Error{Number: 547}, ErrorForeignKeyViolation},
+		{SQLServer, mssql.Error{Number: 1205}, ErrorDeadlock},
+		{SQLServer, mssql.Error{Number: 1222}, ErrorLockTimeout},
+		{SQLServer, mssql.Error{Number: 208}, ErrorOther},
+		{SQLServer, fmt.Errorf("query: %w", io.EOF), ErrorConnection},
+		{SQLServer, driver.ErrBadConn, ErrorConnection},
+		{SQLServer, mssql.StreamError{InnerError: io.ErrUnexpectedEOF}, ErrorConnection},
+		{SQLServer, context.DeadlineExceeded, ErrorOther},
+		{Postgres, &pgconn.PgError{Code: "23505"}, ErrorUniqueViolation},
+		{Postgres, &pgconn.PgError{Code: "40001"}, ErrorDeadlock},
+		{Postgres, &pgconn.PgError{Code: "55P03"}, ErrorLockTimeout},
+		{Postgres, &pgconn.PgError{Code: "08006"}, ErrorConnection},
+		{Postgres, syscall.ECONNRESET, ErrorConnection},
+		{Postgres, mssql.Error{Number: 2627}, ErrorOther},
+	}
+	for _, tt := range tests {
+		if got := tt.dialect.Classify(tt.err); got != tt.want {
+			t.Fatalf("%s: Classify(%v) = %d, want %d", tt.dialect.Name(), tt.err, got, tt.want)
+		}
+	}
+
+	if got := Classify(&pgconn.PgError{Code: "23503"}); got != ErrorForeignKeyViolation {
+		t.Fatalf("expected any driver's errors to be classified, got %d", got)
+	}
+	if ErrorUniqueViolation.Transient() || !ErrorLockTimeout.Transient() {
+		t.Fatal("expected only deadlocks, lock timeouts and lost connections to be transient")
+	}
+}
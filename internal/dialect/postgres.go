@@ -0,0 +1,59 @@
+package dialect
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// postgres targets PostgreSQL 13 or later through pgx.
+type postgres struct{ standardSavepoints }
+
+func (postgres) Name() string { return "postgres" }
+
+func (postgres) DriverName() string { return "pgx" }
+
+func (postgres) Rebind(query string) string { return dollarPlaceholders(query) }
+
+func (postgres) Paginate(offset, limit string) string {
+	return ` LIMIT ` + limit + ` OFFSET ` + offset
+}
+
+func (postgres) Returning(column string) (string, string) {
+	return "", ` RETURNING ` + column
+}
+
+// SetRowVersion draws a random version, since PostgreSQL has no ROWVERSION.
+func (postgres) SetRowVersion(column string) string {
+	return `, ` + column + ` = uuid_send(gen_random_uuid())`
+}
+
+func (postgres) Upsert(table string, keys, columns []string, set string) string {
+	return onConflict(table, keys, columns, set)
+}
+
+func (postgres) Classify(err error) ErrorKind {
+	var e *pgconn.PgError
+	if !errors.As(err, &e) {
+		if connectionLost(err) {
+			return ErrorConnection
+		}
+		return ErrorOther
+	}
+	switch {
+	case e.Code == "23505":
+		return ErrorUniqueViolation
+	case e.Code == "23503":
+		return ErrorForeignKeyViolation
+	case e.Code == "40P01", e.Code == "40001":
+		return ErrorDeadlock
+	case e.Code == "55P03":
+		return ErrorLockTimeout
+	case strings.HasPrefix(e.Code, "08"), e.Code == "57P01":
+		// Connection exceptions and administrator shutdowns.
+		return ErrorConnection
+	default:
+		return ErrorOther
+	}
+}
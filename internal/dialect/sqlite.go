@@ -0,0 +1,55 @@
+package dialect
+
+import (
+	"errors"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqliteDialect targets embedded SQLite 3.35 or later, for local development
+// and tests.
+type sqliteDialect struct{ standardSavepoints }
+
+func (sqliteDialect) Name() string { return "sqlite" }
+
+func (sqliteDialect) DriverName() string { return "sqlite" }
+
+func (sqliteDialect) Rebind(query string) string { return dollarPlaceholders(query) }
+
+func (sqliteDialect) Paginate(offset, limit string) string {
+	return ` LIMIT ` + limit + ` OFFSET ` + offset
+}
+
+func (sqliteDialect) Returning(column string) (string, string) {
+	return "", ` RETURNING ` + column
+}
+
+// SetRowVersion draws a random version, since SQLite has no ROWVERSION.
+func (sqliteDialect) SetRowVersion(column string) string {
+	return `, ` + column + ` = randomblob(8)`
+}
+
+func (sqliteDialect) Upsert(table string, keys, columns []string, set string) string {
+	return onConflict(table, keys, columns, set)
+}
+
+// Classify reads the extended result code, whose low byte is the primary code.
+func (sqliteDialect) Classify(err error) ErrorKind {
+	var e *sqlite.Error
+	if !errors.As(err, &e) {
+		return ErrorOther
+	}
+	switch e.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		return ErrorUniqueViolation
+	case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+		return ErrorForeignKeyViolation
+	}
+	switch e.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return ErrorDeadlock
+	default:
+		return ErrorOther
+	}
+}
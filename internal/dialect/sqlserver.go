@@ -0,0 +1,84 @@
+package dialect
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// sqlServer is the dialect the queries are written in.
+type sqlServer struct{}
+
+func (sqlServer) Name() string { return "sqlserver" }
+
+func (sqlServer) DriverName() string { return "sqlserver" }
+
+func (sqlServer) Rebind(query string) string { return query }
+
+func (sqlServer) Paginate(offset, limit string) string {
+	return ` OFFSET ` + offset + ` ROWS FETCH NEXT ` + limit + ` ROWS ONLY`
+}
+
+func (sqlServer) Returning(column string) (string, string) {
+	return ` OUTPUT INSERTED.` + column, ""
+}
+
+func (sqlServer) SetRowVersion(string) string { return "" }
+
+// Upsert renders a MERGE from a single-row source holding the new values.
+func (sqlServer) Upsert(table string, keys, columns []string, set string) string {
+	source := make([]string, len(columns))
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		source[i] = fmt.Sprintf("@p%d AS %s", i+1, column)
+		values[i] = "source." + column
+	}
+	match := make([]string, len(keys))
+	for i, key := range keys {
+		match[i] = "target." + key + " = source." + key
+	}
+
+	var b strings.Builder
+	b.WriteString(`MERGE ` + table + ` AS target USING (SELECT ` + strings.Join(source, ", ") + `) AS source ON ` + strings.Join(match, " AND "))
+	if set != "" {
+		b.WriteString(` WHEN MATCHED THEN UPDATE SET ` + set)
+	}
+	b.WriteString(` WHEN NOT MATCHED THEN INSERT (` + strings.Join(columns, ", ") + `) VALUES (` + strings.Join(values, ", ") + `);`)
+	return b.String()
+}
+
+func (sqlServer) Savepoint(name string) string { return `SAVE TRANSACTION ` + name }
+
+func (sqlServer) RollbackTo(name string) string { return `ROLLBACK TRANSACTION ` + name }
+
+func (sqlServer) ReleaseSavepoint(string) string { return "" }
+
+// Classify also takes the stream and fatal server errors after which the
+// driver discards the connection for lost connections.
+func (sqlServer) Classify(err error) ErrorKind {
+	var (
+		stream mssql.StreamError
+		fatal  mssql.ServerError
+	)
+	if connectionLost(err) || errors.As(err, &stream) || errors.As(err, &fatal) {
+		return ErrorConnection
+	}
+	var e mssql.Error
+	if !errors.As(err, &e) {
+		return ErrorOther
+	}
+	switch e.Number {
+	case 2601, 2627:
+		return ErrorUniqueViolation
+	case 547:
+		return ErrorForeignKeyViolation
+	case 1205:
+		return ErrorDeadlock
+	case 1222:
+		return ErrorLockTimeout
+	default:
+		return ErrorOther
+	}
+}
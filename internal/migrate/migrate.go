@@ -0,0 +1,433 @@
+// Package migrate applies versioned schema migrations and records them in the
+// schema_migrations table.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"liangxiong/demo/internal/dialect"
+)
+
+// lockResource names the application lock serialising migration runs across
+// server instances and CLI invocations.
+const lockResource = "schema_migrations"
+
+const defaultLockTimeout = time.Minute
+
+// createHistoryTable holds the schema_migrations DDL of each dialect.
+var createHistoryTable = map[string]string{
+	"sqlserver": `IF OBJECT_ID(N'dbo.schema_migrations', N'U') IS NULL
+CREATE TABLE dbo.schema_migrations (
+    version    BIGINT        NOT NULL PRIMARY KEY,
+    name       NVARCHAR(255) NOT NULL,
+    checksum   CHAR(64)      NOT NULL,
+    applied_at DATETIME2     NOT NULL
+)`,
+	"postgres": `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    BIGINT       NOT NULL PRIMARY KEY,
+    name       VARCHAR(255) NOT NULL,
+    checksum   CHAR(64)     NOT NULL,
+    applied_at TIMESTAMPTZ  NOT NULL
+)`,
+	"sqlite": `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    INTEGER  NOT NULL PRIMARY KEY,
+    name       TEXT     NOT NULL,
+    checksum   TEXT     NOT NULL,
+    applied_at DATETIME NOT NULL
+)`,
+}
+
+// historyExists holds the query of each dialect telling whether
+// schema_migrations exists.
+var historyExists = map[string]string{
+	"sqlserver": `SELECT CASE WHEN OBJECT_ID(N'dbo.schema_migrations', N'U') IS NULL THEN 0 ELSE 1 END`,
+	"postgres":  `SELECT to_regclass('schema_migrations') IS NOT NULL`,
+	"sqlite":    `SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`,
+}
+
+var (
+	fileName       = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+	batchSeparator = regexp.MustCompile(`(?im)^[ \t]*GO[ \t]*$`)
+)
+
+// Migration is one schema version. Checksum is the hex SHA-256 of Up; Down
+// is empty for irreversible migrations.
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Status reports whether a migration is applied. Modified is set when the
+// file changed after it was applied, and Missing when an applied version
+// has no file.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+	Modified  bool
+	Missing   bool
+}
+
+// Load reads the migrations of fsys, named <version>_<name>.up.sql and
+// <version>_<name>.down.sql, sorted by version.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: invalid version", entry.Name())
+		}
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d: conflicting names %q and %q", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+			sum := sha256.Sum256(body)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s: missing up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator applies migrations to a database.
+type Migrator struct {
+	db          *sql.DB
+	dialect     dialect.Dialect
+	migrations  []Migration
+	logger      *zap.Logger
+	lockTimeout time.Duration
+}
+
+// New loads the migrations for db from the directory of fsys named after its
+// dialect, such as sqlserver.
+func New(db *sql.DB, fsys fs.FS, logger *zap.Logger) (*Migrator, error) {
+	d := dialect.Of(db)
+	sub, err := fs.Sub(fsys, d.Name())
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := Load(sub)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: d, migrations: migrations, logger: logger, lockTimeout: defaultLockTimeout}, nil
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.migrate(ctx, func(map[int64]applied) (int64, error) {
+		if len(m.migrations) == 0 {
+			return 0, nil
+		}
+		return m.migrations[len(m.migrations)-1].Version, nil
+	})
+}
+
+// Down rolls back the steps most recently applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	if steps < 1 {
+		return errors.New("steps must be at least 1")
+	}
+	return m.migrate(ctx, func(done map[int64]applied) (int64, error) {
+		versions := make([]int64, 0, len(done))
+		for version := range done {
+			versions = append(versions, version)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+		if steps >= len(versions) {
+			return 0, nil
+		}
+		return versions[len(versions)-steps-1], nil
+	})
+}
+
+// Goto migrates up or down until exactly the migrations up to version are
+// applied. Version 0 rolls back everything.
+func (m *Migrator) Goto(ctx context.Context, version int64) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+	return m.migrate(ctx, func(map[int64]applied) (int64, error) { return version, nil })
+}
+
+// Status lists every migration file and applied version.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	done, err := m.loadApplied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mg := range m.migrations {
+		status := Status{Migration: mg}
+		if a, ok := done[mg.Version]; ok {
+			appliedAt := a.appliedAt
+			status.AppliedAt = &appliedAt
+			status.Modified = a.checksum != mg.Checksum
+		}
+		statuses = append(statuses, status)
+	}
+	for version, a := range done {
+		if m.find(version) == nil {
+			appliedAt := a.appliedAt
+			statuses = append(statuses, Status{Migration: Migration{Version: version, Name: a.name}, AppliedAt: &appliedAt, Missing: true})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// applied is a schema_migrations row.
+type applied struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// migrate moves the schema to the version chosen by target while holding the
+// migration lock: applied versions above it are rolled back newest first,
+// then pending versions up to it are applied oldest first.
+func (m *Migrator) migrate(ctx context.Context, target func(done map[int64]applied) (int64, error)) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := m.lock(ctx, conn); err != nil {
+		return err
+	}
+	defer m.unlock(conn)
+
+	if _, err := conn.ExecContext(ctx, createHistoryTable[m.dialect.Name()]); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	done, err := m.loadApplied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if err := m.verify(done); err != nil {
+		return err
+	}
+
+	version, err := target(done)
+	if err != nil {
+		return err
+	}
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		if mg := m.migrations[i]; mg.Version > version {
+			if _, ok := done[mg.Version]; ok {
+				if err := m.run(ctx, conn, mg, false); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, mg := range m.migrations {
+		if _, ok := done[mg.Version]; !ok && mg.Version <= version {
+			if err := m.run(ctx, conn, mg, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lock takes an exclusive session-level lock so that concurrent runs wait for
+// each other instead of applying the same migrations twice: an application
+// lock on SQL Server and an advisory lock on PostgreSQL. SQLite databases
+// belong to a single process and are not locked.
+func (m *Migrator) lock(ctx context.Context, conn *sql.Conn) error {
+	switch m.dialect.Name() {
+	case "sqlserver":
+		var result int
+		row := conn.QueryRowContext(ctx, `DECLARE @result INT; EXEC @result = sp_getapplock @Resource = @p1, @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = @p2; SELECT @result`,
+			lockResource, m.lockTimeout.Milliseconds())
+		if err := row.Scan(&result); err != nil {
+			return fmt.Errorf("acquire migration lock: %w", err)
+		}
+		if result < 0 {
+			return fmt.Errorf("acquire migration lock: sp_getapplock returned %d", result)
+		}
+	case "postgres":
+		// pg_advisory_lock has no timeout of its own; the query is cancelled.
+		ctx, cancel := context.WithTimeout(ctx, m.lockTimeout)
+		defer cancel()
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(hashtext($1))`, lockResource); err != nil {
+			return fmt.Errorf("acquire migration lock: %w", err)
+		}
+	}
+	return nil
+}
+
+// unlock releases the lock taken by lock. The lock is released with the
+// session anyway; this only frees it before the pooled connection is reused.
+func (m *Migrator) unlock(conn *sql.Conn) {
+	var err error
+	switch m.dialect.Name() {
+	case "sqlserver":
+		_, err = conn.ExecContext(context.Background(), `EXEC sp_releaseapplock @Resource = @p1, @LockOwner = 'Session'`, lockResource)
+	case "postgres":
+		_, err = conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext($1))`, lockResource)
+	}
+	if err != nil {
+		m.logger.Warn("release migration lock failed", zap.Error(err))
+	}
+}
+
+// verify refuses to run when an applied migration was edited or removed,
+// since the schema would no longer match the files.
+func (m *Migrator) verify(done map[int64]applied) error {
+	for version, a := range done {
+		mg := m.find(version)
+		if mg == nil {
+			return fmt.Errorf("applied migration %d_%s has no file", version, a.name)
+		}
+		if mg.Checksum != a.checksum {
+			return fmt.Errorf("migration %d_%s was modified after it was applied", version, mg.Name)
+		}
+	}
+	return nil
+}
+
+// run applies or rolls back one migration in a transaction together with its
+// schema_migrations row.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, mg Migration, up bool) error {
+	script, direction := mg.Up, "up"
+	if !up {
+		script, direction = mg.Down, "down"
+		if strings.TrimSpace(script) == "" {
+			return fmt.Errorf("migration %d_%s cannot be rolled back", mg.Version, mg.Name)
+		}
+	}
+
+	start := time.Now()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	batches := []string{script}
+	if m.dialect.Name() == "sqlserver" {
+		batches = splitBatches(script)
+	}
+	for _, batch := range batches {
+		if _, err := tx.ExecContext(ctx, batch); err != nil {
+			return fmt.Errorf("migration %d_%s %s: %w", mg.Version, mg.Name, direction, err)
+		}
+	}
+	if up {
+		_, err = tx.ExecContext(ctx, m.dialect.Rebind(`INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (@p1, @p2, @p3, @p4)`),
+			mg.Version, mg.Name, mg.Checksum, time.Now().UTC())
+	} else {
+		_, err = tx.ExecContext(ctx, m.dialect.Rebind(`DELETE FROM schema_migrations WHERE version = @p1`), mg.Version)
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	m.logger.Info("migration "+direction, zap.Int64("version", mg.Version), zap.String("name", mg.Name), zap.Duration("took", time.Since(start)))
+	return nil
+}
+
+func (m *Migrator) find(version int64) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+// loadApplied reads schema_migrations, which may not exist yet.
+func (m *Migrator) loadApplied(ctx context.Context, conn *sql.Conn) (map[int64]applied, error) {
+	var exists bool
+	if err := conn.QueryRowContext(ctx, historyExists[m.dialect.Name()]).Scan(&exists); err != nil {
+		return nil, err
+	}
+	done := make(map[int64]applied)
+	if !exists {
+		return done, nil
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int64
+		var a applied
+		if err := rows.Scan(&version, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		done[version] = a
+	}
+	return done, rows.Err()
+}
+
+// splitBatches splits a script on lines holding only GO, the batch separator
+// of SQL Server tools, since some statements must start their own batch.
+func splitBatches(script string) []string {
+	var batches []string
+	for _, batch := range batchSeparator.Split(script, -1) {
+		if strings.TrimSpace(batch) != "" {
+			batches = append(batches, batch)
+		}
+	}
+	return batches
+}
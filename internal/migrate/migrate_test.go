@@ -0,0 +1,142 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+
+	"liangxiong/demo/migrations"
+)
+
+var testFS = fstest.MapFS{
+	"sqlserver/0002_add_index.up.sql":     {Data: []byte("CREATE TABLE b (id INT)\nGO\nCREATE INDEX ix_b ON b (id)\n")},
+	"sqlserver/0002_add_index.down.sql":   {Data: []byte("DROP TABLE b")},
+	"sqlserver/0001_create_a.up.sql":      {Data: []byte("CREATE TABLE a (id INT)")},
+	"sqlserver/0001_create_a.down.sql":    {Data: []byte("DROP TABLE a")},
+	"sqlserver/README.md":                 {Data: []byte("ignored")},
+	"sqlserver/0003_irreversible.up.sql":  {Data: []byte("DELETE FROM a")},
+	"sqlserver/0003_irreversible.sql.bak": {Data: []byte("ignored")},
+}
+
+func TestLoad(t *testing.T) {
+	sub, err := fs.Sub(testFS, "sqlserver")
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	migrations, err := Load(sub)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(migrations) != 3 || migrations[0].Version != 1 || migrations[1].Name != "add_index" || migrations[2].Down != "" {
+		t.Fatalf("unexpected migrations %+v", migrations)
+	}
+	if len(migrations[0].Checksum) != 64 {
+		t.Fatalf("expected a SHA-256 checksum, got %q", migrations[0].Checksum)
+	}
+	if batches := splitBatches(migrations[1].Up); len(batches) != 2 || !strings.Contains(batches[1], "CREATE INDEX") {
+		t.Fatalf("expected two batches, got %q", batches)
+	}
+
+	if _, err := Load(fstest.MapFS{"0001_a.down.sql": {Data: []byte("DROP TABLE a")}}); err == nil {
+		t.Fatal("expected a migration without up file to be rejected")
+	}
+}
+
+func TestUpAppliesPendingMigrationsUnderLock(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	migrator, err := New(db, testFS, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	first := migrator.migrations[0]
+
+	expectApplied := func(checksum string) {
+		mock.ExpectQuery(`sp_getapplock`).WithArgs(lockResource, int64(60000)).WillReturnRows(sqlmock.NewRows([]string{""}).AddRow(0))
+		mock.ExpectExec(`CREATE TABLE dbo.schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`OBJECT_ID`).WillReturnRows(sqlmock.NewRows([]string{""}).AddRow(true))
+		mock.ExpectQuery(`SELECT version, name, checksum, applied_at FROM schema_migrations`).
+			WillReturnRows(sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"}).AddRow(1, first.Name, checksum, time.Now()))
+	}
+
+	// Version 1 is applied; 2 and 3 run in order, each in its own transaction.
+	expectApplied(first.Checksum)
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE TABLE b`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE INDEX ix_b`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations`).WithArgs(int64(2), "add_index", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM a`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations`).WithArgs(int64(3), "irreversible", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`sp_releaseapplock`).WithArgs(lockResource).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	// An applied file edited afterwards stops the run before anything executes.
+	expectApplied(strings.Repeat("0", 64))
+	mock.ExpectExec(`sp_releaseapplock`).WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := migrator.Up(context.Background()); err == nil || !strings.Contains(err.Error(), "modified") {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestEmbeddedMigrationsRoundTripOnSQLite(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	migrator, err := New(db, migrations.FS, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctx := context.Background()
+	if err := migrator.Up(ctx); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt == nil || s.Modified || s.Missing {
+			t.Fatalf("expected every migration to be applied, got %+v", s)
+		}
+	}
+
+	// Every dialect must carry the same versions.
+	sqlserver, _ := fs.Sub(migrations.FS, "sqlserver")
+	want, err := Load(sqlserver)
+	if err != nil || len(want) != len(statuses) {
+		t.Fatalf("expected %d migrations like sqlserver, got %d (%v)", len(want), len(statuses), err)
+	}
+
+	if err := migrator.Goto(ctx, 0); err != nil {
+		t.Fatalf("goto 0: %v", err)
+	}
+	var tables int
+	if err := db.QueryRow(`SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name <> 'schema_migrations'`).Scan(&tables); err != nil || tables != 0 {
+		t.Fatalf("expected every table to be dropped, got %d (%v)", tables, err)
+	}
+}
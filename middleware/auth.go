@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"crypto/x509"
 	"net/http"
 	"strings"
 
@@ -9,14 +10,31 @@ import (
 
 	"liangxiong/demo/auth"
 	"liangxiong/demo/controller"
+	"liangxiong/demo/service"
 	"liangxiong/demo/utils"
 )
 
-// Auth validates JWT bearer tokens.
-func Auth(jwtManager *auth.JWTManager, logger *zap.Logger) gin.HandlerFunc {
+// APIKeyHeader carries API keys of service-to-service clients.
+const APIKeyHeader = "X-API-Key"
+
+// Auth validates JWT bearer tokens and rejects revoked ones. Requests with an
+// X-API-Key header are authenticated by API key instead, and requests with
+// neither credential by their verified TLS client certificate, if any.
+// Impersonation tokens are also revoked with the tokens of the real actor,
+// place that actor into context, and every impersonated request is logged.
+func Auth(jwtManager *auth.JWTManager, revocations *service.RevocationService, apiKeys *service.APIKeyService, certs *service.ClientCertService, logger *zap.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if key := strings.TrimSpace(c.GetHeader(APIKeyHeader)); key != "" {
+			authenticateAPIKey(c, apiKeys, key, logger)
+			return
+		}
+
 		token := strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer"))
 		if token == "" {
+			if cert := verifiedClientCert(c.Request); cert != nil {
+				authenticateClientCert(c, certs, cert, logger)
+				return
+			}
 			respondUnauthorized(c)
 			c.Abort()
 			return
@@ -30,10 +48,114 @@ func Auth(jwtManager *auth.JWTManager, logger *zap.Logger) gin.HandlerFunc {
 			return
 		}
 
+		info := utils.TokenInfo{ID: claims.ID}
+		if claims.IssuedAt != nil {
+			info.IssuedAt = claims.IssuedAt.Time
+		}
+		if claims.ExpiresAt != nil {
+			info.ExpiresAt = claims.ExpiresAt.Time
+		}
+
+		revoked, err := revocations.IsRevoked(c.Request.Context(), info.ID, claims.Subject, claims.Generation, info.IssuedAt, info.ExpiresAt)
+		if err == nil && !revoked && claims.Actor != nil {
+			// Revoking the support actor's tokens, as disabling them does,
+			// also ends their impersonations.
+			revoked, err = revocations.IsRevoked(c.Request.Context(), "", claims.Actor.Subject, claims.Actor.Generation, info.IssuedAt, info.ExpiresAt)
+		}
+		if err != nil {
+			controller.RespondError(c, logger, err)
+			c.Abort()
+			return
+		}
+		if revoked {
+			logger.Warn("revoked token presented", zap.String("jti", info.ID), zap.String("sub", claims.Subject))
+			respondUnauthorized(c)
+			c.Abort()
+			return
+		}
+
 		c.Set(utils.GinKeyUserID, claims.Subject)
-		c.Request = c.Request.WithContext(utils.WithUserID(c.Request.Context(), claims.Subject))
+		c.Set(utils.GinKeyRole, claims.Role)
+		ctx := utils.WithUserID(c.Request.Context(), claims.Subject)
+		ctx = utils.WithRole(ctx, claims.Role)
+		ctx = utils.WithTokenInfo(ctx, info)
+		ctx = utils.WithMFAPending(ctx, claims.MFAPending)
+		if claims.Actor == nil {
+			c.Request = c.Request.WithContext(ctx)
+			c.Next()
+			return
+		}
+
+		c.Set(utils.GinKeyActorID, claims.Actor.Subject)
+		c.Request = c.Request.WithContext(utils.WithActorID(ctx, claims.Actor.Subject))
 		c.Next()
+		logger.Info("impersonated request",
+			zap.String("actor", claims.Actor.Subject),
+			zap.String("sub", claims.Subject),
+			zap.String("jti", info.ID),
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.Int("status", c.Writer.Status()),
+			zap.String("traceId", c.GetString(utils.GinKeyTraceID)),
+		)
+	}
+}
+
+// authenticateAPIKey places the key principal and its scopes into context.
+func authenticateAPIKey(c *gin.Context, apiKeys *service.APIKeyService, key string, logger *zap.Logger) {
+	record, err := apiKeys.Authenticate(c.Request.Context(), key)
+	if err != nil {
+		if appErr, ok := utils.IsAppError(err); ok && appErr.HTTPStatus == http.StatusUnauthorized {
+			logger.Warn("invalid api key", zap.Any("details", appErr.Details))
+			respondUnauthorized(c)
+		} else {
+			controller.RespondError(c, logger, err)
+		}
+		c.Abort()
+		return
+	}
+
+	subject := auth.APIKeySubject(record.ID)
+	c.Set(utils.GinKeyUserID, subject)
+	ctx := utils.WithUserID(c.Request.Context(), subject)
+	ctx = utils.WithScopes(ctx, strings.Fields(record.Scopes))
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
+
+// authenticateClientCert places the principal mapped from the certificate into context.
+func authenticateClientCert(c *gin.Context, certs *service.ClientCertService, cert *x509.Certificate, logger *zap.Logger) {
+	principal, err := certs.Authenticate(c.Request.Context(), cert)
+	if err != nil {
+		if appErr, ok := utils.IsAppError(err); ok && appErr.HTTPStatus == http.StatusUnauthorized {
+			logger.Warn("unmapped client certificate", zap.Any("details", appErr.Details))
+			respondUnauthorized(c)
+		} else {
+			controller.RespondError(c, logger, err)
+		}
+		c.Abort()
+		return
+	}
+
+	c.Set(utils.GinKeyUserID, principal.Subject)
+	ctx := utils.WithUserID(c.Request.Context(), principal.Subject)
+	if principal.Service {
+		ctx = utils.WithScopes(ctx, principal.Scopes)
+	} else {
+		c.Set(utils.GinKeyRole, principal.Role)
+		ctx = utils.WithRole(ctx, principal.Role)
+	}
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
+
+// verifiedClientCert returns the leaf of a client certificate chain the TLS
+// handshake verified against the client CA bundle.
+func verifiedClientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
 	}
+	return r.TLS.VerifiedChains[0][0]
 }
 
 func respondUnauthorized(c *gin.Context) {
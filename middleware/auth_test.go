@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/internal/config"
+	"liangxiong/demo/service"
+	"liangxiong/demo/utils"
+)
+
+func TestAuthAcceptsMappedClientCertificate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mapper, err := auth.NewClientCertMapper([]config.ClientIdentityConfig{
+		{Subject: "CN=exporter", Service: "exporter", Scopes: []string{auth.PermExchangesRead}},
+	})
+	if err != nil {
+		t.Fatalf("new mapper: %v", err)
+	}
+	certs := service.NewClientCertService(nil, mapper)
+
+	engine := gin.New()
+	engine.GET("/", Auth(nil, nil, nil, certs, zap.NewNop()), RequirePermission(auth.PermExchangesRead), func(c *gin.Context) {
+		c.String(http.StatusOK, utils.UserIDFromContext(c.Request.Context()))
+	})
+
+	cases := []struct {
+		cn     string
+		tls    bool
+		status int
+	}{
+		{"exporter", true, http.StatusOK},
+		{"intruder", true, http.StatusUnauthorized},
+		{"exporter", false, http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.tls {
+			leaf := &x509.Certificate{Subject: pkix.Name{CommonName: tc.cn}}
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}, VerifiedChains: [][]*x509.Certificate{{leaf}}}
+		}
+
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Fatalf("%s (tls %v): expected %d got %d", tc.cn, tc.tls, tc.status, rec.Code)
+		}
+		if tc.status == http.StatusOK && rec.Body.String() != auth.ClientCertSubject("exporter") {
+			t.Fatalf("unexpected principal %q", rec.Body.String())
+		}
+	}
+}
@@ -15,6 +15,7 @@ func CORS(cfg config.CORSConfig) gin.HandlerFunc {
 		AllowOrigins:     cfg.AllowedOrigins,
 		AllowMethods:     cfg.AllowedMethods,
 		AllowHeaders:     cfg.AllowedHeaders,
+		ExposeHeaders:    []string{"ETag"},
 		AllowCredentials: cfg.AllowCredentials,
 		MaxAge:           12 * time.Hour,
 	})
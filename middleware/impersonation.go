@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"liangxiong/demo/controller"
+	"liangxiong/demo/utils"
+)
+
+// DenyImpersonation rejects impersonated requests. It guards operations that
+// must only be performed by the account owner (credentials, MFA, API keys)
+// or that would escalate an impersonation. It must run after Auth.
+func DenyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if utils.IsImpersonating(c.Request.Context()) {
+			traceID := c.GetString(utils.GinKeyTraceID)
+			c.JSON(utils.ErrImpersonated.HTTPStatus, controller.APIResponse{Code: utils.ErrImpersonated.Code, Message: utils.ErrImpersonated.Message, TraceID: traceID})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/internal/config"
+	"liangxiong/demo/repository"
+	"liangxiong/demo/service"
+	"liangxiong/demo/utils"
+)
+
+func TestImpersonatedRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	manager, err := auth.NewJWTManager(config.AuthConfig{
+		Issuer:         "test-issuer",
+		Audience:       "test-audience",
+		AccessTokenTTL: time.Minute,
+		Algorithm:      "HS256",
+		JWTSecret:      "secret",
+	})
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	impersonated, _, err := manager.GenerateImpersonation(auth.Identity{UserID: "user-1", Role: auth.RoleViewer}, auth.Actor{Subject: "admin-1"}, "jti-1", time.Minute)
+	if err != nil {
+		t.Fatalf("generate impersonation: %v", err)
+	}
+	regular, _, err := manager.Generate(auth.Identity{UserID: "user-1", Role: auth.RoleViewer})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+	// Revocation lookups are cached, so each user and token is queried once.
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM user_token_revocations`)).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM revoked_tokens`)).WithArgs("jti-1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM user_token_revocations`)).WithArgs("admin-1").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM revoked_tokens`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	revocations := service.NewRevocationService(db, repository.NewRevocationRepository(db), repository.NewRefreshTokenRepository(db), time.Minute)
+
+	engine := gin.New()
+	authenticated := Auth(manager, revocations, nil, nil, zap.NewNop())
+	engine.GET("/whoami", authenticated, func(c *gin.Context) {
+		ctx := c.Request.Context()
+		c.String(http.StatusOK, utils.UserIDFromContext(ctx)+"/"+utils.ActorIDFromContext(ctx))
+	})
+	engine.PUT("/password", authenticated, DenyImpersonation(), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	cases := []struct {
+		token  string
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{impersonated, http.MethodGet, "/whoami", http.StatusOK, "user-1/admin-1"},
+		{regular, http.MethodGet, "/whoami", http.StatusOK, "user-1/user-1"},
+		{impersonated, http.MethodPut, "/password", http.StatusForbidden, ""},
+		{regular, http.MethodPut, "/password", http.StatusNoContent, ""},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Fatalf("%s %s: expected %d got %d", tc.method, tc.path, tc.status, rec.Code)
+		}
+		if tc.body != "" && rec.Body.String() != tc.body {
+			t.Fatalf("%s %s: unexpected body %q", tc.method, tc.path, rec.Body.String())
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestImpersonationEndsWithActorRevocation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	manager, err := auth.NewJWTManager(config.AuthConfig{
+		Issuer:         "test-issuer",
+		Audience:       "test-audience",
+		AccessTokenTTL: time.Minute,
+		Algorithm:      "HS256",
+		JWTSecret:      "secret",
+	})
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	impersonated, _, err := manager.GenerateImpersonation(auth.Identity{UserID: "user-1", Role: auth.RoleViewer}, auth.Actor{Subject: "admin-1"}, "jti-1", time.Minute)
+	if err != nil {
+		t.Fatalf("generate impersonation: %v", err)
+	}
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+	// The impersonated user is untouched, but the actor's tokens were revoked
+	// after the impersonation token was issued.
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM user_token_revocations`)).WithArgs("user-1").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM revoked_tokens`)).WithArgs("jti-1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM user_token_revocations`)).WithArgs("admin-1").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "revoked_before", "generation"}).AddRow("admin-1", time.Now().Add(time.Second), 1))
+	revocations := service.NewRevocationService(db, repository.NewRevocationRepository(db), repository.NewRefreshTokenRepository(db), time.Minute)
+
+	engine := gin.New()
+	engine.GET("/whoami", Auth(manager, revocations, nil, nil, zap.NewNop()), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+	req.Header.Set("Authorization", "Bearer "+impersonated)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 once the actor is revoked, got %d", rec.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -19,7 +19,7 @@ func RateLimit(rps int) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if !limiter.Allow() {
 			traceID := c.GetString(utils.GinKeyTraceID)
-			c.AbortWithStatusJSON(http.StatusTooManyRequests, controller.APIResponse{Code: 429001, Message: "Too Many Requests", TraceID: traceID})
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, controller.APIResponse{Code: utils.ErrTooMany.Code, Message: utils.ErrTooMany.Message, TraceID: traceID})
 			return
 		}
 		c.Next()
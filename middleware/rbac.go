@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/controller"
+	"liangxiong/demo/utils"
+)
+
+// RequirePermission rejects callers whose role (or API key scopes) lack the
+// permission, and callers who must enrol in MFA first. It must run after
+// Auth, which places the role claim or scopes into context.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if utils.MFAPendingFromContext(c.Request.Context()) {
+			respondMFARequired(c)
+			c.Abort()
+			return
+		}
+		if !auth.Allowed(c.Request.Context(), permission) {
+			respondForbidden(c, permission)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireUser rejects API keys and client certificate services, which are
+// authorized by scopes and have no user account. It guards the endpoints of
+// the caller's own account and must run after Auth.
+func RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := utils.ScopesFromContext(c.Request.Context()); ok {
+			traceID := c.GetString(utils.GinKeyTraceID)
+			c.JSON(utils.ErrForbidden.HTTPStatus, controller.APIResponse{Code: utils.ErrForbidden.Code, Message: utils.ErrForbidden.Message, Details: map[string]string{"principal": "user accounts only"}, TraceID: traceID})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func respondForbidden(c *gin.Context, permission string) {
+	traceID := c.GetString(utils.GinKeyTraceID)
+	c.JSON(utils.ErrForbidden.HTTPStatus, controller.APIResponse{Code: utils.ErrForbidden.Code, Message: utils.ErrForbidden.Message, Details: map[string]string{"permission": permission}, TraceID: traceID})
+}
+
+func respondMFARequired(c *gin.Context) {
+	traceID := c.GetString(utils.GinKeyTraceID)
+	c.JSON(utils.ErrMFARequired.HTTPStatus, controller.APIResponse{Code: utils.ErrMFARequired.Code, Message: utils.ErrMFARequired.Message, Details: map[string]string{"mfa": "enroll via /api/v1/users/me/mfa/totp"}, TraceID: traceID})
+}
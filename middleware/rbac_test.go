@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/utils"
+)
+
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cases := []struct {
+		role   string
+		perm   string
+		status int
+	}{
+		{auth.RoleAdmin, auth.PermUsersAdmin, http.StatusOK},
+		{auth.RoleOperator, auth.PermExchangesWrite, http.StatusOK},
+		{auth.RoleOperator, auth.PermUsersAdmin, http.StatusForbidden},
+		{auth.RoleViewer, auth.PermExchangesWrite, http.StatusForbidden},
+		{"", auth.PermExchangesRead, http.StatusForbidden},
+	}
+
+	for _, tc := range cases {
+		engine := gin.New()
+		engine.GET("/", func(c *gin.Context) {
+			c.Request = c.Request.WithContext(utils.WithRole(c.Request.Context(), tc.role))
+			c.Next()
+		}, RequirePermission(tc.perm), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != tc.status {
+			t.Fatalf("role %q perm %q: expected %d got %d", tc.role, tc.perm, tc.status, rec.Code)
+		}
+	}
+}
+
+func TestRequirePermissionRejectsPendingMFA(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	engine := gin.New()
+	engine.GET("/", func(c *gin.Context) {
+		ctx := utils.WithRole(c.Request.Context(), auth.RoleAdmin)
+		c.Request = c.Request.WithContext(utils.WithMFAPending(ctx, true))
+		c.Next()
+	}, RequirePermission(auth.PermUsersRead), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "403002") {
+		t.Fatalf("expected mfa enrollment error, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRequirePermissionUsesAPIKeyScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cases := []struct {
+		scopes []string
+		perm   string
+		status int
+	}{
+		{[]string{auth.PermExchangesRead}, auth.PermExchangesRead, http.StatusOK},
+		{[]string{auth.PermExchangesRead}, auth.PermExchangesWrite, http.StatusForbidden},
+		{[]string{}, auth.PermExchangesRead, http.StatusForbidden},
+	}
+
+	for _, tc := range cases {
+		engine := gin.New()
+		engine.GET("/", func(c *gin.Context) {
+			// A role in context must not widen the key's scopes.
+			ctx := utils.WithRole(c.Request.Context(), auth.RoleAdmin)
+			c.Request = c.Request.WithContext(utils.WithScopes(ctx, tc.scopes))
+			c.Next()
+		}, RequirePermission(tc.perm), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != tc.status {
+			t.Fatalf("scopes %v perm %q: expected %d got %d", tc.scopes, tc.perm, tc.status, rec.Code)
+		}
+	}
+}
+
+func TestRequireUserRejectsScopedPrincipals(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cases := []struct {
+		name   string
+		ctx    func(context.Context) context.Context
+		status int
+	}{
+		{"user token", func(ctx context.Context) context.Context {
+			return utils.WithRole(utils.WithUserID(ctx, "user-1"), auth.RoleViewer)
+		}, http.StatusOK},
+		{"api key", func(ctx context.Context) context.Context {
+			return utils.WithScopes(utils.WithUserID(ctx, auth.APIKeySubject("key-1")), []string{auth.PermUsersRead})
+		}, http.StatusForbidden},
+		{"certificate service", func(ctx context.Context) context.Context {
+			return utils.WithScopes(utils.WithUserID(ctx, auth.ClientCertSubject("exporter")), nil)
+		}, http.StatusForbidden},
+	}
+
+	for _, tc := range cases {
+		engine := gin.New()
+		engine.GET("/", func(c *gin.Context) {
+			c.Request = c.Request.WithContext(tc.ctx(c.Request.Context()))
+			c.Next()
+		}, RequireUser(), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != tc.status || (tc.status == http.StatusForbidden && strings.Contains(rec.Body.String(), "key-1")) {
+			t.Fatalf("%s: expected %d, got %d %s", tc.name, tc.status, rec.Code, rec.Body.String())
+		}
+	}
+}
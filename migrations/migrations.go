@@ -0,0 +1,14 @@
+// Package migrations embeds the versioned schema migrations, one directory
+// per database dialect: sqlserver, postgres and sqlite.
+//
+// Files are named <version>_<name>.up.sql and <version>_<name>.down.sql.
+// Applied files must never be edited: their checksums are verified before
+// every migration run. Add a new version instead, to every dialect.
+package migrations
+
+import "embed"
+
+// FS holds the migration files.
+//
+//go:embed sqlserver postgres sqlite
+var FS embed.FS
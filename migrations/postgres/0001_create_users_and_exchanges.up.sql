@@ -0,0 +1,31 @@
+-- Row versions stand in for SQL Server's ROWVERSION: inserts take the column
+-- default and the repositories draw a new value on every update.
+CREATE TABLE users (
+    id            VARCHAR(64)  NOT NULL PRIMARY KEY,
+    username      VARCHAR(100) NOT NULL,
+    email         VARCHAR(255) NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    first_name    VARCHAR(100) NOT NULL,
+    last_name     VARCHAR(100) NOT NULL,
+    role          VARCHAR(50)  NOT NULL,
+    created_at    TIMESTAMPTZ  NOT NULL,
+    updated_at    TIMESTAMPTZ  NOT NULL,
+    locked_until  TIMESTAMPTZ  NULL,
+    status        VARCHAR(20)  NOT NULL DEFAULT 'active',
+    deleted_at    TIMESTAMPTZ  NULL,
+    deleted_by    VARCHAR(64)  NULL,
+    row_version   BYTEA        NOT NULL DEFAULT uuid_send(gen_random_uuid()),
+    CONSTRAINT uq_users_username UNIQUE (username)
+);
+CREATE INDEX ix_users_created_at ON users (created_at, id);
+
+CREATE TABLE TExchange (
+    MQMExchangeCode    VARCHAR(10)   NOT NULL PRIMARY KEY,
+    ClearExchangeCode  VARCHAR(10)   NOT NULL,
+    GlobexExchangeCode VARCHAR(10)   NOT NULL,
+    Description        VARCHAR(3000) NULL,
+    SegType            VARCHAR(10)   NOT NULL,
+    RowVersion         BYTEA         NOT NULL DEFAULT uuid_send(gen_random_uuid())
+);
+CREATE INDEX ix_texchange_clear_code ON TExchange (ClearExchangeCode);
+CREATE INDEX ix_texchange_globex_code ON TExchange (GlobexExchangeCode);
@@ -0,0 +1,37 @@
+CREATE TABLE refresh_tokens (
+    id         VARCHAR(64)  NOT NULL PRIMARY KEY,
+    user_id    VARCHAR(64)  NOT NULL,
+    family_id  VARCHAR(64)  NOT NULL,
+    token_hash VARCHAR(128) NOT NULL,
+    expires_at TIMESTAMPTZ  NOT NULL,
+    created_at TIMESTAMPTZ  NOT NULL,
+    rotated_at TIMESTAMPTZ  NULL,
+    revoked_at TIMESTAMPTZ  NULL,
+    CONSTRAINT uq_refresh_tokens_token_hash UNIQUE (token_hash)
+);
+CREATE INDEX ix_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX ix_refresh_tokens_user_id ON refresh_tokens (user_id);
+
+CREATE TABLE revoked_tokens (
+    jti        VARCHAR(64) NOT NULL PRIMARY KEY,
+    user_id    VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE user_token_revocations (
+    user_id        VARCHAR(64) NOT NULL PRIMARY KEY,
+    revoked_before TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE password_reset_tokens (
+    id         VARCHAR(64)  NOT NULL PRIMARY KEY,
+    user_id    VARCHAR(64)  NOT NULL,
+    token_hash VARCHAR(128) NOT NULL,
+    created_by VARCHAR(64)  NOT NULL,
+    expires_at TIMESTAMPTZ  NOT NULL,
+    created_at TIMESTAMPTZ  NOT NULL,
+    used_at    TIMESTAMPTZ  NULL,
+    CONSTRAINT uq_password_reset_tokens_token_hash UNIQUE (token_hash)
+);
+CREATE INDEX ix_password_reset_tokens_user_id ON password_reset_tokens (user_id);
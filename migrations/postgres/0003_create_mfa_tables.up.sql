@@ -0,0 +1,17 @@
+CREATE TABLE user_mfa (
+    user_id        VARCHAR(64)  NOT NULL PRIMARY KEY,
+    secret         VARCHAR(512) NOT NULL,
+    confirmed_at   TIMESTAMPTZ  NULL,
+    last_used_step BIGINT       NULL,
+    created_at     TIMESTAMPTZ  NOT NULL,
+    updated_at     TIMESTAMPTZ  NOT NULL
+);
+
+CREATE TABLE mfa_recovery_codes (
+    id         VARCHAR(64)  NOT NULL PRIMARY KEY,
+    user_id    VARCHAR(64)  NOT NULL,
+    code_hash  VARCHAR(128) NOT NULL,
+    created_at TIMESTAMPTZ  NOT NULL,
+    used_at    TIMESTAMPTZ  NULL
+);
+CREATE INDEX ix_mfa_recovery_codes_user_id ON mfa_recovery_codes (user_id, code_hash);
@@ -0,0 +1,32 @@
+CREATE TABLE api_keys (
+    id           VARCHAR(64)   NOT NULL PRIMARY KEY,
+    name         VARCHAR(100)  NOT NULL,
+    prefix       VARCHAR(32)   NOT NULL,
+    key_hash     VARCHAR(128)  NOT NULL,
+    scopes       VARCHAR(1000) NOT NULL,
+    created_by   VARCHAR(64)   NOT NULL,
+    expires_at   TIMESTAMPTZ   NULL,
+    last_used_at TIMESTAMPTZ   NULL,
+    created_at   TIMESTAMPTZ   NOT NULL,
+    revoked_at   TIMESTAMPTZ   NULL,
+    CONSTRAINT uq_api_keys_prefix UNIQUE (prefix)
+);
+
+CREATE TABLE user_identities (
+    provider   VARCHAR(50)  NOT NULL,
+    subject    VARCHAR(255) NOT NULL,
+    user_id    VARCHAR(64)  NOT NULL,
+    created_at TIMESTAMPTZ  NOT NULL,
+    CONSTRAINT pk_user_identities PRIMARY KEY (provider, subject)
+);
+
+CREATE TABLE impersonations (
+    id         VARCHAR(64)  NOT NULL PRIMARY KEY,
+    actor_id   VARCHAR(64)  NOT NULL,
+    user_id    VARCHAR(64)  NOT NULL,
+    reason     VARCHAR(500) NOT NULL,
+    token_id   VARCHAR(64)  NOT NULL,
+    created_at TIMESTAMPTZ  NOT NULL,
+    expires_at TIMESTAMPTZ  NOT NULL
+);
+CREATE INDEX ix_impersonations_user_id ON impersonations (user_id, created_at);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS ix_revoked_tokens_expires_at;
@@ -0,0 +1 @@
+CREATE INDEX ix_revoked_tokens_expires_at ON revoked_tokens (expires_at);
@@ -0,0 +1 @@
+ALTER TABLE user_token_revocations ADD COLUMN generation BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,3 @@
+-- The up step fails on existing tables, so both were created by it.
+DROP TABLE IF EXISTS TExchange;
+DROP TABLE IF EXISTS users;
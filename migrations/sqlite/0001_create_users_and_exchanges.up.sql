@@ -0,0 +1,31 @@
+-- Row versions stand in for SQL Server's ROWVERSION: inserts take the column
+-- default and the repositories draw a new value on every update.
+CREATE TABLE users (
+    id            TEXT     NOT NULL PRIMARY KEY,
+    username      TEXT     NOT NULL,
+    email         TEXT     NOT NULL,
+    password_hash TEXT     NOT NULL,
+    first_name    TEXT     NOT NULL,
+    last_name     TEXT     NOT NULL,
+    role          TEXT     NOT NULL,
+    created_at    DATETIME NOT NULL,
+    updated_at    DATETIME NOT NULL,
+    locked_until  DATETIME NULL,
+    status        TEXT     NOT NULL DEFAULT 'active',
+    deleted_at    DATETIME NULL,
+    deleted_by    TEXT     NULL,
+    row_version   BLOB     NOT NULL DEFAULT (randomblob(8)),
+    CONSTRAINT uq_users_username UNIQUE (username)
+);
+CREATE INDEX ix_users_created_at ON users (created_at, id);
+
+CREATE TABLE TExchange (
+    MQMExchangeCode    TEXT NOT NULL PRIMARY KEY,
+    ClearExchangeCode  TEXT NOT NULL,
+    GlobexExchangeCode TEXT NOT NULL,
+    Description        TEXT NULL,
+    SegType            TEXT NOT NULL,
+    RowVersion         BLOB NOT NULL DEFAULT (randomblob(8))
+);
+CREATE INDEX ix_texchange_clear_code ON TExchange (ClearExchangeCode);
+CREATE INDEX ix_texchange_globex_code ON TExchange (GlobexExchangeCode);
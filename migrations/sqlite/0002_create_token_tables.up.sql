@@ -0,0 +1,37 @@
+CREATE TABLE refresh_tokens (
+    id         TEXT     NOT NULL PRIMARY KEY,
+    user_id    TEXT     NOT NULL,
+    family_id  TEXT     NOT NULL,
+    token_hash TEXT     NOT NULL,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL,
+    rotated_at DATETIME NULL,
+    revoked_at DATETIME NULL,
+    CONSTRAINT uq_refresh_tokens_token_hash UNIQUE (token_hash)
+);
+CREATE INDEX ix_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX ix_refresh_tokens_user_id ON refresh_tokens (user_id);
+
+CREATE TABLE revoked_tokens (
+    jti        TEXT     NOT NULL PRIMARY KEY,
+    user_id    TEXT     NOT NULL,
+    expires_at DATETIME NOT NULL,
+    revoked_at DATETIME NOT NULL
+);
+
+CREATE TABLE user_token_revocations (
+    user_id        TEXT     NOT NULL PRIMARY KEY,
+    revoked_before DATETIME NOT NULL
+);
+
+CREATE TABLE password_reset_tokens (
+    id         TEXT     NOT NULL PRIMARY KEY,
+    user_id    TEXT     NOT NULL,
+    token_hash TEXT     NOT NULL,
+    created_by TEXT     NOT NULL,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL,
+    used_at    DATETIME NULL,
+    CONSTRAINT uq_password_reset_tokens_token_hash UNIQUE (token_hash)
+);
+CREATE INDEX ix_password_reset_tokens_user_id ON password_reset_tokens (user_id);
@@ -0,0 +1,17 @@
+CREATE TABLE user_mfa (
+    user_id        TEXT     NOT NULL PRIMARY KEY,
+    secret         TEXT     NOT NULL,
+    confirmed_at   DATETIME NULL,
+    last_used_step INTEGER  NULL,
+    created_at     DATETIME NOT NULL,
+    updated_at     DATETIME NOT NULL
+);
+
+CREATE TABLE mfa_recovery_codes (
+    id         TEXT     NOT NULL PRIMARY KEY,
+    user_id    TEXT     NOT NULL,
+    code_hash  TEXT     NOT NULL,
+    created_at DATETIME NOT NULL,
+    used_at    DATETIME NULL
+);
+CREATE INDEX ix_mfa_recovery_codes_user_id ON mfa_recovery_codes (user_id, code_hash);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS impersonations;
+DROP TABLE IF EXISTS user_identities;
+DROP TABLE IF EXISTS api_keys;
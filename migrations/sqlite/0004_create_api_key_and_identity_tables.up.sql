@@ -0,0 +1,32 @@
+CREATE TABLE api_keys (
+    id           TEXT     NOT NULL PRIMARY KEY,
+    name         TEXT     NOT NULL,
+    prefix       TEXT     NOT NULL,
+    key_hash     TEXT     NOT NULL,
+    scopes       TEXT     NOT NULL,
+    created_by   TEXT     NOT NULL,
+    expires_at   DATETIME NULL,
+    last_used_at DATETIME NULL,
+    created_at   DATETIME NOT NULL,
+    revoked_at   DATETIME NULL,
+    CONSTRAINT uq_api_keys_prefix UNIQUE (prefix)
+);
+
+CREATE TABLE user_identities (
+    provider   TEXT     NOT NULL,
+    subject    TEXT     NOT NULL,
+    user_id    TEXT     NOT NULL,
+    created_at DATETIME NOT NULL,
+    CONSTRAINT pk_user_identities PRIMARY KEY (provider, subject)
+);
+
+CREATE TABLE impersonations (
+    id         TEXT     NOT NULL PRIMARY KEY,
+    actor_id   TEXT     NOT NULL,
+    user_id    TEXT     NOT NULL,
+    reason     TEXT     NOT NULL,
+    token_id   TEXT     NOT NULL,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL
+);
+CREATE INDEX ix_impersonations_user_id ON impersonations (user_id, created_at);
@@ -0,0 +1,10 @@
+-- The tables may predate the migrations, so only what the up step adds to
+-- them is removed; their rows are kept.
+DROP INDEX IF EXISTS ix_texchange_globex_code ON dbo.TExchange;
+DROP INDEX IF EXISTS ix_texchange_clear_code ON dbo.TExchange;
+ALTER TABLE dbo.TExchange DROP COLUMN IF EXISTS RowVersion;
+GO
+
+DROP INDEX IF EXISTS ix_users_created_at ON dbo.users;
+ALTER TABLE dbo.users DROP CONSTRAINT IF EXISTS df_users_status;
+ALTER TABLE dbo.users DROP COLUMN IF EXISTS row_version, deleted_by, deleted_at, status, locked_until;
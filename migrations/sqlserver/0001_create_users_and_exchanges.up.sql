@@ -0,0 +1,49 @@
+-- Tables may predate the migrations; create them when missing and add the
+-- columns introduced since.
+IF OBJECT_ID(N'dbo.users', N'U') IS NULL
+CREATE TABLE dbo.users (
+    id            NVARCHAR(64)  NOT NULL PRIMARY KEY,
+    username      NVARCHAR(100) NOT NULL,
+    email         NVARCHAR(255) NOT NULL,
+    password_hash NVARCHAR(255) NOT NULL,
+    first_name    NVARCHAR(100) NOT NULL,
+    last_name     NVARCHAR(100) NOT NULL,
+    role          NVARCHAR(50)  NOT NULL,
+    created_at    DATETIME2     NOT NULL,
+    updated_at    DATETIME2     NOT NULL,
+    CONSTRAINT uq_users_username UNIQUE (username)
+);
+
+IF COL_LENGTH(N'dbo.users', N'locked_until') IS NULL
+    ALTER TABLE dbo.users ADD locked_until DATETIME2 NULL;
+IF COL_LENGTH(N'dbo.users', N'status') IS NULL
+    ALTER TABLE dbo.users ADD status NVARCHAR(20) NOT NULL CONSTRAINT df_users_status DEFAULT 'active';
+IF COL_LENGTH(N'dbo.users', N'deleted_at') IS NULL
+    ALTER TABLE dbo.users ADD deleted_at DATETIME2 NULL;
+IF COL_LENGTH(N'dbo.users', N'deleted_by') IS NULL
+    ALTER TABLE dbo.users ADD deleted_by NVARCHAR(64) NULL;
+IF COL_LENGTH(N'dbo.users', N'row_version') IS NULL
+    ALTER TABLE dbo.users ADD row_version ROWVERSION;
+GO
+
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'ix_users_created_at' AND object_id = OBJECT_ID(N'dbo.users'))
+    CREATE INDEX ix_users_created_at ON dbo.users (created_at, id);
+GO
+
+IF OBJECT_ID(N'dbo.TExchange', N'U') IS NULL
+CREATE TABLE dbo.TExchange (
+    MQMExchangeCode    NVARCHAR(10)   NOT NULL PRIMARY KEY,
+    ClearExchangeCode  NVARCHAR(10)   NOT NULL,
+    GlobexExchangeCode NVARCHAR(10)   NOT NULL,
+    Description        NVARCHAR(3000) NULL,
+    SegType            NVARCHAR(10)   NOT NULL
+);
+
+IF COL_LENGTH(N'dbo.TExchange', N'RowVersion') IS NULL
+    ALTER TABLE dbo.TExchange ADD RowVersion ROWVERSION;
+GO
+
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'ix_texchange_clear_code' AND object_id = OBJECT_ID(N'dbo.TExchange'))
+    CREATE INDEX ix_texchange_clear_code ON dbo.TExchange (ClearExchangeCode);
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'ix_texchange_globex_code' AND object_id = OBJECT_ID(N'dbo.TExchange'))
+    CREATE INDEX ix_texchange_globex_code ON dbo.TExchange (GlobexExchangeCode);
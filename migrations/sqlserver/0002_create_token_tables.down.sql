@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS dbo.password_reset_tokens;
+DROP TABLE IF EXISTS dbo.user_token_revocations;
+DROP TABLE IF EXISTS dbo.revoked_tokens;
+DROP TABLE IF EXISTS dbo.refresh_tokens;
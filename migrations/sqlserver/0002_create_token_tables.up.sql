@@ -0,0 +1,37 @@
+CREATE TABLE dbo.refresh_tokens (
+    id         NVARCHAR(64)  NOT NULL PRIMARY KEY,
+    user_id    NVARCHAR(64)  NOT NULL,
+    family_id  NVARCHAR(64)  NOT NULL,
+    token_hash NVARCHAR(128) NOT NULL,
+    expires_at DATETIME2     NOT NULL,
+    created_at DATETIME2     NOT NULL,
+    rotated_at DATETIME2     NULL,
+    revoked_at DATETIME2     NULL,
+    CONSTRAINT uq_refresh_tokens_token_hash UNIQUE (token_hash)
+);
+CREATE INDEX ix_refresh_tokens_family_id ON dbo.refresh_tokens (family_id);
+CREATE INDEX ix_refresh_tokens_user_id ON dbo.refresh_tokens (user_id);
+
+CREATE TABLE dbo.revoked_tokens (
+    jti        NVARCHAR(64) NOT NULL PRIMARY KEY,
+    user_id    NVARCHAR(64) NOT NULL,
+    expires_at DATETIME2    NOT NULL,
+    revoked_at DATETIME2    NOT NULL
+);
+
+CREATE TABLE dbo.user_token_revocations (
+    user_id        NVARCHAR(64) NOT NULL PRIMARY KEY,
+    revoked_before DATETIME2    NOT NULL
+);
+
+CREATE TABLE dbo.password_reset_tokens (
+    id         NVARCHAR(64)  NOT NULL PRIMARY KEY,
+    user_id    NVARCHAR(64)  NOT NULL,
+    token_hash NVARCHAR(128) NOT NULL,
+    created_by NVARCHAR(64)  NOT NULL,
+    expires_at DATETIME2     NOT NULL,
+    created_at DATETIME2     NOT NULL,
+    used_at    DATETIME2     NULL,
+    CONSTRAINT uq_password_reset_tokens_token_hash UNIQUE (token_hash)
+);
+CREATE INDEX ix_password_reset_tokens_user_id ON dbo.password_reset_tokens (user_id);
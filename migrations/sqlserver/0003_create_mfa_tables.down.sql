@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS dbo.mfa_recovery_codes;
+DROP TABLE IF EXISTS dbo.user_mfa;
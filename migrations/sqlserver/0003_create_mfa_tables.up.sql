@@ -0,0 +1,17 @@
+CREATE TABLE dbo.user_mfa (
+    user_id        NVARCHAR(64)  NOT NULL PRIMARY KEY,
+    secret         NVARCHAR(512) NOT NULL,
+    confirmed_at   DATETIME2     NULL,
+    last_used_step BIGINT        NULL,
+    created_at     DATETIME2     NOT NULL,
+    updated_at     DATETIME2     NOT NULL
+);
+
+CREATE TABLE dbo.mfa_recovery_codes (
+    id         NVARCHAR(64)  NOT NULL PRIMARY KEY,
+    user_id    NVARCHAR(64)  NOT NULL,
+    code_hash  NVARCHAR(128) NOT NULL,
+    created_at DATETIME2     NOT NULL,
+    used_at    DATETIME2     NULL
+);
+CREATE INDEX ix_mfa_recovery_codes_user_id ON dbo.mfa_recovery_codes (user_id, code_hash);
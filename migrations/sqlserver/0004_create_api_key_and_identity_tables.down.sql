@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS dbo.impersonations;
+DROP TABLE IF EXISTS dbo.user_identities;
+DROP TABLE IF EXISTS dbo.api_keys;
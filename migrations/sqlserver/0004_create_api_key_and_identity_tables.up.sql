@@ -0,0 +1,32 @@
+CREATE TABLE dbo.api_keys (
+    id           NVARCHAR(64)   NOT NULL PRIMARY KEY,
+    name         NVARCHAR(100)  NOT NULL,
+    prefix       NVARCHAR(32)   NOT NULL,
+    key_hash     NVARCHAR(128)  NOT NULL,
+    scopes       NVARCHAR(1000) NOT NULL,
+    created_by   NVARCHAR(64)   NOT NULL,
+    expires_at   DATETIME2      NULL,
+    last_used_at DATETIME2      NULL,
+    created_at   DATETIME2      NOT NULL,
+    revoked_at   DATETIME2      NULL,
+    CONSTRAINT uq_api_keys_prefix UNIQUE (prefix)
+);
+
+CREATE TABLE dbo.user_identities (
+    provider   NVARCHAR(50)  NOT NULL,
+    subject    NVARCHAR(255) NOT NULL,
+    user_id    NVARCHAR(64)  NOT NULL,
+    created_at DATETIME2     NOT NULL,
+    CONSTRAINT pk_user_identities PRIMARY KEY (provider, subject)
+);
+
+CREATE TABLE dbo.impersonations (
+    id         NVARCHAR(64)  NOT NULL PRIMARY KEY,
+    actor_id   NVARCHAR(64)  NOT NULL,
+    user_id    NVARCHAR(64)  NOT NULL,
+    reason     NVARCHAR(500) NOT NULL,
+    token_id   NVARCHAR(64)  NOT NULL,
+    created_at DATETIME2     NOT NULL,
+    expires_at DATETIME2     NOT NULL
+);
+CREATE INDEX ix_impersonations_user_id ON dbo.impersonations (user_id, created_at);
@@ -0,0 +1,2 @@
+ALTER TABLE dbo.user_token_revocations DROP CONSTRAINT IF EXISTS df_user_token_revocations_generation;
+ALTER TABLE dbo.user_token_revocations DROP COLUMN IF EXISTS generation;
@@ -0,0 +1 @@
+ALTER TABLE dbo.user_token_revocations ADD generation BIGINT NOT NULL CONSTRAINT df_user_token_revocations_generation DEFAULT 1;
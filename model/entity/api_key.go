@@ -0,0 +1,21 @@
+package entity
+
+import (
+	"database/sql"
+	"time"
+)
+
+// APIKey mirrors the api_keys table schema. Only the SHA-256 hash of the key
+// is persisted; Scopes holds space-separated permissions.
+type APIKey struct {
+	ID         string       `db:"id"`
+	Name       string       `db:"name"`
+	Prefix     string       `db:"prefix"`
+	KeyHash    string       `db:"key_hash"`
+	Scopes     string       `db:"scopes"`
+	CreatedBy  string       `db:"created_by"`
+	ExpiresAt  sql.NullTime `db:"expires_at"`
+	LastUsedAt sql.NullTime `db:"last_used_at"`
+	CreatedAt  time.Time    `db:"created_at"`
+	RevokedAt  sql.NullTime `db:"revoked_at"`
+}
@@ -9,4 +9,5 @@ type Exchange struct {
 	GlobexExchangeCode string         `db:"globex_exchange_code"`
 	Description        sql.NullString `db:"description"`
 	SegType            string         `db:"seg_type"`
+	RowVersion         []byte         `db:"row_version"`
 }
@@ -0,0 +1,15 @@
+package entity
+
+import "time"
+
+// Impersonation mirrors the impersonations table schema: one audit row per
+// impersonation token issued to support staff. TokenID is the token's jti.
+type Impersonation struct {
+	ID        string    `db:"id"`
+	ActorID   string    `db:"actor_id"`
+	UserID    string    `db:"user_id"`
+	Reason    string    `db:"reason"`
+	TokenID   string    `db:"token_id"`
+	CreatedAt time.Time `db:"created_at"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
@@ -0,0 +1,18 @@
+package entity
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PasswordResetToken mirrors the password_reset_tokens table schema.
+// Only the SHA-256 hash of the one-time token is persisted.
+type PasswordResetToken struct {
+	ID        string       `db:"id"`
+	UserID    string       `db:"user_id"`
+	TokenHash string       `db:"token_hash"`
+	CreatedBy string       `db:"created_by"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	CreatedAt time.Time    `db:"created_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+}
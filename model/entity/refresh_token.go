@@ -0,0 +1,19 @@
+package entity
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RefreshToken mirrors the refresh_tokens table schema.
+// Only the SHA-256 hash of the opaque token is persisted.
+type RefreshToken struct {
+	ID        string       `db:"id"`
+	UserID    string       `db:"user_id"`
+	FamilyID  string       `db:"family_id"`
+	TokenHash string       `db:"token_hash"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	CreatedAt time.Time    `db:"created_at"`
+	RotatedAt sql.NullTime `db:"rotated_at"`
+	RevokedAt sql.NullTime `db:"revoked_at"`
+}
@@ -0,0 +1,21 @@
+package entity
+
+import "time"
+
+// RevokedToken mirrors the revoked_tokens table schema keyed by JWT ID.
+type RevokedToken struct {
+	JTI       string    `db:"jti"`
+	UserID    string    `db:"user_id"`
+	ExpiresAt time.Time `db:"expires_at"`
+	RevokedAt time.Time `db:"revoked_at"`
+}
+
+// UserTokenRevocation mirrors the user_token_revocations table schema.
+// Tokens of the user issued before RevokedBefore, a whole second, are
+// rejected, and so are those issued within it that carry a generation older
+// than Generation, which counts the revocations of the user.
+type UserTokenRevocation struct {
+	UserID        string    `db:"user_id"`
+	RevokedBefore time.Time `db:"revoked_before"`
+	Generation    int64     `db:"generation"`
+}
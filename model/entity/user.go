@@ -1,16 +1,32 @@
 package entity
 
-import "time"
+import (
+	"database/sql"
+	"time"
+)
+
+// User account statuses. Only active users can sign in; deleted users are
+// kept for history and can be restored.
+const (
+	UserStatusActive   = "active"
+	UserStatusDisabled = "disabled"
+	UserStatusDeleted  = "deleted"
+)
 
 // User mirrors the users table schema.
 type User struct {
-	ID           string    `db:"id"`
-	Username     string    `db:"username"`
-	Email        string    `db:"email"`
-	PasswordHash string    `db:"password_hash"`
-	FirstName    string    `db:"first_name"`
-	LastName     string    `db:"last_name"`
-	Role         string    `db:"role"`
-	CreatedAt    time.Time `db:"created_at"`
-	UpdatedAt    time.Time `db:"updated_at"`
+	ID           string         `db:"id"`
+	Username     string         `db:"username"`
+	Email        string         `db:"email"`
+	PasswordHash string         `db:"password_hash"`
+	FirstName    string         `db:"first_name"`
+	LastName     string         `db:"last_name"`
+	Role         string         `db:"role"`
+	CreatedAt    time.Time      `db:"created_at"`
+	UpdatedAt    time.Time      `db:"updated_at"`
+	LockedUntil  sql.NullTime   `db:"locked_until"`
+	Status       string         `db:"status"`
+	DeletedAt    sql.NullTime   `db:"deleted_at"`
+	DeletedBy    sql.NullString `db:"deleted_by"`
+	Version      []byte         `db:"row_version"`
 }
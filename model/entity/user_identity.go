@@ -0,0 +1,12 @@
+package entity
+
+import "time"
+
+// UserIdentity mirrors the user_identities table schema. It links the
+// subject asserted by an external identity provider to a local user.
+type UserIdentity struct {
+	Provider  string    `db:"provider"`
+	Subject   string    `db:"subject"`
+	UserID    string    `db:"user_id"`
+	CreatedAt time.Time `db:"created_at"`
+}
@@ -0,0 +1,27 @@
+package entity
+
+import (
+	"database/sql"
+	"time"
+)
+
+// UserMFA mirrors the user_mfa table schema. Secret holds the TOTP seed
+// encrypted at rest; enrolment is complete once ConfirmedAt is set.
+type UserMFA struct {
+	UserID       string        `db:"user_id"`
+	Secret       string        `db:"secret"`
+	ConfirmedAt  sql.NullTime  `db:"confirmed_at"`
+	LastUsedStep sql.NullInt64 `db:"last_used_step"`
+	CreatedAt    time.Time     `db:"created_at"`
+	UpdatedAt    time.Time     `db:"updated_at"`
+}
+
+// MFARecoveryCode mirrors the mfa_recovery_codes table schema.
+// Only the SHA-256 hash of each one-time code is persisted.
+type MFARecoveryCode struct {
+	ID        string       `db:"id"`
+	UserID    string       `db:"user_id"`
+	CodeHash  string       `db:"code_hash"`
+	CreatedAt time.Time    `db:"created_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+}
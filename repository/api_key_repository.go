@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"liangxiong/demo/internal/dialect"
+	"liangxiong/demo/model/entity"
+	"liangxiong/demo/utils"
+)
+
+// apiKeyColumns lists the api_keys columns in scanAPIKey order.
+const apiKeyColumns = `id, name, prefix, key_hash, scopes, created_by, expires_at, last_used_at, created_at, revoked_at`
+
+// APIKeyRepository exposes persistence operations for API keys.
+type APIKeyRepository interface {
+	GetByID(ctx context.Context, id string) (*entity.APIKey, error)
+	GetByPrefix(ctx context.Context, prefix string) (*entity.APIKey, error)
+	List(ctx context.Context, page, size int) ([]entity.APIKey, int64, error)
+	Create(ctx context.Context, key *entity.APIKey) error
+	Revoke(ctx context.Context, id string, at time.Time) error
+	UpdateLastUsed(ctx context.Context, id string, at time.Time) error
+}
+
+// SQLAPIKeyRepository is the database/sql implementation.
+type SQLAPIKeyRepository struct {
+	db      *sql.DB
+	dialect dialect.Dialect
+}
+
+// NewAPIKeyRepository builds the repository.
+func NewAPIKeyRepository(db *sql.DB) *SQLAPIKeyRepository {
+	return &SQLAPIKeyRepository{db: db, dialect: dialect.Of(db)}
+}
+
+func (r *SQLAPIKeyRepository) withExecutor(ctx context.Context) sqlExecutor {
+	return newExecutor(ctx, r.db, r.dialect)
+}
+
+// GetByID fetches an API key by identifier.
+func (r *SQLAPIKeyRepository) GetByID(ctx context.Context, id string) (*entity.APIKey, error) {
+	row := r.withExecutor(ctx).queryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = @p1`, id)
+	return scanAPIKey(row)
+}
+
+// GetByPrefix fetches an API key by its public lookup prefix.
+func (r *SQLAPIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*entity.APIKey, error) {
+	row := r.withExecutor(ctx).queryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE prefix = @p1`, prefix)
+	return scanAPIKey(row)
+}
+
+// List returns paginated API keys plus total count.
+func (r *SQLAPIKeyRepository) List(ctx context.Context, page, size int) ([]entity.APIKey, int64, error) {
+	exec := r.withExecutor(ctx)
+	offset := utils.Offset(page, size)
+	limit := utils.NormalizeSize(size)
+	rows, err := exec.queryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY created_at DESC`+r.dialect.Paginate("@p1", "@p2"), offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var keys []entity.APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		keys = append(keys, *k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var total int64
+	row := exec.queryRowContext(ctx, `SELECT COUNT(1) FROM api_keys`)
+	if err := row.Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	return keys, total, nil
+}
+
+// Create inserts a new API key.
+func (r *SQLAPIKeyRepository) Create(ctx context.Context, key *entity.APIKey) error {
+	_, err := r.withExecutor(ctx).execContext(ctx, `INSERT INTO api_keys (id, name, prefix, key_hash, scopes, created_by, expires_at, created_at) VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8)`,
+		key.ID, key.Name, key.Prefix, key.KeyHash, key.Scopes, key.CreatedBy, key.ExpiresAt, key.CreatedAt)
+	return err
+}
+
+// Revoke disables an API key. Revoking twice keeps the first timestamp.
+func (r *SQLAPIKeyRepository) Revoke(ctx context.Context, id string, at time.Time) error {
+	_, err := r.withExecutor(ctx).execContext(ctx, `UPDATE api_keys SET revoked_at = @p1 WHERE id = @p2 AND revoked_at IS NULL`, at, id)
+	return err
+}
+
+// UpdateLastUsed records when an API key was last presented.
+func (r *SQLAPIKeyRepository) UpdateLastUsed(ctx context.Context, id string, at time.Time) error {
+	_, err := r.withExecutor(ctx).execContext(ctx, `UPDATE api_keys SET last_used_at = @p1 WHERE id = @p2`, at, id)
+	return err
+}
+
+func scanAPIKey(row rowScanner) (*entity.APIKey, error) {
+	if row == nil {
+		return nil, sql.ErrNoRows
+	}
+	var k entity.APIKey
+	if err := row.Scan(&k.ID, &k.Name, &k.Prefix, &k.KeyHash, &k.Scopes, &k.CreatedBy, &k.ExpiresAt, &k.LastUsedAt, &k.CreatedAt, &k.RevokedAt); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
@@ -3,101 +3,231 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"fmt"
 
+	"liangxiong/demo/internal/dialect"
 	"liangxiong/demo/model/entity"
-	"liangxiong/demo/utils"
 )
 
+// exchangeColumns lists the TExchange columns in scanExchange order.
+const exchangeColumns = `MQMExchangeCode, ClearExchangeCode, GlobexExchangeCode, Description, SegType, RowVersion`
+
+// Exchange code schemes, i.e. the columns an exchange can be looked up by.
+const (
+	ExchangeSchemeMQM    = "mqm"
+	ExchangeSchemeClear  = "clear"
+	ExchangeSchemeGlobex = "globex"
+)
+
+// exchangeCodeColumns maps each code scheme to its column.
+var exchangeCodeColumns = map[string]string{
+	ExchangeSchemeMQM:    "MQMExchangeCode",
+	ExchangeSchemeClear:  "ClearExchangeCode",
+	ExchangeSchemeGlobex: "GlobexExchangeCode",
+}
+
+// exchangeKeyset lists exchanges by MQM code, which is unique.
+var exchangeKeyset = keyset[entity.Exchange]{
+	keys: map[string]sortKey[entity.Exchange]{
+		"mqmExchangeCode": stringKey("MQMExchangeCode", func(e *entity.Exchange) string { return e.MQMExchangeCode }),
+	},
+	tiebreak: "mqmExchangeCode",
+}
+
+// exchangePatchColumns lists the exchange fields a partial update may write.
+var exchangePatchColumns = map[string]patchColumn[entity.Exchange]{
+	"clearExchangeCode":  {"ClearExchangeCode", func(e *entity.Exchange) any { return e.ClearExchangeCode }},
+	"globexExchangeCode": {"GlobexExchangeCode", func(e *entity.Exchange) any { return e.GlobexExchangeCode }},
+	"description":        {"Description", func(e *entity.Exchange) any { return e.Description }},
+	"segType":            {"SegType", func(e *entity.Exchange) any { return e.SegType }},
+}
+
+// ExchangeFilter narrows an exchange listing. Codes and SegType match
+// exactly, Search is a substring of the description. Empty values are ignored.
+type ExchangeFilter struct {
+	ClearCode  string
+	GlobexCode string
+	SegType    string
+	Search     string
+}
+
+func (f ExchangeFilter) where() *whereClause {
+	w := &whereClause{}
+	if f.ClearCode != "" {
+		w.add(`ClearExchangeCode = ?`, f.ClearCode)
+	}
+	if f.GlobexCode != "" {
+		w.add(`GlobexExchangeCode = ?`, f.GlobexCode)
+	}
+	if f.SegType != "" {
+		w.add(`SegType = ?`, f.SegType)
+	}
+	if f.Search != "" {
+		w.add(`Description LIKE ? ESCAPE '\'`, likeContains(f.Search))
+	}
+	return w
+}
+
 // ExchangeRepository exposes persistence operations for exchanges.
 type ExchangeRepository interface {
-	GetByCode(ctx context.Context, exec *sql.Tx, code string) (*entity.Exchange, error)
-	List(ctx context.Context, page, size int) ([]entity.Exchange, int64, error)
-	Create(ctx context.Context, exec *sql.Tx, exchange *entity.Exchange) error
-	Update(ctx context.Context, exec *sql.Tx, exchange *entity.Exchange) error
-	Delete(ctx context.Context, exec *sql.Tx, code string) error
+	GetByCode(ctx context.Context, code string) (*entity.Exchange, error)
+	FindByCode(ctx context.Context, scheme, code string) ([]entity.Exchange, error)
+	List(ctx context.Context, filter ExchangeFilter, page PageQuery) (*Page[entity.Exchange], error)
+	Create(ctx context.Context, exchange *entity.Exchange) error
+	Update(ctx context.Context, exchange *entity.Exchange) (bool, error)
+	Patch(ctx context.Context, exchange *entity.Exchange, fields []string, version []byte) (bool, error)
+	Delete(ctx context.Context, code string, version []byte) (bool, error)
 }
 
-// SQLExchangeRepository is the SQL Server implementation.
+// SQLExchangeRepository is the database/sql implementation.
 type SQLExchangeRepository struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect dialect.Dialect
 }
 
 // NewExchangeRepository builds the repository.
 func NewExchangeRepository(db *sql.DB) *SQLExchangeRepository {
-	return &SQLExchangeRepository{db: db}
+	return &SQLExchangeRepository{db: db, dialect: dialect.Of(db)}
 }
 
-func (r *SQLExchangeRepository) withExecutor(exec *sql.Tx) sqlExecutor {
-	if exec != nil {
-		return sqlExecutor{inner: exec}
-	}
-	return sqlExecutor{inner: r.db}
+func (r *SQLExchangeRepository) withExecutor(ctx context.Context) sqlExecutor {
+	return newExecutor(ctx, r.db, r.dialect)
 }
 
 // GetByCode retrieves a record by MQMExchangeCode.
-func (r *SQLExchangeRepository) GetByCode(ctx context.Context, exec *sql.Tx, code string) (*entity.Exchange, error) {
-	row := r.withExecutor(exec).queryRowContext(ctx, `SELECT MQMExchangeCode, ClearExchangeCode, GlobexExchangeCode, Description, SegType FROM TExchange WHERE MQMExchangeCode = @p1`, code)
+func (r *SQLExchangeRepository) GetByCode(ctx context.Context, code string) (*entity.Exchange, error) {
+	row := r.withExecutor(ctx).queryRowContext(ctx, `SELECT `+exchangeColumns+` FROM TExchange WHERE MQMExchangeCode = @p1`, code)
 	return scanExchange(row)
 }
 
-// List fetches paginated exchanges plus total count.
-func (r *SQLExchangeRepository) List(ctx context.Context, page, size int) ([]entity.Exchange, int64, error) {
-	exec := r.withExecutor(nil)
-	offset := utils.Offset(page, size)
-	limit := utils.NormalizeSize(size)
-	rows, err := exec.queryContext(ctx, `SELECT MQMExchangeCode, ClearExchangeCode, GlobexExchangeCode, Description, SegType FROM TExchange ORDER BY MQMExchangeCode ASC OFFSET @p1 ROWS FETCH NEXT @p2 ROWS ONLY`, offset, limit)
+// FindByCode returns every exchange whose code in the given scheme matches.
+// Clearing and Globex codes are not unique, so several rows may match.
+func (r *SQLExchangeRepository) FindByCode(ctx context.Context, scheme, code string) ([]entity.Exchange, error) {
+	column, ok := exchangeCodeColumns[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported exchange code scheme %q", scheme)
+	}
+	rows, err := r.withExecutor(ctx).queryContext(ctx, `SELECT `+exchangeColumns+` FROM TExchange WHERE `+column+` = @p1 ORDER BY MQMExchangeCode ASC`, code)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	defer rows.Close()
 
 	var exchanges []entity.Exchange
 	for rows.Next() {
-		var e entity.Exchange
-		if err := rows.Scan(&e.MQMExchangeCode, &e.ClearExchangeCode, &e.GlobexExchangeCode, &e.Description, &e.SegType); err != nil {
-			return nil, 0, err
+		e, err := scanExchange(rows)
+		if err != nil {
+			return nil, err
 		}
-		exchanges = append(exchanges, e)
+		exchanges = append(exchanges, *e)
 	}
-	if err := rows.Err(); err != nil {
-		return nil, 0, err
+	return exchanges, rows.Err()
+}
+
+// List fetches a page of the exchanges matching the filter plus their total count.
+func (r *SQLExchangeRepository) List(ctx context.Context, filter ExchangeFilter, page PageQuery) (*Page[entity.Exchange], error) {
+	exec := r.withExecutor(ctx)
+	fields, err := exchangeKeyset.resolve(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	where := filter.where()
+	result := &Page[entity.Exchange]{Total: -1}
+	if !page.SkipTotal {
+		row := exec.queryRowContext(ctx, `SELECT COUNT(1) FROM TExchange`+where.String(), where.args...)
+		if err := row.Scan(&result.Total); err != nil {
+			return nil, err
+		}
 	}
 
-	var total int64
-	row := exec.queryRowContext(ctx, `SELECT COUNT(1) FROM TExchange`)
-	if err := row.Scan(&total); err != nil {
-		return nil, 0, err
+	if page.After != nil {
+		if err := exchangeKeyset.after(where, fields, page.After); err != nil {
+			return nil, err
+		}
+	}
+	limit := where.limit(r.dialect, page)
+	rows, err := exec.queryContext(ctx, `SELECT `+exchangeColumns+` FROM TExchange`+where.String()+exchangeKeyset.orderBy(fields)+limit, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exchanges []entity.Exchange
+	for rows.Next() {
+		e, err := scanExchange(rows)
+		if err != nil {
+			return nil, err
+		}
+		exchanges = append(exchanges, *e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return exchanges, total, nil
+	result.Items, result.Next = exchangeKeyset.trim(exchanges, page, fields)
+	return result, nil
 }
 
-// Create inserts a new exchange row.
-func (r *SQLExchangeRepository) Create(ctx context.Context, exec *sql.Tx, exchange *entity.Exchange) error {
-	_, err := r.withExecutor(exec).execContext(ctx, `INSERT INTO TExchange (MQMExchangeCode, ClearExchangeCode, GlobexExchangeCode, Description, SegType) VALUES (@p1, @p2, @p3, @p4, @p5)`,
+// Create inserts a new exchange row and stores its initial row version in
+// exchange.RowVersion.
+func (r *SQLExchangeRepository) Create(ctx context.Context, exchange *entity.Exchange) error {
+	output, returning := r.dialect.Returning("RowVersion")
+	row := r.withExecutor(ctx).queryRowContext(ctx, `INSERT INTO TExchange (MQMExchangeCode, ClearExchangeCode, GlobexExchangeCode, Description, SegType)`+output+` VALUES (@p1, @p2, @p3, @p4, @p5)`+returning,
 		exchange.MQMExchangeCode, exchange.ClearExchangeCode, exchange.GlobexExchangeCode, exchange.Description, exchange.SegType)
+	_, err := scanRowVersion(row, &exchange.RowVersion)
 	return err
 }
 
-// Update modifies an existing exchange.
-func (r *SQLExchangeRepository) Update(ctx context.Context, exec *sql.Tx, exchange *entity.Exchange) error {
-	_, err := r.withExecutor(exec).execContext(ctx, `UPDATE TExchange SET ClearExchangeCode = @p1, GlobexExchangeCode = @p2, Description = @p3, SegType = @p4 WHERE MQMExchangeCode = @p5`,
-		exchange.ClearExchangeCode, exchange.GlobexExchangeCode, exchange.Description, exchange.SegType, exchange.MQMExchangeCode)
-	return err
+// Update modifies an existing exchange if its row version is still
+// exchange.RowVersion. It reports false when the row changed since it was
+// read; on success exchange.RowVersion holds the new row version.
+func (r *SQLExchangeRepository) Update(ctx context.Context, exchange *entity.Exchange) (bool, error) {
+	output, returning := r.dialect.Returning("RowVersion")
+	row := r.withExecutor(ctx).queryRowContext(ctx, `UPDATE TExchange SET ClearExchangeCode = @p1, GlobexExchangeCode = @p2, Description = @p3, SegType = @p4`+r.dialect.SetRowVersion("RowVersion")+output+` WHERE MQMExchangeCode = @p5 AND RowVersion = @p6`+returning,
+		exchange.ClearExchangeCode, exchange.GlobexExchangeCode, exchange.Description, exchange.SegType, exchange.MQMExchangeCode, exchange.RowVersion)
+	return scanRowVersion(row, &exchange.RowVersion)
 }
 
-// Delete removes a record by MQMExchangeCode.
-func (r *SQLExchangeRepository) Delete(ctx context.Context, exec *sql.Tx, code string) error {
-	_, err := r.withExecutor(exec).execContext(ctx, `DELETE FROM TExchange WHERE MQMExchangeCode = @p1`, code)
-	return err
+// Patch writes only the given fields of an exchange, named as in
+// exchangePatchColumns. A non-nil version must still match the row version,
+// as in Update. It reports false when no row matched; on success
+// exchange.RowVersion holds the new row version.
+func (r *SQLExchangeRepository) Patch(ctx context.Context, exchange *entity.Exchange, fields []string, version []byte) (bool, error) {
+	w := &whereClause{}
+	set, err := patchSet(w, exchangePatchColumns, exchange, fields)
+	if err != nil {
+		return false, err
+	}
+	w.add(`MQMExchangeCode = ?`, exchange.MQMExchangeCode)
+	if version != nil {
+		w.add(`RowVersion = ?`, version)
+	}
+	output, returning := r.dialect.Returning("RowVersion")
+	row := r.withExecutor(ctx).queryRowContext(ctx, `UPDATE TExchange SET `+set+r.dialect.SetRowVersion("RowVersion")+output+w.String()+returning, w.args...)
+	return scanRowVersion(row, &exchange.RowVersion)
+}
+
+// Delete removes a record by MQMExchangeCode if its row version is still
+// version. It reports false when the row changed or is already gone.
+func (r *SQLExchangeRepository) Delete(ctx context.Context, code string, version []byte) (bool, error) {
+	res, err := r.withExecutor(ctx).execContext(ctx, `DELETE FROM TExchange WHERE MQMExchangeCode = @p1 AND RowVersion = @p2`, code, version)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
 }
 
-func scanExchange(row *sql.Row) (*entity.Exchange, error) {
+func scanExchange(row rowScanner) (*entity.Exchange, error) {
 	if row == nil {
 		return nil, sql.ErrNoRows
 	}
 	var e entity.Exchange
-	if err := row.Scan(&e.MQMExchangeCode, &e.ClearExchangeCode, &e.GlobexExchangeCode, &e.Description, &e.SegType); err != nil {
+	if err := row.Scan(&e.MQMExchangeCode, &e.ClearExchangeCode, &e.GlobexExchangeCode, &e.Description, &e.SegType, &e.RowVersion); err != nil {
 		return nil, err
 	}
 	return &e, nil
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"liangxiong/demo/model/entity"
+)
+
+var exchangeRowColumns = []string{"MQMExchangeCode", "ClearExchangeCode", "GlobexExchangeCode", "Description", "SegType", "RowVersion"}
+
+func TestExchangeListAppliesFilter(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	repo := NewExchangeRepository(db)
+	filter := ExchangeFilter{GlobexCode: "XCME", SegType: "F", Search: "E-mini"}
+
+	where := ` WHERE GlobexExchangeCode = @p1 AND SegType = @p2 AND Description LIKE @p3 ESCAPE '\'`
+	mock.ExpectQuery(`SELECT COUNT(1) FROM TExchange`+where).
+		WithArgs("XCME", "F", "%E-mini%").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT MQMExchangeCode, ClearExchangeCode, GlobexExchangeCode, Description, SegType, RowVersion FROM TExchange`+where+` ORDER BY MQMExchangeCode OFFSET @p4 ROWS FETCH NEXT @p5 ROWS ONLY`).
+		WithArgs("XCME", "F", "%E-mini%", 0, 21).
+		WillReturnRows(sqlmock.NewRows(exchangeRowColumns).AddRow("CME", "CME", "XCME", "CME E-mini futures", "F", []byte{1}))
+
+	page, err := repo.List(context.Background(), filter, PageQuery{Page: 1, Size: 20})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if page.Total != 1 || len(page.Items) != 1 || page.Items[0].MQMExchangeCode != "CME" {
+		t.Fatalf("unexpected result %+v", page)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestExchangeFindByCode(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	repo := NewExchangeRepository(db)
+
+	mock.ExpectQuery(`SELECT MQMExchangeCode, ClearExchangeCode, GlobexExchangeCode, Description, SegType, RowVersion FROM TExchange WHERE ClearExchangeCode = @p1 ORDER BY MQMExchangeCode ASC`).
+		WithArgs("07").
+		WillReturnRows(sqlmock.NewRows(exchangeRowColumns).
+			AddRow("CBT", "07", "XCBT", nil, "F", []byte{1}).
+			AddRow("CBTO", "07", "XCBT", nil, "O", []byte{1}))
+
+	exchanges, err := repo.FindByCode(context.Background(), ExchangeSchemeClear, "07")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(exchanges) != 2 || exchanges[1].SegType != "O" {
+		t.Fatalf("unexpected result %v", exchanges)
+	}
+
+	if _, err := repo.FindByCode(context.Background(), "isin", "07"); err == nil {
+		t.Fatal("expected unknown scheme to be rejected")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestExchangeWritesCheckRowVersion(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	repo := NewExchangeRepository(db)
+	exchange := &entity.Exchange{MQMExchangeCode: "CME", ClearExchangeCode: "CME", GlobexExchangeCode: "XCME", SegType: "F", RowVersion: []byte{1}}
+	update := `UPDATE TExchange SET ClearExchangeCode = @p1, GlobexExchangeCode = @p2, Description = @p3, SegType = @p4 OUTPUT INSERTED.RowVersion WHERE MQMExchangeCode = @p5 AND RowVersion = @p6`
+
+	mock.ExpectQuery(update).
+		WithArgs("CME", "XCME", nil, "F", "CME", []byte{1}).
+		WillReturnRows(sqlmock.NewRows([]string{"RowVersion"}).AddRow([]byte{2}))
+	if ok, err := repo.Update(context.Background(), exchange); err != nil || !ok || exchange.RowVersion[0] != 2 {
+		t.Fatalf("expected update to bump the row version, got %v %v %x", ok, err, exchange.RowVersion)
+	}
+
+	// Writing again with the version read before the first update fails.
+	exchange.RowVersion = []byte{1}
+	mock.ExpectQuery(update).
+		WithArgs("CME", "XCME", nil, "F", "CME", []byte{1}).
+		WillReturnRows(sqlmock.NewRows([]string{"RowVersion"}))
+	if ok, err := repo.Update(context.Background(), exchange); err != nil || ok {
+		t.Fatalf("expected stale update to be refused, got %v %v", ok, err)
+	}
+
+	mock.ExpectExec(`DELETE FROM TExchange WHERE MQMExchangeCode = @p1 AND RowVersion = @p2`).
+		WithArgs("CME", []byte{1}).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if ok, err := repo.Delete(context.Background(), "CME", []byte{1}); err != nil || ok {
+		t.Fatalf("expected stale delete to be refused, got %v %v", ok, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
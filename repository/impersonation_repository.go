@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"liangxiong/demo/internal/dialect"
+	"liangxiong/demo/model/entity"
+	"liangxiong/demo/utils"
+)
+
+// impersonationColumns lists the impersonations columns in scanImpersonation order.
+const impersonationColumns = `id, actor_id, user_id, reason, token_id, created_at, expires_at`
+
+// ImpersonationRepository persists the impersonation audit trail.
+type ImpersonationRepository interface {
+	Create(ctx context.Context, record *entity.Impersonation) error
+	ListByUser(ctx context.Context, userID string, page, size int) ([]entity.Impersonation, int64, error)
+}
+
+// SQLImpersonationRepository is the database/sql implementation.
+type SQLImpersonationRepository struct {
+	db      *sql.DB
+	dialect dialect.Dialect
+}
+
+// NewImpersonationRepository builds the repository.
+func NewImpersonationRepository(db *sql.DB) *SQLImpersonationRepository {
+	return &SQLImpersonationRepository{db: db, dialect: dialect.Of(db)}
+}
+
+func (r *SQLImpersonationRepository) withExecutor(ctx context.Context) sqlExecutor {
+	return newExecutor(ctx, r.db, r.dialect)
+}
+
+// Create records an issued impersonation token.
+func (r *SQLImpersonationRepository) Create(ctx context.Context, record *entity.Impersonation) error {
+	_, err := r.withExecutor(ctx).execContext(ctx, `INSERT INTO impersonations (id, actor_id, user_id, reason, token_id, created_at, expires_at) VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7)`,
+		record.ID, record.ActorID, record.UserID, record.Reason, record.TokenID, record.CreatedAt, record.ExpiresAt)
+	return err
+}
+
+// ListByUser returns the impersonations of a user, newest first, plus total count.
+func (r *SQLImpersonationRepository) ListByUser(ctx context.Context, userID string, page, size int) ([]entity.Impersonation, int64, error) {
+	exec := r.withExecutor(ctx)
+	offset := utils.Offset(page, size)
+	limit := utils.NormalizeSize(size)
+	rows, err := exec.queryContext(ctx, `SELECT `+impersonationColumns+` FROM impersonations WHERE user_id = @p1 ORDER BY created_at DESC`+r.dialect.Paginate("@p2", "@p3"), userID, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var records []entity.Impersonation
+	for rows.Next() {
+		i, err := scanImpersonation(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		records = append(records, *i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var total int64
+	row := exec.queryRowContext(ctx, `SELECT COUNT(1) FROM impersonations WHERE user_id = @p1`, userID)
+	if err := row.Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	return records, total, nil
+}
+
+func scanImpersonation(row rowScanner) (*entity.Impersonation, error) {
+	if row == nil {
+		return nil, sql.ErrNoRows
+	}
+	var i entity.Impersonation
+	if err := row.Scan(&i.ID, &i.ActorID, &i.UserID, &i.Reason, &i.TokenID, &i.CreatedAt, &i.ExpiresAt); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
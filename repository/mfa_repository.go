@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"liangxiong/demo/internal/dialect"
+	"liangxiong/demo/model/entity"
+)
+
+// MFARepository persists TOTP enrolments and recovery codes.
+type MFARepository interface {
+	GetByUserID(ctx context.Context, userID string) (*entity.UserMFA, error)
+	Upsert(ctx context.Context, mfa *entity.UserMFA) error
+	Confirm(ctx context.Context, userID string, at time.Time) error
+	MarkStepUsed(ctx context.Context, userID string, step int64) (bool, error)
+	Delete(ctx context.Context, userID string) error
+	ReplaceRecoveryCodes(ctx context.Context, userID string, codes []*entity.MFARecoveryCode) error
+	UseRecoveryCode(ctx context.Context, userID, hash string, at time.Time) (bool, error)
+}
+
+// SQLMFARepository is the database/sql implementation.
+type SQLMFARepository struct {
+	db      *sql.DB
+	dialect dialect.Dialect
+}
+
+// NewMFARepository builds the repository.
+func NewMFARepository(db *sql.DB) *SQLMFARepository {
+	return &SQLMFARepository{db: db, dialect: dialect.Of(db)}
+}
+
+func (r *SQLMFARepository) withExecutor(ctx context.Context) sqlExecutor {
+	return newExecutor(ctx, r.db, r.dialect)
+}
+
+// GetByUserID fetches the TOTP enrolment of a user.
+func (r *SQLMFARepository) GetByUserID(ctx context.Context, userID string) (*entity.UserMFA, error) {
+	row := r.withExecutor(ctx).queryRowContext(ctx, `SELECT user_id, secret, confirmed_at, last_used_step, created_at, updated_at FROM user_mfa WHERE user_id = @p1`, userID)
+	if row == nil {
+		return nil, sql.ErrNoRows
+	}
+	var m entity.UserMFA
+	if err := row.Scan(&m.UserID, &m.Secret, &m.ConfirmedAt, &m.LastUsedStep, &m.CreatedAt, &m.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Upsert stores a new, unconfirmed enrolment, replacing any previous one.
+func (r *SQLMFARepository) Upsert(ctx context.Context, mfa *entity.UserMFA) error {
+	query := r.dialect.Upsert("user_mfa", []string{"user_id"}, []string{"user_id", "secret", "created_at", "updated_at"},
+		"secret = @p2, confirmed_at = NULL, last_used_step = NULL, updated_at = @p4")
+	_, err := r.withExecutor(ctx).execContext(ctx, query,
+		mfa.UserID, mfa.Secret, mfa.CreatedAt, mfa.UpdatedAt)
+	return err
+}
+
+// Confirm completes an enrolment.
+func (r *SQLMFARepository) Confirm(ctx context.Context, userID string, at time.Time) error {
+	_, err := r.withExecutor(ctx).execContext(ctx, `UPDATE user_mfa SET confirmed_at = @p1, updated_at = @p1 WHERE user_id = @p2`, at, userID)
+	return err
+}
+
+// MarkStepUsed records the time step of an accepted code. It reports false
+// when that step (or a later one) was already used, which rejects replays.
+func (r *SQLMFARepository) MarkStepUsed(ctx context.Context, userID string, step int64) (bool, error) {
+	res, err := r.withExecutor(ctx).execContext(ctx, `UPDATE user_mfa SET last_used_step = @p1 WHERE user_id = @p2 AND (last_used_step IS NULL OR last_used_step < @p1)`, step, userID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// Delete removes the enrolment and recovery codes of a user.
+func (r *SQLMFARepository) Delete(ctx context.Context, userID string) error {
+	executor := r.withExecutor(ctx)
+	if _, err := executor.execContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = @p1`, userID); err != nil {
+		return err
+	}
+	_, err := executor.execContext(ctx, `DELETE FROM user_mfa WHERE user_id = @p1`, userID)
+	return err
+}
+
+// ReplaceRecoveryCodes discards existing recovery codes and stores new ones.
+func (r *SQLMFARepository) ReplaceRecoveryCodes(ctx context.Context, userID string, codes []*entity.MFARecoveryCode) error {
+	executor := r.withExecutor(ctx)
+	if _, err := executor.execContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = @p1`, userID); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		if _, err := executor.execContext(ctx, `INSERT INTO mfa_recovery_codes (id, user_id, code_hash, created_at) VALUES (@p1, @p2, @p3, @p4)`,
+			code.ID, code.UserID, code.CodeHash, code.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode consumes a recovery code. It reports false when the code
+// does not exist or was already used.
+func (r *SQLMFARepository) UseRecoveryCode(ctx context.Context, userID, hash string, at time.Time) (bool, error) {
+	res, err := r.withExecutor(ctx).execContext(ctx, `UPDATE mfa_recovery_codes SET used_at = @p1 WHERE user_id = @p2 AND code_hash = @p3 AND used_at IS NULL`, at, userID, hash)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
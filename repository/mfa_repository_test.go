@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMarkStepUsedRejectsReplay(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	repo := NewMFARepository(db)
+	query := regexp.QuoteMeta(`UPDATE user_mfa SET last_used_step = @p1 WHERE user_id = @p2 AND (last_used_step IS NULL OR last_used_step < @p1)`)
+
+	mock.ExpectExec(query).WithArgs(int64(41152263), "user-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(query).WithArgs(int64(41152263), "user-1").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	used, err := repo.MarkStepUsed(context.Background(), "user-1", 41152263)
+	if err != nil || !used {
+		t.Fatalf("expected first use to succeed, got %v %v", used, err)
+	}
+
+	used, err = repo.MarkStepUsed(context.Background(), "user-1", 41152263)
+	if err != nil || used {
+		t.Fatalf("expected replay to be rejected, got %v %v", used, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"liangxiong/demo/internal/dialect"
+	"liangxiong/demo/model/entity"
+)
+
+// PasswordResetRepository exposes persistence operations for password reset tokens.
+type PasswordResetRepository interface {
+	GetByHash(ctx context.Context, hash string) (*entity.PasswordResetToken, error)
+	Create(ctx context.Context, token *entity.PasswordResetToken) error
+	MarkUsed(ctx context.Context, id string, at time.Time) (bool, error)
+	InvalidateByUser(ctx context.Context, userID string, at time.Time) error
+}
+
+// SQLPasswordResetRepository is the database/sql implementation.
+type SQLPasswordResetRepository struct {
+	db      *sql.DB
+	dialect dialect.Dialect
+}
+
+// NewPasswordResetRepository builds the repository.
+func NewPasswordResetRepository(db *sql.DB) *SQLPasswordResetRepository {
+	return &SQLPasswordResetRepository{db: db, dialect: dialect.Of(db)}
+}
+
+func (r *SQLPasswordResetRepository) withExecutor(ctx context.Context) sqlExecutor {
+	return newExecutor(ctx, r.db, r.dialect)
+}
+
+// GetByHash fetches a reset token by its SHA-256 hash.
+func (r *SQLPasswordResetRepository) GetByHash(ctx context.Context, hash string) (*entity.PasswordResetToken, error) {
+	row := r.withExecutor(ctx).queryRowContext(ctx, `SELECT id, user_id, token_hash, created_by, expires_at, created_at, used_at FROM password_reset_tokens WHERE token_hash = @p1`, hash)
+	if row == nil {
+		return nil, sql.ErrNoRows
+	}
+	var t entity.PasswordResetToken
+	if err := row.Scan(&t.ID, &t.UserID, &t.TokenHash, &t.CreatedBy, &t.ExpiresAt, &t.CreatedAt, &t.UsedAt); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Create inserts a new reset token.
+func (r *SQLPasswordResetRepository) Create(ctx context.Context, token *entity.PasswordResetToken) error {
+	_, err := r.withExecutor(ctx).execContext(ctx, `INSERT INTO password_reset_tokens (id, user_id, token_hash, created_by, expires_at, created_at) VALUES (@p1, @p2, @p3, @p4, @p5, @p6)`,
+		token.ID, token.UserID, token.TokenHash, token.CreatedBy, token.ExpiresAt, token.CreatedAt)
+	return err
+}
+
+// MarkUsed consumes a reset token. It reports false when the token was already used.
+func (r *SQLPasswordResetRepository) MarkUsed(ctx context.Context, id string, at time.Time) (bool, error) {
+	res, err := r.withExecutor(ctx).execContext(ctx, `UPDATE password_reset_tokens SET used_at = @p1 WHERE id = @p2 AND used_at IS NULL`, at, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// InvalidateByUser consumes every outstanding reset token of a user.
+func (r *SQLPasswordResetRepository) InvalidateByUser(ctx context.Context, userID string, at time.Time) error {
+	_, err := r.withExecutor(ctx).execContext(ctx, `UPDATE password_reset_tokens SET used_at = @p1 WHERE user_id = @p2 AND used_at IS NULL`, at, userID)
+	return err
+}
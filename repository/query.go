@@ -0,0 +1,250 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"liangxiong/demo/internal/dialect"
+	"liangxiong/demo/utils"
+)
+
+// likeEscaper escapes LIKE wildcards so user input only matches literally.
+// Patterns built with it must declare ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, `[`, `\[`)
+
+// likePrefix builds a LIKE pattern matching values that start with s.
+func likePrefix(s string) string {
+	return likeEscaper.Replace(s) + "%"
+}
+
+// likeContains builds a LIKE pattern matching values that contain s.
+func likeContains(s string) string {
+	return "%" + likeEscaper.Replace(s) + "%"
+}
+
+// whereClause accumulates AND-ed conditions and their arguments. Conditions
+// are trusted SQL fragments whose "?" markers become numbered @pN
+// placeholders; values are only ever passed as arguments.
+type whereClause struct {
+	conds []string
+	args  []any
+}
+
+// add appends a condition with one argument per "?" marker.
+func (w *whereClause) add(cond string, args ...any) {
+	var b strings.Builder
+	for _, r := range cond {
+		if r == '?' {
+			w.args = append(w.args, args[0])
+			args = args[1:]
+			fmt.Fprintf(&b, "@p%d", len(w.args))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	w.conds = append(w.conds, b.String())
+}
+
+// placeholder registers an argument and returns its @pN marker, for values
+// outside the conditions, such as SET values or paging.
+func (w *whereClause) placeholder(arg any) string {
+	w.args = append(w.args, arg)
+	return fmt.Sprintf("@p%d", len(w.args))
+}
+
+// String renders the clause with a leading space, or nothing without conditions.
+func (w *whereClause) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conds, " AND ")
+}
+
+// patchColumn maps a patchable field to its column and entity value.
+type patchColumn[T any] struct {
+	column string
+	value  func(*T) any
+}
+
+// patchSet renders the SET list of a partial update writing the given fields
+// of row, registering their values as arguments of w. SET values come first,
+// so w must not have conditions yet.
+func patchSet[T any](w *whereClause, columns map[string]patchColumn[T], row *T, fields []string) (string, error) {
+	if len(fields) == 0 {
+		return "", errors.New("no fields to patch")
+	}
+	sets := make([]string, 0, len(fields))
+	for _, field := range fields {
+		c, ok := columns[field]
+		if !ok {
+			return "", fmt.Errorf("field %q cannot be patched", field)
+		}
+		sets = append(sets, c.column+" = "+w.placeholder(c.value(row)))
+	}
+	return strings.Join(sets, ", "), nil
+}
+
+// PageQuery selects a page of a listing. Without After it is the page-th page
+// of size rows; with After, the keyset page of the size rows
+// that follow the row whose sort key After holds. SkipTotal omits the count.
+type PageQuery struct {
+	Page      int
+	Size      int
+	After     []json.RawMessage
+	SkipTotal bool
+}
+
+// Page is one page of a listing. Total is -1 when the count was skipped;
+// Next holds the sort key of the last row when more rows follow.
+type Page[T any] struct {
+	Items []T
+	Total int64
+	Next  []any
+}
+
+// sortKey is a sortable field: its column, the type its cursor values decode
+// to, and how to read it from a row.
+type sortKey[T any] struct {
+	column string
+	decode func(json.RawMessage) (any, error)
+	value  func(*T) any
+}
+
+func stringKey[T any](column string, value func(*T) string) sortKey[T] {
+	return sortKey[T]{column: column, decode: decodeKey[string], value: func(row *T) any { return value(row) }}
+}
+
+func timeKey[T any](column string, value func(*T) time.Time) sortKey[T] {
+	return sortKey[T]{column: column, decode: decodeKey[time.Time], value: func(row *T) any { return value(row) }}
+}
+
+func decodeKey[V any](raw json.RawMessage) (any, error) {
+	var v V
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// keyset orders a listing by whitelisted fields plus a unique ascending
+// tiebreaker, and pages through it by sort key.
+type keyset[T any] struct {
+	keys     map[string]sortKey[T]
+	tiebreak string
+	fallback []utils.SortField
+}
+
+// sortable lists the sortable fields, without the tiebreaker, in a stable order.
+func (k keyset[T]) sortable() []string {
+	fields := make([]string, 0, len(k.keys))
+	for field := range k.keys {
+		if field != k.tiebreak {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// resolve validates the requested sort and completes it with the fallback
+// and the tiebreaker.
+func (k keyset[T]) resolve(fields []utils.SortField) ([]utils.SortField, error) {
+	if len(fields) == 0 {
+		fields = k.fallback
+	}
+	resolved := make([]utils.SortField, 0, len(fields)+1)
+	for _, f := range fields {
+		if _, ok := k.keys[f.Field]; !ok || f.Field == k.tiebreak {
+			return nil, fmt.Errorf("unsupported sort field %q", f.Field)
+		}
+		resolved = append(resolved, f)
+	}
+	return append(resolved, utils.SortField{Field: k.tiebreak}), nil
+}
+
+// orderBy renders the ORDER BY clause of resolved fields.
+func (k keyset[T]) orderBy(fields []utils.SortField) string {
+	terms := make([]string, 0, len(fields))
+	for _, f := range fields {
+		term := k.keys[f.Field].column
+		if f.Desc {
+			term += " DESC"
+		}
+		terms = append(terms, term)
+	}
+	return " ORDER BY " + strings.Join(terms, ", ")
+}
+
+// after restricts w to the rows that sort after the given key. SQL Server has
+// no row value comparison, so on every dialect (a, b) > (x, y) is expanded to
+// a > x OR (a = x AND b > y), with < for descending fields.
+func (k keyset[T]) after(w *whereClause, fields []utils.SortField, raw []json.RawMessage) error {
+	if len(raw) != len(fields) {
+		return utils.ErrInvalidCursor
+	}
+	values := make([]any, len(raw))
+	for i, f := range fields {
+		v, err := k.keys[f.Field].decode(raw[i])
+		if err != nil {
+			return utils.ErrInvalidCursor
+		}
+		values[i] = v
+	}
+
+	var cond strings.Builder
+	var args []any
+	cond.WriteString("(")
+	for i, f := range fields {
+		if i > 0 {
+			cond.WriteString(" OR ")
+		}
+		cond.WriteString("(")
+		for j := 0; j < i; j++ {
+			cond.WriteString(k.keys[fields[j].Field].column + " = ? AND ")
+			args = append(args, values[j])
+		}
+		op := " > ?"
+		if f.Desc {
+			op = " < ?"
+		}
+		cond.WriteString(k.keys[f.Field].column + op + ")")
+		args = append(args, values[i])
+	}
+	cond.WriteString(")")
+	w.add(cond.String(), args...)
+	return nil
+}
+
+// next reads the sort key of row.
+func (k keyset[T]) next(row *T, fields []utils.SortField) []any {
+	values := make([]any, len(fields))
+	for i, f := range fields {
+		values[i] = k.keys[f.Field].value(row)
+	}
+	return values
+}
+
+// limit renders the paging clause of d for the page, fetching one extra row
+// to learn whether another page follows. Keyset pages always start at offset 0.
+func (w *whereClause) limit(d dialect.Dialect, page PageQuery) string {
+	offset := 0
+	if page.After == nil {
+		offset = utils.Offset(page.Page, page.Size)
+	}
+	return d.Paginate(w.placeholder(offset), w.placeholder(utils.NormalizeSize(page.Size)+1))
+}
+
+// trim cuts the extra row fetched by limit and returns the sort key of the
+// last row kept when it was there.
+func (k keyset[T]) trim(items []T, page PageQuery, fields []utils.SortField) ([]T, []any) {
+	size := utils.NormalizeSize(page.Size)
+	if len(items) <= size {
+		return items, nil
+	}
+	items = items[:size]
+	return items, k.next(&items[size-1], fields)
+}
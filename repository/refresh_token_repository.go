@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"liangxiong/demo/internal/dialect"
+	"liangxiong/demo/model/entity"
+)
+
+// RefreshTokenRepository exposes persistence operations for refresh tokens.
+type RefreshTokenRepository interface {
+	GetByHash(ctx context.Context, hash string) (*entity.RefreshToken, error)
+	Create(ctx context.Context, token *entity.RefreshToken) error
+	MarkRotated(ctx context.Context, id string, at time.Time) (bool, error)
+	RevokeFamily(ctx context.Context, familyID string, at time.Time) error
+	RevokeByUser(ctx context.Context, userID string, at time.Time) error
+}
+
+// SQLRefreshTokenRepository is the database/sql implementation.
+type SQLRefreshTokenRepository struct {
+	db      *sql.DB
+	dialect dialect.Dialect
+}
+
+// NewRefreshTokenRepository builds the repository.
+func NewRefreshTokenRepository(db *sql.DB) *SQLRefreshTokenRepository {
+	return &SQLRefreshTokenRepository{db: db, dialect: dialect.Of(db)}
+}
+
+func (r *SQLRefreshTokenRepository) withExecutor(ctx context.Context) sqlExecutor {
+	return newExecutor(ctx, r.db, r.dialect)
+}
+
+// GetByHash fetches a token by its SHA-256 hash.
+func (r *SQLRefreshTokenRepository) GetByHash(ctx context.Context, hash string) (*entity.RefreshToken, error) {
+	row := r.withExecutor(ctx).queryRowContext(ctx, `SELECT id, user_id, family_id, token_hash, expires_at, created_at, rotated_at, revoked_at FROM refresh_tokens WHERE token_hash = @p1`, hash)
+	if row == nil {
+		return nil, sql.ErrNoRows
+	}
+	var t entity.RefreshToken
+	if err := row.Scan(&t.ID, &t.UserID, &t.FamilyID, &t.TokenHash, &t.ExpiresAt, &t.CreatedAt, &t.RotatedAt, &t.RevokedAt); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Create inserts a new refresh token.
+func (r *SQLRefreshTokenRepository) Create(ctx context.Context, token *entity.RefreshToken) error {
+	_, err := r.withExecutor(ctx).execContext(ctx, `INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, expires_at, created_at) VALUES (@p1, @p2, @p3, @p4, @p5, @p6)`,
+		token.ID, token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt, token.CreatedAt)
+	return err
+}
+
+// MarkRotated flags a token as used. It reports false when the token had
+// already been rotated or revoked, which callers must treat as reuse.
+func (r *SQLRefreshTokenRepository) MarkRotated(ctx context.Context, id string, at time.Time) (bool, error) {
+	res, err := r.withExecutor(ctx).execContext(ctx, `UPDATE refresh_tokens SET rotated_at = @p1 WHERE id = @p2 AND rotated_at IS NULL AND revoked_at IS NULL`, at, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// RevokeFamily revokes every live token descending from the same login.
+func (r *SQLRefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string, at time.Time) error {
+	_, err := r.withExecutor(ctx).execContext(ctx, `UPDATE refresh_tokens SET revoked_at = @p1 WHERE family_id = @p2 AND revoked_at IS NULL`, at, familyID)
+	return err
+}
+
+// RevokeByUser revokes every live refresh token belonging to a user.
+func (r *SQLRefreshTokenRepository) RevokeByUser(ctx context.Context, userID string, at time.Time) error {
+	_, err := r.withExecutor(ctx).execContext(ctx, `UPDATE refresh_tokens SET revoked_at = @p1 WHERE user_id = @p2 AND revoked_at IS NULL`, at, userID)
+	return err
+}
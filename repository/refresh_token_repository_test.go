@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMarkRotatedDetectsReuse(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	repo := NewRefreshTokenRepository(db)
+	now := time.Now()
+	query := regexp.QuoteMeta(`UPDATE refresh_tokens SET rotated_at = @p1 WHERE id = @p2 AND rotated_at IS NULL AND revoked_at IS NULL`)
+
+	mock.ExpectExec(query).WithArgs(now, "token-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(query).WithArgs(now, "token-1").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rotated, err := repo.MarkRotated(context.Background(), "token-1", now)
+	if err != nil || !rotated {
+		t.Fatalf("expected first rotation to succeed, got %v %v", rotated, err)
+	}
+
+	rotated, err = repo.MarkRotated(context.Background(), "token-1", now)
+	if err != nil || rotated {
+		t.Fatalf("expected second rotation to be rejected, got %v %v", rotated, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy bounds how often and how fast a unit of work failing with a
+// transient database error is run again.
+type RetryPolicy struct {
+	// MaxAttempts counts every run, the first included.
+	MaxAttempts int
+	// BaseDelay caps the wait before the second run. The cap doubles for
+	// every further run up to MaxDelay, and each wait is drawn at random
+	// below it so that the transactions of a deadlock do not meet again.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy is the policy of the transaction managers.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: 20 * time.Millisecond, MaxDelay: 500 * time.Millisecond}
+
+// backoff returns the wait after the given failed run, counted from 1.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	limit := p.MaxDelay
+	if shift := attempt - 1; shift < 32 && p.BaseDelay<<shift < limit {
+		limit = p.BaseDelay << shift
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return rand.N(limit)
+}
+
+// RetryWithinTx runs fn like WithinTx, then runs it again in a new
+// transaction while it fails with a transient error: a deadlock, a lock
+// timeout or a lost connection. fn must be idempotent, since anything it does
+// besides statements in the transaction is repeated, and a connection lost
+// while committing may leave the first run committed.
+//
+// Nested in another unit, fn runs once: a transient error aborts the outer
+// transaction, which is the one to run again.
+func (m *TxManager) RetryWithinTx(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*txState); ok {
+		return m.WithinTx(ctx, opts, fn)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := m.WithinTx(ctx, opts, fn)
+		if err == nil || attempt >= m.retry.MaxAttempts || !m.dialect.Classify(err).Transient() {
+			return err
+		}
+
+		timer := time.NewTimer(m.retry.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"liangxiong/demo/internal/dialect"
+	"liangxiong/demo/model/entity"
+)
+
+// RevocationRepository persists revoked access tokens.
+type RevocationRepository interface {
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	RevokeToken(ctx context.Context, token *entity.RevokedToken) error
+	RevokeTokenOnce(ctx context.Context, token *entity.RevokedToken) error
+	PurgeExpiredTokens(ctx context.Context, now time.Time) (int64, error)
+	GetUserRevocation(ctx context.Context, userID string) (*entity.UserTokenRevocation, error)
+	RevokeUser(ctx context.Context, revocation *entity.UserTokenRevocation) error
+}
+
+// SQLRevocationRepository is the database/sql implementation.
+type SQLRevocationRepository struct {
+	db      *sql.DB
+	dialect dialect.Dialect
+}
+
+// NewRevocationRepository builds the repository.
+func NewRevocationRepository(db *sql.DB) *SQLRevocationRepository {
+	return &SQLRevocationRepository{db: db, dialect: dialect.Of(db)}
+}
+
+func (r *SQLRevocationRepository) withExecutor(ctx context.Context) sqlExecutor {
+	return newExecutor(ctx, r.db, r.dialect)
+}
+
+// IsTokenRevoked reports whether a JWT ID has been revoked.
+func (r *SQLRevocationRepository) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	row := r.withExecutor(ctx).queryRowContext(ctx, `SELECT COUNT(1) FROM revoked_tokens WHERE jti = @p1`, jti)
+	if row == nil {
+		return false, sql.ErrNoRows
+	}
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// RevokeToken records a revoked JWT ID. Revoking twice is a no-op.
+func (r *SQLRevocationRepository) RevokeToken(ctx context.Context, token *entity.RevokedToken) error {
+	query := r.dialect.Upsert("revoked_tokens", []string{"jti"}, []string{"jti", "user_id", "expires_at", "revoked_at"}, "")
+	_, err := r.withExecutor(ctx).execContext(ctx, query,
+		token.JTI, token.UserID, token.ExpiresAt, token.RevokedAt)
+	return err
+}
+
+// RevokeTokenOnce records a revoked JWT ID that must not be revoked yet:
+// revoking it again fails with a unique violation, also while the first
+// revocation is not committed.
+func (r *SQLRevocationRepository) RevokeTokenOnce(ctx context.Context, token *entity.RevokedToken) error {
+	_, err := r.withExecutor(ctx).execContext(ctx, `INSERT INTO revoked_tokens (jti, user_id, expires_at, revoked_at) VALUES (@p1, @p2, @p3, @p4)`,
+		token.JTI, token.UserID, token.ExpiresAt, token.RevokedAt)
+	return err
+}
+
+// PurgeExpiredTokens deletes the revoked JWT IDs of tokens expired by now,
+// which no longer need to be recorded.
+func (r *SQLRevocationRepository) PurgeExpiredTokens(ctx context.Context, now time.Time) (int64, error) {
+	res, err := r.withExecutor(ctx).execContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at <= @p1`, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetUserRevocation fetches the per-user revocation cutoff and generation.
+func (r *SQLRevocationRepository) GetUserRevocation(ctx context.Context, userID string) (*entity.UserTokenRevocation, error) {
+	row := r.withExecutor(ctx).queryRowContext(ctx, `SELECT user_id, revoked_before, generation FROM user_token_revocations WHERE user_id = @p1`, userID)
+	if row == nil {
+		return nil, sql.ErrNoRows
+	}
+	var rev entity.UserTokenRevocation
+	if err := row.Scan(&rev.UserID, &rev.RevokedBefore, &rev.Generation); err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// RevokeUser upserts the per-user revocation cutoff and advances the
+// generation, which starts at 1. revocation.Generation is ignored.
+func (r *SQLRevocationRepository) RevokeUser(ctx context.Context, revocation *entity.UserTokenRevocation) error {
+	query := r.dialect.Upsert("user_token_revocations", []string{"user_id"}, []string{"user_id", "revoked_before"}, "revoked_before = @p2, generation = generation + 1")
+	_, err := r.withExecutor(ctx).execContext(ctx, query,
+		revocation.UserID, revocation.RevokedBefore)
+	return err
+}
@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"liangxiong/demo/internal/dialect"
+	"liangxiong/demo/internal/migrate"
+	"liangxiong/demo/migrations"
+	"liangxiong/demo/model/entity"
+)
+
+// openSQLite returns a migrated SQLite database private to the test.
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+
+	migrator, err := migrate.New(db, migrations.FS, zap.NewNop())
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func TestUserRepositoryOnSQLite(t *testing.T) {
+	db := openSQLite(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= 3; i++ {
+		user := &entity.User{ID: fmt.Sprintf("user-%d", i), Username: fmt.Sprintf("user%d", i), Email: "u@example.com", PasswordHash: "hash",
+			FirstName: "First", LastName: "Last", Role: "user", CreatedAt: created.Add(time.Duration(i) * time.Hour), UpdatedAt: created, Status: entity.UserStatusActive}
+		if err := repo.Create(ctx, user); err != nil || len(user.Version) == 0 {
+			t.Fatalf("create %d: expected a row version, got %x (%v)", i, user.Version, err)
+		}
+	}
+
+	duplicate := &entity.User{ID: "user-4", Username: "user1", CreatedAt: created, UpdatedAt: created, Status: entity.UserStatusActive}
+	if err := repo.Create(ctx, duplicate); dialect.SQLite.Classify(err) != dialect.ErrorUniqueViolation {
+		t.Fatalf("expected a unique violation, got %v", err)
+	}
+
+	page, err := repo.List(ctx, UserFilter{UsernamePrefix: "user"}, PageQuery{Page: 1, Size: 2})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if page.Total != 3 || len(page.Items) != 2 || page.Items[0].ID != "user-3" || page.Next == nil {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+	page, err = repo.List(ctx, UserFilter{}, PageQuery{Page: 2, Size: 2, SkipTotal: true})
+	if err != nil || len(page.Items) != 1 || page.Items[0].ID != "user-1" || page.Next != nil {
+		t.Fatalf("unexpected second page %+v (%v)", page, err)
+	}
+
+	user, err := repo.GetByID(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	read := user.Version
+	user.Email = "new@example.com"
+	if ok, err := repo.Update(ctx, user); err != nil || !ok || bytes.Equal(user.Version, read) {
+		t.Fatalf("expected update to bump the row version, got %v %v", ok, err)
+	}
+	user.Version = read
+	if ok, err := repo.Update(ctx, user); err != nil || ok {
+		t.Fatalf("expected stale update to be refused, got %v %v", ok, err)
+	}
+	user.LastName = "Patched"
+	if ok, err := repo.Patch(ctx, user, []string{"lastName"}, read); err != nil || ok {
+		t.Fatalf("expected stale patch to be refused, got %v %v", ok, err)
+	}
+	if ok, err := repo.Patch(ctx, user, []string{"lastName"}, nil); err != nil || !ok {
+		t.Fatalf("expected unconditional patch to apply, got %v %v", ok, err)
+	}
+	if got, _ := repo.GetByID(ctx, "user-1"); got.LastName != "Patched" || got.Email != "new@example.com" || !bytes.Equal(got.Version, user.Version) {
+		t.Fatalf("unexpected user after writes %+v", got)
+	}
+}
+
+func TestUpsertsOnSQLite(t *testing.T) {
+	db := openSQLite(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	mfa := NewMFARepository(db)
+	if err := mfa.Upsert(ctx, &entity.UserMFA{UserID: "user-1", Secret: "one", CreatedAt: now, UpdatedAt: now}); err != nil {
+		t.Fatalf("insert enrolment: %v", err)
+	}
+	if err := mfa.Confirm(ctx, "user-1", now); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	if err := mfa.Upsert(ctx, &entity.UserMFA{UserID: "user-1", Secret: "two", CreatedAt: now, UpdatedAt: now}); err != nil {
+		t.Fatalf("replace enrolment: %v", err)
+	}
+	if got, err := mfa.GetByUserID(ctx, "user-1"); err != nil || got.Secret != "two" || got.ConfirmedAt.Valid {
+		t.Fatalf("expected an unconfirmed replacement, got %+v (%v)", got, err)
+	}
+
+	revocations := NewRevocationRepository(db)
+	token := &entity.RevokedToken{JTI: "jti-1", UserID: "user-1", ExpiresAt: now.Add(time.Hour), RevokedAt: now}
+	for i := 0; i < 2; i++ {
+		if err := revocations.RevokeToken(ctx, token); err != nil {
+			t.Fatalf("revoke token %d: %v", i, err)
+		}
+	}
+	if revoked, err := revocations.IsTokenRevoked(ctx, "jti-1"); err != nil || !revoked {
+		t.Fatalf("expected token to be revoked, got %v %v", revoked, err)
+	}
+	expired := &entity.RevokedToken{JTI: "jti-2", UserID: "user-1", ExpiresAt: now.Add(-time.Minute), RevokedAt: now.Add(-time.Hour)}
+	if err := revocations.RevokeToken(ctx, expired); err != nil {
+		t.Fatalf("revoke expired token: %v", err)
+	}
+	if purged, err := revocations.PurgeExpiredTokens(ctx, now); err != nil || purged != 1 {
+		t.Fatalf("expected the expired token to be purged, got %d %v", purged, err)
+	}
+	if revoked, err := revocations.IsTokenRevoked(ctx, "jti-1"); err != nil || !revoked {
+		t.Fatalf("expected the unexpired token to be kept, got %v %v", revoked, err)
+	}
+	for _, before := range []time.Time{now, now.Add(time.Minute)} {
+		if err := revocations.RevokeUser(ctx, &entity.UserTokenRevocation{UserID: "user-1", RevokedBefore: before}); err != nil {
+			t.Fatalf("revoke user: %v", err)
+		}
+	}
+	if got, err := revocations.GetUserRevocation(ctx, "user-1"); err != nil || !got.RevokedBefore.Equal(now.Add(time.Minute)) || got.Generation != 2 {
+		t.Fatalf("expected the later cutoff of the second generation, got %+v (%v)", got, err)
+	}
+}
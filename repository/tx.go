@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"liangxiong/demo/internal/dialect"
+)
+
+// txKey is the context key of the running transaction.
+type txKey struct{}
+
+// txState is the transaction carried by a context. depth counts the
+// WithinTx calls nested inside the outermost one and names their savepoints.
+// afterCommit holds the functions registered by AfterCommit in this unit.
+type txState struct {
+	tx          *sql.Tx
+	opts        sql.TxOptions
+	depth       int
+	afterCommit []func()
+}
+
+// AfterCommit runs fn once the transaction of ctx has committed, or right
+// away outside of a transaction. fn is dropped when the transaction, or the
+// nested unit that registered it, is rolled back.
+func AfterCommit(ctx context.Context, fn func()) {
+	state, ok := ctx.Value(txKey{}).(*txState)
+	if !ok {
+		fn()
+		return
+	}
+	state.afterCommit = append(state.afterCommit, fn)
+}
+
+// newExecutor runs statements in the transaction of ctx, if any, and
+// directly on db otherwise.
+func newExecutor(ctx context.Context, db *sql.DB, d dialect.Dialect) sqlExecutor {
+	if state, ok := ctx.Value(txKey{}).(*txState); ok {
+		return sqlExecutor{inner: state.tx, dialect: d}
+	}
+	return sqlExecutor{inner: db, dialect: d}
+}
+
+// TxManager runs units of work in a database transaction that repositories
+// pick up from the context.
+type TxManager struct {
+	db      *sql.DB
+	dialect dialect.Dialect
+	retry   RetryPolicy
+}
+
+// NewTxManager builds a transaction manager for db that retries with
+// DefaultRetryPolicy.
+func NewTxManager(db *sql.DB) *TxManager {
+	return &TxManager{db: db, dialect: dialect.Of(db), retry: DefaultRetryPolicy}
+}
+
+// WithinTx runs fn with a context carrying a transaction begun with opts,
+// committed when fn returns nil and rolled back when it fails or panics.
+//
+// Called inside another WithinTx, fn joins the outer transaction under a
+// savepoint instead: its failure only undoes its own work, and nothing is
+// committed before the outermost fn returns. Nested calls cannot change the
+// isolation level of the transaction.
+func (m *TxManager) WithinTx(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) (err error) {
+	if opts == nil {
+		opts = &sql.TxOptions{}
+	}
+	if outer, ok := ctx.Value(txKey{}).(*txState); ok {
+		return m.nested(ctx, outer, opts, fn)
+	}
+
+	tx, err := m.db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	state := &txState{tx: tx, opts: *opts}
+	if err = fn(context.WithValue(ctx, txKey{}, state)); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	for _, after := range state.afterCommit {
+		after()
+	}
+	return nil
+}
+
+func (m *TxManager) nested(ctx context.Context, outer *txState, opts *sql.TxOptions, fn func(ctx context.Context) error) (err error) {
+	if opts.Isolation != sql.LevelDefault && opts.Isolation != outer.opts.Isolation {
+		return fmt.Errorf("nested transaction cannot change the isolation level to %s", opts.Isolation)
+	}
+
+	state := &txState{tx: outer.tx, opts: outer.opts, depth: outer.depth + 1}
+	name := fmt.Sprintf("sp_%d", state.depth)
+	if _, err := outer.tx.ExecContext(ctx, m.dialect.Savepoint(name)); err != nil {
+		return err
+	}
+	defer func() {
+		p := recover()
+		if p != nil || err != nil {
+			if _, rbErr := outer.tx.ExecContext(ctx, m.dialect.RollbackTo(name)); rbErr != nil && err != nil {
+				err = errors.Join(err, rbErr)
+			}
+		}
+		if p != nil {
+			panic(p)
+		}
+	}()
+
+	if err = fn(context.WithValue(ctx, txKey{}, state)); err != nil {
+		return err
+	}
+	if release := m.dialect.ReleaseSavepoint(name); release != "" {
+		if _, err = outer.tx.ExecContext(ctx, release); err != nil {
+			return err
+		}
+	}
+	outer.afterCommit = append(outer.afterCommit, state.afterCommit...)
+	return nil
+}
@@ -0,0 +1,201 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	mssql "github.com/microsoft/go-mssqldb"
+
+	"liangxiong/demo/internal/dialect"
+	"liangxiong/demo/model/entity"
+)
+
+func TestTxManagerOnSQLite(t *testing.T) {
+	db := openSQLite(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+	manager := NewTxManager(db)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newUser := func(id string) *entity.User {
+		return &entity.User{ID: id, Username: id, CreatedAt: now, UpdatedAt: now, Status: entity.UserStatusActive}
+	}
+	exists := func(id string) bool {
+		t.Helper()
+		_, err := repo.GetByID(ctx, id)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("get %s: %v", id, err)
+		}
+		return err == nil
+	}
+
+	if err := manager.WithinTx(ctx, nil, func(ctx context.Context) error {
+		return repo.Create(ctx, newUser("committed"))
+	}); err != nil || !exists("committed") {
+		t.Fatalf("expected the unit to commit, got %v", err)
+	}
+
+	boom := errors.New("boom")
+	if err := manager.WithinTx(ctx, nil, func(ctx context.Context) error {
+		if err := repo.Create(ctx, newUser("failed")); err != nil {
+			return err
+		}
+		return boom
+	}); !errors.Is(err, boom) || exists("failed") {
+		t.Fatalf("expected the unit to roll back, got %v", err)
+	}
+
+	// A failed nested unit only undoes its own work.
+	err := manager.WithinTx(ctx, nil, func(ctx context.Context) error {
+		if err := repo.Create(ctx, newUser("outer")); err != nil {
+			return err
+		}
+		inner := manager.WithinTx(ctx, nil, func(ctx context.Context) error {
+			if err := repo.Create(ctx, newUser("inner")); err != nil {
+				return err
+			}
+			return boom
+		})
+		if !errors.Is(inner, boom) {
+			t.Fatalf("expected the nested error, got %v", inner)
+		}
+		return manager.WithinTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(context.Context) error { return nil })
+	})
+	if err == nil {
+		t.Fatal("expected nested units to keep the isolation level")
+	}
+
+	if err := manager.WithinTx(ctx, nil, func(ctx context.Context) error {
+		if err := repo.Create(ctx, newUser("outer")); err != nil {
+			return err
+		}
+		manager.WithinTx(ctx, nil, func(ctx context.Context) error {
+			repo.Create(ctx, newUser("inner"))
+			return boom
+		})
+		return nil
+	}); err != nil || !exists("outer") || exists("inner") {
+		t.Fatalf("expected only the outer unit to commit, got %v", err)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected the panic to propagate")
+			}
+		}()
+		manager.WithinTx(ctx, nil, func(ctx context.Context) error {
+			repo.Create(ctx, newUser("panicked"))
+			panic("boom")
+		})
+	}()
+	if exists("panicked") {
+		t.Fatal("expected a panicking unit to roll back")
+	}
+}
+
+func TestAfterCommitRunsOnlyCommittedUnits(t *testing.T) {
+	db := openSQLite(t)
+	ctx := context.Background()
+	manager := NewTxManager(db)
+	boom := errors.New("boom")
+
+	var ran []string
+	record := func(name string) func() { return func() { ran = append(ran, name) } }
+
+	AfterCommit(ctx, record("no tx"))
+	if err := manager.WithinTx(ctx, nil, func(ctx context.Context) error {
+		AfterCommit(ctx, record("outer"))
+		manager.WithinTx(ctx, nil, func(ctx context.Context) error {
+			AfterCommit(ctx, record("failed inner"))
+			return boom
+		})
+		if err := manager.WithinTx(ctx, nil, func(ctx context.Context) error {
+			AfterCommit(ctx, record("inner"))
+			return nil
+		}); err != nil {
+			return err
+		}
+		if len(ran) != 1 {
+			t.Fatalf("expected nothing to run before the commit, got %v", ran)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("within tx: %v", err)
+	}
+	manager.WithinTx(ctx, nil, func(ctx context.Context) error {
+		AfterCommit(ctx, record("rolled back"))
+		return boom
+	})
+
+	if got := strings.Join(ran, ","); got != "no tx,outer,inner" {
+		t.Fatalf("expected the committed units to run in order, got %s", got)
+	}
+}
+
+func TestRetryWithinTxRetriesTransientErrors(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	manager := NewTxManager(db)
+	manager.retry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	repo := NewRevocationRepository(db)
+	revoke := func(ctx context.Context) error {
+		return repo.RevokeToken(ctx, &entity.RevokedToken{JTI: "jti-1", UserID: "user-1"})
+	}
+
+	// A deadlock victim is run again in a new transaction.
+	mock.ExpectBegin()
+	mock.ExpectExec(`MERGE revoked_tokens`).WillReturnError(mssql.Error{Number: 1205})
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectExec(`MERGE revoked_tokens`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := manager.RetryWithinTx(context.Background(), nil, revoke); err != nil {
+		t.Fatalf("expected the retry to succeed, got %v", err)
+	}
+
+	// Constraint violations are final, and so is the last attempt.
+	mock.ExpectBegin()
+	mock.ExpectExec(`MERGE revoked_tokens`).WillReturnError(mssql.Error{Number: 547})
+	mock.ExpectRollback()
+	if err := manager.RetryWithinTx(context.Background(), nil, revoke); dialect.SQLServer.Classify(err) != dialect.ErrorForeignKeyViolation {
+		t.Fatalf("expected the violation without retry, got %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		mock.ExpectBegin()
+		mock.ExpectExec(`MERGE revoked_tokens`).WillReturnError(mssql.Error{Number: 1222})
+		mock.ExpectRollback()
+	}
+	if err := manager.RetryWithinTx(context.Background(), nil, revoke); dialect.SQLServer.Classify(err) != dialect.ErrorLockTimeout {
+		t.Fatalf("expected the lock timeout after three attempts, got %v", err)
+	}
+
+	// Nested units leave the retry to the outermost one.
+	mock.ExpectBegin()
+	mock.ExpectExec(`SAVE TRANSACTION sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`MERGE revoked_tokens`).WillReturnError(mssql.Error{Number: 1205})
+	mock.ExpectExec(`ROLLBACK TRANSACTION sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectExec(`SAVE TRANSACTION sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`MERGE revoked_tokens`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := manager.RetryWithinTx(context.Background(), nil, func(ctx context.Context) error {
+		return manager.RetryWithinTx(ctx, nil, revoke)
+	}); err != nil {
+		t.Fatalf("expected the outer unit to be retried, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"liangxiong/demo/internal/dialect"
+	"liangxiong/demo/model/entity"
+)
+
+// UserIdentityRepository persists links between external identities and users.
+type UserIdentityRepository interface {
+	Get(ctx context.Context, provider, subject string) (*entity.UserIdentity, error)
+	Create(ctx context.Context, identity *entity.UserIdentity) error
+}
+
+// SQLUserIdentityRepository is the database/sql implementation.
+type SQLUserIdentityRepository struct {
+	db      *sql.DB
+	dialect dialect.Dialect
+}
+
+// NewUserIdentityRepository builds the repository.
+func NewUserIdentityRepository(db *sql.DB) *SQLUserIdentityRepository {
+	return &SQLUserIdentityRepository{db: db, dialect: dialect.Of(db)}
+}
+
+func (r *SQLUserIdentityRepository) withExecutor(ctx context.Context) sqlExecutor {
+	return newExecutor(ctx, r.db, r.dialect)
+}
+
+// Get fetches the link of a provider subject.
+func (r *SQLUserIdentityRepository) Get(ctx context.Context, provider, subject string) (*entity.UserIdentity, error) {
+	row := r.withExecutor(ctx).queryRowContext(ctx, `SELECT provider, subject, user_id, created_at FROM user_identities WHERE provider = @p1 AND subject = @p2`, provider, subject)
+	if row == nil {
+		return nil, sql.ErrNoRows
+	}
+	var i entity.UserIdentity
+	if err := row.Scan(&i.Provider, &i.Subject, &i.UserID, &i.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// Create links an external identity to a user.
+func (r *SQLUserIdentityRepository) Create(ctx context.Context, identity *entity.UserIdentity) error {
+	_, err := r.withExecutor(ctx).execContext(ctx, `INSERT INTO user_identities (provider, subject, user_id, created_at) VALUES (@p1, @p2, @p3, @p4)`,
+		identity.Provider, identity.Subject, identity.UserID, identity.CreatedAt)
+	return err
+}
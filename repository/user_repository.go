@@ -4,22 +4,33 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"time"
 
+	"liangxiong/demo/internal/dialect"
 	"liangxiong/demo/model/entity"
 	"liangxiong/demo/utils"
 )
 
-// sqlExecutor wraps *sql.DB or *sql.Tx to unify method calls.
+// sqlExecutor wraps *sql.DB or *sql.Tx to unify method calls, rebinding the
+// @pN placeholders of queries for the dialect.
 type sqlExecutor struct {
-	inner interface{}
+	inner   interface{}
+	dialect dialect.Dialect
+}
+
+func (s sqlExecutor) rebind(query string) string {
+	if s.dialect == nil {
+		return query
+	}
+	return s.dialect.Rebind(query)
 }
 
 func (s sqlExecutor) execContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
 	switch db := s.inner.(type) {
 	case *sql.DB:
-		return db.ExecContext(ctx, query, args...)
+		return db.ExecContext(ctx, s.rebind(query), args...)
 	case *sql.Tx:
-		return db.ExecContext(ctx, query, args...)
+		return db.ExecContext(ctx, s.rebind(query), args...)
 	default:
 		return nil, errors.New("unsupported executor")
 	}
@@ -28,9 +39,9 @@ func (s sqlExecutor) execContext(ctx context.Context, query string, args ...any)
 func (s sqlExecutor) queryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
 	switch db := s.inner.(type) {
 	case *sql.DB:
-		return db.QueryContext(ctx, query, args...)
+		return db.QueryContext(ctx, s.rebind(query), args...)
 	case *sql.Tx:
-		return db.QueryContext(ctx, query, args...)
+		return db.QueryContext(ctx, s.rebind(query), args...)
 	default:
 		return nil, errors.New("unsupported executor")
 	}
@@ -39,111 +50,276 @@ func (s sqlExecutor) queryContext(ctx context.Context, query string, args ...any
 func (s sqlExecutor) queryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
 	switch db := s.inner.(type) {
 	case *sql.DB:
-		return db.QueryRowContext(ctx, query, args...)
+		return db.QueryRowContext(ctx, s.rebind(query), args...)
 	case *sql.Tx:
-		return db.QueryRowContext(ctx, query, args...)
+		return db.QueryRowContext(ctx, s.rebind(query), args...)
 	default:
 		return nil
 	}
 }
 
+// rowScanner is satisfied by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanRowVersion reads the row version returned by a conditional UPDATE into
+// dest. It reports false when no row matched, i.e. the version was stale.
+func scanRowVersion(row *sql.Row, dest *[]byte) (bool, error) {
+	if row == nil {
+		return false, errors.New("unsupported executor")
+	}
+	var version []byte
+	if err := row.Scan(&version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	*dest = version
+	return true, nil
+}
+
+// userColumns lists the users columns in scanUser order.
+const userColumns = `id, username, email, password_hash, first_name, last_name, role, created_at, updated_at, locked_until, status, deleted_at, deleted_by, row_version`
+
+// userKeyset whitelists the sortable user fields. Users are listed newest
+// first by default, ties broken by id.
+var userKeyset = keyset[entity.User]{
+	keys: map[string]sortKey[entity.User]{
+		"id":        stringKey("id", func(u *entity.User) string { return u.ID }),
+		"username":  stringKey("username", func(u *entity.User) string { return u.Username }),
+		"email":     stringKey("email", func(u *entity.User) string { return u.Email }),
+		"firstName": stringKey("first_name", func(u *entity.User) string { return u.FirstName }),
+		"lastName":  stringKey("last_name", func(u *entity.User) string { return u.LastName }),
+		"role":      stringKey("role", func(u *entity.User) string { return u.Role }),
+		"createdAt": timeKey("created_at", func(u *entity.User) time.Time { return u.CreatedAt }),
+		"updatedAt": timeKey("updated_at", func(u *entity.User) time.Time { return u.UpdatedAt }),
+	},
+	tiebreak: "id",
+	fallback: []utils.SortField{{Field: "createdAt", Desc: true}},
+}
+
+// UserSortFields lists the fields accepted in UserFilter.Sort.
+func UserSortFields() []string {
+	return userKeyset.sortable()
+}
+
+// userPatchColumns lists the user fields a partial update may write.
+var userPatchColumns = map[string]patchColumn[entity.User]{
+	"email":     {"email", func(u *entity.User) any { return u.Email }},
+	"firstName": {"first_name", func(u *entity.User) any { return u.FirstName }},
+	"lastName":  {"last_name", func(u *entity.User) any { return u.LastName }},
+	"role":      {"role", func(u *entity.User) any { return u.Role }},
+}
+
+// UserFilter narrows and orders a user listing. Zero values disable a filter;
+// CreatedFrom is inclusive and CreatedTo exclusive.
+type UserFilter struct {
+	Status         string
+	Role           string
+	UsernamePrefix string
+	EmailPrefix    string
+	Name           string
+	CreatedFrom    *time.Time
+	CreatedTo      *time.Time
+	Sort           []utils.SortField
+}
+
+func (f UserFilter) where() *whereClause {
+	w := &whereClause{}
+	if f.Status != "" {
+		w.add(`status = ?`, f.Status)
+	}
+	if f.Role != "" {
+		w.add(`role = ?`, f.Role)
+	}
+	if f.UsernamePrefix != "" {
+		w.add(`username LIKE ? ESCAPE '\'`, likePrefix(f.UsernamePrefix))
+	}
+	if f.EmailPrefix != "" {
+		w.add(`email LIKE ? ESCAPE '\'`, likePrefix(f.EmailPrefix))
+	}
+	if f.Name != "" {
+		pattern := likeContains(f.Name)
+		w.add(`(first_name LIKE ? ESCAPE '\' OR last_name LIKE ? ESCAPE '\')`, pattern, pattern)
+	}
+	if f.CreatedFrom != nil {
+		w.add(`created_at >= ?`, *f.CreatedFrom)
+	}
+	if f.CreatedTo != nil {
+		w.add(`created_at < ?`, *f.CreatedTo)
+	}
+	return w
+}
+
 // UserRepository exposes user persistence operations.
 type UserRepository interface {
-	GetByID(ctx context.Context, exec *sql.Tx, id string) (*entity.User, error)
-	GetByUsername(ctx context.Context, exec *sql.Tx, username string) (*entity.User, error)
-	List(ctx context.Context, page, size int) ([]entity.User, int64, error)
-	Create(ctx context.Context, exec *sql.Tx, user *entity.User) error
-	Update(ctx context.Context, exec *sql.Tx, user *entity.User) error
-	Delete(ctx context.Context, exec *sql.Tx, id string) error
+	GetByID(ctx context.Context, id string) (*entity.User, error)
+	GetByUsername(ctx context.Context, username string) (*entity.User, error)
+	List(ctx context.Context, filter UserFilter, page PageQuery) (*Page[entity.User], error)
+	Create(ctx context.Context, user *entity.User) error
+	Update(ctx context.Context, user *entity.User) (bool, error)
+	Patch(ctx context.Context, user *entity.User, fields []string, version []byte) (bool, error)
+	UpdateStatus(ctx context.Context, user *entity.User) (bool, error)
+	SetLockedUntil(ctx context.Context, id string, until sql.NullTime) error
+	UpdatePassword(ctx context.Context, id, passwordHash string, updatedAt time.Time) error
+	MarkPasswordRehashed(ctx context.Context, id, oldHash, newHash string) (bool, error)
 }
 
-// SQLUserRepository is the concrete repository backed by SQL Server.
+// SQLUserRepository is the concrete repository backed by database/sql.
 type SQLUserRepository struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect dialect.Dialect
 }
 
 // NewUserRepository instantiates a repository.
 func NewUserRepository(db *sql.DB) *SQLUserRepository {
-	return &SQLUserRepository{db: db}
+	return &SQLUserRepository{db: db, dialect: dialect.Of(db)}
 }
 
-func (r *SQLUserRepository) withExecutor(exec *sql.Tx) sqlExecutor {
-	if exec != nil {
-		return sqlExecutor{inner: exec}
-	}
-	return sqlExecutor{inner: r.db}
+func (r *SQLUserRepository) withExecutor(ctx context.Context) sqlExecutor {
+	return newExecutor(ctx, r.db, r.dialect)
 }
 
 // GetByID fetches a user by identifier.
-func (r *SQLUserRepository) GetByID(ctx context.Context, exec *sql.Tx, id string) (*entity.User, error) {
-	row := r.withExecutor(exec).queryRowContext(ctx, `SELECT id, username, email, password_hash, first_name, last_name, role, created_at, updated_at FROM users WHERE id = @p1`, id)
+func (r *SQLUserRepository) GetByID(ctx context.Context, id string) (*entity.User, error) {
+	row := r.withExecutor(ctx).queryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = @p1`, id)
 	return scanUser(row)
 }
 
 // GetByUsername fetches a user by username.
-func (r *SQLUserRepository) GetByUsername(ctx context.Context, exec *sql.Tx, username string) (*entity.User, error) {
-	row := r.withExecutor(exec).queryRowContext(ctx, `SELECT id, username, email, password_hash, first_name, last_name, role, created_at, updated_at FROM users WHERE username = @p1`, username)
+func (r *SQLUserRepository) GetByUsername(ctx context.Context, username string) (*entity.User, error) {
+	row := r.withExecutor(ctx).queryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE username = @p1`, username)
 	return scanUser(row)
 }
 
-// List returns paginated users plus total count.
-func (r *SQLUserRepository) List(ctx context.Context, page, size int) ([]entity.User, int64, error) {
-	exec := r.withExecutor(nil)
-	offset := utils.Offset(page, size)
-	limit := utils.NormalizeSize(size)
-	rows, err := exec.queryContext(ctx, `SELECT id, username, email, password_hash, first_name, last_name, role, created_at, updated_at FROM users ORDER BY created_at DESC OFFSET @p1 ROWS FETCH NEXT @p2 ROWS ONLY`, offset, limit)
+// List returns a page of the users matching the filter plus their total count.
+func (r *SQLUserRepository) List(ctx context.Context, filter UserFilter, page PageQuery) (*Page[entity.User], error) {
+	exec := r.withExecutor(ctx)
+	fields, err := userKeyset.resolve(filter.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	where := filter.where()
+	result := &Page[entity.User]{Total: -1}
+	if !page.SkipTotal {
+		row := exec.queryRowContext(ctx, `SELECT COUNT(1) FROM users`+where.String(), where.args...)
+		if err := row.Scan(&result.Total); err != nil {
+			return nil, err
+		}
+	}
+
+	if page.After != nil {
+		if err := userKeyset.after(where, fields, page.After); err != nil {
+			return nil, err
+		}
+	}
+	limit := where.limit(r.dialect, page)
+	rows, err := exec.queryContext(ctx, `SELECT `+userColumns+` FROM users`+where.String()+userKeyset.orderBy(fields)+limit, where.args...)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	defer rows.Close()
 
 	var users []entity.User
 	for rows.Next() {
-		var u entity.User
-		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.FirstName, &u.LastName, &u.Role, &u.CreatedAt, &u.UpdatedAt); err != nil {
-			return nil, 0, err
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
 		}
-		users = append(users, u)
+		users = append(users, *u)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
-	var total int64
-	row := exec.queryRowContext(ctx, `SELECT COUNT(1) FROM users`)
-	if err := row.Scan(&total); err != nil {
-		return nil, 0, err
+	result.Items, result.Next = userKeyset.trim(users, page, fields)
+	return result, nil
+}
+
+// Create inserts a new user and stores its initial row version in user.Version.
+func (r *SQLUserRepository) Create(ctx context.Context, user *entity.User) error {
+	output, returning := r.dialect.Returning("row_version")
+	row := r.withExecutor(ctx).queryRowContext(ctx, `INSERT INTO users (id, username, email, password_hash, first_name, last_name, role, created_at, updated_at, status)`+output+` VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9, @p10)`+returning,
+		user.ID, user.Username, user.Email, user.PasswordHash, user.FirstName, user.LastName, user.Role, user.CreatedAt, user.UpdatedAt, user.Status)
+	_, err := scanRowVersion(row, &user.Version)
+	return err
+}
+
+// Update modifies an existing user if its row version is still user.Version.
+// It reports false when the row changed since it was read; on success
+// user.Version holds the new row version.
+func (r *SQLUserRepository) Update(ctx context.Context, user *entity.User) (bool, error) {
+	output, returning := r.dialect.Returning("row_version")
+	row := r.withExecutor(ctx).queryRowContext(ctx, `UPDATE users SET email = @p1, first_name = @p2, last_name = @p3, role = @p4, updated_at = @p5`+r.dialect.SetRowVersion("row_version")+output+` WHERE id = @p6 AND row_version = @p7`+returning,
+		user.Email, user.FirstName, user.LastName, user.Role, user.UpdatedAt, user.ID, user.Version)
+	return scanRowVersion(row, &user.Version)
+}
+
+// Patch writes only the given fields of a user, named as in userPatchColumns,
+// plus updated_at. A non-nil version must still match the row version, as in
+// Update. It reports false when no row matched; on success user.Version holds
+// the new row version.
+func (r *SQLUserRepository) Patch(ctx context.Context, user *entity.User, fields []string, version []byte) (bool, error) {
+	w := &whereClause{}
+	set, err := patchSet(w, userPatchColumns, user, fields)
+	if err != nil {
+		return false, err
 	}
+	set += ", updated_at = " + w.placeholder(user.UpdatedAt)
+	w.add(`id = ?`, user.ID)
+	if version != nil {
+		w.add(`row_version = ?`, version)
+	}
+	output, returning := r.dialect.Returning("row_version")
+	row := r.withExecutor(ctx).queryRowContext(ctx, `UPDATE users SET `+set+r.dialect.SetRowVersion("row_version")+output+w.String()+returning, w.args...)
+	return scanRowVersion(row, &user.Version)
+}
 
-	return users, total, nil
+// UpdateStatus writes the status and soft-delete columns of a user under the
+// same row version check as Update. Rows are never physically deleted.
+func (r *SQLUserRepository) UpdateStatus(ctx context.Context, user *entity.User) (bool, error) {
+	output, returning := r.dialect.Returning("row_version")
+	row := r.withExecutor(ctx).queryRowContext(ctx, `UPDATE users SET status = @p1, deleted_at = @p2, deleted_by = @p3, updated_at = @p4`+r.dialect.SetRowVersion("row_version")+output+` WHERE id = @p5 AND row_version = @p6`+returning,
+		user.Status, user.DeletedAt, user.DeletedBy, user.UpdatedAt, user.ID, user.Version)
+	return scanRowVersion(row, &user.Version)
 }
 
-// Create inserts a new user.
-func (r *SQLUserRepository) Create(ctx context.Context, exec *sql.Tx, user *entity.User) error {
-	_, err := r.withExecutor(exec).execContext(ctx, `INSERT INTO users (id, username, email, password_hash, first_name, last_name, role, created_at, updated_at) VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9)`,
-		user.ID, user.Username, user.Email, user.PasswordHash, user.FirstName, user.LastName, user.Role, user.CreatedAt, user.UpdatedAt)
+// SetLockedUntil sets or clears the login lockout of a user.
+func (r *SQLUserRepository) SetLockedUntil(ctx context.Context, id string, until sql.NullTime) error {
+	_, err := r.withExecutor(ctx).execContext(ctx, `UPDATE users SET locked_until = @p1`+r.dialect.SetRowVersion("row_version")+` WHERE id = @p2`, until, id)
 	return err
 }
 
-// Update modifies an existing user.
-func (r *SQLUserRepository) Update(ctx context.Context, exec *sql.Tx, user *entity.User) error {
-	_, err := r.withExecutor(exec).execContext(ctx, `UPDATE users SET email = @p1, first_name = @p2, last_name = @p3, role = @p4, updated_at = @p5 WHERE id = @p6`,
-		user.Email, user.FirstName, user.LastName, user.Role, user.UpdatedAt, user.ID)
+// UpdatePassword replaces the password hash of a user.
+func (r *SQLUserRepository) UpdatePassword(ctx context.Context, id, passwordHash string, updatedAt time.Time) error {
+	_, err := r.withExecutor(ctx).execContext(ctx, `UPDATE users SET password_hash = @p1, updated_at = @p2`+r.dialect.SetRowVersion("row_version")+` WHERE id = @p3`, passwordHash, updatedAt, id)
 	return err
 }
 
-// Delete removes a user row.
-func (r *SQLUserRepository) Delete(ctx context.Context, exec *sql.Tx, id string) error {
-	_, err := r.withExecutor(exec).execContext(ctx, `DELETE FROM users WHERE id = @p1`, id)
-	return err
+// MarkPasswordRehashed swaps in an upgraded hash of the same password. It
+// reports false when the hash changed concurrently, in which case the newer
+// password wins. updated_at is left alone since the password did not change.
+func (r *SQLUserRepository) MarkPasswordRehashed(ctx context.Context, id, oldHash, newHash string) (bool, error) {
+	res, err := r.withExecutor(ctx).execContext(ctx, `UPDATE users SET password_hash = @p1`+r.dialect.SetRowVersion("row_version")+` WHERE id = @p2 AND password_hash = @p3`, newHash, id, oldHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
 }
 
-func scanUser(row *sql.Row) (*entity.User, error) {
+func scanUser(row rowScanner) (*entity.User, error) {
 	if row == nil {
 		return nil, sql.ErrNoRows
 	}
 	var u entity.User
-	if err := row.Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.FirstName, &u.LastName, &u.Role, &u.CreatedAt, &u.UpdatedAt); err != nil {
+	if err := row.Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.FirstName, &u.LastName, &u.Role, &u.CreatedAt, &u.UpdatedAt, &u.LockedUntil, &u.Status, &u.DeletedAt, &u.DeletedBy, &u.Version); err != nil {
 		return nil, err
 	}
 	return &u, nil
@@ -2,6 +2,8 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"regexp"
 	"testing"
 	"time"
@@ -9,6 +11,7 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 
 	"liangxiong/demo/model/entity"
+	"liangxiong/demo/utils"
 )
 
 func TestGetByID(t *testing.T) {
@@ -30,16 +33,17 @@ func TestGetByID(t *testing.T) {
 		Role:         "admin",
 		CreatedAt:    time.Now(),
 		UpdatedAt:    time.Now(),
+		Status:       entity.UserStatusActive,
 	}
 
-	mockRows := sqlmock.NewRows([]string{"id", "username", "email", "password_hash", "first_name", "last_name", "role", "created_at", "updated_at"}).
-		AddRow(expected.ID, expected.Username, expected.Email, expected.PasswordHash, expected.FirstName, expected.LastName, expected.Role, expected.CreatedAt, expected.UpdatedAt)
+	mockRows := sqlmock.NewRows([]string{"id", "username", "email", "password_hash", "first_name", "last_name", "role", "created_at", "updated_at", "locked_until", "status", "deleted_at", "deleted_by", "row_version"}).
+		AddRow(expected.ID, expected.Username, expected.Email, expected.PasswordHash, expected.FirstName, expected.LastName, expected.Role, expected.CreatedAt, expected.UpdatedAt, nil, expected.Status, nil, nil, []byte{1})
 
-	mock.ExpectQuery(`SELECT id, username, email, password_hash, first_name, last_name, role, created_at, updated_at FROM users WHERE id = @p1`).
+	mock.ExpectQuery(`SELECT id, username, email, password_hash, first_name, last_name, role, created_at, updated_at, locked_until, status, deleted_at, deleted_by, row_version FROM users WHERE id = @p1`).
 		WithArgs(expected.ID).
 		WillReturnRows(mockRows)
 
-	user, err := repo.GetByID(context.Background(), nil, expected.ID)
+	user, err := repo.GetByID(context.Background(), expected.ID)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -72,16 +76,140 @@ func TestCreate(t *testing.T) {
 		Role:         "admin",
 		CreatedAt:    time.Now(),
 		UpdatedAt:    time.Now(),
+		Status:       entity.UserStatusActive,
 	}
 
-	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO users (id, username, email, password_hash, first_name, last_name, role, created_at, updated_at) VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9)`)).
-		WithArgs(user.ID, user.Username, user.Email, user.PasswordHash, user.FirstName, user.LastName, user.Role, user.CreatedAt, user.UpdatedAt).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO users (id, username, email, password_hash, first_name, last_name, role, created_at, updated_at, status) OUTPUT INSERTED.row_version VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9, @p10)`)).
+		WithArgs(user.ID, user.Username, user.Email, user.PasswordHash, user.FirstName, user.LastName, user.Role, user.CreatedAt, user.UpdatedAt, user.Status).
+		WillReturnRows(sqlmock.NewRows([]string{"row_version"}).AddRow([]byte{1}))
 
-	tx, _ := db.Begin()
-	if err := repo.Create(context.Background(), tx, user); err != nil {
+	if err := repo.Create(context.Background(), user); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	if string(user.Version) != "\x01" {
+		t.Fatalf("expected initial row version, got %x", user.Version)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestListAppliesFilterAndSort(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	repo := NewUserRepository(db)
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	filter := UserFilter{
+		Role:           "admin",
+		UsernamePrefix: "al_",
+		Name:           "50%",
+		CreatedFrom:    &from,
+		Sort:           []utils.SortField{{Field: "createdAt", Desc: true}, {Field: "username"}},
+	}
+
+	where := ` WHERE role = @p1 AND username LIKE @p2 ESCAPE '\' AND (first_name LIKE @p3 ESCAPE '\' OR last_name LIKE @p4 ESCAPE '\') AND created_at >= @p5`
+	mock.ExpectQuery(`SELECT COUNT(1) FROM users`+where).
+		WithArgs("admin", `al\_%`, `%50\%%`, `%50\%%`, from).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT id, username, email, password_hash, first_name, last_name, role, created_at, updated_at, locked_until, status, deleted_at, deleted_by, row_version FROM users`+where+` ORDER BY created_at DESC, username, id OFFSET @p6 ROWS FETCH NEXT @p7 ROWS ONLY`).
+		WithArgs("admin", `al\_%`, `%50\%%`, `%50\%%`, from, 20, 21).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "password_hash", "first_name", "last_name", "role", "created_at", "updated_at", "locked_until", "status", "deleted_at", "deleted_by", "row_version"}).
+			AddRow("user-1", "al_ice", "alice@example.com", "hash", "50% Alice", "Lee", "admin", from, from, nil, "active", nil, nil, []byte{1}))
+
+	page, err := repo.List(context.Background(), filter, PageQuery{Page: 2, Size: 20})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if page.Total != 1 || len(page.Items) != 1 || page.Items[0].ID != "user-1" || page.Next != nil {
+		t.Fatalf("unexpected result %+v", page)
+	}
+
+	if _, err := repo.List(context.Background(), UserFilter{Sort: []utils.SortField{{Field: "password_hash"}}}, PageQuery{}); err == nil {
+		t.Fatal("expected unknown sort field to be rejected")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestListKeysetPage(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	repo := NewUserRepository(db)
+	after := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	filter := UserFilter{Role: "viewer", Sort: []utils.SortField{{Field: "createdAt", Desc: true}}}
+	columns := []string{"id", "username", "email", "password_hash", "first_name", "last_name", "role", "created_at", "updated_at", "locked_until", "status", "deleted_at", "deleted_by", "row_version"}
+
+	// No count query: the total is skipped.
+	mock.ExpectQuery(`SELECT id, username, email, password_hash, first_name, last_name, role, created_at, updated_at, locked_until, status, deleted_at, deleted_by, row_version FROM users WHERE role = @p1 AND ((created_at < @p2) OR (created_at = @p3 AND id > @p4)) ORDER BY created_at DESC, id OFFSET @p5 ROWS FETCH NEXT @p6 ROWS ONLY`).
+		WithArgs("viewer", after, after, "user-5", 0, 3).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("user-6", "u6", "u6@example.com", "hash", "U", "Six", "viewer", after, after, nil, "active", nil, nil, []byte{1}).
+			AddRow("user-2", "u2", "u2@example.com", "hash", "U", "Two", "viewer", after.Add(-time.Hour), after, nil, "active", nil, nil, []byte{1}).
+			AddRow("user-3", "u3", "u3@example.com", "hash", "U", "Three", "viewer", after.Add(-2*time.Hour), after, nil, "active", nil, nil, []byte{1}))
+
+	page, err := repo.List(context.Background(), filter, PageQuery{
+		Size:      2,
+		After:     []json.RawMessage{json.RawMessage(`"2026-03-01T12:00:00Z"`), json.RawMessage(`"user-5"`)},
+		SkipTotal: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if page.Total != -1 || len(page.Items) != 2 || page.Items[1].ID != "user-2" {
+		t.Fatalf("unexpected result %+v", page)
+	}
+	if len(page.Next) != 2 || !page.Next[0].(time.Time).Equal(after.Add(-time.Hour)) || page.Next[1] != "user-2" {
+		t.Fatalf("unexpected next key %v", page.Next)
+	}
+
+	if _, err := repo.List(context.Background(), filter, PageQuery{After: []json.RawMessage{json.RawMessage(`"user-5"`)}, SkipTotal: true}); !errors.Is(err, utils.ErrInvalidCursor) {
+		t.Fatalf("expected invalid cursor, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPatchWritesOnlyGivenFields(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	repo := NewUserRepository(db)
+	now := time.Now()
+	user := &entity.User{ID: "user-1", Email: "alice@example.com", LastName: "Lee", UpdatedAt: now}
+
+	mock.ExpectQuery(`UPDATE users SET email = @p1, last_name = @p2, updated_at = @p3 OUTPUT INSERTED.row_version WHERE id = @p4`).
+		WithArgs("alice@example.com", "Lee", now, "user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"row_version"}).AddRow([]byte{2}))
+	if ok, err := repo.Patch(context.Background(), user, []string{"email", "lastName"}, nil); err != nil || !ok {
+		t.Fatalf("expected patch to succeed, got %v %v", ok, err)
+	}
+
+	mock.ExpectQuery(`UPDATE users SET role = @p1, updated_at = @p2 OUTPUT INSERTED.row_version WHERE id = @p3 AND row_version = @p4`).
+		WithArgs("", now, "user-1", []byte{1}).
+		WillReturnRows(sqlmock.NewRows([]string{"row_version"}))
+	if ok, err := repo.Patch(context.Background(), user, []string{"role"}, []byte{1}); err != nil || ok {
+		t.Fatalf("expected stale patch to be refused, got %v %v", ok, err)
+	}
+
+	if _, err := repo.Patch(context.Background(), user, []string{"passwordHash"}, nil); err == nil {
+		t.Fatal("expected unpatchable field to be rejected")
+	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Fatalf("there were unfulfilled expectations: %v", err)
@@ -13,9 +13,10 @@ import (
 	"liangxiong/demo/docs"
 	"liangxiong/demo/internal/config"
 	"liangxiong/demo/middleware"
+	"liangxiong/demo/service"
 )
 
-func setupRoutes(engine *gin.Engine, cfg *config.Config, logger *zap.Logger, userController *controller.UserController, exchangeController *controller.ExchangeController, authController *controller.AuthController, jwtManager *auth.JWTManager) {
+func setupRoutes(engine *gin.Engine, cfg *config.Config, logger *zap.Logger, userController *controller.UserController, exchangeController *controller.ExchangeController, authController *controller.AuthController, passwordController *controller.PasswordController, mfaController *controller.MFAController, apiKeyController *controller.APIKeyController, impersonationController *controller.ImpersonationController, jwtManager *auth.JWTManager, revocations *service.RevocationService, apiKeys *service.APIKeyService, clientCerts *service.ClientCertService) {
 	docs.SwaggerInfo.Title = cfg.App.Name + " API"
 	docs.SwaggerInfo.Version = "1.0.0"
 	docs.SwaggerInfo.BasePath = "/"
@@ -24,26 +25,63 @@ func setupRoutes(engine *gin.Engine, cfg *config.Config, logger *zap.Logger, use
 
 	engine.GET("/healthz", controller.Health)
 	engine.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	engine.GET("/.well-known/jwks.json", authController.JWKS)
+
+	authenticated := middleware.Auth(jwtManager, revocations, apiKeys, clientCerts, logger)
 
 	api := engine.Group("/api/v1")
 	{
 		authGroup := api.Group("/auth")
+		authGroup.GET("/providers", authController.Providers)
 		authGroup.POST("/login", authController.Login)
+		authGroup.GET("/oidc/:provider/authorize", authController.AuthorizeOIDC)
+		authGroup.POST("/oidc/:provider/callback", authController.CallbackOIDC)
+		authGroup.POST("/mfa/verify", authController.VerifyMFA)
+		authGroup.POST("/refresh", authController.Refresh)
+		authGroup.POST("/logout", authenticated, authController.Logout)
+		authGroup.POST("/introspect", authenticated, middleware.RequirePermission(auth.PermTokensIntrospect), authController.Introspect)
+		authGroup.POST("/password/reset", passwordController.ResetPassword)
 
 		userGroup := api.Group("/users")
-		userGroup.Use(middleware.Auth(jwtManager, logger))
-		userGroup.GET("", userController.List)
-		userGroup.GET("/:id", userController.Get)
-		userGroup.POST("", userController.Create)
-		userGroup.PUT("/:id", userController.Update)
-		userGroup.DELETE("/:id", userController.Delete)
+		userGroup.Use(authenticated)
+		userGroup.GET("/me", middleware.RequireUser(), userController.Me)
+		userGroup.PATCH("/me", middleware.RequireUser(), middleware.DenyImpersonation(), userController.UpdateMe)
+		userGroup.PUT("/me/password", middleware.RequireUser(), middleware.DenyImpersonation(), passwordController.ChangePassword)
+		userGroup.POST("/me/mfa/totp", middleware.RequireUser(), middleware.DenyImpersonation(), mfaController.BeginEnrollment)
+		userGroup.POST("/me/mfa/totp/confirm", middleware.RequireUser(), middleware.DenyImpersonation(), mfaController.ConfirmEnrollment)
+		userGroup.POST("/me/mfa/recovery-codes", middleware.RequireUser(), middleware.DenyImpersonation(), mfaController.RegenerateRecoveryCodes)
+		userGroup.DELETE("/me/mfa", middleware.RequireUser(), middleware.DenyImpersonation(), mfaController.Disable)
+		userGroup.GET("", middleware.RequirePermission(auth.PermUsersRead), userController.List)
+		userGroup.GET("/:id", middleware.RequirePermission(auth.PermUsersRead), userController.Get)
+		userGroup.POST("", middleware.RequirePermission(auth.PermUsersAdmin), userController.Create)
+		userGroup.PUT("/:id", middleware.RequirePermission(auth.PermUsersAdmin), userController.Update)
+		userGroup.PATCH("/:id", middleware.RequirePermission(auth.PermUsersAdmin), userController.Patch)
+		userGroup.DELETE("/:id", middleware.RequirePermission(auth.PermUsersAdmin), userController.Delete)
+		userGroup.POST("/:id/disable", middleware.RequirePermission(auth.PermUsersAdmin), userController.Disable)
+		userGroup.POST("/:id/enable", middleware.RequirePermission(auth.PermUsersAdmin), userController.Enable)
+		userGroup.POST("/:id/restore", middleware.RequirePermission(auth.PermUsersAdmin), userController.Restore)
+		userGroup.POST("/:id/tokens/revoke", middleware.RequirePermission(auth.PermUsersAdmin), authController.RevokeUserTokens)
+		userGroup.POST("/:id/unlock", middleware.RequirePermission(auth.PermUsersAdmin), authController.UnlockUser)
+		userGroup.POST("/:id/password-reset", middleware.RequirePermission(auth.PermUsersAdmin), passwordController.IssueReset)
+		userGroup.DELETE("/:id/mfa", middleware.RequirePermission(auth.PermUsersAdmin), mfaController.Reset)
+		userGroup.POST("/:id/impersonate", middleware.DenyImpersonation(), middleware.RequirePermission(auth.PermUsersImpersonate), impersonationController.Impersonate)
+		userGroup.GET("/:id/impersonations", middleware.RequirePermission(auth.PermUsersImpersonate), impersonationController.List)
+
+		apiKeyGroup := api.Group("/api-keys")
+		apiKeyGroup.Use(authenticated, middleware.DenyImpersonation(), middleware.RequirePermission(auth.PermAPIKeysAdmin))
+		apiKeyGroup.GET("", apiKeyController.List)
+		apiKeyGroup.GET("/:id", apiKeyController.Get)
+		apiKeyGroup.POST("", apiKeyController.Create)
+		apiKeyGroup.DELETE("/:id", apiKeyController.Revoke)
 
 		exchangeGroup := api.Group("/exchanges")
-		exchangeGroup.Use(middleware.Auth(jwtManager, logger))
-		exchangeGroup.GET("", exchangeController.List)
-		exchangeGroup.GET("/:code", exchangeController.Get)
-		exchangeGroup.POST("", exchangeController.Create)
-		exchangeGroup.PUT("/:code", exchangeController.Update)
-		exchangeGroup.DELETE("/:code", exchangeController.Delete)
+		exchangeGroup.Use(authenticated)
+		exchangeGroup.GET("", middleware.RequirePermission(auth.PermExchangesRead), exchangeController.List)
+		exchangeGroup.GET("/translate", middleware.RequirePermission(auth.PermExchangesRead), exchangeController.Translate)
+		exchangeGroup.GET("/:code", middleware.RequirePermission(auth.PermExchangesRead), exchangeController.Get)
+		exchangeGroup.POST("", middleware.RequirePermission(auth.PermExchangesWrite), exchangeController.Create)
+		exchangeGroup.PUT("/:code", middleware.RequirePermission(auth.PermExchangesWrite), exchangeController.Update)
+		exchangeGroup.PATCH("/:code", middleware.RequirePermission(auth.PermExchangesWrite), exchangeController.Patch)
+		exchangeGroup.DELETE("/:code", middleware.RequirePermission(auth.PermExchangesWrite), exchangeController.Delete)
 	}
 }
@@ -16,6 +16,7 @@ import (
 	"liangxiong/demo/middleware"
 	"liangxiong/demo/repository"
 	"liangxiong/demo/service"
+	"liangxiong/demo/utils"
 )
 
 // Server represents the HTTP server.
@@ -24,6 +25,7 @@ type Server struct {
 	logger *zap.Logger
 	http   *http.Server
 	engine *gin.Engine
+	tls    *tlsReloader
 }
 
 // New configures routing, handlers, and middleware.
@@ -45,22 +47,82 @@ func New(cfg *config.Config, logger *zap.Logger, db *sql.DB) (*Server, error) {
 
 	userRepo := repository.NewUserRepository(db)
 	exchangeRepo := repository.NewExchangeRepository(db)
+	refreshTokenRepo := repository.NewRefreshTokenRepository(db)
+	revocationRepo := repository.NewRevocationRepository(db)
+	passwordResetRepo := repository.NewPasswordResetRepository(db)
+	mfaRepo := repository.NewMFARepository(db)
+	apiKeyRepo := repository.NewAPIKeyRepository(db)
+	identityRepo := repository.NewUserIdentityRepository(db)
+	impersonationRepo := repository.NewImpersonationRepository(db)
 	jwtManager, err := auth.NewJWTManager(cfg.Auth)
 	if err != nil {
 		return nil, err
 	}
 
-	userService := service.NewUserService(db, userRepo)
-	exchangeService := service.NewExchangeService(db, exchangeRepo)
-	authService := service.NewAuthService(userRepo, jwtManager)
+	passwordHasher, err := auth.NewPasswordHasher(cfg.Auth.PasswordHash)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordPolicy, err := auth.NewPasswordPolicy(cfg.Auth.PasswordPolicy, passwordHasher)
+	if err != nil {
+		return nil, err
+	}
+
+	var mfaSecrets *auth.SecretBox
+	if cfg.Auth.MFA.Enabled {
+		mfaSecrets, err = auth.NewSecretBox(cfg.Auth.MFA.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	clientCertMapper, err := auth.NewClientCertMapper(cfg.Server.TLS.ClientIdentities)
+	if err != nil {
+		return nil, err
+	}
+
+	revocationService := service.NewRevocationService(db, revocationRepo, refreshTokenRepo, cfg.Auth.RevocationCacheTTL)
+	identityProviders, err := service.NewIdentityProviders(cfg.Auth.Providers, userRepo, service.NewUserProvisioner(db, userRepo, identityRepo, revocationService))
+	if err != nil {
+		return nil, err
+	}
+
+	cursorSecret := cfg.Pagination.CursorSecret
+	if cursorSecret == "" {
+		cursorSecret, err = jwtManager.DeriveSecret("pagination cursor")
+		if err != nil {
+			return nil, err
+		}
+	}
+	cursors := utils.NewCursorCodec(cursorSecret)
+	userService := service.NewUserService(db, userRepo, passwordResetRepo, passwordPolicy, passwordHasher, cursors, revocationService)
+	exchangeService := service.NewExchangeService(db, exchangeRepo, cursors)
+	loginAttempts := auth.NewLoginAttemptTracker(cfg.Auth.Lockout)
+	mfaService := service.NewMFAService(db, userRepo, mfaRepo, mfaSecrets, loginAttempts, cfg.Auth.MFA)
+	authService := service.NewAuthService(db, userRepo, refreshTokenRepo, revocationService, loginAttempts, mfaService, identityProviders, passwordHasher, jwtManager, cfg.Auth.RefreshTokenTTL, logger)
+	apiKeyService := service.NewAPIKeyService(db, apiKeyRepo, userRepo, clientCertMapper)
+	clientCertService := service.NewClientCertService(userRepo, clientCertMapper)
+	impersonationService := service.NewImpersonationService(db, userRepo, impersonationRepo, revocationService, jwtManager, cfg.Auth.ImpersonationTTL)
+	passwordService := service.NewPasswordService(db, userRepo, passwordResetRepo, revocationService, loginAttempts, passwordPolicy, passwordHasher, cfg.Auth.PasswordResetTTL)
 
 	userController := controller.NewUserController(userService, logger)
 	exchangeController := controller.NewExchangeController(exchangeService, logger)
 	authController := controller.NewAuthController(authService, logger)
-
-	setupRoutes(engine, cfg, logger, userController, exchangeController, authController, jwtManager)
-
-	return &Server{cfg: cfg, logger: logger, engine: engine}, nil
+	passwordController := controller.NewPasswordController(passwordService, logger)
+	mfaController := controller.NewMFAController(mfaService, logger)
+	apiKeyController := controller.NewAPIKeyController(apiKeyService, logger)
+	impersonationController := controller.NewImpersonationController(impersonationService, logger)
+
+	setupRoutes(engine, cfg, logger, userController, exchangeController, authController, passwordController, mfaController, apiKeyController, impersonationController, jwtManager, revocationService, apiKeyService, clientCertService)
+
+	srv := &Server{cfg: cfg, logger: logger, engine: engine}
+	if cfg.Server.TLS.Enabled {
+		if srv.tls, err = newTLSReloader(cfg.Server.TLS, logger); err != nil {
+			return nil, err
+		}
+	}
+	return srv, nil
 }
 
 // Start boots the HTTP server and blocks until it exits.
@@ -73,8 +135,14 @@ func (s *Server) Start() error {
 		WriteTimeout: s.cfg.Server.WriteTimeout,
 	}
 
-	s.logger.Info("server starting", zap.String("addr", addr), zap.String("env", s.cfg.App.Env))
-	err := s.http.ListenAndServe()
+	s.logger.Info("server starting", zap.String("addr", addr), zap.String("env", s.cfg.App.Env), zap.Bool("tls", s.tls != nil))
+	var err error
+	if s.tls != nil {
+		s.http.TLSConfig = s.tls.TLSConfig()
+		err = s.http.ListenAndServeTLS("", "")
+	} else {
+		err = s.http.ListenAndServe()
+	}
 	if err != nil && err != http.ErrServerClosed {
 		return err
 	}
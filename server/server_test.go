@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+
+	"liangxiong/demo/internal/config"
+)
+
+func testConfig() *config.Config {
+	return &config.Config{
+		App:    config.AppConfig{Name: "test", Env: "test", LogLevel: "info"},
+		Server: config.ServerConfig{Address: "127.0.0.1", Port: 8080, MaxBodyBytes: 1 << 20},
+		Auth: config.AuthConfig{
+			JWTSecret:       "secret",
+			Issuer:          "test",
+			Audience:        "test",
+			AccessTokenTTL:  time.Minute,
+			RefreshTokenTTL: time.Hour,
+			Algorithm:       "HS256",
+			MFA:             config.MFAConfig{Enabled: true, Issuer: "test", EncryptionKey: "secret"},
+		},
+		CORS:      config.CORSConfig{AllowedOrigins: []string{"http://localhost:3000"}},
+		RateLimit: config.RateLimitConfig{RPS: 100},
+	}
+}
+
+// TestNewRegistersRoutes fails if route patterns conflict, since gin panics on registration.
+func TestNewRegistersRoutes(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	srv, err := New(testConfig(), zap.NewNop(), db)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+
+	cases := map[string]int{
+		"/healthz":               http.StatusOK,
+		"/.well-known/jwks.json": http.StatusOK,
+		"/api/v1/users/me":       http.StatusUnauthorized,
+		"/api/v1/exchanges":      http.StatusUnauthorized,
+	}
+	for path, status := range cases {
+		rec := httptest.NewRecorder()
+		srv.Engine().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != status {
+			t.Fatalf("%s: expected %d got %d", path, status, rec.Code)
+		}
+	}
+}
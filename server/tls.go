@@ -0,0 +1,164 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"liangxiong/demo/internal/config"
+)
+
+const defaultTLSReloadInterval = 30 * time.Second
+
+// modernCipherSuites are the TLS 1.2 suites with forward secrecy and AEAD.
+// TLS 1.3 suites are not configurable and always enabled.
+var modernCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// tlsReloader serves the TLS configuration built from the configured files
+// and rebuilds it when any of them changes, so certificates can be rotated
+// without a restart. Files are checked on handshake, at most once per interval;
+// a broken update is logged and the previous configuration kept.
+type tlsReloader struct {
+	cfg      config.TLSConfig
+	logger   *zap.Logger
+	interval time.Duration
+
+	mu        sync.Mutex
+	current   *tls.Config
+	modTimes  map[string]time.Time
+	checkedAt time.Time
+}
+
+func newTLSReloader(cfg config.TLSConfig, logger *zap.Logger) (*tlsReloader, error) {
+	r := &tlsReloader{cfg: cfg, logger: logger, interval: cfg.ReloadInterval}
+	if r.interval <= 0 {
+		r.interval = defaultTLSReloadInterval
+	}
+
+	modTimes, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	current, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.current, r.modTimes, r.checkedAt = current, modTimes, time.Now()
+	return r, nil
+}
+
+// TLSConfig is the listener configuration; every handshake gets the latest
+// certificate and client CA bundle through GetConfigForClient.
+func (r *tlsReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.config(time.Now()), nil
+		},
+	}
+}
+
+func (r *tlsReloader) config(now time.Time) *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.checkedAt) < r.interval {
+		return r.current
+	}
+	r.checkedAt = now
+
+	modTimes, err := r.stat()
+	if err != nil {
+		r.logger.Warn("tls reload skipped", zap.Error(err))
+		return r.current
+	}
+	if !r.changed(modTimes) {
+		return r.current
+	}
+
+	next, err := r.load()
+	if err != nil {
+		r.logger.Error("tls reload failed, keeping previous certificate", zap.Error(err))
+		return r.current
+	}
+	r.current, r.modTimes = next, modTimes
+	r.logger.Info("tls configuration reloaded", zap.String("certFile", r.cfg.CertFile))
+	return r.current
+}
+
+func (r *tlsReloader) changed(modTimes map[string]time.Time) bool {
+	for file, modTime := range modTimes {
+		if !modTime.Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *tlsReloader) stat() (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time, 3)
+	for _, file := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[file] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+func (r *tlsReloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load tls certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if r.cfg.MinVersion == "1.3" {
+		tlsConfig.MinVersion = tls.VersionTLS13
+	}
+	if strings.EqualFold(r.cfg.CipherPolicy, "modern") {
+		tlsConfig.CipherSuites = modernCipherSuites
+	}
+
+	switch strings.ToLower(r.cfg.ClientAuth) {
+	case "optional":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case "required":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(r.cfg.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read client ca bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("client ca bundle contains no certificates")
+	}
+	tlsConfig.ClientCAs = pool
+	return tlsConfig, nil
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"liangxiong/demo/internal/config"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func issueTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template.NotBefore = time.Now().Add(-time.Minute)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerCert := key, template
+	if parent != nil {
+		signer, signerCert = parent.key, parent.cert
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key, Leaf: c.cert}
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0o600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if keyFile == "" {
+		return
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+}
+
+func TestTLSReloaderClientAuthAndRotation(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.TLSConfig{
+		Enabled:        true,
+		CertFile:       filepath.Join(dir, "server.pem"),
+		KeyFile:        filepath.Join(dir, "server-key.pem"),
+		ClientCAFile:   filepath.Join(dir, "ca.pem"),
+		ClientAuth:     "required",
+		MinVersion:     "1.2",
+		CipherPolicy:   "modern",
+		ReloadInterval: time.Millisecond,
+	}
+
+	ca := issueTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test ca"}, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil)
+	serverTemplate := func(serial int64) *x509.Certificate {
+		return &x509.Certificate{SerialNumber: big.NewInt(serial), Subject: pkix.Name{CommonName: "server"}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+	}
+	client := issueTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(3), Subject: pkix.Name{CommonName: "exporter"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, ca)
+	ca.write(t, cfg.ClientCAFile, "")
+	issueTestCert(t, serverTemplate(2), ca).write(t, cfg.CertFile, cfg.KeyFile)
+
+	reloader, err := newTLSReloader(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("new reloader: %v", err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", reloader.TLSConfig())
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.TLS.VerifiedChains[0][0].Subject.CommonName))
+		}),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	handshake := func(certs []tls.Certificate) (*x509.Certificate, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certs},
+			DisableKeepAlives: true,
+		}}
+		resp, err := client.Get("https://" + listener.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != "exporter" {
+			t.Fatalf("expected verified client cert, got %q", body)
+		}
+		return resp.TLS.PeerCertificates[0], nil
+	}
+
+	served, err := handshake([]tls.Certificate{client.tlsCertificate()})
+	if err != nil {
+		t.Fatalf("handshake with client cert: %v", err)
+	}
+	if served.SerialNumber.Int64() != 2 {
+		t.Fatalf("expected serial 2, got %d", served.SerialNumber.Int64())
+	}
+	if _, err := handshake(nil); err == nil {
+		t.Fatalf("expected handshake without client cert to fail")
+	}
+
+	// Rotate the server certificate; new connections pick it up.
+	issueTestCert(t, serverTemplate(4), ca).write(t, cfg.CertFile, cfg.KeyFile)
+	future := time.Now().Add(time.Minute)
+	for _, file := range []string{cfg.CertFile, cfg.KeyFile} {
+		if err := os.Chtimes(file, future, future); err != nil {
+			t.Fatalf("touch: %v", err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	served, err = handshake([]tls.Certificate{client.tlsCertificate()})
+	if err != nil {
+		t.Fatalf("handshake after rotation: %v", err)
+	}
+	if served.SerialNumber.Int64() != 4 {
+		t.Fatalf("expected rotated serial 4, got %d", served.SerialNumber.Int64())
+	}
+
+	// A broken update keeps the previous certificate.
+	if err := os.WriteFile(cfg.CertFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatalf("write garbage: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if served, err = handshake([]tls.Certificate{client.tlsCertificate()}); err != nil || served.SerialNumber.Int64() != 4 {
+		t.Fatalf("expected previous certificate after failed reload, got %v %v", served, err)
+	}
+}
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/dto"
+	"liangxiong/demo/model/entity"
+	"liangxiong/demo/repository"
+	"liangxiong/demo/utils"
+)
+
+// lastUsedInterval throttles last_used_at writes to one per key per interval.
+const lastUsedInterval = time.Minute
+
+// maxKeyChain bounds how many keys created by keys are followed back to the
+// account that started the chain.
+const maxKeyChain = 8
+
+// APIKeyService issues, revokes and authenticates API keys.
+type APIKeyService struct {
+	tx    *repository.TxManager
+	repo  repository.APIKeyRepository
+	users repository.UserRepository
+	certs *auth.ClientCertMapper
+
+	mu       sync.Mutex
+	lastUsed map[string]time.Time
+}
+
+// NewAPIKeyService constructs the service. certs resolves keys created by
+// client certificate services.
+func NewAPIKeyService(db *sql.DB, repo repository.APIKeyRepository, users repository.UserRepository, certs *auth.ClientCertMapper) *APIKeyService {
+	return &APIKeyService{tx: repository.NewTxManager(db), repo: repo, users: users, certs: certs, lastUsed: make(map[string]time.Time)}
+}
+
+// Authenticate resolves a presented key. Unknown, revoked and expired keys
+// are rejected alike, and so are keys whose creator is no longer active. The
+// scopes of the returned key are narrowed to the permissions its creator
+// still holds.
+func (s *APIKeyService) Authenticate(ctx context.Context, key string) (*entity.APIKey, error) {
+	prefix, ok := auth.ParseAPIKey(key)
+	if !ok {
+		return nil, utils.Clone(utils.ErrUnauthorized, map[string]string{"apiKey": "malformed"}, nil)
+	}
+
+	record, err := s.repo.GetByPrefix(ctx, prefix)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.Clone(utils.ErrUnauthorized, map[string]string{"apiKey": "invalid"}, err)
+		}
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(record.KeyHash), []byte(auth.HashToken(key))) != 1 {
+		return nil, utils.Clone(utils.ErrUnauthorized, map[string]string{"apiKey": "invalid"}, nil)
+	}
+
+	now := time.Now().UTC()
+	if err := checkKeyUsable(record, now); err != nil {
+		return nil, err
+	}
+	if err := s.narrowToCreator(ctx, record, now, 0); err != nil {
+		return nil, err
+	}
+
+	if err := s.touch(ctx, record.ID, now); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// CreateKey issues a new key. Callers can only grant permissions they hold.
+func (s *APIKeyService) CreateKey(ctx context.Context, req dto.APIKeyCreateRequest) (*dto.APIKeyCreatedResponse, error) {
+	scopes, err := normalizeScopes(ctx, req.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	var expiresAt sql.NullTime
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) {
+			return nil, utils.Clone(utils.ErrBadRequest, map[string]string{"expiresAt": "must be in the future"}, nil)
+		}
+		expiresAt = sql.NullTime{Time: req.ExpiresAt.UTC(), Valid: true}
+	}
+
+	key, prefix, err := auth.NewAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	record := &entity.APIKey{
+		ID:        utils.NewID(),
+		Name:      req.Name,
+		Prefix:    prefix,
+		KeyHash:   auth.HashToken(key),
+		Scopes:    strings.Join(scopes, " "),
+		CreatedBy: utils.UserIDFromContext(ctx),
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+
+	err = s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		return s.repo.Create(ctx, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.APIKeyCreatedResponse{APIKeyResponse: mapAPIKeyToDTO(record), Key: key}, nil
+}
+
+// ListKeys returns paginated keys.
+func (s *APIKeyService) ListKeys(ctx context.Context, page, size int) (*dto.APIKeyListResponse, error) {
+	keys, total, err := s.repo.List(ctx, page, size)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]dto.APIKeyResponse, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, mapAPIKeyToDTO(&k))
+	}
+
+	return &dto.APIKeyListResponse{Total: total, Items: items}, nil
+}
+
+// GetKey fetches a single key.
+func (s *APIKeyService) GetKey(ctx context.Context, id string) (*dto.APIKeyResponse, error) {
+	record, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.Clone(utils.ErrNotFound, map[string]string{"id": id}, err)
+		}
+		return nil, err
+	}
+	resp := mapAPIKeyToDTO(record)
+	return &resp, nil
+}
+
+// RevokeKey disables a key immediately.
+func (s *APIKeyService) RevokeKey(ctx context.Context, id string) error {
+	if _, err := s.repo.GetByID(ctx, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return utils.Clone(utils.ErrNotFound, map[string]string{"id": id}, err)
+		}
+		return err
+	}
+	return s.repo.Revoke(ctx, id, time.Now().UTC())
+}
+
+func checkKeyUsable(record *entity.APIKey, now time.Time) error {
+	if record.RevokedAt.Valid {
+		return utils.Clone(utils.ErrUnauthorized, map[string]string{"apiKey": "revoked"}, nil)
+	}
+	if record.ExpiresAt.Valid && !now.Before(record.ExpiresAt.Time) {
+		return utils.Clone(utils.ErrUnauthorized, map[string]string{"apiKey": "expired"}, nil)
+	}
+	return nil
+}
+
+// narrowToCreator drops the scopes of record its creator no longer holds, so
+// that a key never outlives or outranks the account that issued it: keys of
+// a disabled or deleted user stop working, and keys of a demoted user lose
+// the permissions of the old role. A key created with another key is held to
+// that key, in turn checked against its own creator. Keys of a client
+// certificate service are held to the scopes the configuration currently
+// maps to it, and stop working once the mapping is removed.
+func (s *APIKeyService) narrowToCreator(ctx context.Context, record *entity.APIKey, now time.Time, depth int) error {
+	var holds func(scope string) bool
+	switch {
+	case strings.HasPrefix(record.CreatedBy, auth.APIKeySubject("")):
+		if depth >= maxKeyChain {
+			return utils.Clone(utils.ErrUnauthorized, map[string]string{"apiKey": "creator chain too long"}, nil)
+		}
+		parent, err := s.repo.GetByID(ctx, strings.TrimPrefix(record.CreatedBy, auth.APIKeySubject("")))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return utils.Clone(utils.ErrUnauthorized, map[string]string{"apiKey": "creator inactive"}, err)
+			}
+			return err
+		}
+		if checkKeyUsable(parent, now) != nil {
+			return utils.Clone(utils.ErrUnauthorized, map[string]string{"apiKey": "creator inactive"}, nil)
+		}
+		if err := s.narrowToCreator(ctx, parent, now, depth+1); err != nil {
+			return err
+		}
+		granted := strings.Fields(parent.Scopes)
+		holds = func(scope string) bool { return slices.Contains(granted, scope) }
+	case strings.HasPrefix(record.CreatedBy, auth.ClientCertSubject("")):
+		rule, ok := s.certs.Service(strings.TrimPrefix(record.CreatedBy, auth.ClientCertSubject("")))
+		if !ok {
+			return utils.Clone(utils.ErrUnauthorized, map[string]string{"apiKey": "creator inactive"}, nil)
+		}
+		holds = func(scope string) bool { return slices.Contains(rule.Scopes, scope) }
+	default:
+		creator, err := s.users.GetByID(ctx, record.CreatedBy)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return utils.Clone(utils.ErrUnauthorized, map[string]string{"apiKey": "creator inactive"}, err)
+			}
+			return err
+		}
+		if creator.Status != entity.UserStatusActive {
+			return utils.Clone(utils.ErrUnauthorized, map[string]string{"apiKey": "creator inactive"}, nil)
+		}
+		holds = func(scope string) bool { return auth.HasPermission(creator.Role, scope) }
+	}
+
+	var scopes []string
+	for _, scope := range strings.Fields(record.Scopes) {
+		if holds(scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	record.Scopes = strings.Join(scopes, " ")
+	return nil
+}
+
+// touch records key usage at most once per lastUsedInterval per instance.
+func (s *APIKeyService) touch(ctx context.Context, id string, now time.Time) error {
+	s.mu.Lock()
+	last, ok := s.lastUsed[id]
+	due := !ok || now.Sub(last) >= lastUsedInterval
+	if due {
+		s.lastUsed[id] = now
+	}
+	s.mu.Unlock()
+	if !due {
+		return nil
+	}
+	return s.repo.UpdateLastUsed(ctx, id, now)
+}
+
+// normalizeScopes deduplicates and sorts scopes, rejecting unknown permissions
+// and permissions the caller does not hold.
+func normalizeScopes(ctx context.Context, requested []string) ([]string, error) {
+	seen := make(map[string]bool, len(requested))
+	scopes := make([]string, 0, len(requested))
+	for _, scope := range requested {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if !auth.IsValidPermission(scope) {
+			return nil, utils.Clone(utils.ErrBadRequest, map[string]string{"scopes": "unknown permission " + scope}, nil)
+		}
+		if !auth.Allowed(ctx, scope) {
+			return nil, utils.Clone(utils.ErrForbidden, map[string]string{"scopes": "cannot grant " + scope}, nil)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Strings(scopes)
+	return scopes, nil
+}
+
+func mapAPIKeyToDTO(k *entity.APIKey) dto.APIKeyResponse {
+	resp := dto.APIKeyResponse{
+		ID:        k.ID,
+		Name:      k.Name,
+		Prefix:    k.Prefix,
+		Scopes:    strings.Fields(k.Scopes),
+		CreatedBy: k.CreatedBy,
+		CreatedAt: k.CreatedAt,
+	}
+	if k.ExpiresAt.Valid {
+		resp.ExpiresAt = &k.ExpiresAt.Time
+	}
+	if k.LastUsedAt.Valid {
+		resp.LastUsedAt = &k.LastUsedAt.Time
+	}
+	if k.RevokedAt.Valid {
+		resp.RevokedAt = &k.RevokedAt.Time
+	}
+	return resp
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/dto"
+	"liangxiong/demo/internal/config"
+	"liangxiong/demo/model/entity"
+	"liangxiong/demo/repository"
+	"liangxiong/demo/utils"
+)
+
+func TestAPIKeyAuthenticate(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	certs, err := auth.NewClientCertMapper([]config.ClientIdentityConfig{{SAN: "exporter.example.org", Service: "exporter", Scopes: []string{auth.PermExchangesRead}}})
+	if err != nil {
+		t.Fatalf("new mapper: %v", err)
+	}
+	svc := NewAPIKeyService(db, repository.NewAPIKeyRepository(db), repository.NewUserRepository(db), certs)
+	ctx := context.Background()
+
+	key, prefix, err := auth.NewAPIKey()
+	if err != nil {
+		t.Fatalf("new key: %v", err)
+	}
+	other, _, err := auth.NewAPIKey()
+	if err != nil {
+		t.Fatalf("new key: %v", err)
+	}
+
+	columns := []string{"id", "name", "prefix", "key_hash", "scopes", "created_by", "expires_at", "last_used_at", "created_at", "revoked_at"}
+	keyRow := func(hash string, expiresAt, revokedAt any) *sqlmock.Rows {
+		return sqlmock.NewRows(columns).AddRow("key-1", "batch", prefix, hash, "exchanges:read exchanges:write", "admin-1", expiresAt, nil, time.Now().Add(-time.Hour), revokedAt)
+	}
+	creatorRow := func(status, role string) *sqlmock.Rows {
+		now := time.Now()
+		return sqlmock.NewRows(userColumns).AddRow("admin-1", "root", "root@example.org", "hash", "Root", "A", role, now, now, nil, status, nil, nil, []byte{1})
+	}
+	rejected := func(err error, reason string) bool {
+		var appErr *utils.AppError
+		if !errors.As(err, &appErr) || appErr.Code != utils.ErrUnauthorized.Code {
+			return false
+		}
+		details, _ := appErr.Details.(map[string]string)
+		return details["apiKey"] == reason
+	}
+
+	if _, err := svc.Authenticate(ctx, "Bearer "+key); !rejected(err, "malformed") {
+		t.Fatalf("expected a malformed key to be rejected, got %v", err)
+	}
+
+	mock.ExpectQuery(`FROM api_keys WHERE prefix = @p1`).WithArgs(prefix).WillReturnError(sql.ErrNoRows)
+	if _, err := svc.Authenticate(ctx, key); !rejected(err, "invalid") {
+		t.Fatalf("expected an unknown key to be rejected, got %v", err)
+	}
+
+	// Another secret under the same prefix.
+	mock.ExpectQuery(`FROM api_keys WHERE prefix = @p1`).WithArgs(prefix).WillReturnRows(keyRow(auth.HashToken(other), nil, nil))
+	if _, err := svc.Authenticate(ctx, key); !rejected(err, "invalid") {
+		t.Fatalf("expected a hash mismatch to be rejected, got %v", err)
+	}
+
+	mock.ExpectQuery(`FROM api_keys WHERE prefix = @p1`).WithArgs(prefix).WillReturnRows(keyRow(auth.HashToken(key), nil, time.Now().Add(-time.Minute)))
+	if _, err := svc.Authenticate(ctx, key); !rejected(err, "revoked") {
+		t.Fatalf("expected a revoked key to be rejected, got %v", err)
+	}
+
+	mock.ExpectQuery(`FROM api_keys WHERE prefix = @p1`).WithArgs(prefix).WillReturnRows(keyRow(auth.HashToken(key), time.Now().Add(-time.Second), nil))
+	if _, err := svc.Authenticate(ctx, key); !rejected(err, "expired") {
+		t.Fatalf("expected an expired key to be rejected, got %v", err)
+	}
+
+	// Usage is recorded on the first use, then at most once per interval.
+	mock.ExpectQuery(`FROM api_keys WHERE prefix = @p1`).WithArgs(prefix).WillReturnRows(keyRow(auth.HashToken(key), time.Now().Add(time.Hour), nil))
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("admin-1").WillReturnRows(creatorRow(entity.UserStatusActive, auth.RoleAdmin))
+	mock.ExpectExec(`UPDATE api_keys SET last_used_at = @p1 WHERE id = @p2`).WithArgs(sqlmock.AnyArg(), "key-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	record, err := svc.Authenticate(ctx, key)
+	if err != nil || record.ID != "key-1" || record.Scopes != "exchanges:read exchanges:write" {
+		t.Fatalf("expected the key to authenticate, got %+v %v", record, err)
+	}
+
+	mock.ExpectQuery(`FROM api_keys WHERE prefix = @p1`).WithArgs(prefix).WillReturnRows(keyRow(auth.HashToken(key), nil, nil))
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("admin-1").WillReturnRows(creatorRow(entity.UserStatusActive, auth.RoleAdmin))
+	if _, err := svc.Authenticate(ctx, key); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+
+	svc.lastUsed["key-1"] = time.Now().Add(-lastUsedInterval)
+	mock.ExpectQuery(`FROM api_keys WHERE prefix = @p1`).WithArgs(prefix).WillReturnRows(keyRow(auth.HashToken(key), nil, nil))
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("admin-1").WillReturnRows(creatorRow(entity.UserStatusActive, auth.RoleAdmin))
+	mock.ExpectExec(`UPDATE api_keys SET last_used_at = @p1 WHERE id = @p2`).WithArgs(sqlmock.AnyArg(), "key-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	if _, err := svc.Authenticate(ctx, key); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+
+	// Keys do not outlive their creator, nor outrank a demoted one.
+	mock.ExpectQuery(`FROM api_keys WHERE prefix = @p1`).WithArgs(prefix).WillReturnRows(keyRow(auth.HashToken(key), nil, nil))
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("admin-1").WillReturnRows(creatorRow(entity.UserStatusDisabled, auth.RoleAdmin))
+	if _, err := svc.Authenticate(ctx, key); !rejected(err, "creator inactive") {
+		t.Fatalf("expected the key of a disabled creator to be rejected, got %v", err)
+	}
+
+	mock.ExpectQuery(`FROM api_keys WHERE prefix = @p1`).WithArgs(prefix).WillReturnRows(keyRow(auth.HashToken(key), nil, nil))
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("admin-1").WillReturnRows(creatorRow(entity.UserStatusActive, auth.RoleViewer))
+	if record, err := svc.Authenticate(ctx, key); err != nil || record.Scopes != "exchanges:read" {
+		t.Fatalf("expected the scopes to follow the demotion, got %+v %v", record, err)
+	}
+
+	// A key created with a key is held to that key and its creator.
+	childRow := func() *sqlmock.Rows {
+		return sqlmock.NewRows(columns).AddRow("key-2", "child", prefix, auth.HashToken(key), "exchanges:read exchanges:write", auth.APIKeySubject("key-1"), nil, nil, time.Now(), nil)
+	}
+	mock.ExpectQuery(`FROM api_keys WHERE prefix = @p1`).WithArgs(prefix).WillReturnRows(childRow())
+	mock.ExpectQuery(`FROM api_keys WHERE id = @p1`).WithArgs("key-1").WillReturnRows(keyRow(auth.HashToken(other), nil, time.Now().Add(-time.Minute)))
+	if _, err := svc.Authenticate(ctx, key); !rejected(err, "creator inactive") {
+		t.Fatalf("expected the key of a revoked key to be rejected, got %v", err)
+	}
+
+	mock.ExpectQuery(`FROM api_keys WHERE prefix = @p1`).WithArgs(prefix).WillReturnRows(childRow())
+	mock.ExpectQuery(`FROM api_keys WHERE id = @p1`).WithArgs("key-1").WillReturnRows(keyRow(auth.HashToken(other), nil, nil))
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("admin-1").WillReturnRows(creatorRow(entity.UserStatusActive, auth.RoleViewer))
+	mock.ExpectExec(`UPDATE api_keys SET last_used_at = @p1 WHERE id = @p2`).WithArgs(sqlmock.AnyArg(), "key-2").WillReturnResult(sqlmock.NewResult(0, 1))
+	if record, err := svc.Authenticate(ctx, key); err != nil || record.Scopes != "exchanges:read" {
+		t.Fatalf("expected the scopes to follow the parent key, got %+v %v", record, err)
+	}
+
+	// A key created by a client certificate service follows its current mapping.
+	certRow := func(service string) *sqlmock.Rows {
+		return sqlmock.NewRows(columns).AddRow("key-3", "export", prefix, auth.HashToken(key), "exchanges:read exchanges:write", auth.ClientCertSubject(service), nil, nil, time.Now(), nil)
+	}
+	mock.ExpectQuery(`FROM api_keys WHERE prefix = @p1`).WithArgs(prefix).WillReturnRows(certRow("exporter"))
+	mock.ExpectExec(`UPDATE api_keys SET last_used_at = @p1 WHERE id = @p2`).WithArgs(sqlmock.AnyArg(), "key-3").WillReturnResult(sqlmock.NewResult(0, 1))
+	if record, err := svc.Authenticate(ctx, key); err != nil || record.Scopes != "exchanges:read" {
+		t.Fatalf("expected the scopes to follow the certificate mapping, got %+v %v", record, err)
+	}
+
+	mock.ExpectQuery(`FROM api_keys WHERE prefix = @p1`).WithArgs(prefix).WillReturnRows(certRow("retired"))
+	if _, err := svc.Authenticate(ctx, key); !rejected(err, "creator inactive") {
+		t.Fatalf("expected the key of an unmapped service to be rejected, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateKeyScopes(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	svc := NewAPIKeyService(db, repository.NewAPIKeyRepository(db), repository.NewUserRepository(db), nil)
+	operator := utils.WithRole(utils.WithUserID(context.Background(), "user-1"), auth.RoleOperator)
+	scoped := utils.WithScopes(utils.WithUserID(context.Background(), auth.APIKeySubject("key-1")), []string{auth.PermAPIKeysAdmin, auth.PermExchangesRead})
+
+	cases := []struct {
+		name   string
+		ctx    context.Context
+		scopes []string
+		want   []string
+		code   int
+	}{
+		{"role permissions", operator, []string{" Exchanges:Write", auth.PermUsersRead, "exchanges:write"}, []string{auth.PermExchangesWrite, auth.PermUsersRead}, 0},
+		{"unknown permission", operator, []string{"exchanges:delete"}, nil, utils.ErrBadRequest.Code},
+		{"permission beyond the role", operator, []string{auth.PermExchangesRead, auth.PermAPIKeysAdmin}, nil, utils.ErrForbidden.Code},
+		{"key scopes", scoped, []string{auth.PermExchangesRead}, []string{auth.PermExchangesRead}, 0},
+		// A key holding apikeys:admin cannot grant more than its own scopes.
+		{"permission beyond the key scopes", scoped, []string{auth.PermExchangesWrite}, nil, utils.ErrForbidden.Code},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.code == 0 {
+				mock.ExpectBegin()
+				mock.ExpectExec(`INSERT INTO api_keys`).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			}
+			resp, err := svc.CreateKey(tc.ctx, dto.APIKeyCreateRequest{Name: "batch", Scopes: tc.scopes})
+			if tc.code != 0 {
+				var appErr *utils.AppError
+				if !errors.As(err, &appErr) || appErr.Code != tc.code {
+					t.Fatalf("expected error code %d, got %v", tc.code, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("create: %v", err)
+			}
+			if !reflect.DeepEqual(resp.Scopes, tc.want) || resp.CreatedBy != utils.UserIDFromContext(tc.ctx) {
+				t.Fatalf("unexpected key: %+v", resp.APIKeyResponse)
+			}
+		})
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -2,44 +2,527 @@ package service
 
 import (
 	"context"
+	"crypto/subtle"
 	"database/sql"
 	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
 
 	"liangxiong/demo/auth"
 	"liangxiong/demo/dto"
+	"liangxiong/demo/internal/dialect"
+	"liangxiong/demo/model/entity"
 	"liangxiong/demo/repository"
 	"liangxiong/demo/utils"
 )
 
+// oidcStateTTL bounds the time a user may spend at the OIDC provider.
+const oidcStateTTL = 10 * time.Minute
+
 // AuthService handles authentication flows.
 type AuthService struct {
-	repo repository.UserRepository
-	jwt  *auth.JWTManager
+	tx          *repository.TxManager
+	repo        repository.UserRepository
+	refresh     repository.RefreshTokenRepository
+	revocations *RevocationService
+	attempts    *auth.LoginAttemptTracker
+	mfa         *MFAService
+	providers   *IdentityProviders
+	hasher      *auth.PasswordHasher
+	jwt         *auth.JWTManager
+	refreshTTL  time.Duration
+	logger      *zap.Logger
 }
 
 // NewAuthService constructs the service.
-func NewAuthService(repo repository.UserRepository, jwt *auth.JWTManager) *AuthService {
-	return &AuthService{repo: repo, jwt: jwt}
+func NewAuthService(db *sql.DB, repo repository.UserRepository, refresh repository.RefreshTokenRepository, revocations *RevocationService, attempts *auth.LoginAttemptTracker, mfa *MFAService, providers *IdentityProviders, hasher *auth.PasswordHasher, jwt *auth.JWTManager, refreshTTL time.Duration, logger *zap.Logger) *AuthService {
+	return &AuthService{tx: repository.NewTxManager(db), repo: repo, refresh: refresh, revocations: revocations, attempts: attempts, mfa: mfa, providers: providers, hasher: hasher, jwt: jwt, refreshTTL: refreshTTL, logger: logger}
+}
+
+// Providers lists the configured login identity providers.
+func (s *AuthService) Providers() []dto.ProviderResponse {
+	return s.providers.List()
+}
+
+// Login authenticates username/password against the named password provider,
+// or the default one when provider is empty. Failed attempts are throttled per
+// username and client IP, and repeated failures lock the account. Users with
+// MFA enabled receive a challenge token instead of a token pair. Local
+// passwords stored with outdated hash settings are rehashed on success; a
+// failed rehash is logged and retried on the next login.
+func (s *AuthService) Login(ctx context.Context, provider, username, password, clientIP string) (*dto.LoginResponse, error) {
+	userKey, ipKey := auth.UsernameKey(username), auth.IPKey(clientIP)
+	if wait := s.attempts.RetryAfter(userKey, ipKey); wait > 0 {
+		return nil, utils.Clone(utils.ErrTooManyLogin, map[string]string{"retryAfter": retryAfterSeconds(wait)}, nil)
+	}
+
+	if provider == "" {
+		provider = s.providers.Default()
+	}
+	authn, ok := s.providers.Get(provider)
+	if !ok {
+		return nil, utils.Clone(utils.ErrBadRequest, map[string]string{"provider": "unknown"}, nil)
+	}
+	if _, redirect := authn.(RedirectAuthenticator); redirect {
+		return nil, utils.Clone(utils.ErrBadRequest, map[string]string{"provider": "does not accept passwords"}, nil)
+	}
+
+	// External users may not have a local row yet; lockout only applies to
+	// accounts that exist.
+	user, err := s.repo.GetByUsername(ctx, username)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		user = nil
+	}
+
+	now := time.Now().UTC()
+	if user != nil && user.LockedUntil.Valid && now.Before(user.LockedUntil.Time) {
+		return nil, accountLockedError(user.LockedUntil.Time)
+	}
+
+	identity, err := authn.Authenticate(ctx, Credentials{Username: username, Password: password})
+	if err != nil {
+		if !errors.Is(err, ErrInvalidCredentials) {
+			return nil, err
+		}
+		s.attempts.RecordFailure(ipKey)
+		if user == nil {
+			s.attempts.RecordFailure(userKey)
+			return nil, utils.Clone(utils.ErrUnauthorized, map[string]string{"username": "invalid credentials"}, err)
+		}
+		return nil, recordFailure(ctx, s.attempts, s.repo, user, userKey, now, utils.Clone(utils.ErrUnauthorized, map[string]string{"username": "invalid credentials"}, nil))
+	}
+	s.attempts.Reset(userKey)
+
+	if identity.User != nil && s.hasher.NeedsRehash(identity.User.PasswordHash) {
+		if err := s.rehashPassword(ctx, identity.User, password); err != nil {
+			s.logger.Warn("password rehash failed", zap.String("userId", identity.User.ID), zap.Error(err))
+		}
+	}
+
+	user, err = s.providers.Resolve(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+	return s.completeLogin(ctx, user)
+}
+
+// BeginOIDC starts the authorization code flow of an OIDC provider. The
+// returned state is a signed token binding the provider, the nonce and the
+// returned binding secret, which the client must keep to itself and present
+// again to CompleteOIDC.
+func (s *AuthService) BeginOIDC(ctx context.Context, provider string) (*dto.OIDCAuthorizeResponse, string, error) {
+	authn, err := s.redirectProvider(provider)
+	if err != nil {
+		return nil, "", err
+	}
+
+	nonce, err := auth.NewOpaqueToken()
+	if err != nil {
+		return nil, "", err
+	}
+	binding, err := auth.NewOpaqueToken()
+	if err != nil {
+		return nil, "", err
+	}
+	state, expiresAt, err := s.jwt.GenerateState(provider, nonce, auth.HashToken(binding), oidcStateTTL)
+	if err != nil {
+		return nil, "", err
+	}
+	authorizationURL, err := authn.AuthorizationURL(ctx, state, nonce)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &dto.OIDCAuthorizeResponse{AuthorizationURL: authorizationURL, State: state, ExpiresAt: expiresAt}, binding, nil
+}
+
+// CompleteOIDC redeems the authorization code returned by an OIDC provider,
+// provisions the user on first login and issues tokens like Login. binding
+// must be the secret BeginOIDC returned with state, so that a code and state
+// obtained by someone else cannot be replayed into another client (login CSRF).
+func (s *AuthService) CompleteOIDC(ctx context.Context, provider, code, state, binding string) (*dto.LoginResponse, error) {
+	authn, err := s.redirectProvider(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := s.jwt.ValidateState(state)
+	if err != nil {
+		return nil, utils.Clone(utils.ErrUnauthorized, map[string]string{"state": "invalid"}, err)
+	}
+	if claims.Subject != provider {
+		return nil, utils.Clone(utils.ErrUnauthorized, map[string]string{"state": "issued for another provider"}, nil)
+	}
+	if binding == "" || subtle.ConstantTimeCompare([]byte(auth.HashToken(binding)), []byte(claims.Binding)) != 1 {
+		return nil, utils.Clone(utils.ErrUnauthorized, map[string]string{"state": "not issued to this client"}, nil)
+	}
+
+	identity, err := authn.Authenticate(ctx, Credentials{Code: code, Nonce: claims.Nonce})
+	if err != nil {
+		if errors.Is(err, ErrInvalidCredentials) {
+			return nil, utils.Clone(utils.ErrUnauthorized, map[string]string{"code": "invalid"}, err)
+		}
+		return nil, err
+	}
+
+	user, err := s.providers.Resolve(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+	return s.completeLogin(ctx, user)
 }
 
-// Login authenticates username/password.
-func (s *AuthService) Login(ctx context.Context, username, password string) (*dto.LoginResponse, error) {
-	user, err := s.repo.GetByUsername(ctx, nil, username)
+// VerifyMFA completes a two-step login with a TOTP or recovery code.
+// Each challenge token can be redeemed once; failed codes count towards the
+// account lockout.
+func (s *AuthService) VerifyMFA(ctx context.Context, mfaToken, code string) (*dto.LoginResponse, error) {
+	claims, err := s.jwt.ValidateChallenge(mfaToken)
+	if err != nil {
+		return nil, utils.Clone(utils.ErrUnauthorized, map[string]string{"mfaToken": "invalid"}, err)
+	}
+	issuedAt, expiresAt := claims.IssuedAt.Time, claims.ExpiresAt.Time
+
+	revoked, err := s.revocations.IsRevoked(ctx, claims.ID, claims.Subject, claims.Generation, issuedAt, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, utils.Clone(utils.ErrUnauthorized, map[string]string{"mfaToken": "already used"}, nil)
+	}
+
+	key := auth.MFAKey(claims.Subject)
+	if wait := s.attempts.RetryAfter(key); wait > 0 {
+		return nil, utils.Clone(utils.ErrTooManyLogin, map[string]string{"retryAfter": retryAfterSeconds(wait)}, nil)
+	}
+
+	user, err := s.repo.GetByID(ctx, claims.Subject)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, utils.Clone(utils.ErrUnauthorized, map[string]string{"username": "not found"}, err)
+			return nil, utils.Clone(utils.ErrUnauthorized, map[string]string{"mfaToken": "user not found"}, err)
 		}
 		return nil, err
 	}
 
-	if !auth.VerifyPassword(user.PasswordHash, password) {
-		return nil, utils.Clone(utils.ErrUnauthorized, map[string]string{"username": "invalid credentials"}, nil)
+	if err := inactiveUserError(user); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if user.LockedUntil.Valid && now.Before(user.LockedUntil.Time) {
+		return nil, accountLockedError(user.LockedUntil.Time)
 	}
 
-	token, expiresAt, err := s.jwt.Generate(user.ID)
+	var (
+		ok               bool
+		refreshToken     string
+		refreshExpiresAt time.Time
+	)
+	err = s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		var err error
+		if ok, err = s.mfa.VerifyCode(ctx, user.ID, code); err != nil || !ok {
+			return err
+		}
+		// Marking the challenge used in the transaction of the refresh token
+		// lets only one of concurrent redemptions commit.
+		if err := s.revocations.RedeemToken(ctx, claims.ID, user.ID, expiresAt); err != nil {
+			if dialect.Classify(err) == dialect.ErrorUniqueViolation {
+				return utils.Clone(utils.ErrUnauthorized, map[string]string{"mfaToken": "already used"}, err)
+			}
+			return err
+		}
+		refreshToken, refreshExpiresAt, err = s.issueRefreshToken(ctx, user.ID, utils.NewID())
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
+	if !ok {
+		return nil, recordFailure(ctx, s.attempts, s.repo, user, key, now, utils.Clone(utils.ErrUnauthorized, map[string]string{"code": "invalid"}, nil))
+	}
+	s.attempts.Reset(key)
+
+	return s.loginResponse(ctx, user, false, refreshToken, refreshExpiresAt)
+}
+
+// Refresh rotates a refresh token and issues a new access token.
+// Presenting a token that was already rotated or revoked is treated as
+// theft and revokes the whole token family.
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (*dto.LoginResponse, error) {
+	var (
+		reused        bool
+		user          *entity.User
+		enabled       bool
+		next          string
+		nextExpiresAt time.Time
+	)
+	err := s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		current, err := s.refresh.GetByHash(ctx, auth.HashToken(refreshToken))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return utils.Clone(utils.ErrUnauthorized, map[string]string{"refreshToken": "invalid"}, err)
+			}
+			return err
+		}
+
+		now := time.Now().UTC()
+		if current.RotatedAt.Valid || current.RevokedAt.Valid {
+			reused = true
+			return s.refresh.RevokeFamily(ctx, current.FamilyID, now)
+		}
+		if !now.Before(current.ExpiresAt) {
+			return utils.Clone(utils.ErrUnauthorized, map[string]string{"refreshToken": "expired"}, nil)
+		}
+
+		rotated, err := s.refresh.MarkRotated(ctx, current.ID, now)
+		if err != nil {
+			return err
+		}
+		if !rotated {
+			reused = true
+			return s.refresh.RevokeFamily(ctx, current.FamilyID, now)
+		}
+
+		if user, err = s.repo.GetByID(ctx, current.UserID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return utils.Clone(utils.ErrUnauthorized, map[string]string{"refreshToken": "user not found"}, err)
+			}
+			return err
+		}
+		if err := inactiveUserError(user); err != nil {
+			return err
+		}
+		// Rolling back keeps the refresh token usable once the lock expires.
+		if user.LockedUntil.Valid && now.Before(user.LockedUntil.Time) {
+			return accountLockedError(user.LockedUntil.Time)
+		}
+
+		if enabled, err = s.mfa.Enabled(ctx, user.ID); err != nil {
+			return err
+		}
+
+		next, nextExpiresAt, err = s.issueRefreshToken(ctx, user.ID, current.FamilyID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The family revocation is committed before reporting the reuse.
+	if reused {
+		return nil, utils.Clone(utils.ErrUnauthorized, map[string]string{"refreshToken": "reused"}, nil)
+	}
+
+	return s.loginResponse(ctx, user, s.mfa.Required(user.Role) && !enabled, next, nextExpiresAt)
+}
+
+// Logout revokes the access token that authenticated the request and,
+// when provided, the refresh token family it was issued with.
+func (s *AuthService) Logout(ctx context.Context, refreshToken string) error {
+	userID := utils.UserIDFromContext(ctx)
+	info, ok := utils.TokenInfoFromContext(ctx)
+	if !ok || info.ID == "" {
+		return utils.Clone(utils.ErrBadRequest, map[string]string{"token": "missing jti claim"}, nil)
+	}
+
+	if err := s.revocations.RevokeToken(ctx, info.ID, userID, info.ExpiresAt); err != nil {
+		return err
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	current, err := s.refresh.GetByHash(ctx, auth.HashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if current.UserID != userID {
+		return utils.Clone(utils.ErrForbidden, map[string]string{"refreshToken": "belongs to another user"}, nil)
+	}
+	return s.refresh.RevokeFamily(ctx, current.FamilyID, time.Now().UTC())
+}
+
+// RevokeUserTokens revokes every token issued to the user.
+func (s *AuthService) RevokeUserTokens(ctx context.Context, userID string) error {
+	if _, err := s.repo.GetByID(ctx, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return utils.Clone(utils.ErrNotFound, map[string]string{"id": userID}, err)
+		}
+		return err
+	}
+	return s.revocations.RevokeUser(ctx, userID)
+}
+
+// UnlockUser clears a login lockout and the failure counter of the user.
+func (s *AuthService) UnlockUser(ctx context.Context, userID string) error {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return utils.Clone(utils.ErrNotFound, map[string]string{"id": userID}, err)
+		}
+		return err
+	}
+
+	if err := s.repo.SetLockedUntil(ctx, user.ID, sql.NullTime{}); err != nil {
+		return err
+	}
+	s.attempts.Reset(auth.UsernameKey(user.Username))
+	return nil
+}
+
+// JWKS returns the public verification keys of the token key ring.
+func (s *AuthService) JWKS() auth.JSONWebKeySet {
+	return s.jwt.JWKS()
+}
+
+// completeLogin finishes a first-factor login: inactive and locked accounts are refused,
+// users with MFA get a challenge, everyone else a token pair.
+func (s *AuthService) completeLogin(ctx context.Context, user *entity.User) (*dto.LoginResponse, error) {
+	if err := inactiveUserError(user); err != nil {
+		return nil, err
+	}
+	if user.LockedUntil.Valid && time.Now().UTC().Before(user.LockedUntil.Time) {
+		return nil, accountLockedError(user.LockedUntil.Time)
+	}
+
+	enabled, err := s.mfa.Enabled(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		generation, err := s.revocations.Generation(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		token, expiresAt, err := s.jwt.GenerateChallenge(user.ID, generation, s.mfa.ChallengeTTL())
+		if err != nil {
+			return nil, err
+		}
+		return &dto.LoginResponse{MFARequired: true, MFAToken: token, MFAExpiresAt: expiresAt}, nil
+	}
+
+	var (
+		refreshToken     string
+		refreshExpiresAt time.Time
+	)
+	err = s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		var err error
+		refreshToken, refreshExpiresAt, err = s.issueRefreshToken(ctx, user.ID, utils.NewID())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.loginResponse(ctx, user, s.mfa.Required(user.Role), refreshToken, refreshExpiresAt)
+}
+
+// rehashPassword upgrades the stored hash of a verified password to the
+// configured algorithm and parameters.
+func (s *AuthService) rehashPassword(ctx context.Context, user *entity.User, password string) error {
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	return s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		_, err := s.repo.MarkPasswordRehashed(ctx, user.ID, user.PasswordHash, hash)
+		return err
+	})
+}
+
+// redirectProvider returns the named provider if it uses the redirect flow.
+func (s *AuthService) redirectProvider(name string) (RedirectAuthenticator, error) {
+	authn, ok := s.providers.Get(name)
+	if !ok {
+		return nil, utils.Clone(utils.ErrNotFound, map[string]string{"provider": name}, nil)
+	}
+	redirect, ok := authn.(RedirectAuthenticator)
+	if !ok {
+		return nil, utils.Clone(utils.ErrBadRequest, map[string]string{"provider": "does not support redirect login"}, nil)
+	}
+	return redirect, nil
+}
+
+// recordFailure counts a failed password or code of the user under key and
+// locks the account once the limit is reached; until then it returns failed.
+func recordFailure(ctx context.Context, attempts *auth.LoginAttemptTracker, users repository.UserRepository, user *entity.User, key string, now time.Time, failed error) error {
+	if failures := attempts.RecordFailure(key); failures >= attempts.MaxFailedAttempts() {
+		lockedUntil := now.Add(attempts.LockoutDuration())
+		if err := users.SetLockedUntil(ctx, user.ID, sql.NullTime{Time: lockedUntil, Valid: true}); err != nil {
+			return err
+		}
+		attempts.Reset(key)
+		return accountLockedError(lockedUntil)
+	}
+	return failed
+}
+
+func (s *AuthService) issueRefreshToken(ctx context.Context, userID, familyID string) (string, time.Time, error) {
+	token, err := auth.NewOpaqueToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now().UTC()
+	record := &entity.RefreshToken{
+		ID:        utils.NewID(),
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: now.Add(s.refreshTTL),
+		CreatedAt: now,
+	}
+	if err := s.refresh.Create(ctx, record); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, record.ExpiresAt, nil
+}
+
+// loginResponse issues the access token. mfaPending restricts it to
+// self-service endpoints until the user enrols in MFA.
+func (s *AuthService) loginResponse(ctx context.Context, user *entity.User, mfaPending bool, refreshToken string, refreshExpiresAt time.Time) (*dto.LoginResponse, error) {
+	generation, err := s.revocations.Generation(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	token, expiresAt, err := s.jwt.Generate(auth.Identity{UserID: user.ID, Role: user.Role, MFAPending: mfaPending, Generation: generation})
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.LoginResponse{
+		AccessToken:      token,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, nil
+}
+
+func accountLockedError(until time.Time) *utils.AppError {
+	return utils.Clone(utils.ErrLocked, map[string]string{"lockedUntil": until.UTC().Format(time.RFC3339)}, nil)
+}
+
+// inactiveUserError refuses disabled and deleted users; deleted accounts are
+// reported like unknown ones.
+func inactiveUserError(user *entity.User) error {
+	switch user.Status {
+	case entity.UserStatusActive:
+		return nil
+	case entity.UserStatusDisabled:
+		return utils.Clone(utils.ErrDisabled, nil, nil)
+	default:
+		return utils.Clone(utils.ErrUnauthorized, nil, nil)
+	}
+}
 
-	return &dto.LoginResponse{AccessToken: token, ExpiresAt: expiresAt}, nil
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
 }
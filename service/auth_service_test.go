@@ -0,0 +1,313 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/internal/config"
+	"liangxiong/demo/model/entity"
+	"liangxiong/demo/repository"
+	"liangxiong/demo/utils"
+)
+
+// argon2idHash matches any argon2id PHC string.
+type argon2idHash struct{}
+
+func (argon2idHash) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	return ok && strings.HasPrefix(s, "$argon2id$")
+}
+
+// newTestAuthService returns an AuthService over sqlmock with the local
+// provider and argon2id hashing. Accounts lock after three failed logins,
+// without backoff between attempts.
+func newTestAuthService(t *testing.T) (*AuthService, sqlmock.Sqlmock, *auth.PasswordHasher) {
+	t.Helper()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	jwtManager, err := auth.NewJWTManager(config.AuthConfig{JWTSecret: "secret", Issuer: "test", Audience: "test", AccessTokenTTL: time.Minute, Algorithm: "HS256"})
+	if err != nil {
+		t.Fatalf("jwt manager: %v", err)
+	}
+	hasher, err := auth.NewPasswordHasher(config.PasswordHashConfig{Algorithm: auth.HashArgon2id, Argon2: config.Argon2Config{Memory: 64, Time: 1}})
+	if err != nil {
+		t.Fatalf("hasher: %v", err)
+	}
+
+	users := repository.NewUserRepository(db)
+	attempts := auth.NewLoginAttemptTracker(config.LockoutConfig{MaxFailedAttempts: 3, LockoutDuration: time.Hour, BaseBackoff: time.Nanosecond, MaxBackoff: time.Nanosecond})
+	providers, err := NewIdentityProviders(nil, users, nil)
+	if err != nil {
+		t.Fatalf("providers: %v", err)
+	}
+	mfa := NewMFAService(db, users, repository.NewMFARepository(db), nil, attempts, config.MFAConfig{})
+	svc := NewAuthService(db, users, repository.NewRefreshTokenRepository(db), nil, attempts, mfa, providers, hasher, jwtManager, time.Hour, zap.NewNop())
+	return svc, mock, hasher
+}
+
+func TestLoginRehashesOutdatedPassword(t *testing.T) {
+	svc, mock, hasher := newTestAuthService(t)
+	legacy, err := bcrypt.GenerateFromPassword([]byte("Secret123!"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+
+	userRow := func() *sqlmock.Rows {
+		now := time.Now()
+		return sqlmock.NewRows(userColumns).AddRow("user-1", "alice", "alice@example.org", string(legacy), "Alice", "L", auth.RoleViewer, now, now, nil, "active", nil, nil, []byte{1})
+	}
+	// Lockout check, then the local provider's password check.
+	mock.ExpectQuery(`FROM users WHERE username = @p1`).WithArgs("alice").WillReturnRows(userRow())
+	mock.ExpectQuery(`FROM users WHERE username = @p1`).WithArgs("alice").WillReturnRows(userRow())
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE users SET password_hash = @p1 WHERE id = @p2 AND password_hash = @p3`)).
+		WithArgs(argon2idHash{}, "user-1", string(legacy)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`FROM user_mfa WHERE user_id = @p1`).WithArgs("user-1").WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO refresh_tokens`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	resp, err := svc.Login(context.Background(), "", "alice", "Secret123!", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if resp.AccessToken == "" || resp.RefreshToken == "" {
+		t.Fatalf("expected token pair, got %+v", resp)
+	}
+
+	// A failed rehash does not fail the login.
+	mock.ExpectQuery(`FROM users WHERE username = @p1`).WithArgs("alice").WillReturnRows(userRow())
+	mock.ExpectQuery(`FROM users WHERE username = @p1`).WithArgs("alice").WillReturnRows(userRow())
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users SET password_hash`).WillReturnError(errors.New("boom"))
+	mock.ExpectRollback()
+	mock.ExpectQuery(`FROM user_mfa WHERE user_id = @p1`).WithArgs("user-1").WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO refresh_tokens`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if resp, err := svc.Login(context.Background(), "", "alice", "Secret123!", "127.0.0.1"); err != nil || resp.AccessToken == "" {
+		t.Fatalf("expected the login to succeed, got %+v %v", resp, err)
+	}
+
+	// Disabled users are refused once their password checks out.
+	current, err := hasher.Hash("Secret123!")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	disabledRow := func() *sqlmock.Rows {
+		now := time.Now()
+		return sqlmock.NewRows(userColumns).AddRow("user-1", "alice", "alice@example.org", current, "Alice", "L", auth.RoleViewer, now, now, nil, "disabled", nil, nil, []byte{1})
+	}
+	mock.ExpectQuery(`FROM users WHERE username = @p1`).WithArgs("alice").WillReturnRows(disabledRow())
+	mock.ExpectQuery(`FROM users WHERE username = @p1`).WithArgs("alice").WillReturnRows(disabledRow())
+
+	var appErr *utils.AppError
+	if _, err := svc.Login(context.Background(), "", "alice", "Secret123!", "127.0.0.1"); !errors.As(err, &appErr) || appErr.Code != utils.ErrDisabled.Code {
+		t.Fatalf("expected disabled account error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+}
+
+func TestRefreshDetectsReuse(t *testing.T) {
+	svc, mock, _ := newTestAuthService(t)
+	token := "refresh-token"
+	refreshColumns := []string{"id", "user_id", "family_id", "token_hash", "expires_at", "created_at", "rotated_at", "revoked_at"}
+	refreshRow := func(rotatedAt any) *sqlmock.Rows {
+		now := time.Now()
+		return sqlmock.NewRows(refreshColumns).AddRow("rt-1", "user-1", "family-1", auth.HashToken(token), now.Add(time.Hour), now.Add(-time.Hour), rotatedAt, nil)
+	}
+	reused := func(err error) bool {
+		var appErr *utils.AppError
+		if !errors.As(err, &appErr) || appErr.Code != utils.ErrUnauthorized.Code {
+			return false
+		}
+		details, _ := appErr.Details.(map[string]string)
+		return details["refreshToken"] == "reused"
+	}
+
+	// An already rotated token revokes its whole family, and the revocation
+	// is committed although the refresh fails.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM refresh_tokens WHERE token_hash = @p1`).WithArgs(auth.HashToken(token)).WillReturnRows(refreshRow(time.Now().Add(-time.Minute)))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE refresh_tokens SET revoked_at = @p1 WHERE family_id = @p2 AND revoked_at IS NULL`)).
+		WithArgs(sqlmock.AnyArg(), "family-1").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	if _, err := svc.Refresh(context.Background(), token); !reused(err) {
+		t.Fatalf("expected reuse to be reported, got %v", err)
+	}
+
+	// So does a token rotated concurrently between the read and the update.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM refresh_tokens WHERE token_hash = @p1`).WithArgs(auth.HashToken(token)).WillReturnRows(refreshRow(nil))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE refresh_tokens SET rotated_at = @p1 WHERE id = @p2`)).
+		WithArgs(sqlmock.AnyArg(), "rt-1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE refresh_tokens SET revoked_at = @p1 WHERE family_id = @p2`)).
+		WithArgs(sqlmock.AnyArg(), "family-1").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	if _, err := svc.Refresh(context.Background(), token); !reused(err) {
+		t.Fatalf("expected reuse to be reported, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLoginLocksAccount(t *testing.T) {
+	svc, mock, hasher := newTestAuthService(t)
+	hash, err := hasher.Hash("Secret123!")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	userRow := func(lockedUntil any) *sqlmock.Rows {
+		now := time.Now()
+		return sqlmock.NewRows(userColumns).AddRow("user-1", "alice", "alice@example.org", hash, "Alice", "L", auth.RoleViewer, now, now, lockedUntil, "active", nil, nil, []byte{1})
+	}
+	var appErr *utils.AppError
+
+	// Lockout check, then the local provider's password check.
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(`FROM users WHERE username = @p1`).WithArgs("alice").WillReturnRows(userRow(nil))
+		mock.ExpectQuery(`FROM users WHERE username = @p1`).WithArgs("alice").WillReturnRows(userRow(nil))
+		if _, err := svc.Login(context.Background(), "", "alice", "wrong-password", "127.0.0.1"); !errors.As(err, &appErr) || appErr.Code != utils.ErrUnauthorized.Code {
+			t.Fatalf("failure %d: expected invalid credentials, got %v", i+1, err)
+		}
+	}
+
+	// The third failure stores the lockout.
+	mock.ExpectQuery(`FROM users WHERE username = @p1`).WithArgs("alice").WillReturnRows(userRow(nil))
+	mock.ExpectQuery(`FROM users WHERE username = @p1`).WithArgs("alice").WillReturnRows(userRow(nil))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE users SET locked_until = @p1`)).
+		WithArgs(sqlmock.AnyArg(), "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if _, err := svc.Login(context.Background(), "", "alice", "wrong-password", "127.0.0.1"); !errors.As(err, &appErr) || appErr.Code != utils.ErrLocked.Code {
+		t.Fatalf("expected the account to lock, got %v", err)
+	}
+
+	// A locked account is refused even with the right password, before it
+	// is checked.
+	mock.ExpectQuery(`FROM users WHERE username = @p1`).WithArgs("alice").WillReturnRows(userRow(time.Now().Add(time.Hour)))
+	if _, err := svc.Login(context.Background(), "", "alice", "Secret123!", "127.0.0.1"); !errors.As(err, &appErr) || appErr.Code != utils.ErrLocked.Code || appErr.HTTPStatus != http.StatusLocked {
+		t.Fatalf("expected HTTP 423, got %v", err)
+	}
+
+	// So is a refresh, whose rotation is rolled back so that the token
+	// still works once the lock expires.
+	token := "refresh-token"
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM refresh_tokens WHERE token_hash = @p1`).WithArgs(auth.HashToken(token)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "family_id", "token_hash", "expires_at", "created_at", "rotated_at", "revoked_at"}).
+			AddRow("rt-1", "user-1", "family-1", auth.HashToken(token), time.Now().Add(time.Hour), time.Now(), nil, nil))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE refresh_tokens SET rotated_at = @p1 WHERE id = @p2`)).
+		WithArgs(sqlmock.AnyArg(), "rt-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow(time.Now().Add(time.Hour)))
+	mock.ExpectRollback()
+	if _, err := svc.Refresh(context.Background(), token); !errors.As(err, &appErr) || appErr.Code != utils.ErrLocked.Code {
+		t.Fatalf("expected the refresh to be refused while locked, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestVerifyMFARedeemsChallengeOnce(t *testing.T) {
+	db := openSQLite(t)
+	ctx := context.Background()
+	jwtManager, err := auth.NewJWTManager(config.AuthConfig{JWTSecret: "secret", Issuer: "test", Audience: "test", AccessTokenTTL: time.Minute, Algorithm: "HS256"})
+	if err != nil {
+		t.Fatalf("jwt manager: %v", err)
+	}
+	users := repository.NewUserRepository(db)
+	mfaRepo := repository.NewMFARepository(db)
+	// Two instances, each with its own revocation cache.
+	instance := func() (*AuthService, *RevocationService) {
+		attempts := auth.NewLoginAttemptTracker(config.LockoutConfig{})
+		revocations := NewRevocationService(db, repository.NewRevocationRepository(db), repository.NewRefreshTokenRepository(db), time.Minute)
+		mfa := NewMFAService(db, users, mfaRepo, nil, attempts, config.MFAConfig{})
+		return NewAuthService(db, users, repository.NewRefreshTokenRepository(db), revocations, attempts, mfa, nil, nil, jwtManager, time.Hour, zap.NewNop()), revocations
+	}
+	first, _ := instance()
+	second, secondRevocations := instance()
+
+	now := time.Now().UTC()
+	if err := users.Create(ctx, &entity.User{ID: "user-1", Username: "alice", PasswordHash: "hash", Role: auth.RoleViewer, CreatedAt: now, UpdatedAt: now, Status: entity.UserStatusActive}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := mfaRepo.Upsert(ctx, &entity.UserMFA{UserID: "user-1", Secret: "sealed", CreatedAt: now, UpdatedAt: now}); err != nil {
+		t.Fatalf("enrol: %v", err)
+	}
+	if err := mfaRepo.Confirm(ctx, "user-1", now); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	codes := []string{"aaaa-bbbb", "cccc-dddd"}
+	records := make([]*entity.MFARecoveryCode, 0, len(codes))
+	for _, code := range codes {
+		records = append(records, &entity.MFARecoveryCode{ID: utils.NewID(), UserID: "user-1", CodeHash: auth.HashRecoveryCode(code), CreatedAt: now})
+	}
+	if err := mfaRepo.ReplaceRecoveryCodes(ctx, "user-1", records); err != nil {
+		t.Fatalf("recovery codes: %v", err)
+	}
+	challenge, _, err := jwtManager.GenerateChallenge("user-1", 0, time.Minute)
+	if err != nil {
+		t.Fatalf("challenge: %v", err)
+	}
+	claims, err := jwtManager.ValidateChallenge(challenge)
+	if err != nil {
+		t.Fatalf("validate challenge: %v", err)
+	}
+
+	// The second instance checks the challenge before the first redeems it,
+	// as in a concurrent redemption, and keeps it cached as unused.
+	if revoked, err := secondRevocations.IsRevoked(ctx, claims.ID, claims.Subject, claims.Generation, claims.IssuedAt.Time, claims.ExpiresAt.Time); err != nil || revoked {
+		t.Fatalf("expected an unused challenge, got %v %v", revoked, err)
+	}
+	if _, err := first.VerifyMFA(ctx, challenge, codes[0]); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	// The insert of the challenge's jti refuses the second redemption and
+	// rolls back its recovery code.
+	var appErr *utils.AppError
+	if _, err := second.VerifyMFA(ctx, challenge, codes[1]); !errors.As(err, &appErr) || appErr.Code != utils.ErrUnauthorized.Code {
+		t.Fatalf("expected the challenge to be refused, got %v", err)
+	}
+	if details, _ := appErr.Details.(map[string]string); details["mfaToken"] != "already used" {
+		t.Fatalf("unexpected details %v", appErr.Details)
+	}
+
+	var refreshTokens, usedCodes int
+	if err := db.QueryRow(`SELECT COUNT(1) FROM refresh_tokens`).Scan(&refreshTokens); err != nil {
+		t.Fatalf("count refresh tokens: %v", err)
+	}
+	if err := db.QueryRow(`SELECT COUNT(1) FROM mfa_recovery_codes WHERE used_at IS NOT NULL`).Scan(&usedCodes); err != nil {
+		t.Fatalf("count used codes: %v", err)
+	}
+	if refreshTokens != 1 || usedCodes != 1 {
+		t.Fatalf("expected one redemption, got %d refresh tokens and %d used codes", refreshTokens, usedCodes)
+	}
+}
@@ -0,0 +1,300 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/dto"
+	"liangxiong/demo/internal/config"
+	"liangxiong/demo/model/entity"
+	"liangxiong/demo/repository"
+	"liangxiong/demo/utils"
+)
+
+// Identity provider types accepted in auth.providers.
+const (
+	ProviderTypeLocal = "local"
+	ProviderTypeLDAP  = "ldap"
+	ProviderTypeOIDC  = "oidc"
+)
+
+// externalPasswordHash is stored for provisioned users. It is not a valid
+// bcrypt hash, so such users can never log in with the local provider.
+const externalPasswordHash = "!external"
+
+// ErrInvalidCredentials is returned by authenticators when the identity
+// source rejects the credentials. Login counts it as a failed attempt.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Credentials carries what the caller presented to an authenticator:
+// username/password for password providers, code/nonce for redirect providers.
+type Credentials struct {
+	Username string
+	Password string
+	Code     string
+	Nonce    string
+}
+
+// Identity is the subject asserted by an authenticator. Providers backed by
+// the users table set User; external ones fill the remaining fields, which
+// are used to provision a user on first login. Role is set only when the
+// provider mapped one, e.g. from a directory group; DefaultRole is the role
+// of users provisioned without one.
+type Identity struct {
+	User        *entity.User
+	Provider    string
+	Subject     string
+	Username    string
+	Email       string
+	FirstName   string
+	LastName    string
+	Role        string
+	DefaultRole string
+}
+
+// Authenticator verifies credentials against one identity source.
+type Authenticator interface {
+	Name() string
+	Type() string
+	Authenticate(ctx context.Context, creds Credentials) (*Identity, error)
+}
+
+// RedirectAuthenticator is implemented by providers that authenticate the
+// user through a browser redirect (authorization code flow).
+type RedirectAuthenticator interface {
+	Authenticator
+	AuthorizationURL(ctx context.Context, state, nonce string) (string, error)
+}
+
+// Provisioner resolves an external identity to a local user, creating the
+// user on first login.
+type Provisioner interface {
+	Provision(ctx context.Context, identity *Identity) (*entity.User, error)
+}
+
+// LocalAuthenticator checks passwords against the users table.
+type LocalAuthenticator struct {
+	name string
+	repo repository.UserRepository
+}
+
+// NewLocalAuthenticator builds the username/password authenticator.
+func NewLocalAuthenticator(name string, repo repository.UserRepository) *LocalAuthenticator {
+	return &LocalAuthenticator{name: name, repo: repo}
+}
+
+// Name returns the configured provider name.
+func (a *LocalAuthenticator) Name() string { return a.name }
+
+// Type returns ProviderTypeLocal.
+func (a *LocalAuthenticator) Type() string { return ProviderTypeLocal }
+
+// Authenticate verifies the bcrypt password of the user.
+func (a *LocalAuthenticator) Authenticate(ctx context.Context, creds Credentials) (*Identity, error) {
+	user, err := a.repo.GetByUsername(ctx, creds.Username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if !auth.VerifyPassword(user.PasswordHash, creds.Password) {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{User: user, Provider: a.name, Subject: user.ID, Username: user.Username}, nil
+}
+
+// IdentityProviders holds the configured authenticators.
+type IdentityProviders struct {
+	authenticators map[string]Authenticator
+	order          []string
+	defaultName    string
+	provisioner    Provisioner
+}
+
+// NewIdentityProviders builds authenticators from auth.providers. Without
+// configuration a single local provider named "local" is registered.
+func NewIdentityProviders(cfgs []config.ProviderConfig, repo repository.UserRepository, provisioner Provisioner) (*IdentityProviders, error) {
+	if len(cfgs) == 0 {
+		cfgs = []config.ProviderConfig{{Name: ProviderTypeLocal, Type: ProviderTypeLocal}}
+	}
+
+	p := &IdentityProviders{authenticators: make(map[string]Authenticator), provisioner: provisioner}
+	for _, cfg := range cfgs {
+		if _, exists := p.authenticators[cfg.Name]; exists {
+			return nil, fmt.Errorf("duplicate identity provider %q", cfg.Name)
+		}
+		if cfg.DefaultRole != "" && !auth.IsValidRole(cfg.DefaultRole) {
+			return nil, fmt.Errorf("identity provider %q: invalid default role %q", cfg.Name, cfg.DefaultRole)
+		}
+
+		var authenticator Authenticator
+		switch strings.ToLower(cfg.Type) {
+		case ProviderTypeLocal:
+			authenticator = NewLocalAuthenticator(cfg.Name, repo)
+		case ProviderTypeLDAP:
+			authenticator = NewLDAPAuthenticator(cfg.Name, cfg.DefaultRole, cfg.LDAP)
+		case ProviderTypeOIDC:
+			authenticator = NewOIDCAuthenticator(cfg.Name, cfg.DefaultRole, cfg.OIDC, nil)
+		default:
+			return nil, fmt.Errorf("identity provider %q: unsupported type %q", cfg.Name, cfg.Type)
+		}
+
+		p.authenticators[cfg.Name] = authenticator
+		p.order = append(p.order, cfg.Name)
+		if _, redirect := authenticator.(RedirectAuthenticator); !redirect && p.defaultName == "" {
+			p.defaultName = cfg.Name
+		}
+	}
+	return p, nil
+}
+
+// Get returns the authenticator registered under name.
+func (p *IdentityProviders) Get(name string) (Authenticator, bool) {
+	a, ok := p.authenticators[name]
+	return a, ok
+}
+
+// Default names the provider used when a password login names none.
+func (p *IdentityProviders) Default() string {
+	return p.defaultName
+}
+
+// List describes the configured providers in configuration order.
+func (p *IdentityProviders) List() []dto.ProviderResponse {
+	out := make([]dto.ProviderResponse, 0, len(p.order))
+	for _, name := range p.order {
+		out = append(out, dto.ProviderResponse{Name: name, Type: p.authenticators[name].Type()})
+	}
+	return out
+}
+
+// Resolve returns the local user of an authenticated identity.
+func (p *IdentityProviders) Resolve(ctx context.Context, identity *Identity) (*entity.User, error) {
+	if identity.User != nil {
+		return identity.User, nil
+	}
+	return p.provisioner.Provision(ctx, identity)
+}
+
+// UserProvisioner links external identities to users and creates users
+// just in time. It never links to an existing user by username, so an
+// external account cannot take over a local one.
+type UserProvisioner struct {
+	tx          *repository.TxManager
+	users       repository.UserRepository
+	identities  repository.UserIdentityRepository
+	revocations *RevocationService
+}
+
+// NewUserProvisioner constructs the provisioner.
+func NewUserProvisioner(db *sql.DB, users repository.UserRepository, identities repository.UserIdentityRepository, revocations *RevocationService) *UserProvisioner {
+	return &UserProvisioner{tx: repository.NewTxManager(db), users: users, identities: identities, revocations: revocations}
+}
+
+// Provision returns the linked user or creates one from the identity. The
+// role the provider mapped and the profile it asserts are applied on every
+// login, so that directory changes such as moving to a group mapped to
+// another role reach the user. Without a mapped role, linked users keep
+// theirs and new users get the provider's default role.
+func (p *UserProvisioner) Provision(ctx context.Context, identity *Identity) (*entity.User, error) {
+	role := auth.NormalizeRole(identity.Role)
+	if !auth.IsValidRole(role) {
+		role = ""
+	}
+
+	var user *entity.User
+	err := p.tx.RetryWithinTx(ctx, serializable, func(ctx context.Context) error {
+		link, err := p.identities.Get(ctx, identity.Provider, identity.Subject)
+		if err == nil {
+			if user, err = p.users.GetByID(ctx, link.UserID); err != nil {
+				return err
+			}
+			return p.sync(ctx, user, identity, role)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		if _, err := p.users.GetByUsername(ctx, identity.Username); err == nil {
+			return utils.Clone(utils.ErrForbidden, map[string]string{"username": "already used by another account"}, nil)
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		now := time.Now().UTC()
+		user = &entity.User{
+			Status:       entity.UserStatusActive,
+			ID:           utils.NewID(),
+			Username:     identity.Username,
+			Email:        identity.Email,
+			PasswordHash: externalPasswordHash,
+			FirstName:    identity.FirstName,
+			LastName:     identity.LastName,
+			Role:         newUserRole(role, identity.DefaultRole),
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}
+		if err := p.users.Create(ctx, user); err != nil {
+			return err
+		}
+		return p.identities.Create(ctx, &entity.UserIdentity{Provider: identity.Provider, Subject: identity.Subject, UserID: user.ID, CreatedAt: now})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// sync writes the role and the profile attributes the provider asserted to
+// the linked user when they differ. An empty role and attributes the
+// provider left empty keep their stored value. A role change revokes the
+// tokens issued so far, which carry the old role.
+func (p *UserProvisioner) sync(ctx context.Context, user *entity.User, identity *Identity, role string) error {
+	if role == "" {
+		role = user.Role
+	}
+	email, firstName, lastName := user.Email, user.FirstName, user.LastName
+	if identity.Email != "" {
+		email = identity.Email
+	}
+	if identity.FirstName != "" {
+		firstName = identity.FirstName
+	}
+	if identity.LastName != "" {
+		lastName = identity.LastName
+	}
+	if role == user.Role && email == user.Email && firstName == user.FirstName && lastName == user.LastName {
+		return nil
+	}
+
+	roleChanged := role != user.Role
+	user.Role, user.Email, user.FirstName, user.LastName = role, email, firstName, lastName
+	user.UpdatedAt = time.Now().UTC()
+	if ok, err := p.users.Update(ctx, user); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("user %s changed while syncing identity %s", user.ID, identity.Subject)
+	}
+	if roleChanged {
+		return p.revocations.RevokeUser(ctx, user.ID)
+	}
+	return nil
+}
+
+// newUserRole returns the role of a user provisioned with the mapped role,
+// falling back to the provider's default role, then to viewer.
+func newUserRole(mapped, defaultRole string) string {
+	if mapped != "" {
+		return mapped
+	}
+	if role := auth.NormalizeRole(defaultRole); auth.IsValidRole(role) {
+		return role
+	}
+	return auth.RoleViewer
+}
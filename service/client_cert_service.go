@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"time"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/repository"
+	"liangxiong/demo/utils"
+)
+
+// CertPrincipal is the caller identified by a client certificate. Services
+// carry scopes like API keys; users carry the role of their account.
+type CertPrincipal struct {
+	Subject string
+	Role    string
+	Scopes  []string
+	Service bool
+}
+
+// ClientCertService authenticates callers by verified TLS client certificate.
+type ClientCertService struct {
+	users  repository.UserRepository
+	mapper *auth.ClientCertMapper
+}
+
+// NewClientCertService constructs the service.
+func NewClientCertService(users repository.UserRepository, mapper *auth.ClientCertMapper) *ClientCertService {
+	return &ClientCertService{users: users, mapper: mapper}
+}
+
+// Authenticate maps the leaf certificate to its principal. Certificates
+// without a mapping, or mapped to a missing, inactive or locked user, are rejected.
+func (s *ClientCertService) Authenticate(ctx context.Context, cert *x509.Certificate) (*CertPrincipal, error) {
+	rule, ok := s.mapper.Match(cert)
+	if !ok {
+		return nil, utils.Clone(utils.ErrUnauthorized, map[string]string{"certificate": "no identity mapped to " + cert.Subject.String()}, nil)
+	}
+	if rule.Service != "" {
+		return &CertPrincipal{Subject: auth.ClientCertSubject(rule.Service), Scopes: rule.Scopes, Service: true}, nil
+	}
+
+	user, err := s.users.GetByUsername(ctx, rule.Username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.Clone(utils.ErrUnauthorized, map[string]string{"certificate": "mapped user not found"}, err)
+		}
+		return nil, err
+	}
+	if err := inactiveUserError(user); err != nil {
+		return nil, err
+	}
+	if user.LockedUntil.Valid && time.Now().Before(user.LockedUntil.Time) {
+		return nil, accountLockedError(user.LockedUntil.Time)
+	}
+	return &CertPrincipal{Subject: user.ID, Role: user.Role}, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strings"
 
 	"liangxiong/demo/dto"
 	"liangxiong/demo/model/entity"
@@ -13,33 +14,53 @@ import (
 
 // ExchangeService orchestrates exchange workflows.
 type ExchangeService struct {
-	repo repository.ExchangeRepository
-	db   *sql.DB
+	repo    repository.ExchangeRepository
+	tx      *repository.TxManager
+	cursors *utils.CursorCodec
 }
 
 // NewExchangeService creates a service.
-func NewExchangeService(db *sql.DB, repo repository.ExchangeRepository) *ExchangeService {
-	return &ExchangeService{repo: repo, db: db}
+func NewExchangeService(db *sql.DB, repo repository.ExchangeRepository, cursors *utils.CursorCodec) *ExchangeService {
+	return &ExchangeService{repo: repo, tx: repository.NewTxManager(db), cursors: cursors}
 }
 
-// ListExchanges returns paginated exchanges.
-func (s *ExchangeService) ListExchanges(ctx context.Context, page, size int) (*dto.ExchangeListResponse, error) {
-	exchanges, total, err := s.repo.List(ctx, page, size)
+// ListExchanges returns a filtered page of exchanges.
+func (s *ExchangeService) ListExchanges(ctx context.Context, query dto.ExchangeListQuery) (*dto.ExchangeListResponse, error) {
+	filter := repository.ExchangeFilter{
+		ClearCode:  strings.TrimSpace(query.ClearCode),
+		GlobexCode: strings.TrimSpace(query.GlobexCode),
+		SegType:    strings.TrimSpace(query.SegType),
+		Search:     strings.TrimSpace(query.Search),
+	}
+	scope, err := utils.NewCursorScope("exchanges", filter)
+	if err != nil {
+		return nil, err
+	}
+	page, _, err := pageQuery(s.cursors, scope, query.Cursor, "", query.Page, query.Size, query.SkipTotal)
 	if err != nil {
 		return nil, err
 	}
 
-	items := make([]dto.ExchangeResponse, 0, len(exchanges))
-	for _, exch := range exchanges {
+	result, err := s.repo.List(ctx, filter, page)
+	if err != nil {
+		return nil, listError(err)
+	}
+
+	items := make([]dto.ExchangeResponse, 0, len(result.Items))
+	for _, exch := range result.Items {
 		items = append(items, mapExchangeToDTO(&exch))
 	}
+	next, err := nextCursor(s.cursors, scope, "", result.Next)
+	if err != nil {
+		return nil, err
+	}
 
-	return &dto.ExchangeListResponse{Total: total, Items: items}, nil
+	return &dto.ExchangeListResponse{Total: pageTotal(result.Total), Items: items, NextCursor: next}, nil
 }
 
 // GetExchange fetches a single exchange.
 func (s *ExchangeService) GetExchange(ctx context.Context, code string) (*dto.ExchangeResponse, error) {
-	exchange, err := s.repo.GetByCode(ctx, nil, code)
+	exchange, err := s.repo.GetByCode(ctx, code)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, utils.Clone(utils.ErrNotFound, map[string]string{"mqmExchangeCode": code}, err)
@@ -50,14 +71,32 @@ func (s *ExchangeService) GetExchange(ctx context.Context, code string) (*dto.Ex
 	return &resp, nil
 }
 
-// CreateExchange inserts a new row.
-func (s *ExchangeService) CreateExchange(ctx context.Context, req dto.ExchangeCreateRequest) (*dto.ExchangeResponse, error) {
-	if _, err := s.repo.GetByCode(ctx, nil, req.MQMExchangeCode); err == nil {
-		return nil, utils.Clone(utils.ErrBadRequest, map[string]string{"mqmExchangeCode": "already exists"}, nil)
-	} else if !errors.Is(err, sql.ErrNoRows) {
+// TranslateCode maps a code of one scheme (mqm, clear or globex) to the
+// codes of every exchange it identifies.
+func (s *ExchangeService) TranslateCode(ctx context.Context, query dto.ExchangeTranslateQuery) (*dto.ExchangeTranslationResponse, error) {
+	code := strings.TrimSpace(query.Code)
+	exchanges, err := s.repo.FindByCode(ctx, query.From, code)
+	if err != nil {
 		return nil, err
 	}
+	if len(exchanges) == 0 {
+		return nil, utils.Clone(utils.ErrNotFound, map[string]string{query.From + "Code": code}, nil)
+	}
+
+	matches := make([]dto.ExchangeCodes, 0, len(exchanges))
+	for _, e := range exchanges {
+		matches = append(matches, dto.ExchangeCodes{
+			MQMExchangeCode:    e.MQMExchangeCode,
+			ClearExchangeCode:  e.ClearExchangeCode,
+			GlobexExchangeCode: e.GlobexExchangeCode,
+			SegType:            e.SegType,
+		})
+	}
+	return &dto.ExchangeTranslationResponse{From: query.From, Code: code, Matches: matches}, nil
+}
 
+// CreateExchange inserts a new row.
+func (s *ExchangeService) CreateExchange(ctx context.Context, req dto.ExchangeCreateRequest) (*dto.ExchangeResponse, error) {
 	exchange := &entity.Exchange{
 		MQMExchangeCode:    req.MQMExchangeCode,
 		ClearExchangeCode:  req.ClearExchangeCode,
@@ -66,50 +105,102 @@ func (s *ExchangeService) CreateExchange(ctx context.Context, req dto.ExchangeCr
 		SegType:            req.SegType,
 	}
 
-	tx, err := s.db.BeginTx(ctx, nil)
+	err := s.tx.RetryWithinTx(ctx, serializable, func(ctx context.Context) error {
+		exists := map[string]string{"mqmExchangeCode": "already exists"}
+		if _, err := s.repo.GetByCode(ctx, req.MQMExchangeCode); err == nil {
+			return utils.Clone(utils.ErrConflict, exists, nil)
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		return conflictError(s.repo.Create(ctx, exchange), exists)
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback()
-
-	if err := s.repo.Create(ctx, tx, exchange); err != nil {
-		return nil, err
-	}
-
-	if err := tx.Commit(); err != nil {
-		return nil, err
-	}
 
 	resp := mapExchangeToDTO(exchange)
 	return &resp, nil
 }
 
-// UpdateExchange modifies a row by code.
-func (s *ExchangeService) UpdateExchange(ctx context.Context, code string, req dto.ExchangeUpdateRequest) (*dto.ExchangeResponse, error) {
-	exchange, err := s.repo.GetByCode(ctx, nil, code)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, utils.Clone(utils.ErrNotFound, map[string]string{"mqmExchangeCode": code}, err)
+// UpdateExchange modifies a row by code if it still matches ifMatch.
+func (s *ExchangeService) UpdateExchange(ctx context.Context, code, ifMatch string, req dto.ExchangeUpdateRequest) (*dto.ExchangeResponse, error) {
+	var exchange *entity.Exchange
+	err := s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		var err error
+		if exchange, err = s.current(ctx, code, ifMatch); err != nil {
+			return err
 		}
-		return nil, err
-	}
 
-	exchange.ClearExchangeCode = req.ClearExchangeCode
-	exchange.GlobexExchangeCode = req.GlobexExchangeCode
-	exchange.Description = toNullString(req.Description)
-	exchange.SegType = req.SegType
+		exchange.ClearExchangeCode = req.ClearExchangeCode
+		exchange.GlobexExchangeCode = req.GlobexExchangeCode
+		exchange.Description = toNullString(req.Description)
+		exchange.SegType = req.SegType
 
-	tx, err := s.db.BeginTx(ctx, nil)
+		if ok, err := s.repo.Update(ctx, exchange); err != nil {
+			return err
+		} else if !ok {
+			return staleVersionError()
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback()
 
-	if err := s.repo.Update(ctx, tx, exchange); err != nil {
-		return nil, err
-	}
+	resp := mapExchangeToDTO(exchange)
+	return &resp, nil
+}
+
+// PatchExchange applies a JSON Merge Patch or JSON Patch, as told by
+// contentType, to the fields of dto.ExchangeUpdateRequest and writes only
+// the fields that changed. With ifMatch the exchange must still match it;
+// without, concurrent changes to other fields are kept.
+func (s *ExchangeService) PatchExchange(ctx context.Context, code, ifMatch, contentType string, patch []byte) (*dto.ExchangeResponse, error) {
+	var exchange *entity.Exchange
+	err := s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		var err error
+		if exchange, err = s.current(ctx, code, ifMatch); err != nil {
+			return err
+		}
+
+		current := dto.ExchangeUpdateRequest{
+			ClearExchangeCode:  exchange.ClearExchangeCode,
+			GlobexExchangeCode: exchange.GlobexExchangeCode,
+			Description:        nullStringToPtr(exchange.Description),
+			SegType:            exchange.SegType,
+		}
+		var req dto.ExchangeUpdateRequest
+		fields, err := applyPatch(contentType, patch, current, &req)
+		if err != nil {
+			return err
+		}
+		if len(fields) == 0 {
+			return nil
+		}
+
+		exchange.ClearExchangeCode = req.ClearExchangeCode
+		exchange.GlobexExchangeCode = req.GlobexExchangeCode
+		exchange.Description = toNullString(req.Description)
+		exchange.SegType = req.SegType
 
-	if err := tx.Commit(); err != nil {
+		var version []byte
+		if ifMatch != "" {
+			version = exchange.RowVersion
+		}
+		if ok, err := s.repo.Patch(ctx, exchange, fields, version); err != nil {
+			return err
+		} else if !ok {
+			if version == nil {
+				return utils.Clone(utils.ErrNotFound, map[string]string{"mqmExchangeCode": code}, nil)
+			}
+			return staleVersionError()
+		}
+		// Re-read so the response and its ETag include concurrent changes to
+		// the fields left alone.
+		exchange, err = s.repo.GetByCode(ctx, code)
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -117,26 +208,36 @@ func (s *ExchangeService) UpdateExchange(ctx context.Context, code string, req d
 	return &resp, nil
 }
 
-// DeleteExchange removes a row by code.
-func (s *ExchangeService) DeleteExchange(ctx context.Context, code string) error {
-	if _, err := s.repo.GetByCode(ctx, nil, code); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return utils.Clone(utils.ErrNotFound, map[string]string{"mqmExchangeCode": code}, err)
+// DeleteExchange removes a row by code if it still matches ifMatch.
+func (s *ExchangeService) DeleteExchange(ctx context.Context, code, ifMatch string) error {
+	return s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		exchange, err := s.current(ctx, code, ifMatch)
+		if err != nil {
+			return err
 		}
-		return err
-	}
 
-	tx, err := s.db.BeginTx(ctx, nil)
+		if ok, err := s.repo.Delete(ctx, code, exchange.RowVersion); err != nil {
+			return err
+		} else if !ok {
+			return staleVersionError()
+		}
+		return nil
+	})
+}
+
+// current loads the exchange to change and checks it against ifMatch.
+func (s *ExchangeService) current(ctx context.Context, code, ifMatch string) (*entity.Exchange, error) {
+	exchange, err := s.repo.GetByCode(ctx, code)
 	if err != nil {
-		return err
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.Clone(utils.ErrNotFound, map[string]string{"mqmExchangeCode": code}, err)
+		}
+		return nil, err
 	}
-	defer tx.Rollback()
-
-	if err := s.repo.Delete(ctx, tx, code); err != nil {
-		return err
+	if err := checkIfMatch(ifMatch, exchange.RowVersion); err != nil {
+		return nil, err
 	}
-
-	return tx.Commit()
+	return exchange, nil
 }
 
 func mapExchangeToDTO(e *entity.Exchange) dto.ExchangeResponse {
@@ -146,6 +247,7 @@ func mapExchangeToDTO(e *entity.Exchange) dto.ExchangeResponse {
 		GlobexExchangeCode: e.GlobexExchangeCode,
 		Description:        nullStringToPtr(e.Description),
 		SegType:            e.SegType,
+		ETag:               utils.ETag(e.RowVersion),
 	}
 }
 
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"liangxiong/demo/dto"
+	"liangxiong/demo/repository"
+	"liangxiong/demo/utils"
+)
+
+func TestTranslateCode(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	svc := NewExchangeService(db, repository.NewExchangeRepository(db), nil)
+	ctx := context.Background()
+	columns := []string{"MQMExchangeCode", "ClearExchangeCode", "GlobexExchangeCode", "Description", "SegType", "RowVersion"}
+
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM TExchange WHERE GlobexExchangeCode = @p1`)).WithArgs("XNYM").
+		WillReturnRows(sqlmock.NewRows(columns))
+	var appErr *utils.AppError
+	_, err = svc.TranslateCode(ctx, dto.ExchangeTranslateQuery{From: "globex", Code: " XNYM "})
+	if !errors.As(err, &appErr) || appErr.Code != utils.ErrNotFound.Code || !reflect.DeepEqual(appErr.Details, map[string]string{"globexCode": "XNYM"}) {
+		t.Fatalf("expected not found for the trimmed code, got %v", err)
+	}
+
+	// A code shared by several exchanges returns all of them.
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM TExchange WHERE ClearExchangeCode = @p1 ORDER BY MQMExchangeCode ASC`)).WithArgs("CME").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("CME", "CME", "XCME", "CME Globex", "F", []byte{1}).
+			AddRow("CMEO", "CME", "XCME", nil, "O", []byte{2}))
+	resp, err := svc.TranslateCode(ctx, dto.ExchangeTranslateQuery{From: "clear", Code: "CME"})
+	if err != nil {
+		t.Fatalf("translate: %v", err)
+	}
+	want := &dto.ExchangeTranslationResponse{From: "clear", Code: "CME", Matches: []dto.ExchangeCodes{
+		{MQMExchangeCode: "CME", ClearExchangeCode: "CME", GlobexExchangeCode: "XCME", SegType: "F"},
+		{MQMExchangeCode: "CMEO", ClearExchangeCode: "CME", GlobexExchangeCode: "XCME", SegType: "O"},
+	}}
+	if !reflect.DeepEqual(resp, want) {
+		t.Fatalf("expected %+v, got %+v", want, resp)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
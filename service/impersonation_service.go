@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/dto"
+	"liangxiong/demo/model/entity"
+	"liangxiong/demo/repository"
+	"liangxiong/demo/utils"
+)
+
+const defaultImpersonationTTL = 15 * time.Minute
+
+// ImpersonationService lets support staff act as another user and keeps the
+// audit trail of who impersonated whom and why.
+type ImpersonationService struct {
+	tx          *repository.TxManager
+	users       repository.UserRepository
+	repo        repository.ImpersonationRepository
+	revocations *RevocationService
+	jwt         *auth.JWTManager
+	ttl         time.Duration
+}
+
+// NewImpersonationService constructs the service.
+func NewImpersonationService(db *sql.DB, users repository.UserRepository, repo repository.ImpersonationRepository, revocations *RevocationService, jwt *auth.JWTManager, ttl time.Duration) *ImpersonationService {
+	if ttl <= 0 {
+		ttl = defaultImpersonationTTL
+	}
+	return &ImpersonationService{tx: repository.NewTxManager(db), users: users, repo: repo, revocations: revocations, jwt: jwt, ttl: ttl}
+}
+
+// Impersonate issues an access token for the user on behalf of the caller.
+// Only people can impersonate, never API keys or an impersonated session,
+// and users who may impersonate themselves cannot be impersonated.
+func (s *ImpersonationService) Impersonate(ctx context.Context, userID string, req dto.ImpersonateRequest) (*dto.ImpersonationTokenResponse, error) {
+	if utils.IsImpersonating(ctx) {
+		return nil, utils.Clone(utils.ErrImpersonated, nil, nil)
+	}
+	if _, isKey := utils.ScopesFromContext(ctx); isKey {
+		return nil, utils.Clone(utils.ErrForbidden, map[string]string{"actor": "api keys cannot impersonate"}, nil)
+	}
+	actorID := utils.UserIDFromContext(ctx)
+	if userID == actorID {
+		return nil, utils.Clone(utils.ErrBadRequest, map[string]string{"id": "cannot impersonate yourself"}, nil)
+	}
+
+	target, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.Clone(utils.ErrNotFound, map[string]string{"id": userID}, err)
+		}
+		return nil, err
+	}
+	if target.Status != entity.UserStatusActive {
+		return nil, utils.Clone(utils.ErrBadRequest, map[string]string{"id": "user is not active"}, nil)
+	}
+	if auth.HasPermission(target.Role, auth.PermUsersImpersonate) {
+		return nil, utils.Clone(utils.ErrForbidden, map[string]string{"id": "privileged users cannot be impersonated"}, nil)
+	}
+
+	record := &entity.Impersonation{
+		ID:        utils.NewID(),
+		ActorID:   actorID,
+		UserID:    target.ID,
+		Reason:    req.Reason,
+		TokenID:   utils.NewID(),
+		CreatedAt: time.Now().UTC(),
+	}
+	generation, err := s.revocations.Generation(ctx, target.ID)
+	if err != nil {
+		return nil, err
+	}
+	actor := auth.Actor{Subject: actorID}
+	if actor.Generation, err = s.revocations.Generation(ctx, actorID); err != nil {
+		return nil, err
+	}
+	token, expiresAt, err := s.jwt.GenerateImpersonation(auth.Identity{UserID: target.ID, Role: target.Role, Generation: generation}, actor, record.TokenID, s.ttl)
+	if err != nil {
+		return nil, err
+	}
+	record.ExpiresAt = expiresAt.UTC()
+
+	err = s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		return s.repo.Create(ctx, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.ImpersonationTokenResponse{AccessToken: token, ExpiresAt: expiresAt, UserID: target.ID, ActorID: actorID}, nil
+}
+
+// ListImpersonations returns the audit trail of a user.
+func (s *ImpersonationService) ListImpersonations(ctx context.Context, userID string, page, size int) (*dto.ImpersonationListResponse, error) {
+	records, total, err := s.repo.ListByUser(ctx, userID, page, size)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]dto.ImpersonationResponse, 0, len(records))
+	for _, r := range records {
+		items = append(items, dto.ImpersonationResponse{
+			ID:        r.ID,
+			ActorID:   r.ActorID,
+			UserID:    r.UserID,
+			Reason:    r.Reason,
+			TokenID:   r.TokenID,
+			CreatedAt: r.CreatedAt,
+			ExpiresAt: r.ExpiresAt,
+		})
+	}
+
+	return &dto.ImpersonationListResponse{Total: total, Items: items}, nil
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/dto"
+	"liangxiong/demo/internal/config"
+	"liangxiong/demo/model/entity"
+	"liangxiong/demo/repository"
+	"liangxiong/demo/utils"
+)
+
+// capturedArg matches any string argument and remembers it.
+type capturedArg struct{ value *string }
+
+func (a capturedArg) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	*a.value = s
+	return ok
+}
+
+func TestImpersonate(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	jwtManager, err := auth.NewJWTManager(config.AuthConfig{JWTSecret: "secret", Issuer: "test", Audience: "test", AccessTokenTTL: time.Minute, Algorithm: "HS256"})
+	if err != nil {
+		t.Fatalf("jwt manager: %v", err)
+	}
+	svc := NewImpersonationService(db, repository.NewUserRepository(db), repository.NewImpersonationRepository(db), nil, jwtManager, time.Minute)
+	ctx := utils.WithRole(utils.WithUserID(context.Background(), "admin-1"), auth.RoleAdmin)
+	req := dto.ImpersonateRequest{Reason: "ticket 42"}
+	userRow := func(role, status string) *sqlmock.Rows {
+		now := time.Now()
+		return sqlmock.NewRows(userColumns).AddRow("user-1", "alice", "alice@example.org", "hash", "Alice", "L", role, now, now, nil, status, nil, nil, []byte{1})
+	}
+	refused := func(err error, code int) bool {
+		var appErr *utils.AppError
+		return errors.As(err, &appErr) && appErr.Code == code
+	}
+
+	// Callers without a user account, or already impersonating, are refused
+	// before the target is read.
+	apiKey := utils.WithScopes(utils.WithUserID(context.Background(), auth.APIKeySubject("key-1")), []string{auth.PermUsersImpersonate})
+	if _, err := svc.Impersonate(apiKey, "user-1", req); !refused(err, utils.ErrForbidden.Code) {
+		t.Fatalf("expected an API key to be refused, got %v", err)
+	}
+	if _, err := svc.Impersonate(utils.WithActorID(ctx, "root-1"), "user-1", req); !refused(err, utils.ErrImpersonated.Code) {
+		t.Fatalf("expected a nested impersonation to be refused, got %v", err)
+	}
+	if _, err := svc.Impersonate(ctx, "admin-1", req); !refused(err, utils.ErrBadRequest.Code) {
+		t.Fatalf("expected self impersonation to be refused, got %v", err)
+	}
+
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow(auth.RoleAdmin, entity.UserStatusActive))
+	if _, err := svc.Impersonate(ctx, "user-1", req); !refused(err, utils.ErrForbidden.Code) {
+		t.Fatalf("expected a privileged target to be refused, got %v", err)
+	}
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow(auth.RoleViewer, entity.UserStatusDisabled))
+	if _, err := svc.Impersonate(ctx, "user-1", req); !refused(err, utils.ErrBadRequest.Code) {
+		t.Fatalf("expected an inactive target to be refused, got %v", err)
+	}
+
+	// No token leaves the service unless its audit row is committed.
+	boom := errors.New("boom")
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow(auth.RoleViewer, entity.UserStatusActive))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO impersonations`).WillReturnError(boom)
+	mock.ExpectRollback()
+	if resp, err := svc.Impersonate(ctx, "user-1", req); !errors.Is(err, boom) || resp != nil {
+		t.Fatalf("expected the audit failure without a token, got %+v %v", resp, err)
+	}
+
+	var tokenID string
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow(auth.RoleViewer, entity.UserStatusActive))
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO impersonations`).
+		WithArgs(sqlmock.AnyArg(), "admin-1", "user-1", "ticket 42", capturedArg{&tokenID}, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	resp, err := svc.Impersonate(ctx, "user-1", req)
+	if err != nil {
+		t.Fatalf("impersonate: %v", err)
+	}
+	claims, err := jwtManager.Validate(resp.AccessToken)
+	if err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if claims.Subject != "user-1" || claims.Role != auth.RoleViewer || claims.Actor == nil || claims.Actor.Subject != "admin-1" || claims.ID != tokenID {
+		t.Fatalf("expected the audited token, got %+v (audited %q)", claims, tokenID)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
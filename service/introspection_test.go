@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/internal/config"
+	"liangxiong/demo/repository"
+)
+
+func TestIntrospect(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	jwtManager, err := auth.NewJWTManager(config.AuthConfig{JWTSecret: "secret", Issuer: "test", Audience: "test", AccessTokenTTL: time.Minute, Algorithm: "HS256"})
+	if err != nil {
+		t.Fatalf("jwt manager: %v", err)
+	}
+	refreshRepo := repository.NewRefreshTokenRepository(db)
+	revocations := NewRevocationService(db, repository.NewRevocationRepository(db), refreshRepo, time.Minute)
+	svc := NewAuthService(db, repository.NewUserRepository(db), refreshRepo, revocations, nil, nil, nil, nil, jwtManager, time.Hour, zap.NewNop())
+
+	access, _, err := jwtManager.Generate(auth.Identity{UserID: "user-1", Role: auth.RoleViewer})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT user_id, revoked_before, generation FROM user_token_revocations WHERE user_id = @p1`)).
+		WithArgs("user-1").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(1) FROM revoked_tokens WHERE jti = @p1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	resp, err := svc.Introspect(context.Background(), access, "")
+	if err != nil {
+		t.Fatalf("introspect access token: %v", err)
+	}
+	if !resp.Active || resp.Sub != "user-1" || resp.Scope != auth.PermExchangesRead || resp.TokenType != "Bearer" {
+		t.Fatalf("unexpected access token response: %+v", resp)
+	}
+
+	// Challenge tokens and unknown strings fall through to the refresh token lookup.
+	challenge, _, err := jwtManager.GenerateChallenge("user-1", 0, time.Minute)
+	if err != nil {
+		t.Fatalf("generate challenge: %v", err)
+	}
+	for _, token := range []string{challenge, "not-a-token"} {
+		mock.ExpectQuery(regexp.QuoteMeta(`FROM refresh_tokens WHERE token_hash = @p1`)).
+			WithArgs(auth.HashToken(token)).
+			WillReturnError(sql.ErrNoRows)
+
+		resp, err := svc.Introspect(context.Background(), token, tokenHintAccess)
+		if err != nil {
+			t.Fatalf("introspect %q: %v", token, err)
+		}
+		if resp.Active || resp.Sub != "" {
+			t.Fatalf("expected inactive response for %q, got %+v", token, resp)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
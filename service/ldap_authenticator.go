@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/internal/config"
+)
+
+const (
+	defaultLDAPTimeout        = 5 * time.Second
+	defaultLDAPUserFilter     = "(uid=%s)"
+	defaultLDAPUsernameAttr   = "uid"
+	defaultLDAPEmailAttr      = "mail"
+	defaultLDAPFirstNameAttr  = "givenName"
+	defaultLDAPLastNameAttr   = "sn"
+	defaultLDAPGroupAttribute = "memberOf"
+)
+
+// LDAPAuthenticator performs search-then-bind authentication: it finds the
+// user entry with the service account, then binds as that entry with the
+// presented password.
+type LDAPAuthenticator struct {
+	name        string
+	defaultRole string
+	cfg         config.LDAPConfig
+	groupRoles  map[string]string
+}
+
+// NewLDAPAuthenticator builds the authenticator, filling unset attributes with defaults.
+func NewLDAPAuthenticator(name, defaultRole string, cfg config.LDAPConfig) *LDAPAuthenticator {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultLDAPTimeout
+	}
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = defaultLDAPUserFilter
+	}
+	if cfg.UsernameAttribute == "" {
+		cfg.UsernameAttribute = defaultLDAPUsernameAttr
+	}
+	if cfg.EmailAttribute == "" {
+		cfg.EmailAttribute = defaultLDAPEmailAttr
+	}
+	if cfg.FirstNameAttribute == "" {
+		cfg.FirstNameAttribute = defaultLDAPFirstNameAttr
+	}
+	if cfg.LastNameAttribute == "" {
+		cfg.LastNameAttribute = defaultLDAPLastNameAttr
+	}
+	if cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = defaultLDAPGroupAttribute
+	}
+
+	// DNs compare case-insensitively; config keys may also arrive lowercased.
+	groupRoles := make(map[string]string, len(cfg.GroupRoles))
+	for dn, role := range cfg.GroupRoles {
+		groupRoles[strings.ToLower(dn)] = role
+	}
+	return &LDAPAuthenticator{name: name, defaultRole: defaultRole, cfg: cfg, groupRoles: groupRoles}
+}
+
+// Name returns the configured provider name.
+func (a *LDAPAuthenticator) Name() string { return a.name }
+
+// Type returns ProviderTypeLDAP.
+func (a *LDAPAuthenticator) Type() string { return ProviderTypeLDAP }
+
+// Authenticate binds as the directory entry of the username.
+func (a *LDAPAuthenticator) Authenticate(ctx context.Context, creds Credentials) (*Identity, error) {
+	// An empty password would perform an unauthenticated bind, which most
+	// servers report as success.
+	if creds.Username == "" || creds.Password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := a.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if a.cfg.BindDN != "" {
+		if err := conn.Bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap service bind: %w", err)
+		}
+	}
+
+	entry, err := a.findUser(conn, creds.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(entry.DN, creds.Password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap user bind: %w", err)
+	}
+
+	username := entry.GetAttributeValue(a.cfg.UsernameAttribute)
+	if username == "" {
+		username = creds.Username
+	}
+	return &Identity{
+		Provider:    a.name,
+		Subject:     strings.ToLower(entry.DN),
+		Username:    username,
+		Email:       entry.GetAttributeValue(a.cfg.EmailAttribute),
+		FirstName:   entry.GetAttributeValue(a.cfg.FirstNameAttribute),
+		LastName:    entry.GetAttributeValue(a.cfg.LastNameAttribute),
+		Role:        a.role(entry.GetAttributeValues(a.cfg.GroupAttribute)),
+		DefaultRole: a.defaultRole,
+	}, nil
+}
+
+func (a *LDAPAuthenticator) dial(ctx context.Context) (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: a.cfg.InsecureSkipVerify} // #nosec G402 -- opt-in for test directories
+	dialer := &net.Dialer{Timeout: a.cfg.Timeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+
+	conn, err := ldap.DialURL(a.cfg.URL, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("ldap dial: %w", err)
+	}
+	conn.SetTimeout(a.cfg.Timeout)
+
+	if a.cfg.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap starttls: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// findUser returns the single entry matching the username. Zero or several
+// matches are reported as invalid credentials.
+func (a *LDAPAuthenticator) findUser(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(
+		a.cfg.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2,
+		int(a.cfg.Timeout.Seconds()),
+		false,
+		fmt.Sprintf(a.cfg.UserFilter, ldap.EscapeFilter(username)),
+		[]string{a.cfg.UsernameAttribute, a.cfg.EmailAttribute, a.cfg.FirstNameAttribute, a.cfg.LastNameAttribute, a.cfg.GroupAttribute},
+		nil,
+	)
+	res, err := conn.Search(req)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) || ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap search: %w", err)
+	}
+	if len(res.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	return res.Entries[0], nil
+}
+
+// role returns the most privileged role mapped from the groups the entry
+// belongs to, whatever order the directory lists them in, or "" when no
+// group is mapped.
+func (a *LDAPAuthenticator) role(groups []string) string {
+	role := ""
+	for _, group := range groups {
+		if mapped, ok := a.groupRoles[strings.ToLower(group)]; ok && (role == "" || auth.MorePrivileged(mapped, role)) {
+			role = mapped
+		}
+	}
+	return role
+}
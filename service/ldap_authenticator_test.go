@@ -0,0 +1,300 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/internal/config"
+	"liangxiong/demo/model/entity"
+	"liangxiong/demo/repository"
+)
+
+type fakeLDAPEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// fakeLDAPServer answers simple binds and equality searches on uid, which is
+// all LDAPAuthenticator sends.
+type fakeLDAPServer struct {
+	listener net.Listener
+	entries  []fakeLDAPEntry
+}
+
+func newFakeLDAPServer(t *testing.T, entries ...fakeLDAPEntry) *fakeLDAPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &fakeLDAPServer{listener: listener, entries: entries}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeLDAPServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *fakeLDAPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeLDAPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, password := op.Children[1].Data.String(), op.Children[2].Data.String()
+			code := int64(ldap.LDAPResultInvalidCredentials)
+			if entry := s.byDN(dn); entry != nil && entry.password == password {
+				code = ldap.LDAPResultSuccess
+			}
+			conn.Write(ldapResponse(id, ldap.ApplicationBindResponse, code).Bytes())
+		case ldap.ApplicationSearchRequest:
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				conn.Write(ldapResponse(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError).Bytes())
+				continue
+			}
+			for _, entry := range s.entries {
+				if filter == fmt.Sprintf("(uid=%s)", ldap.EscapeFilter(entry.attrs["uid"][0])) {
+					conn.Write(ldapEntry(id, entry).Bytes())
+				}
+			}
+			conn.Write(ldapResponse(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+func (s *fakeLDAPServer) byDN(dn string) *fakeLDAPEntry {
+	for i := range s.entries {
+		if strings.EqualFold(s.entries[i].dn, dn) {
+			return &s.entries[i]
+		}
+	}
+	return nil
+}
+
+func ldapEnvelope(id int64, op *ber.Packet) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	p.AppendChild(op)
+	return p
+}
+
+func ldapResponse(id int64, app ber.Tag, code int64) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, app, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return ldapEnvelope(id, op)
+}
+
+func ldapEntry(id int64, entry fakeLDAPEntry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, ""))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for name, values := range entry.attrs {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+		}
+		attr.AppendChild(set)
+		attrs.AppendChild(attr)
+	}
+	op.AppendChild(attrs)
+	return ldapEnvelope(id, op)
+}
+
+func TestLDAPAuthenticator(t *testing.T) {
+	server := newFakeLDAPServer(t,
+		fakeLDAPEntry{dn: "cn=svc,dc=example,dc=org", password: "svc-secret", attrs: map[string][]string{"uid": {"svc"}}},
+		fakeLDAPEntry{
+			dn:       "uid=alice,ou=people,dc=example,dc=org",
+			password: "alice-secret",
+			attrs: map[string][]string{
+				"uid":       {"alice"},
+				"mail":      {"alice@example.org"},
+				"givenName": {"Alice"},
+				"sn":        {"Liddell"},
+				"memberOf":  {"cn=staff,ou=groups,dc=example,dc=org", "CN=Admins,ou=groups,dc=example,dc=org"},
+			},
+		},
+		fakeLDAPEntry{dn: "uid=bob,ou=people,dc=example,dc=org", password: "bob-secret", attrs: map[string][]string{"uid": {"bob"}}},
+	)
+
+	authn := NewLDAPAuthenticator("corp", auth.RoleViewer, config.LDAPConfig{
+		URL:          server.URL(),
+		BindDN:       "cn=svc,dc=example,dc=org",
+		BindPassword: "svc-secret",
+		BaseDN:       "dc=example,dc=org",
+		// viper lowercases map keys.
+		GroupRoles: map[string]string{"cn=admins,ou=groups,dc=example,dc=org": auth.RoleAdmin},
+	})
+
+	identity, err := authn.Authenticate(context.Background(), Credentials{Username: "alice", Password: "alice-secret"})
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if identity.Provider != "corp" || identity.Subject != "uid=alice,ou=people,dc=example,dc=org" || identity.Username != "alice" {
+		t.Fatalf("unexpected identity: %+v", identity)
+	}
+	if identity.Email != "alice@example.org" || identity.FirstName != "Alice" || identity.LastName != "Liddell" || identity.Role != auth.RoleAdmin {
+		t.Fatalf("unexpected attributes: %+v", identity)
+	}
+
+	identity, err = authn.Authenticate(context.Background(), Credentials{Username: "bob", Password: "bob-secret"})
+	if err != nil {
+		t.Fatalf("authenticate bob: %v", err)
+	}
+	if identity.Role != "" || identity.DefaultRole != auth.RoleViewer {
+		t.Fatalf("expected no mapped role and the default role, got %+v", identity)
+	}
+
+	rejected := []Credentials{
+		{Username: "alice", Password: "wrong"},
+		{Username: "alice", Password: ""},
+		{Username: "mallory", Password: "anything"},
+		{Username: "*", Password: "alice-secret"},
+	}
+	for _, creds := range rejected {
+		if _, err := authn.Authenticate(context.Background(), creds); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("expected invalid credentials for %q/%q, got %v", creds.Username, creds.Password, err)
+		}
+	}
+
+	// A broken service account is a configuration error, not a failed login.
+	misconfigured := NewLDAPAuthenticator("corp", "", config.LDAPConfig{
+		URL:          server.URL(),
+		BindDN:       "cn=svc,dc=example,dc=org",
+		BindPassword: "stale",
+		BaseDN:       "dc=example,dc=org",
+	})
+	if _, err := misconfigured.Authenticate(context.Background(), Credentials{Username: "alice", Password: "alice-secret"}); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected service bind error, got %v", err)
+	}
+}
+
+func TestLDAPRoleFromSeveralGroups(t *testing.T) {
+	authn := NewLDAPAuthenticator("corp", auth.RoleViewer, config.LDAPConfig{GroupRoles: map[string]string{
+		"cn=admins,ou=groups,dc=example,dc=org":    auth.RoleAdmin,
+		"cn=operators,ou=groups,dc=example,dc=org": auth.RoleOperator,
+		"cn=viewers,ou=groups,dc=example,dc=org":   auth.RoleViewer,
+	}})
+	admins, operators, viewers := "cn=admins,ou=groups,dc=example,dc=org", "cn=operators,ou=groups,dc=example,dc=org", "cn=viewers,ou=groups,dc=example,dc=org"
+
+	// The most privileged mapped role wins whatever the attribute order; no
+	// mapped group maps no role.
+	cases := []struct {
+		groups []string
+		role   string
+	}{
+		{[]string{viewers, admins}, auth.RoleAdmin},
+		{[]string{admins, viewers}, auth.RoleAdmin},
+		{[]string{viewers, "cn=staff,ou=groups,dc=example,dc=org", operators}, auth.RoleOperator},
+		{[]string{operators, viewers}, auth.RoleOperator},
+		{[]string{"cn=staff,ou=groups,dc=example,dc=org"}, ""},
+	}
+	for _, tc := range cases {
+		if got := authn.role(tc.groups); got != tc.role {
+			t.Fatalf("groups %v: expected %q, got %q", tc.groups, tc.role, got)
+		}
+	}
+}
+
+func TestLDAPLoginAppliesDirectoryChanges(t *testing.T) {
+	db := openSQLite(t)
+	users := repository.NewUserRepository(db)
+	revocations := NewRevocationService(db, repository.NewRevocationRepository(db), repository.NewRefreshTokenRepository(db), time.Minute)
+	providers, err := NewIdentityProviders(nil, users, NewUserProvisioner(db, users, repository.NewUserIdentityRepository(db), revocations))
+	if err != nil {
+		t.Fatalf("providers: %v", err)
+	}
+
+	alice := func(groups ...string) fakeLDAPEntry {
+		return fakeLDAPEntry{
+			dn:       "uid=alice,ou=people,dc=example,dc=org",
+			password: "alice-secret",
+			attrs:    map[string][]string{"uid": {"alice"}, "mail": {"alice@example.org"}, "givenName": {"Alice"}, "sn": {"Liddell"}, "memberOf": groups},
+		}
+	}
+	login := func(entry fakeLDAPEntry) *entity.User {
+		t.Helper()
+		server := newFakeLDAPServer(t, entry)
+		authn := NewLDAPAuthenticator("corp", auth.RoleViewer, config.LDAPConfig{
+			URL:        server.URL(),
+			BaseDN:     "dc=example,dc=org",
+			GroupRoles: map[string]string{"cn=admins,ou=groups,dc=example,dc=org": auth.RoleAdmin, "cn=staff,ou=groups,dc=example,dc=org": auth.RoleViewer},
+		})
+		identity, err := authn.Authenticate(context.Background(), Credentials{Username: "alice", Password: "alice-secret"})
+		if err != nil {
+			t.Fatalf("authenticate: %v", err)
+		}
+		user, err := providers.Resolve(context.Background(), identity)
+		if err != nil {
+			t.Fatalf("resolve: %v", err)
+		}
+		return user
+	}
+
+	first := login(alice("cn=admins,ou=groups,dc=example,dc=org"))
+	if first.Role != auth.RoleAdmin {
+		t.Fatalf("expected the group to grant admin, got %q", first.Role)
+	}
+	// Tokens carry iat in whole seconds.
+	issuedAt := time.Now().Truncate(time.Second)
+	if revoked, err := revocations.IsRevoked(context.Background(), "", first.ID, 0, issuedAt, issuedAt.Add(time.Minute)); err != nil || revoked {
+		t.Fatalf("expected the admin token to be valid before the demotion, got %v %v", revoked, err)
+	}
+
+	// Without a mapped group, the stored role is kept.
+	if kept := login(alice()); kept.Role != auth.RoleAdmin {
+		t.Fatalf("expected the role to be kept without a mapped group, got %q", kept.Role)
+	}
+
+	// Moved from the admin group to one mapped to viewer, with a new mail
+	// address.
+	demoted := alice("cn=staff,ou=groups,dc=example,dc=org")
+	demoted.attrs["mail"] = []string{"liddell@example.org"}
+	second := login(demoted)
+	if second.ID != first.ID || second.Role != auth.RoleViewer || second.Email != "liddell@example.org" {
+		t.Fatalf("expected the linked user to be demoted and updated, got %+v", second)
+	}
+	stored, err := users.GetByID(context.Background(), first.ID)
+	if err != nil || stored.Role != auth.RoleViewer || stored.Email != "liddell@example.org" || stored.FirstName != "Alice" {
+		t.Fatalf("expected the changes to be stored, got %+v %v", stored, err)
+	}
+	// Tokens issued with the admin role no longer authenticate.
+	if revoked, err := revocations.IsRevoked(context.Background(), "", first.ID, 0, issuedAt, issuedAt.Add(time.Minute)); err != nil || !revoked {
+		t.Fatalf("expected the admin token to be revoked by the demotion, got %v %v", revoked, err)
+	}
+}
@@ -0,0 +1,308 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/dto"
+	"liangxiong/demo/internal/config"
+	"liangxiong/demo/model/entity"
+	"liangxiong/demo/repository"
+	"liangxiong/demo/utils"
+)
+
+const (
+	defaultMFAChallengeTTL   = 5 * time.Minute
+	defaultRecoveryCodeCount = 10
+)
+
+// MFAService manages TOTP enrolment, recovery codes and the per-role MFA policy.
+type MFAService struct {
+	tx            *repository.TxManager
+	users         repository.UserRepository
+	repo          repository.MFARepository
+	secrets       *auth.SecretBox
+	attempts      *auth.LoginAttemptTracker
+	issuer        string
+	challengeTTL  time.Duration
+	recoveryCodes int
+	requiredRoles map[string]bool
+}
+
+// NewMFAService constructs the service. secrets is nil when MFA is disabled.
+func NewMFAService(db *sql.DB, users repository.UserRepository, repo repository.MFARepository, secrets *auth.SecretBox, attempts *auth.LoginAttemptTracker, cfg config.MFAConfig) *MFAService {
+	s := &MFAService{
+		tx:            repository.NewTxManager(db),
+		users:         users,
+		repo:          repo,
+		secrets:       secrets,
+		attempts:      attempts,
+		issuer:        cfg.Issuer,
+		challengeTTL:  cfg.ChallengeTTL,
+		recoveryCodes: cfg.RecoveryCodes,
+		requiredRoles: make(map[string]bool),
+	}
+	if s.challengeTTL <= 0 {
+		s.challengeTTL = defaultMFAChallengeTTL
+	}
+	if s.recoveryCodes <= 0 {
+		s.recoveryCodes = defaultRecoveryCodeCount
+	}
+	for _, role := range cfg.RequiredRoles {
+		s.requiredRoles[auth.NormalizeRole(role)] = true
+	}
+	return s
+}
+
+// ChallengeTTL is the lifetime of the token bridging the two login steps.
+func (s *MFAService) ChallengeTTL() time.Duration {
+	return s.challengeTTL
+}
+
+// Required reports whether the policy forces MFA for the role.
+func (s *MFAService) Required(role string) bool {
+	return s.requiredRoles[auth.NormalizeRole(role)]
+}
+
+// Enabled reports whether the user has a confirmed TOTP enrolment.
+func (s *MFAService) Enabled(ctx context.Context, userID string) (bool, error) {
+	m, err := s.repo.GetByUserID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return m.ConfirmedAt.Valid, nil
+}
+
+// BeginEnrollment generates a new TOTP secret for the caller. The enrolment
+// stays inactive until confirmed with a valid code.
+func (s *MFAService) BeginEnrollment(ctx context.Context) (*dto.TOTPEnrollmentResponse, error) {
+	if s.secrets == nil {
+		return nil, mfaUnavailableError()
+	}
+	userID := utils.UserIDFromContext(ctx)
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.Clone(utils.ErrNotFound, map[string]string{"id": userID}, err)
+		}
+		return nil, err
+	}
+
+	enabled, err := s.Enabled(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, utils.Clone(utils.ErrBadRequest, map[string]string{"mfa": "already enabled"}, nil)
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.secrets.Seal(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if err := s.repo.Upsert(ctx, &entity.UserMFA{UserID: user.ID, Secret: sealed, CreatedAt: now, UpdatedAt: now}); err != nil {
+		return nil, err
+	}
+
+	return &dto.TOTPEnrollmentResponse{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(s.issuer, user.Username, secret),
+	}, nil
+}
+
+// ConfirmEnrollment activates a pending enrolment and returns fresh recovery codes.
+func (s *MFAService) ConfirmEnrollment(ctx context.Context, req dto.MFACodeRequest) (*dto.RecoveryCodesResponse, error) {
+	userID := utils.UserIDFromContext(ctx)
+
+	var codes []string
+	err := s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		m, err := s.repo.GetByUserID(ctx, userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return utils.Clone(utils.ErrBadRequest, map[string]string{"mfa": "enrollment not started"}, err)
+			}
+			return err
+		}
+		if m.ConfirmedAt.Valid {
+			return utils.Clone(utils.ErrBadRequest, map[string]string{"mfa": "already enabled"}, nil)
+		}
+
+		if err := s.checkCode(ctx, userID, func() (bool, error) { return s.verifyTOTP(ctx, m, req.Code) }); err != nil {
+			return err
+		}
+
+		if err := s.repo.Confirm(ctx, userID, time.Now().UTC()); err != nil {
+			return err
+		}
+		codes, err = s.replaceRecoveryCodes(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &dto.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// RegenerateRecoveryCodes replaces the caller's recovery codes after checking a TOTP code.
+func (s *MFAService) RegenerateRecoveryCodes(ctx context.Context, req dto.MFACodeRequest) (*dto.RecoveryCodesResponse, error) {
+	userID := utils.UserIDFromContext(ctx)
+
+	var codes []string
+	err := s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		m, err := s.confirmedEnrollment(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if err := s.checkCode(ctx, userID, func() (bool, error) { return s.verifyTOTP(ctx, m, req.Code) }); err != nil {
+			return err
+		}
+
+		codes, err = s.replaceRecoveryCodes(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &dto.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// Disable removes the caller's enrolment. Roles the policy forces into MFA
+// cannot opt out.
+func (s *MFAService) Disable(ctx context.Context, req dto.MFACodeRequest) error {
+	if s.Required(utils.RoleFromContext(ctx)) {
+		return utils.Clone(utils.ErrForbidden, map[string]string{"mfa": "required for role"}, nil)
+	}
+	userID := utils.UserIDFromContext(ctx)
+
+	return s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		if _, err := s.confirmedEnrollment(ctx, userID); err != nil {
+			return err
+		}
+		if err := s.checkCode(ctx, userID, func() (bool, error) { return s.VerifyCode(ctx, userID, req.Code) }); err != nil {
+			return err
+		}
+		return s.repo.Delete(ctx, userID)
+	})
+}
+
+// Reset removes the enrolment of a user who lost their authenticator.
+// Users in a role that requires MFA must enrol again on next login.
+func (s *MFAService) Reset(ctx context.Context, userID string) error {
+	return s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		if _, err := s.users.GetByID(ctx, userID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return utils.Clone(utils.ErrNotFound, map[string]string{"id": userID}, err)
+			}
+			return err
+		}
+		return s.repo.Delete(ctx, userID)
+	})
+}
+
+// VerifyCode checks a TOTP code or consumes a recovery code of a confirmed
+// enrolment. Accepted codes cannot be replayed.
+func (s *MFAService) VerifyCode(ctx context.Context, userID, code string) (bool, error) {
+	m, err := s.repo.GetByUserID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !m.ConfirmedAt.Valid {
+		return false, nil
+	}
+
+	if auth.IsTOTPCode(code) {
+		return s.verifyTOTP(ctx, m, code)
+	}
+	return s.repo.UseRecoveryCode(ctx, userID, auth.HashRecoveryCode(code), time.Now().UTC())
+}
+
+func (s *MFAService) verifyTOTP(ctx context.Context, m *entity.UserMFA, code string) (bool, error) {
+	if s.secrets == nil {
+		return false, mfaUnavailableError()
+	}
+	secret, err := s.secrets.Open(m.Secret)
+	if err != nil {
+		return false, err
+	}
+	step, ok, err := auth.ValidateTOTP(secret, code, time.Now())
+	if err != nil || !ok {
+		return false, err
+	}
+	return s.repo.MarkStepUsed(ctx, m.UserID, step)
+}
+
+func (s *MFAService) confirmedEnrollment(ctx context.Context, userID string) (*entity.UserMFA, error) {
+	m, err := s.repo.GetByUserID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.Clone(utils.ErrBadRequest, map[string]string{"mfa": "not enabled"}, err)
+		}
+		return nil, err
+	}
+	if !m.ConfirmedAt.Valid {
+		return nil, utils.Clone(utils.ErrBadRequest, map[string]string{"mfa": "not enabled"}, nil)
+	}
+	return m, nil
+}
+
+// checkCode applies the second-factor backoff of the user around verify.
+func (s *MFAService) checkCode(ctx context.Context, userID string, verify func() (bool, error)) error {
+	key := auth.MFAKey(userID)
+	if wait := s.attempts.RetryAfter(key); wait > 0 {
+		return utils.Clone(utils.ErrTooManyLogin, map[string]string{"retryAfter": retryAfterSeconds(wait)}, nil)
+	}
+	ok, err := verify()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		s.attempts.RecordFailure(key)
+		return utils.Clone(utils.ErrBadRequest, map[string]string{"code": "invalid"}, nil)
+	}
+	s.attempts.Reset(key)
+	return nil
+}
+
+func (s *MFAService) replaceRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	now := time.Now().UTC()
+	codes := make([]string, 0, s.recoveryCodes)
+	records := make([]*entity.MFARecoveryCode, 0, s.recoveryCodes)
+	for i := 0; i < s.recoveryCodes; i++ {
+		code, err := auth.NewRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		records = append(records, &entity.MFARecoveryCode{
+			ID:        utils.NewID(),
+			UserID:    userID,
+			CodeHash:  auth.HashRecoveryCode(code),
+			CreatedAt: now,
+		})
+	}
+	if err := s.repo.ReplaceRecoveryCodes(ctx, userID, records); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// mfaUnavailableError answers TOTP operations while MFA is disabled in the
+// configuration. Recovery codes and admin resets keep working.
+func mfaUnavailableError() *utils.AppError {
+	return utils.Clone(utils.ErrUnavailable, map[string]string{"mfa": "disabled on this server"}, nil)
+}
@@ -0,0 +1,308 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/internal/config"
+)
+
+const (
+	defaultOIDCTimeout = 10 * time.Second
+	defaultOIDCScopes  = "openid profile email"
+	// maxOIDCResponseSize caps discovery, JWKS and token responses.
+	maxOIDCResponseSize = 1 << 20
+	// oidcKeyRefetchInterval spaces out the key set fetches triggered by
+	// unknown key IDs.
+	oidcKeyRefetchInterval = time.Minute
+)
+
+// oidcDiscovery holds the fields of /.well-known/openid-configuration used here.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcClaims are the ID token claims mapped onto an Identity.
+type oidcClaims struct {
+	Nonce             string `json:"nonce"`
+	Email             string `json:"email"`
+	PreferredUsername string `json:"preferred_username"`
+	GivenName         string `json:"given_name"`
+	FamilyName        string `json:"family_name"`
+	jwt.RegisteredClaims
+}
+
+// OIDCAuthenticator implements the OpenID Connect authorization code flow
+// for a confidential client. Discovery and signing keys are fetched lazily
+// and cached; the key set is refreshed when an unknown key ID shows up, at
+// most once per oidcKeyRefetchInterval.
+type OIDCAuthenticator struct {
+	name        string
+	defaultRole string
+	cfg         config.OIDCConfig
+	client      *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]any
+	keysFetchedAt time.Time
+}
+
+// NewOIDCAuthenticator builds the authenticator. A nil client uses a default
+// client with the configured timeout.
+func NewOIDCAuthenticator(name, defaultRole string, cfg config.OIDCConfig, client *http.Client) *OIDCAuthenticator {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultOIDCTimeout
+	}
+	if client == nil {
+		client = &http.Client{Timeout: cfg.Timeout}
+	}
+	cfg.IssuerURL = strings.TrimSuffix(cfg.IssuerURL, "/")
+	return &OIDCAuthenticator{name: name, defaultRole: defaultRole, cfg: cfg, client: client}
+}
+
+// Name returns the configured provider name.
+func (a *OIDCAuthenticator) Name() string { return a.name }
+
+// Type returns ProviderTypeOIDC.
+func (a *OIDCAuthenticator) Type() string { return ProviderTypeOIDC }
+
+// AuthorizationURL builds the provider URL the browser is redirected to.
+func (a *OIDCAuthenticator) AuthorizationURL(ctx context.Context, state, nonce string) (string, error) {
+	d, err := a.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	scope := defaultOIDCScopes
+	if len(a.cfg.Scopes) > 0 {
+		scope = strings.Join(a.cfg.Scopes, " ")
+	}
+
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("oidc authorization endpoint: %w", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", a.cfg.ClientID)
+	q.Set("redirect_uri", a.cfg.RedirectURL)
+	q.Set("scope", scope)
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Authenticate redeems the authorization code and verifies the ID token.
+func (a *OIDCAuthenticator) Authenticate(ctx context.Context, creds Credentials) (*Identity, error) {
+	if creds.Code == "" || creds.Nonce == "" {
+		return nil, ErrInvalidCredentials
+	}
+	d, err := a.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, err := a.exchange(ctx, d, creds.Code)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := a.verify(ctx, d, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Nonce != creds.Nonce {
+		return nil, ErrInvalidCredentials
+	}
+
+	username := claims.PreferredUsername
+	if username == "" {
+		username = claims.Email
+	}
+	if username == "" {
+		username = claims.Subject
+	}
+	return &Identity{
+		Provider:    a.name,
+		Subject:     claims.Subject,
+		Username:    username,
+		Email:       claims.Email,
+		FirstName:   claims.GivenName,
+		LastName:    claims.FamilyName,
+		DefaultRole: a.defaultRole,
+	}, nil
+}
+
+// exchange posts the code to the token endpoint and returns the raw ID token.
+// Rejections by the provider (expired or reused codes) are invalid credentials.
+func (a *OIDCAuthenticator) exchange(ctx context.Context, d *oidcDiscovery, code string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", a.cfg.RedirectURL)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.cfg.ClientID), url.QueryEscape(a.cfg.ClientSecret))
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oidc token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return "", fmt.Errorf("oidc token endpoint returned %d", resp.StatusCode)
+		}
+		return "", ErrInvalidCredentials
+	}
+
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxOIDCResponseSize)).Decode(&body); err != nil {
+		return "", fmt.Errorf("decode oidc token response: %w", err)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("oidc token response has no id_token")
+	}
+	return body.IDToken, nil
+}
+
+// verify checks signature, issuer, audience and expiry of the ID token.
+func (a *OIDCAuthenticator) verify(ctx context.Context, d *oidcDiscovery, raw string) (*oidcClaims, error) {
+	claims := &oidcClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return a.key(ctx, d, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(a.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return claims, nil
+}
+
+// key returns the signing key for kid, refetching the key set once when the
+// kid is unknown so provider key rotation is picked up. Once a key set is
+// held, unknown kids are refused without a fetch until
+// oidcKeyRefetchInterval has passed since the last one, so tokens with made
+// up kids cannot make every login call the provider.
+func (a *OIDCAuthenticator) key(ctx context.Context, d *oidcDiscovery, kid string) (any, error) {
+	a.mu.Lock()
+	key, ok := a.keys[kid]
+	due := a.keys == nil || time.Since(a.keysFetchedAt) >= oidcKeyRefetchInterval
+	if !ok && due {
+		a.keysFetchedAt = time.Now()
+	}
+	a.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !due {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	keys, err := a.fetchKeys(ctx, d.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	a.keys = keys
+	a.mu.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (a *OIDCAuthenticator) fetchKeys(ctx context.Context, uri string) (map[string]any, error) {
+	var set auth.JSONWebKeySet
+	if err := a.getJSON(ctx, uri, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]any, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = pub
+	}
+	return keys, nil
+}
+
+// discover fetches and caches the provider metadata.
+func (a *OIDCAuthenticator) discover(ctx context.Context) (*oidcDiscovery, error) {
+	a.mu.Lock()
+	d := a.discovery
+	a.mu.Unlock()
+	if d != nil {
+		return d, nil
+	}
+
+	var doc oidcDiscovery
+	if err := a.getJSON(ctx, a.cfg.IssuerURL+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != a.cfg.IssuerURL {
+		return nil, fmt.Errorf("oidc issuer mismatch: %s", doc.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("oidc discovery document is incomplete")
+	}
+
+	a.mu.Lock()
+	a.discovery = &doc
+	a.mu.Unlock()
+	return &doc, nil
+}
+
+func (a *OIDCAuthenticator) getJSON(ctx context.Context, uri string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("oidc request %s: %w", uri, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc request %s returned %d", uri, resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxOIDCResponseSize)).Decode(out); err != nil {
+		return fmt.Errorf("decode %s: %w", uri, err)
+	}
+	return nil
+}
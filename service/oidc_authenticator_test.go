@@ -0,0 +1,310 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/internal/config"
+	"liangxiong/demo/model/entity"
+	"liangxiong/demo/repository"
+	"liangxiong/demo/utils"
+)
+
+// fakeOIDCProvider serves discovery, JWKS and a token endpoint that signs an
+// ID token for a single valid code. kid names the signing key in the ID token
+// header; jwksFetches counts the key set requests.
+type fakeOIDCProvider struct {
+	server      *httptest.Server
+	signer      *rsa.PrivateKey
+	nonce       string
+	kid         string
+	jwksFetches atomic.Int32
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	p := &fakeOIDCProvider{signer: key, kid: "k1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.jwksFetches.Add(1)
+		json.NewEncoder(w).Encode(auth.JSONWebKeySet{Keys: []auth.JSONWebKey{{
+			Kty: "RSA",
+			Use: "sig",
+			Kid: "k1",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "client" || secret != "client-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("code") != "good-code" || r.PostFormValue("redirect_uri") != "https://app.example.org/callback" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		now := time.Now()
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":                p.server.URL,
+			"aud":                "client",
+			"sub":                "00u-1",
+			"iat":                now.Unix(),
+			"exp":                now.Add(time.Minute).Unix(),
+			"nonce":              p.nonce,
+			"email":              "carol@example.org",
+			"preferred_username": "carol",
+			"given_name":         "Carol",
+			"family_name":        "Danvers",
+		})
+		token.Header["kid"] = p.kid
+		signed, err := token.SignedString(p.signer)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "opaque", "token_type": "Bearer", "id_token": signed})
+	})
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func TestOIDCAuthenticator(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+	authn := NewOIDCAuthenticator("sso", auth.RoleOperator, config.OIDCConfig{
+		IssuerURL:    provider.server.URL,
+		ClientID:     "client",
+		ClientSecret: "client-secret",
+		RedirectURL:  "https://app.example.org/callback",
+	}, provider.server.Client())
+	ctx := context.Background()
+
+	raw, err := authn.AuthorizationURL(ctx, "state-1", "nonce-1")
+	if err != nil {
+		t.Fatalf("authorization url: %v", err)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("parse authorization url: %v", err)
+	}
+	q := u.Query()
+	if u.Path != "/authorize" || q.Get("response_type") != "code" || q.Get("client_id") != "client" || q.Get("state") != "state-1" || q.Get("nonce") != "nonce-1" || q.Get("scope") != "openid profile email" {
+		t.Fatalf("unexpected authorization url: %s", raw)
+	}
+
+	provider.nonce = "nonce-1"
+	identity, err := authn.Authenticate(ctx, Credentials{Code: "good-code", Nonce: "nonce-1"})
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if identity.Provider != "sso" || identity.Subject != "00u-1" || identity.Username != "carol" || identity.Email != "carol@example.org" || identity.Role != "" || identity.DefaultRole != auth.RoleOperator {
+		t.Fatalf("unexpected identity: %+v", identity)
+	}
+
+	// Replayed ID token from another login flow.
+	if _, err := authn.Authenticate(ctx, Credentials{Code: "good-code", Nonce: "nonce-2"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected nonce mismatch to be rejected, got %v", err)
+	}
+	if _, err := authn.Authenticate(ctx, Credentials{Code: "bad-code", Nonce: "nonce-1"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected rejected code, got %v", err)
+	}
+
+	forged, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	provider.signer = forged
+	if _, err := authn.Authenticate(ctx, Credentials{Code: "good-code", Nonce: "nonce-1"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("expected forged signature to be rejected, got %v", err)
+	}
+}
+
+func TestOIDCRateLimitsKeyRefetches(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+	authn := NewOIDCAuthenticator("sso", auth.RoleViewer, config.OIDCConfig{
+		IssuerURL:    provider.server.URL,
+		ClientID:     "client",
+		ClientSecret: "client-secret",
+		RedirectURL:  "https://app.example.org/callback",
+	}, provider.server.Client())
+	ctx := context.Background()
+	provider.nonce = "nonce-1"
+	credentials := Credentials{Code: "good-code", Nonce: "nonce-1"}
+
+	if _, err := authn.Authenticate(ctx, credentials); err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if got := provider.jwksFetches.Load(); got != 1 {
+		t.Fatalf("expected the key set to be fetched once, got %d", got)
+	}
+
+	// Once the interval has passed, the first unknown kid refetches the key
+	// set; the next ones within the interval are refused without a fetch.
+	authn.keysFetchedAt = authn.keysFetchedAt.Add(-oidcKeyRefetchInterval)
+	provider.kid = "unknown"
+	for i := 0; i < 3; i++ {
+		if _, err := authn.Authenticate(ctx, credentials); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: expected an unknown kid to be rejected, got %v", i, err)
+		}
+	}
+	if got := provider.jwksFetches.Load(); got != 2 {
+		t.Fatalf("expected a single refetch, got %d fetches", got)
+	}
+
+	// Known kids keep working meanwhile, and the next interval allows
+	// another refetch.
+	provider.kid = "k1"
+	if _, err := authn.Authenticate(ctx, credentials); err != nil {
+		t.Fatalf("authenticate with a known kid: %v", err)
+	}
+	provider.kid = "unknown"
+	authn.keysFetchedAt = authn.keysFetchedAt.Add(-oidcKeyRefetchInterval)
+	authn.Authenticate(ctx, credentials)
+	if got := provider.jwksFetches.Load(); got != 3 {
+		t.Fatalf("expected a refetch after the interval, got %d fetches", got)
+	}
+}
+
+func TestOIDCLoginKeepsAssignedRole(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+	db := openSQLite(t)
+	users := repository.NewUserRepository(db)
+	revocations := NewRevocationService(db, repository.NewRevocationRepository(db), repository.NewRefreshTokenRepository(db), time.Minute)
+	providers, err := NewIdentityProviders(nil, users, NewUserProvisioner(db, users, repository.NewUserIdentityRepository(db), revocations))
+	if err != nil {
+		t.Fatalf("providers: %v", err)
+	}
+	authn := NewOIDCAuthenticator("sso", "", config.OIDCConfig{
+		IssuerURL:    provider.server.URL,
+		ClientID:     "client",
+		ClientSecret: "client-secret",
+		RedirectURL:  "https://app.example.org/callback",
+	}, provider.server.Client())
+	ctx := context.Background()
+	login := func() *entity.User {
+		t.Helper()
+		provider.nonce = "nonce-1"
+		identity, err := authn.Authenticate(ctx, Credentials{Code: "good-code", Nonce: "nonce-1"})
+		if err != nil {
+			t.Fatalf("authenticate: %v", err)
+		}
+		user, err := providers.Resolve(ctx, identity)
+		if err != nil {
+			t.Fatalf("resolve: %v", err)
+		}
+		return user
+	}
+
+	first := login()
+	if first.Role != auth.RoleViewer {
+		t.Fatalf("expected a new user to get the default role, got %q", first.Role)
+	}
+
+	// An admin promotes the linked user; the provider maps no role.
+	first.Role = auth.RoleOperator
+	if ok, err := users.Update(ctx, first); err != nil || !ok {
+		t.Fatalf("promote: %v %v", ok, err)
+	}
+	issuedAt := time.Now().Truncate(time.Second)
+	if second := login(); second.ID != first.ID || second.Role != auth.RoleOperator {
+		t.Fatalf("expected the promotion to survive the login, got %+v", second)
+	}
+	if revoked, err := revocations.IsRevoked(ctx, "", first.ID, 0, issuedAt, issuedAt.Add(time.Minute)); err != nil || revoked {
+		t.Fatalf("expected the other sessions to survive the login, got %v %v", revoked, err)
+	}
+}
+
+func TestCompleteOIDCRequiresBinding(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+	db := openSQLite(t)
+	users := repository.NewUserRepository(db)
+	providers, err := NewIdentityProviders([]config.ProviderConfig{{
+		Name: "sso",
+		Type: ProviderTypeOIDC,
+		OIDC: config.OIDCConfig{
+			IssuerURL:    provider.server.URL,
+			ClientID:     "client",
+			ClientSecret: "client-secret",
+			RedirectURL:  "https://app.example.org/callback",
+		},
+	}}, users, NewUserProvisioner(db, users, repository.NewUserIdentityRepository(db), NewRevocationService(db, repository.NewRevocationRepository(db), repository.NewRefreshTokenRepository(db), time.Minute)))
+	if err != nil {
+		t.Fatalf("providers: %v", err)
+	}
+	jwtManager, err := auth.NewJWTManager(config.AuthConfig{JWTSecret: "secret", Issuer: "test", Audience: "test", AccessTokenTTL: time.Minute, Algorithm: "HS256"})
+	if err != nil {
+		t.Fatalf("jwt manager: %v", err)
+	}
+	attempts := auth.NewLoginAttemptTracker(config.LockoutConfig{})
+	mfa := NewMFAService(db, users, repository.NewMFARepository(db), nil, attempts, config.MFAConfig{})
+	svc := NewAuthService(db, users, repository.NewRefreshTokenRepository(db), nil, attempts, mfa, providers, nil, jwtManager, time.Hour, zap.NewNop())
+	ctx := context.Background()
+
+	begin, binding, err := svc.BeginOIDC(ctx, "sso")
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	u, err := url.Parse(begin.AuthorizationURL)
+	if err != nil {
+		t.Fatalf("parse authorization url: %v", err)
+	}
+	provider.nonce = u.Query().Get("nonce")
+
+	// A state lured into another browser arrives without, or with another, binding.
+	other, _, err := svc.BeginOIDC(ctx, "sso")
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	for _, presented := range []string{"", "forged"} {
+		_, err := svc.CompleteOIDC(ctx, "sso", "good-code", begin.State, presented)
+		var appErr *utils.AppError
+		if !errors.As(err, &appErr) || appErr.Code != utils.ErrUnauthorized.Code {
+			t.Fatalf("expected the unbound state to be rejected, got %v", err)
+		}
+	}
+	if _, err := svc.CompleteOIDC(ctx, "sso", "good-code", other.State, binding); err == nil {
+		t.Fatal("expected the binding of another login to be rejected")
+	}
+
+	resp, err := svc.CompleteOIDC(ctx, "sso", "good-code", begin.State, binding)
+	if err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if resp.AccessToken == "" {
+		t.Fatalf("expected tokens, got %+v", resp)
+	}
+}
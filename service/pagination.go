@@ -0,0 +1,57 @@
+package service
+
+import (
+	"errors"
+
+	"liangxiong/demo/repository"
+	"liangxiong/demo/utils"
+)
+
+// pageQuery builds the repository page for a list request. A cursor replaces
+// the page number and must have been issued for the same scope; its sort
+// applies when the request names none and must otherwise be the requested
+// one. It returns the effective sort.
+func pageQuery(cursors *utils.CursorCodec, scope utils.CursorScope, token, sort string, page, size int, skipTotal bool) (repository.PageQuery, string, error) {
+	query := repository.PageQuery{Page: page, Size: size, SkipTotal: skipTotal}
+	if token == "" {
+		return query, sort, nil
+	}
+
+	cursor, err := cursors.Decode(token, scope)
+	if err != nil {
+		return query, "", invalidCursorError(err)
+	}
+	if sort != "" && sort != cursor.Sort {
+		return query, "", utils.Clone(utils.ErrBadRequest, map[string]string{"sort": "must match the sort of the cursor"}, nil)
+	}
+	query.After = cursor.Keys
+	return query, cursor.Sort, nil
+}
+
+// nextCursor encodes the continuation token of a page, if another follows.
+func nextCursor(cursors *utils.CursorCodec, scope utils.CursorScope, sort string, keys []any) (string, error) {
+	if keys == nil {
+		return "", nil
+	}
+	return cursors.Encode(scope, sort, keys)
+}
+
+// listError maps cursors rejected by the repository to a bad request.
+func listError(err error) error {
+	if errors.Is(err, utils.ErrInvalidCursor) {
+		return invalidCursorError(err)
+	}
+	return err
+}
+
+// pageTotal returns the total for a list response, nil when it was skipped.
+func pageTotal(total int64) *int64 {
+	if total < 0 {
+		return nil
+	}
+	return &total
+}
+
+func invalidCursorError(err error) *utils.AppError {
+	return utils.Clone(utils.ErrBadRequest, map[string]string{"cursor": "invalid cursor"}, err)
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"liangxiong/demo/repository"
+	"liangxiong/demo/utils"
+)
+
+func TestPageQueryCursors(t *testing.T) {
+	cursors := utils.NewCursorCodec("secret")
+	scope := func(resource string, filter any) utils.CursorScope {
+		t.Helper()
+		scope, err := utils.NewCursorScope(resource, filter)
+		if err != nil {
+			t.Fatalf("scope: %v", err)
+		}
+		return scope
+	}
+	viewers := scope("users", repository.UserFilter{Status: "active", Role: "viewer"})
+	createdAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	token, err := nextCursor(cursors, viewers, "-createdAt", []any{createdAt, "user-5"})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	page, sort, err := pageQuery(cursors, viewers, token, "", 3, 10, true)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if sort != "-createdAt" || len(page.After) != 2 || string(page.After[1]) != `"user-5"` || page.Size != 10 || !page.SkipTotal {
+		t.Fatalf("unexpected page %+v sort %q", page, sort)
+	}
+
+	var appErr *utils.AppError
+	if _, _, err := pageQuery(cursors, viewers, token, "username", 1, 10, false); !errors.As(err, &appErr) || appErr.Code != utils.ErrBadRequest.Code {
+		t.Fatalf("expected sort mismatch to be rejected, got %v", err)
+	}
+	if _, _, err := pageQuery(utils.NewCursorCodec("other"), viewers, token, "", 1, 10, false); !errors.As(err, &appErr) || appErr.Code != utils.ErrBadRequest.Code {
+		t.Fatalf("expected foreign cursor to be rejected, got %v", err)
+	}
+	if _, _, err := pageQuery(cursors, viewers, token[:len(token)-2]+"xx", "", 1, 10, false); err == nil {
+		t.Fatal("expected tampered cursor to be rejected")
+	}
+	// A cursor is refused by a listing with another filter or resource.
+	for _, other := range []utils.CursorScope{
+		scope("users", repository.UserFilter{Status: "active", Role: "admin"}),
+		scope("users", repository.UserFilter{Status: "active", Role: "viewer", Name: "alice"}),
+		scope("exchanges", repository.UserFilter{Status: "active", Role: "viewer"}),
+	} {
+		if _, _, err := pageQuery(cursors, other, token, "", 1, 10, false); !errors.As(err, &appErr) || appErr.Code != utils.ErrBadRequest.Code || !errors.Is(err, utils.ErrInvalidCursor) {
+			t.Fatalf("expected a cursor of another listing to be rejected, got %v", err)
+		}
+	}
+	if next, err := nextCursor(cursors, viewers, "", nil); err != nil || next != "" {
+		t.Fatalf("expected no cursor on the last page, got %q %v", next, err)
+	}
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/dto"
+	"liangxiong/demo/model/entity"
+	"liangxiong/demo/repository"
+	"liangxiong/demo/utils"
+)
+
+const defaultPasswordResetTTL = time.Hour
+
+// PasswordService handles password changes and admin-initiated resets.
+type PasswordService struct {
+	tx          *repository.TxManager
+	users       repository.UserRepository
+	resets      repository.PasswordResetRepository
+	revocations *RevocationService
+	attempts    *auth.LoginAttemptTracker
+	policy      *auth.PasswordPolicy
+	hasher      *auth.PasswordHasher
+	resetTTL    time.Duration
+}
+
+// NewPasswordService constructs the service.
+func NewPasswordService(db *sql.DB, users repository.UserRepository, resets repository.PasswordResetRepository, revocations *RevocationService, attempts *auth.LoginAttemptTracker, policy *auth.PasswordPolicy, hasher *auth.PasswordHasher, resetTTL time.Duration) *PasswordService {
+	if resetTTL <= 0 {
+		resetTTL = defaultPasswordResetTTL
+	}
+	return &PasswordService{tx: repository.NewTxManager(db), users: users, resets: resets, revocations: revocations, attempts: attempts, policy: policy, hasher: hasher, resetTTL: resetTTL}
+}
+
+// ChangePassword lets the authenticated user replace their password.
+// Wrong current passwords are throttled and lock the account like failed
+// logins. Every token issued before the change is revoked.
+func (s *PasswordService) ChangePassword(ctx context.Context, req dto.ChangePasswordRequest) error {
+	userID := utils.UserIDFromContext(ctx)
+	key := auth.PasswordKey(userID)
+	if wait := s.attempts.RetryAfter(key); wait > 0 {
+		return utils.Clone(utils.ErrTooManyLogin, map[string]string{"retryAfter": retryAfterSeconds(wait)}, nil)
+	}
+
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return utils.Clone(utils.ErrNotFound, map[string]string{"id": userID}, err)
+		}
+		return err
+	}
+
+	now := time.Now().UTC()
+	if user.LockedUntil.Valid && now.Before(user.LockedUntil.Time) {
+		return accountLockedError(user.LockedUntil.Time)
+	}
+	if !auth.VerifyPassword(user.PasswordHash, req.CurrentPassword) {
+		return recordFailure(ctx, s.attempts, s.users, user, key, now, utils.Clone(utils.ErrBadRequest, map[string]string{"currentPassword": "incorrect"}, nil))
+	}
+	s.attempts.Reset(key)
+	if req.CurrentPassword == req.NewPassword {
+		return utils.Clone(utils.ErrBadRequest, map[string]string{"newPassword": "must differ from the current password"}, nil)
+	}
+	if err := checkPasswordPolicy(s.policy, "newPassword", req.NewPassword); err != nil {
+		return err
+	}
+
+	hash, err := s.hasher.Hash(req.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	return s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		if err := s.users.UpdatePassword(ctx, user.ID, hash, now); err != nil {
+			return err
+		}
+		if err := s.resets.InvalidateByUser(ctx, user.ID, now); err != nil {
+			return err
+		}
+		return s.revocations.RevokeUser(ctx, user.ID)
+	})
+}
+
+// IssueReset creates a one-time reset token for an active user on behalf of
+// an admin. Outstanding reset tokens of the user are invalidated.
+func (s *PasswordService) IssueReset(ctx context.Context, userID string) (*dto.PasswordResetResponse, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, utils.Clone(utils.ErrNotFound, map[string]string{"id": userID}, err)
+		}
+		return nil, err
+	}
+	if user.Status != entity.UserStatusActive {
+		return nil, utils.Clone(utils.ErrBadRequest, map[string]string{"id": "user is not active"}, nil)
+	}
+
+	token, err := auth.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	record := &entity.PasswordResetToken{
+		ID:        utils.NewID(),
+		UserID:    userID,
+		TokenHash: auth.HashToken(token),
+		CreatedBy: utils.UserIDFromContext(ctx),
+		ExpiresAt: now.Add(s.resetTTL),
+		CreatedAt: now,
+	}
+
+	err = s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		if err := s.resets.InvalidateByUser(ctx, userID, now); err != nil {
+			return err
+		}
+		return s.resets.Create(ctx, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.PasswordResetResponse{ResetToken: token, ExpiresAt: record.ExpiresAt}, nil
+}
+
+// ResetPassword consumes a reset token and sets a new password. Tokens of
+// disabled or deleted users are refused. The lockout is cleared and every
+// token issued before the reset is revoked.
+func (s *PasswordService) ResetPassword(ctx context.Context, req dto.ResetPasswordRequest) error {
+	if err := checkPasswordPolicy(s.policy, "newPassword", req.NewPassword); err != nil {
+		return err
+	}
+
+	hash, err := s.hasher.Hash(req.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	return s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		reset, err := s.resets.GetByHash(ctx, auth.HashToken(req.Token))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return utils.Clone(utils.ErrBadRequest, map[string]string{"token": "invalid"}, err)
+			}
+			return err
+		}
+
+		now := time.Now().UTC()
+		if !now.Before(reset.ExpiresAt) {
+			return utils.Clone(utils.ErrBadRequest, map[string]string{"token": "expired"}, nil)
+		}
+		user, err := s.users.GetByID(ctx, reset.UserID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if err != nil || user.Status != entity.UserStatusActive {
+			return utils.Clone(utils.ErrBadRequest, map[string]string{"token": "invalid"}, err)
+		}
+		used, err := s.resets.MarkUsed(ctx, reset.ID, now)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return utils.Clone(utils.ErrBadRequest, map[string]string{"token": "already used"}, nil)
+		}
+
+		if err := s.users.UpdatePassword(ctx, reset.UserID, hash, now); err != nil {
+			return err
+		}
+		if err := s.users.SetLockedUntil(ctx, reset.UserID, sql.NullTime{}); err != nil {
+			return err
+		}
+		return s.revocations.RevokeUser(ctx, reset.UserID)
+	})
+}
+
+// checkPasswordPolicy converts policy violations into a bad request error on field.
+func checkPasswordPolicy(policy *auth.PasswordPolicy, field, password string) error {
+	if violations := policy.Check(password); len(violations) > 0 {
+		return utils.Clone(utils.ErrBadRequest, map[string]string{field: strings.Join(violations, "; ")}, nil)
+	}
+	return nil
+}
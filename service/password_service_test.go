@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin/binding"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/dto"
+	"liangxiong/demo/internal/config"
+	"liangxiong/demo/model/entity"
+	"liangxiong/demo/repository"
+	"liangxiong/demo/utils"
+)
+
+// newTestPasswordService returns a PasswordService over sqlmock and a hash
+// of the password "Secret123!". Two wrong current passwords lock the account.
+func newTestPasswordService(t *testing.T) (*PasswordService, sqlmock.Sqlmock, string) {
+	t.Helper()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	hasher, err := auth.NewPasswordHasher(config.PasswordHashConfig{Algorithm: auth.HashArgon2id, Argon2: config.Argon2Config{Memory: 64, Time: 1}})
+	if err != nil {
+		t.Fatalf("hasher: %v", err)
+	}
+	hash, err := hasher.Hash("Secret123!")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+
+	policy, err := auth.NewPasswordPolicy(config.PasswordPolicyConfig{MinLength: 8}, hasher)
+	if err != nil {
+		t.Fatalf("policy: %v", err)
+	}
+	attempts := auth.NewLoginAttemptTracker(config.LockoutConfig{MaxFailedAttempts: 2, LockoutDuration: time.Hour, BaseBackoff: time.Nanosecond, MaxBackoff: time.Nanosecond})
+	revocations := NewRevocationService(db, repository.NewRevocationRepository(db), repository.NewRefreshTokenRepository(db), time.Minute)
+	svc := NewPasswordService(db, repository.NewUserRepository(db), repository.NewPasswordResetRepository(db), revocations, attempts, policy, hasher, time.Hour)
+	return svc, mock, hash
+}
+
+func TestChangePassword(t *testing.T) {
+	svc, mock, hash := newTestPasswordService(t)
+	ctx := utils.WithUserID(context.Background(), "user-1")
+	userRow := func(lockedUntil ...any) *sqlmock.Rows {
+		now := time.Now()
+		locked := any(nil)
+		if len(lockedUntil) > 0 {
+			locked = lockedUntil[0]
+		}
+		return sqlmock.NewRows(userColumns).AddRow("user-1", "alice", "alice@example.org", hash, "Alice", "L", auth.RoleViewer, now, now, locked, entity.UserStatusActive, nil, nil, []byte{1})
+	}
+	var appErr *utils.AppError
+
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow())
+	if err := svc.ChangePassword(ctx, dto.ChangePasswordRequest{CurrentPassword: "Secret124!", NewPassword: "Changed123!"}); !errors.As(err, &appErr) || appErr.Code != utils.ErrBadRequest.Code {
+		t.Fatalf("expected a wrong current password to be rejected, got %v", err)
+	}
+
+	// The new hash, the reset tokens and the token revocation are written in
+	// one transaction.
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow())
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE users SET password_hash = @p1, updated_at = @p2`)).
+		WithArgs(argon2idHash{}, sqlmock.AnyArg(), "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE password_reset_tokens SET used_at = @p1 WHERE user_id = @p2`)).
+		WithArgs(sqlmock.AnyArg(), "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`SAVE TRANSACTION sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`MERGE user_token_revocations`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM user_token_revocations`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "revoked_before", "generation"}).AddRow("user-1", time.Now(), 1))
+	mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := svc.ChangePassword(ctx, dto.ChangePasswordRequest{CurrentPassword: "Secret123!", NewPassword: "Changed123!"}); err != nil {
+		t.Fatalf("change password: %v", err)
+	}
+
+	// Failing to revoke the tokens rolls the password change back.
+	boom := errors.New("boom")
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow())
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users SET password_hash`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE password_reset_tokens SET used_at`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`SAVE TRANSACTION sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`MERGE user_token_revocations`).WillReturnError(boom)
+	mock.ExpectExec(`ROLLBACK TRANSACTION sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if err := svc.ChangePassword(ctx, dto.ChangePasswordRequest{CurrentPassword: "Secret123!", NewPassword: "Changed123!"}); !errors.Is(err, boom) {
+		t.Fatalf("expected the revocation failure, got %v", err)
+	}
+
+	// Wrong current passwords lock the account like failed logins.
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow())
+	if err := svc.ChangePassword(ctx, dto.ChangePasswordRequest{CurrentPassword: "Secret124!", NewPassword: "Changed123!"}); !errors.As(err, &appErr) || appErr.Code != utils.ErrBadRequest.Code {
+		t.Fatalf("expected a wrong current password to be rejected, got %v", err)
+	}
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow())
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE users SET locked_until = @p1`)).
+		WithArgs(sqlmock.AnyArg(), "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := svc.ChangePassword(ctx, dto.ChangePasswordRequest{CurrentPassword: "Secret124!", NewPassword: "Changed123!"}); !errors.As(err, &appErr) || appErr.Code != utils.ErrLocked.Code {
+		t.Fatalf("expected the account to be locked, got %v", err)
+	}
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow(time.Now().Add(time.Hour)))
+	if err := svc.ChangePassword(ctx, dto.ChangePasswordRequest{CurrentPassword: "Secret123!", NewPassword: "Changed123!"}); !errors.As(err, &appErr) || appErr.Code != utils.ErrLocked.Code {
+		t.Fatalf("expected a locked account to be refused, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	svc, mock, _ := newTestPasswordService(t)
+	ctx := context.Background()
+	token := "reset-token"
+	resetColumns := []string{"id", "user_id", "token_hash", "created_by", "expires_at", "created_at", "used_at"}
+	resetRow := func(expiresAt time.Time) *sqlmock.Rows {
+		return sqlmock.NewRows(resetColumns).AddRow("reset-1", "user-1", auth.HashToken(token), "admin-1", expiresAt, time.Now().Add(-time.Minute), nil)
+	}
+	userRow := func(status string) *sqlmock.Rows {
+		now := time.Now()
+		return sqlmock.NewRows(userColumns).AddRow("user-1", "alice", "alice@example.org", "hash", "Alice", "L", auth.RoleViewer, now, now, nil, status, nil, nil, []byte{1})
+	}
+	rejected := func(err error, reason string) bool {
+		var appErr *utils.AppError
+		if !errors.As(err, &appErr) || appErr.Code != utils.ErrBadRequest.Code {
+			return false
+		}
+		details, _ := appErr.Details.(map[string]string)
+		return details["token"] == reason
+	}
+	req := dto.ResetPasswordRequest{Token: token, NewPassword: "Changed123!"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM password_reset_tokens WHERE token_hash = @p1`).WithArgs(auth.HashToken(token)).WillReturnRows(resetRow(time.Now().Add(-time.Second)))
+	mock.ExpectRollback()
+	if err := svc.ResetPassword(ctx, req); !rejected(err, "expired") {
+		t.Fatalf("expected an expired token to be rejected, got %v", err)
+	}
+
+	// Disabled and deleted users cannot get a new password.
+	for _, status := range []string{entity.UserStatusDisabled, entity.UserStatusDeleted} {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`FROM password_reset_tokens WHERE token_hash = @p1`).WithArgs(auth.HashToken(token)).WillReturnRows(resetRow(time.Now().Add(time.Hour)))
+		mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow(status))
+		mock.ExpectRollback()
+		if err := svc.ResetPassword(ctx, req); !rejected(err, "invalid") {
+			t.Fatalf("expected the token of a %s user to be rejected, got %v", status, err)
+		}
+	}
+
+	// A token consumed concurrently is not used twice.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM password_reset_tokens WHERE token_hash = @p1`).WithArgs(auth.HashToken(token)).WillReturnRows(resetRow(time.Now().Add(time.Hour)))
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow(entity.UserStatusActive))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE password_reset_tokens SET used_at = @p1 WHERE id = @p2 AND used_at IS NULL`)).
+		WithArgs(sqlmock.AnyArg(), "reset-1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if err := svc.ResetPassword(ctx, req); !rejected(err, "already used") {
+		t.Fatalf("expected a used token to be rejected, got %v", err)
+	}
+
+	// Consuming the token, setting the password, clearing the lockout and
+	// revoking the tokens commit together. Under argon2id, passwords longer
+	// than bcrypt's 72 bytes pass both the request binding and the policy.
+	req.NewPassword = "Changed123!" + strings.Repeat("é", 70)
+	if err := binding.Validator.ValidateStruct(req); err != nil {
+		t.Fatalf("expected the long password to bind, got %v", err)
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM password_reset_tokens WHERE token_hash = @p1`).WithArgs(auth.HashToken(token)).WillReturnRows(resetRow(time.Now().Add(time.Hour)))
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow(entity.UserStatusActive))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE password_reset_tokens SET used_at = @p1 WHERE id = @p2 AND used_at IS NULL`)).
+		WithArgs(sqlmock.AnyArg(), "reset-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE users SET password_hash = @p1`)).
+		WithArgs(argon2idHash{}, sqlmock.AnyArg(), "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE users SET locked_until = @p1`)).
+		WithArgs(nil, "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`SAVE TRANSACTION sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`MERGE user_token_revocations`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM user_token_revocations`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "revoked_before", "generation"}).AddRow("user-1", time.Now(), 1))
+	mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := svc.ResetPassword(ctx, req); err != nil {
+		t.Fatalf("reset password: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
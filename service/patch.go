@@ -0,0 +1,73 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+
+	"github.com/gin-gonic/gin/binding"
+
+	"liangxiong/demo/utils"
+)
+
+// applyPatch applies a JSON Merge Patch or JSON Patch, chosen by contentType,
+// to the JSON form of current and decodes the result into target, which must
+// pass the same binding rules as a full update. Plain application/json is
+// read as a merge patch. It returns the JSON names of the fields whose value
+// changed, sorted.
+func applyPatch(contentType string, patch []byte, current, target any) ([]string, error) {
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+
+	var patched []byte
+	switch contentType {
+	case utils.MergePatchType, binding.MIMEJSON:
+		patched, err = utils.ApplyMergePatch(doc, patch)
+	case utils.JSONPatchType:
+		patched, err = utils.ApplyJSONPatch(doc, patch)
+	default:
+		return nil, utils.Clone(utils.ErrMediaType, map[string]string{"Content-Type": "must be " + utils.MergePatchType + " or " + utils.JSONPatchType}, nil)
+	}
+	if err != nil {
+		return nil, utils.Clone(utils.ErrBadRequest, map[string]string{"patch": err.Error()}, err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		return nil, utils.Clone(utils.ErrBadRequest, map[string]string{"patch": err.Error()}, err)
+	}
+	if err := binding.Validator.ValidateStruct(target); err != nil {
+		return nil, utils.Clone(utils.ErrBadRequest, utils.ValidationDetails(err), err)
+	}
+	return changedFields(current, target)
+}
+
+// changedFields compares the top-level JSON fields of two values.
+func changedFields(before, after any) ([]string, error) {
+	var b, a map[string]any
+	for _, v := range []struct {
+		value any
+		dest  *map[string]any
+	}{{before, &b}, {after, &a}} {
+		raw, err := json.Marshal(v.value)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, v.dest); err != nil {
+			return nil, err
+		}
+	}
+
+	var fields []string
+	for field, value := range a {
+		if !reflect.DeepEqual(b[field], value) {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"liangxiong/demo/dto"
+	"liangxiong/demo/utils"
+)
+
+func TestApplyPatch(t *testing.T) {
+	description := "CME Globex"
+	current := dto.ExchangeUpdateRequest{ClearExchangeCode: "CME", GlobexExchangeCode: "XCME", Description: &description, SegType: "F"}
+
+	cases := []struct {
+		name        string
+		contentType string
+		patch       string
+		fields      []string
+		code        int
+	}{
+		{"merge changes one field", utils.MergePatchType, `{"segType":"O"}`, []string{"segType"}, 0},
+		{"merge null clears optional field", utils.MergePatchType, `{"description":null}`, []string{"description"}, 0},
+		{"merge without changes", "application/json", `{"segType":"F"}`, nil, 0},
+		{"merge null on required field fails validation", utils.MergePatchType, `{"segType":null}`, nil, utils.ErrBadRequest.Code},
+		{"merge unknown field", utils.MergePatchType, `{"mqmExchangeCode":"X"}`, nil, utils.ErrBadRequest.Code},
+		{"json patch", utils.JSONPatchType, `[{"op":"test","path":"/segType","value":"F"},{"op":"replace","path":"/segType","value":"O"},{"op":"copy","from":"/clearExchangeCode","path":"/globexExchangeCode"}]`, []string{"globexExchangeCode", "segType"}, 0},
+		{"json patch failed test", utils.JSONPatchType, `[{"op":"test","path":"/segType","value":"O"},{"op":"replace","path":"/segType","value":"O"}]`, nil, utils.ErrBadRequest.Code},
+		{"json patch removes required field", utils.JSONPatchType, `[{"op":"remove","path":"/clearExchangeCode"}]`, nil, utils.ErrBadRequest.Code},
+		{"unsupported media type", "text/plain", `{}`, nil, utils.ErrMediaType.Code},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var target dto.ExchangeUpdateRequest
+			fields, err := applyPatch(tc.contentType, []byte(tc.patch), current, &target)
+			if tc.code != 0 {
+				var appErr *utils.AppError
+				if !errors.As(err, &appErr) || appErr.Code != tc.code {
+					t.Fatalf("expected error code %d, got %v", tc.code, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(fields, tc.fields) {
+				t.Fatalf("expected changed fields %v, got %v", tc.fields, fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package service
+
+import (
+	"liangxiong/demo/internal/dialect"
+	"liangxiong/demo/utils"
+)
+
+// checkIfMatch enforces an If-Match precondition against the row version the
+// service read. An empty header skips the check; the controller decides
+// whether the header is required.
+func checkIfMatch(ifMatch string, version []byte) error {
+	if ifMatch != "" && !utils.ETagMatches(ifMatch, utils.ETag(version), false) {
+		return staleVersionError()
+	}
+	return nil
+}
+
+// staleVersionError reports that the resource changed since the client read it.
+func staleVersionError() error {
+	return utils.Clone(utils.ErrPrecondition, map[string]string{"If-Match": "resource has been modified"}, nil)
+}
+
+// conflictError reports a unique key violation as a conflict on the fields
+// of details, and returns other errors unchanged.
+func conflictError(err error, details map[string]string) error {
+	if dialect.Classify(err) == dialect.ErrorUniqueViolation {
+		return utils.Clone(utils.ErrConflict, details, err)
+	}
+	return err
+}
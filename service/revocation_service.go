@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"liangxiong/demo/model/entity"
+	"liangxiong/demo/repository"
+)
+
+const defaultRevocationCacheTTL = 30 * time.Second
+
+// revokedTokenPurgeInterval spaces out the deletion of revoked JWT IDs whose
+// tokens have expired.
+const revokedTokenPurgeInterval = time.Hour
+
+type revocationCacheEntry struct {
+	revoked   bool
+	expiresAt time.Time
+}
+
+type userCutoffCacheEntry struct {
+	cutoff     time.Time
+	generation int64
+	expiresAt  time.Time
+}
+
+// RevocationService decides whether access tokens have been revoked.
+// Lookups are cached in-process for cacheTTL, so revocations performed by
+// another instance take effect within that window; local revocations apply immediately.
+type RevocationService struct {
+	tx       *repository.TxManager
+	repo     repository.RevocationRepository
+	refresh  repository.RefreshTokenRepository
+	cacheTTL time.Duration
+
+	mu        sync.RWMutex
+	tokens    map[string]revocationCacheEntry
+	users     map[string]userCutoffCacheEntry
+	lastPrune time.Time
+	lastPurge time.Time
+}
+
+// NewRevocationService constructs the service.
+func NewRevocationService(db *sql.DB, repo repository.RevocationRepository, refresh repository.RefreshTokenRepository, cacheTTL time.Duration) *RevocationService {
+	if cacheTTL <= 0 {
+		cacheTTL = defaultRevocationCacheTTL
+	}
+	return &RevocationService{
+		tx:       repository.NewTxManager(db),
+		repo:     repo,
+		refresh:  refresh,
+		cacheTTL: cacheTTL,
+		tokens:   make(map[string]revocationCacheEntry),
+		users:    make(map[string]userCutoffCacheEntry),
+	}
+}
+
+// IsRevoked reports whether the token identified by jti, or every token of
+// the user issued up to a cutoff, has been revoked. generation is the one the
+// token was issued with.
+func (s *RevocationService) IsRevoked(ctx context.Context, jti, userID string, generation int64, issuedAt, expiresAt time.Time) (bool, error) {
+	now := time.Now()
+	s.pruneIfDue(now)
+
+	entry, err := s.userCutoff(ctx, userID, now)
+	if err != nil {
+		return false, err
+	}
+	if entry.revokes(generation, issuedAt) {
+		return true, nil
+	}
+
+	if jti == "" {
+		return false, nil
+	}
+
+	s.mu.RLock()
+	cached, ok := s.tokens[jti]
+	s.mu.RUnlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.revoked, nil
+	}
+
+	revoked, err := s.repo.IsTokenRevoked(ctx, jti)
+	if err != nil {
+		return false, err
+	}
+
+	cached = revocationCacheEntry{revoked: revoked, expiresAt: now.Add(s.cacheTTL)}
+	if revoked {
+		cached.expiresAt = expiresAt
+	}
+	s.mu.Lock()
+	s.tokens[jti] = cached
+	s.mu.Unlock()
+	return revoked, nil
+}
+
+// RevokeToken revokes a single access token until it expires. At most once
+// per revokedTokenPurgeInterval, it also deletes the records of revoked
+// tokens that have since expired.
+func (s *RevocationService) RevokeToken(ctx context.Context, jti, userID string, expiresAt time.Time) error {
+	now := time.Now().UTC()
+	if err := s.purgeIfDue(ctx, now); err != nil {
+		return err
+	}
+
+	record := &entity.RevokedToken{
+		JTI:       jti,
+		UserID:    userID,
+		ExpiresAt: expiresAt.UTC(),
+		RevokedAt: now,
+	}
+	if err := s.repo.RevokeToken(ctx, record); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.tokens[jti] = revocationCacheEntry{revoked: true, expiresAt: expiresAt}
+	s.mu.Unlock()
+	return nil
+}
+
+// RedeemToken revokes a single-use token such as an MFA challenge, within
+// the caller's transaction. Redeeming it again, even concurrently, fails
+// with a unique violation. The local cache is left alone, so a rolled-back
+// redemption does not block the token.
+func (s *RevocationService) RedeemToken(ctx context.Context, jti, userID string, expiresAt time.Time) error {
+	now := time.Now().UTC()
+	if err := s.purgeIfDue(ctx, now); err != nil {
+		return err
+	}
+	return s.repo.RevokeTokenOnce(ctx, &entity.RevokedToken{
+		JTI:       jti,
+		UserID:    userID,
+		ExpiresAt: expiresAt.UTC(),
+		RevokedAt: now,
+	})
+}
+
+// RevokeUser revokes every access and refresh token issued to the user so
+// far. JWT iat has second precision, so the cutoff is the current second and
+// the user's generation is advanced with it: tokens issued within that second
+// afterwards carry the new generation and stay valid.
+//
+// Called within a transaction, it joins it; the local cache takes the new
+// cutoff once that transaction commits.
+func (s *RevocationService) RevokeUser(ctx context.Context, userID string) error {
+	now := time.Now().UTC()
+	return s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		if err := s.repo.RevokeUser(ctx, &entity.UserTokenRevocation{UserID: userID, RevokedBefore: now.Truncate(time.Second)}); err != nil {
+			return err
+		}
+		rev, err := s.repo.GetUserRevocation(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if err := s.refresh.RevokeByUser(ctx, userID, now); err != nil {
+			return err
+		}
+
+		repository.AfterCommit(ctx, func() {
+			s.mu.Lock()
+			s.users[userID] = userCutoffCacheEntry{cutoff: rev.RevokedBefore, generation: rev.Generation, expiresAt: time.Now().Add(s.cacheTTL)}
+			s.mu.Unlock()
+		})
+		return nil
+	})
+}
+
+// Generation returns the user's revocation generation, to be embedded in the
+// tokens issued to them. It is read from the database rather than the cache,
+// so that a token issued right after a revocation by another instance does
+// not fall within its cutoff.
+func (s *RevocationService) Generation(ctx context.Context, userID string) (int64, error) {
+	if s == nil {
+		return 0, nil
+	}
+	rev, err := s.repo.GetUserRevocation(ctx, userID)
+	switch {
+	case err == nil:
+		return rev.Generation, nil
+	case errors.Is(err, sql.ErrNoRows):
+		return 0, nil
+	default:
+		return 0, err
+	}
+}
+
+func (s *RevocationService) userCutoff(ctx context.Context, userID string, now time.Time) (userCutoffCacheEntry, error) {
+	s.mu.RLock()
+	entry, ok := s.users[userID]
+	s.mu.RUnlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry, nil
+	}
+
+	entry = userCutoffCacheEntry{expiresAt: now.Add(s.cacheTTL)}
+	rev, err := s.repo.GetUserRevocation(ctx, userID)
+	switch {
+	case err == nil:
+		entry.cutoff, entry.generation = rev.RevokedBefore, rev.Generation
+	case !errors.Is(err, sql.ErrNoRows):
+		return userCutoffCacheEntry{}, err
+	}
+
+	s.mu.Lock()
+	s.users[userID] = entry
+	s.mu.Unlock()
+	return entry, nil
+}
+
+// revokes reports whether the cutoff revokes a token issued at issuedAt with
+// the given generation: every token of an earlier second, and the tokens of
+// the cutoff's second issued before the revocation.
+func (e userCutoffCacheEntry) revokes(generation int64, issuedAt time.Time) bool {
+	if e.cutoff.IsZero() {
+		return false
+	}
+	issued := issuedAt.Truncate(time.Second)
+	return issued.Before(e.cutoff) || issued.Equal(e.cutoff) && generation < e.generation
+}
+
+// purgeIfDue deletes expired revoked tokens at most once per
+// revokedTokenPurgeInterval.
+func (s *RevocationService) purgeIfDue(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	due := now.Sub(s.lastPurge) >= revokedTokenPurgeInterval
+	if due {
+		s.lastPurge = now
+	}
+	s.mu.Unlock()
+	if !due {
+		return nil
+	}
+
+	_, err := s.repo.PurgeExpiredTokens(ctx, now)
+	return err
+}
+
+// pruneIfDue drops expired cache entries at most once per cacheTTL.
+func (s *RevocationService) pruneIfDue(now time.Time) {
+	s.mu.RLock()
+	due := now.Sub(s.lastPrune) >= s.cacheTTL
+	s.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastPrune = now
+	for jti, entry := range s.tokens {
+		if !now.Before(entry.expiresAt) {
+			delete(s.tokens, jti)
+		}
+	}
+	for userID, entry := range s.users {
+		if !now.Before(entry.expiresAt) {
+			delete(s.users, userID)
+		}
+	}
+}
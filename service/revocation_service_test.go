@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/internal/config"
+	"liangxiong/demo/repository"
+)
+
+func TestRevocationServiceCachesLookups(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	svc := NewRevocationService(db, repository.NewRevocationRepository(db), repository.NewRefreshTokenRepository(db), time.Minute)
+	issuedAt := time.Now().Add(-time.Minute)
+	expiresAt := time.Now().Add(time.Hour)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT user_id, revoked_before, generation FROM user_token_revocations WHERE user_id = @p1`)).
+		WithArgs("user-1").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(1) FROM revoked_tokens WHERE jti = @p1`)).
+		WithArgs("jti-1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM revoked_tokens WHERE expires_at <= @p1`)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta(`MERGE revoked_tokens`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	for i := 0; i < 2; i++ {
+		revoked, err := svc.IsRevoked(context.Background(), "jti-1", "user-1", 0, issuedAt, expiresAt)
+		if err != nil || revoked {
+			t.Fatalf("lookup %d: expected active token, got %v %v", i, revoked, err)
+		}
+	}
+
+	if err := svc.RevokeToken(context.Background(), "jti-1", "user-1", expiresAt); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+
+	revoked, err := svc.IsRevoked(context.Background(), "jti-1", "user-1", 0, issuedAt, expiresAt)
+	if err != nil || !revoked {
+		t.Fatalf("expected revoked token, got %v %v", revoked, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRevokeUserCutoffPrecision(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	jwtManager, err := auth.NewJWTManager(config.AuthConfig{JWTSecret: "secret", Issuer: "test", Audience: "test", AccessTokenTTL: time.Minute, Algorithm: "HS256"})
+	if err != nil {
+		t.Fatalf("jwt manager: %v", err)
+	}
+	svc := NewRevocationService(db, repository.NewRevocationRepository(db), repository.NewRefreshTokenRepository(db), time.Minute)
+	ctx := context.Background()
+	issue := func(generation int64) *auth.Claims {
+		t.Helper()
+		token, _, err := jwtManager.Generate(auth.Identity{UserID: "user-1", Role: auth.RoleViewer, Generation: generation})
+		if err != nil {
+			t.Fatalf("generate: %v", err)
+		}
+		claims, err := jwtManager.Validate(token)
+		if err != nil {
+			t.Fatalf("validate: %v", err)
+		}
+		return claims
+	}
+	revocationRows := func(cutoff time.Time, generation int64) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"user_id", "revoked_before", "generation"}).AddRow("user-1", cutoff, generation)
+	}
+
+	before := issue(0)
+	if before.IssuedAt.Nanosecond() != 0 {
+		t.Fatalf("expected iat in whole seconds, got %v", before.IssuedAt.Time)
+	}
+	cutoff := time.Now().UTC().Truncate(time.Second)
+	mock.ExpectBegin()
+	mock.ExpectExec(`MERGE user_token_revocations`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM user_token_revocations`).WithArgs("user-1").WillReturnRows(revocationRows(cutoff, 1))
+	mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := svc.RevokeUser(ctx, "user-1"); err != nil {
+		t.Fatalf("revoke user: %v", err)
+	}
+	if entry := svc.users["user-1"]; !entry.cutoff.Equal(cutoff) || entry.generation != 1 {
+		t.Fatalf("expected the committed cutoff to be cached, got %+v", entry)
+	}
+
+	// A token issued right after the revocation, as on an immediate
+	// re-login, carries the new generation and stays valid without waiting
+	// for the next second.
+	mock.ExpectQuery(`FROM user_token_revocations`).WithArgs("user-1").WillReturnRows(revocationRows(cutoff, 1))
+	generation, err := svc.Generation(ctx, "user-1")
+	if err != nil || generation != 1 {
+		t.Fatalf("expected the new generation, got %d %v", generation, err)
+	}
+	after := issue(generation)
+
+	revoked, err := svc.IsRevoked(ctx, "", "user-1", 0, cutoff, cutoff.Add(time.Minute))
+	if err != nil || !revoked {
+		t.Fatalf("expected an older token of the cutoff's second to be revoked, got %v %v", revoked, err)
+	}
+	revoked, err = svc.IsRevoked(ctx, "", "user-1", 1, cutoff, cutoff.Add(time.Minute))
+	if err != nil || revoked {
+		t.Fatalf("expected a newer token of the cutoff's second to stay valid, got %v %v", revoked, err)
+	}
+	revoked, err = svc.IsRevoked(ctx, "", "user-1", 1, cutoff.Add(-time.Second), cutoff.Add(time.Minute))
+	if err != nil || !revoked {
+		t.Fatalf("expected a token of an earlier second to be revoked, got %v %v", revoked, err)
+	}
+	revoked, err = svc.IsRevoked(ctx, before.ID, "user-1", before.Generation, before.IssuedAt.Time, before.ExpiresAt.Time)
+	if err != nil || !revoked {
+		t.Fatalf("expected the earlier token to be revoked, got %v %v", revoked, err)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(1) FROM revoked_tokens WHERE jti = @p1`)).
+		WithArgs(after.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	revoked, err = svc.IsRevoked(ctx, after.ID, "user-1", after.Generation, after.IssuedAt.Time, after.ExpiresAt.Time)
+	if err != nil || revoked {
+		t.Fatalf("expected the later token to stay valid, got %v %v", revoked, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRevokeUserCachesOnlyCommittedCutoffs(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	svc := NewRevocationService(db, repository.NewRevocationRepository(db), repository.NewRefreshTokenRepository(db), time.Minute)
+	boom := errors.New("boom")
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SAVE TRANSACTION sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`MERGE user_token_revocations`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM user_token_revocations`).WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "revoked_before", "generation"}).AddRow("user-1", time.Now().UTC().Truncate(time.Second), 1))
+	mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+	err = repository.NewTxManager(db).WithinTx(context.Background(), nil, func(ctx context.Context) error {
+		if err := svc.RevokeUser(ctx, "user-1"); err != nil {
+			return err
+		}
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected the caller's error, got %v", err)
+	}
+	if entry, ok := svc.users["user-1"]; ok {
+		t.Fatalf("expected a rolled back revocation to stay out of the cache, got %+v", entry)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
 
 	"liangxiong/demo/auth"
@@ -13,35 +14,67 @@ import (
 	"liangxiong/demo/utils"
 )
 
+// serializable isolates the existence check of a creation from concurrent
+// inserts of the same key until the transaction ends.
+var serializable = &sql.TxOptions{Isolation: sql.LevelSerializable}
+
 // UserService exposes application use cases for users.
 type UserService struct {
-	repo repository.UserRepository
-	db   *sql.DB
+	repo        repository.UserRepository
+	resets      repository.PasswordResetRepository
+	tx          *repository.TxManager
+	policy      *auth.PasswordPolicy
+	hasher      *auth.PasswordHasher
+	cursors     *utils.CursorCodec
+	revocations *RevocationService
 }
 
 // NewUserService constructs the service.
-func NewUserService(db *sql.DB, repo repository.UserRepository) *UserService {
-	return &UserService{db: db, repo: repo}
+func NewUserService(db *sql.DB, repo repository.UserRepository, resets repository.PasswordResetRepository, policy *auth.PasswordPolicy, hasher *auth.PasswordHasher, cursors *utils.CursorCodec, revocations *RevocationService) *UserService {
+	return &UserService{tx: repository.NewTxManager(db), repo: repo, resets: resets, policy: policy, hasher: hasher, cursors: cursors, revocations: revocations}
 }
 
-// ListUsers returns paginated list.
-func (s *UserService) ListUsers(ctx context.Context, page, size int) (*dto.UserListResponse, error) {
-	users, total, err := s.repo.List(ctx, page, size)
+// ListUsers returns a filtered, sorted page of users.
+func (s *UserService) ListUsers(ctx context.Context, query dto.UserListQuery) (*dto.UserListResponse, error) {
+	filter, err := userFilter(query)
+	if err != nil {
+		return nil, err
+	}
+	// The sort is checked against the cursor's own.
+	unsorted := filter
+	unsorted.Sort = nil
+	scope, err := utils.NewCursorScope("users", unsorted)
+	if err != nil {
+		return nil, err
+	}
+	page, sort, err := pageQuery(s.cursors, scope, query.Cursor, utils.FormatSort(filter.Sort), query.Page, query.Size, query.SkipTotal)
 	if err != nil {
 		return nil, err
 	}
+	if filter.Sort, err = utils.ParseSort(sort, repository.UserSortFields()); err != nil {
+		return nil, invalidCursorError(err)
+	}
+
+	result, err := s.repo.List(ctx, filter, page)
+	if err != nil {
+		return nil, listError(err)
+	}
 
-	items := make([]dto.UserResponse, 0, len(users))
-	for _, u := range users {
+	items := make([]dto.UserResponse, 0, len(result.Items))
+	for _, u := range result.Items {
 		items = append(items, mapUserToDTO(&u))
 	}
+	next, err := nextCursor(s.cursors, scope, sort, result.Next)
+	if err != nil {
+		return nil, err
+	}
 
-	return &dto.UserListResponse{Total: total, Items: items}, nil
+	return &dto.UserListResponse{Total: pageTotal(result.Total), Items: items, NextCursor: next}, nil
 }
 
 // GetUser fetches a single user.
 func (s *UserService) GetUser(ctx context.Context, id string) (*dto.UserResponse, error) {
-	user, err := s.repo.GetByID(ctx, nil, id)
+	user, err := s.repo.GetByID(ctx, id)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, utils.Clone(utils.ErrNotFound, map[string]string{"id": id}, err)
@@ -54,13 +87,14 @@ func (s *UserService) GetUser(ctx context.Context, id string) (*dto.UserResponse
 
 // CreateUser inserts a new user row.
 func (s *UserService) CreateUser(ctx context.Context, req dto.UserCreateRequest) (*dto.UserResponse, error) {
-	if _, err := s.repo.GetByUsername(ctx, nil, req.Username); err == nil {
-		return nil, utils.Clone(utils.ErrBadRequest, map[string]string{"username": "already exists"}, nil)
-	} else if !errors.Is(err, sql.ErrNoRows) {
+	if !auth.IsValidRole(req.Role) {
+		return nil, invalidRoleError()
+	}
+	if err := checkPasswordPolicy(s.policy, "password", req.Password); err != nil {
 		return nil, err
 	}
 
-	hash, err := auth.HashPassword(req.Password)
+	hash, err := s.hasher.Hash(req.Password)
 	if err != nil {
 		return nil, err
 	}
@@ -73,22 +107,72 @@ func (s *UserService) CreateUser(ctx context.Context, req dto.UserCreateRequest)
 		PasswordHash: hash,
 		FirstName:    req.FirstName,
 		LastName:     req.LastName,
-		Role:         req.Role,
+		Role:         auth.NormalizeRole(req.Role),
 		CreatedAt:    now,
 		UpdatedAt:    now,
+		Status:       entity.UserStatusActive,
 	}
 
-	tx, err := s.db.BeginTx(ctx, nil)
+	err = s.tx.RetryWithinTx(ctx, serializable, func(ctx context.Context) error {
+		exists := map[string]string{"username": "already exists"}
+		if _, err := s.repo.GetByUsername(ctx, req.Username); err == nil {
+			return utils.Clone(utils.ErrConflict, exists, nil)
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		return conflictError(s.repo.Create(ctx, user), exists)
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback()
 
-	if err := s.repo.Create(ctx, tx, user); err != nil {
-		return nil, err
+	resp := mapUserToDTO(user)
+	return &resp, nil
+}
+
+// UpdateUser updates selected fields if the user still matches ifMatch.
+func (s *UserService) UpdateUser(ctx context.Context, id, ifMatch string, req dto.UserUpdateRequest) (*dto.UserResponse, error) {
+	if !auth.IsValidRole(req.Role) {
+		return nil, invalidRoleError()
 	}
 
-	if err := tx.Commit(); err != nil {
+	var user *entity.User
+	err := s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		var err error
+		if user, err = s.repo.GetByID(ctx, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return utils.Clone(utils.ErrNotFound, map[string]string{"id": id}, err)
+			}
+			return err
+		}
+		// Deleted users are reported like unknown ones, as by DeleteUser.
+		if user.Status == entity.UserStatusDeleted {
+			return utils.Clone(utils.ErrNotFound, map[string]string{"id": id}, nil)
+		}
+		if err := checkIfMatch(ifMatch, user.Version); err != nil {
+			return err
+		}
+
+		role := auth.NormalizeRole(req.Role)
+		roleChanged := role != user.Role
+		user.Email = req.Email
+		user.FirstName = req.FirstName
+		user.LastName = req.LastName
+		user.Role = role
+		user.UpdatedAt = time.Now().UTC()
+
+		if ok, err := s.repo.Update(ctx, user); err != nil {
+			return err
+		} else if !ok {
+			return staleVersionError()
+		}
+		// Tokens carry the role, so those issued before the change must go.
+		if roleChanged {
+			return s.revocations.RevokeUser(ctx, user.ID)
+		}
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -96,33 +180,171 @@ func (s *UserService) CreateUser(ctx context.Context, req dto.UserCreateRequest)
 	return &resp, nil
 }
 
-// UpdateUser updates selected fields.
-func (s *UserService) UpdateUser(ctx context.Context, id string, req dto.UserUpdateRequest) (*dto.UserResponse, error) {
-	user, err := s.repo.GetByID(ctx, nil, id)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, utils.Clone(utils.ErrNotFound, map[string]string{"id": id}, err)
+// PatchUser applies a JSON Merge Patch or JSON Patch, as told by
+// contentType, to the fields of dto.UserUpdateRequest and writes only the
+// fields that changed. With ifMatch the user must still match it; without,
+// concurrent changes to other fields are kept.
+func (s *UserService) PatchUser(ctx context.Context, id, ifMatch, contentType string, patch []byte) (*dto.UserResponse, error) {
+	var user *entity.User
+	err := s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		var err error
+		if user, err = s.repo.GetByID(ctx, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return utils.Clone(utils.ErrNotFound, map[string]string{"id": id}, err)
+			}
+			return err
+		}
+		// Deleted users are reported like unknown ones, as by DeleteUser.
+		if user.Status == entity.UserStatusDeleted {
+			return utils.Clone(utils.ErrNotFound, map[string]string{"id": id}, nil)
+		}
+		if err := checkIfMatch(ifMatch, user.Version); err != nil {
+			return err
+		}
+
+		current := dto.UserUpdateRequest{Email: user.Email, FirstName: user.FirstName, LastName: user.LastName, Role: user.Role}
+		var req dto.UserUpdateRequest
+		fields, err := applyPatch(contentType, patch, current, &req)
+		if err != nil {
+			return err
+		}
+		if !auth.IsValidRole(req.Role) {
+			return invalidRoleError()
+		}
+		if len(fields) == 0 {
+			return nil
 		}
-		return nil, err
-	}
 
-	user.Email = req.Email
-	user.FirstName = req.FirstName
-	user.LastName = req.LastName
-	user.Role = req.Role
-	user.UpdatedAt = time.Now().UTC()
+		role := auth.NormalizeRole(req.Role)
+		roleChanged := role != user.Role
+		user.Email = req.Email
+		user.FirstName = req.FirstName
+		user.LastName = req.LastName
+		user.Role = role
+		user.UpdatedAt = time.Now().UTC()
 
-	tx, err := s.db.BeginTx(ctx, nil)
+		var version []byte
+		if ifMatch != "" {
+			version = user.Version
+		}
+		if ok, err := s.repo.Patch(ctx, user, fields, version); err != nil {
+			return err
+		} else if !ok {
+			if version == nil {
+				return utils.Clone(utils.ErrNotFound, map[string]string{"id": id}, nil)
+			}
+			return staleVersionError()
+		}
+		if roleChanged {
+			if err := s.revocations.RevokeUser(ctx, user.ID); err != nil {
+				return err
+			}
+		}
+		// Re-read so the response and its ETag include concurrent changes to
+		// the fields left alone.
+		user, err = s.repo.GetByID(ctx, id)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback()
 
-	if err := s.repo.Update(ctx, tx, user); err != nil {
-		return nil, err
+	resp := mapUserToDTO(user)
+	return &resp, nil
+}
+
+// DeleteUser soft-deletes a user: the row is kept with deleted_at and
+// deleted_by, and every token of the user is revoked.
+func (s *UserService) DeleteUser(ctx context.Context, id, ifMatch string) error {
+	_, err := s.changeStatus(ctx, id, ifMatch, func(user *entity.User, now time.Time) error {
+		if user.Status == entity.UserStatusDeleted {
+			return utils.Clone(utils.ErrNotFound, map[string]string{"id": id}, nil)
+		}
+		user.Status = entity.UserStatusDeleted
+		user.DeletedAt = sql.NullTime{Time: now, Valid: true}
+		user.DeletedBy = sql.NullString{String: utils.ActorIDFromContext(ctx), Valid: true}
+		return nil
+	})
+	return err
+}
+
+// DisableUser blocks an active user from signing in and revokes their tokens.
+func (s *UserService) DisableUser(ctx context.Context, id string) (*dto.UserResponse, error) {
+	return s.changeStatus(ctx, id, "", func(user *entity.User, _ time.Time) error {
+		if user.Status == entity.UserStatusDeleted {
+			return utils.Clone(utils.ErrBadRequest, map[string]string{"status": "user is deleted"}, nil)
+		}
+		user.Status = entity.UserStatusDisabled
+		return nil
+	})
+}
+
+// EnableUser reactivates a disabled user.
+func (s *UserService) EnableUser(ctx context.Context, id string) (*dto.UserResponse, error) {
+	return s.changeStatus(ctx, id, "", func(user *entity.User, _ time.Time) error {
+		if user.Status == entity.UserStatusDeleted {
+			return utils.Clone(utils.ErrBadRequest, map[string]string{"status": "user is deleted, restore it instead"}, nil)
+		}
+		user.Status = entity.UserStatusActive
+		return nil
+	})
+}
+
+// RestoreUser reactivates a soft-deleted user.
+func (s *UserService) RestoreUser(ctx context.Context, id string) (*dto.UserResponse, error) {
+	return s.changeStatus(ctx, id, "", func(user *entity.User, _ time.Time) error {
+		if user.Status != entity.UserStatusDeleted {
+			return utils.Clone(utils.ErrBadRequest, map[string]string{"status": "user is not deleted"}, nil)
+		}
+		user.Status = entity.UserStatusActive
+		user.DeletedAt = sql.NullTime{}
+		user.DeletedBy = sql.NullString{}
+		return nil
+	})
+}
+
+// changeStatus applies a status transition to another user than the caller,
+// provided the user still matches ifMatch. Tokens and outstanding password
+// resets are revoked in the same transaction whenever the user ends up
+// inactive.
+func (s *UserService) changeStatus(ctx context.Context, id, ifMatch string, apply func(user *entity.User, now time.Time) error) (*dto.UserResponse, error) {
+	if id == utils.ActorIDFromContext(ctx) {
+		return nil, utils.Clone(utils.ErrBadRequest, map[string]string{"id": "cannot change your own status"}, nil)
 	}
 
-	if err := tx.Commit(); err != nil {
+	var user *entity.User
+	err := s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		var err error
+		if user, err = s.repo.GetByID(ctx, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return utils.Clone(utils.ErrNotFound, map[string]string{"id": id}, err)
+			}
+			return err
+		}
+		if err := checkIfMatch(ifMatch, user.Version); err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		if err := apply(user, now); err != nil {
+			return err
+		}
+		user.UpdatedAt = now
+		if ok, err := s.repo.UpdateStatus(ctx, user); err != nil {
+			return err
+		} else if !ok {
+			return staleVersionError()
+		}
+
+		if user.Status != entity.UserStatusActive {
+			if err := s.resets.InvalidateByUser(ctx, user.ID, now); err != nil {
+				return err
+			}
+			return s.revocations.RevokeUser(ctx, user.ID)
+		}
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -130,30 +352,106 @@ func (s *UserService) UpdateUser(ctx context.Context, id string, req dto.UserUpd
 	return &resp, nil
 }
 
-// DeleteUser deletes by id.
-func (s *UserService) DeleteUser(ctx context.Context, id string) error {
-	if _, err := s.repo.GetByID(ctx, nil, id); err != nil {
+// GetProfile returns the authenticated caller with the role and permissions
+// carried by their token, which are what authorization checks use.
+func (s *UserService) GetProfile(ctx context.Context) (*dto.ProfileResponse, error) {
+	user, err := s.repo.GetByID(ctx, utils.UserIDFromContext(ctx))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return utils.Clone(utils.ErrNotFound, map[string]string{"id": id}, err)
+			return nil, utils.Clone(utils.ErrNotFound, map[string]string{"id": utils.UserIDFromContext(ctx)}, err)
 		}
-		return err
+		return nil, err
 	}
+	return mapProfileToDTO(ctx, user), nil
+}
 
-	tx, err := s.db.BeginTx(ctx, nil)
+// UpdateProfile lets the caller edit their own name and email.
+func (s *UserService) UpdateProfile(ctx context.Context, req dto.ProfileUpdateRequest) (*dto.ProfileResponse, error) {
+	id := utils.UserIDFromContext(ctx)
+	var user *entity.User
+	err := s.tx.RetryWithinTx(ctx, nil, func(ctx context.Context) error {
+		var err error
+		if user, err = s.repo.GetByID(ctx, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return utils.Clone(utils.ErrNotFound, map[string]string{"id": id}, err)
+			}
+			return err
+		}
+
+		if req.Email != nil {
+			user.Email = *req.Email
+		}
+		if req.FirstName != nil {
+			user.FirstName = *req.FirstName
+		}
+		if req.LastName != nil {
+			user.LastName = *req.LastName
+		}
+		user.UpdatedAt = time.Now().UTC()
+
+		if ok, err := s.repo.Update(ctx, user); err != nil {
+			return err
+		} else if !ok {
+			return staleVersionError()
+		}
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer tx.Rollback()
 
-	if err := s.repo.Delete(ctx, tx, id); err != nil {
-		return err
+	return mapProfileToDTO(ctx, user), nil
+}
+
+func mapProfileToDTO(ctx context.Context, u *entity.User) *dto.ProfileResponse {
+	role := utils.RoleFromContext(ctx)
+	return &dto.ProfileResponse{
+		UserResponse:  mapUserToDTO(u),
+		EffectiveRole: role,
+		Permissions:   auth.PermissionsForRole(role),
+	}
+}
+
+// userFilter validates the list query and converts it to a repository filter.
+func userFilter(query dto.UserListQuery) (repository.UserFilter, error) {
+	filter := repository.UserFilter{
+		Status:         query.Status,
+		UsernamePrefix: strings.TrimSpace(query.Username),
+		EmailPrefix:    strings.TrimSpace(query.Email),
+		Name:           strings.TrimSpace(query.Name),
+		CreatedFrom:    query.CreatedFrom,
+		CreatedTo:      query.CreatedTo,
+	}
+	switch query.Status {
+	case "":
+		filter.Status = entity.UserStatusActive
+	case "all":
+		filter.Status = ""
+	}
+	if query.Role != "" {
+		if !auth.IsValidRole(query.Role) {
+			return filter, invalidRoleError()
+		}
+		filter.Role = auth.NormalizeRole(query.Role)
+	}
+	if filter.CreatedFrom != nil && filter.CreatedTo != nil && !filter.CreatedFrom.Before(*filter.CreatedTo) {
+		return filter, utils.Clone(utils.ErrBadRequest, map[string]string{"createdTo": "must be after createdFrom"}, nil)
 	}
 
-	return tx.Commit()
+	sort, err := utils.ParseSort(query.Sort, repository.UserSortFields())
+	if err != nil {
+		return filter, utils.Clone(utils.ErrBadRequest, map[string]string{"sort": err.Error()}, err)
+	}
+	filter.Sort = sort
+	return filter, nil
+}
+
+func invalidRoleError() *utils.AppError {
+	return utils.Clone(utils.ErrBadRequest, map[string]string{"role": "must be one of " + strings.Join(auth.Roles(), ", ")}, nil)
 }
 
 func mapUserToDTO(u *entity.User) dto.UserResponse {
-	return dto.UserResponse{
+	resp := dto.UserResponse{
 		ID:        u.ID,
 		Username:  u.Username,
 		Email:     u.Email,
@@ -162,5 +460,17 @@ func mapUserToDTO(u *entity.User) dto.UserResponse {
 		Role:      u.Role,
 		CreatedAt: u.CreatedAt,
 		UpdatedAt: u.UpdatedAt,
+		Status:    u.Status,
+		ETag:      utils.ETag(u.Version),
+	}
+	if u.DeletedAt.Valid {
+		deletedAt := u.DeletedAt.Time
+		resp.DeletedAt = &deletedAt
+		resp.DeletedBy = u.DeletedBy.String
+	}
+	if u.LockedUntil.Valid && u.LockedUntil.Time.After(time.Now()) {
+		lockedUntil := u.LockedUntil.Time
+		resp.LockedUntil = &lockedUntil
 	}
+	return resp
 }
@@ -0,0 +1,373 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	mssql "github.com/microsoft/go-mssqldb"
+
+	"liangxiong/demo/auth"
+	"liangxiong/demo/dto"
+	"liangxiong/demo/internal/config"
+	"liangxiong/demo/model/entity"
+	"liangxiong/demo/repository"
+	"liangxiong/demo/utils"
+)
+
+// userColumns lists the users columns in scanUser order.
+var userColumns = []string{"id", "username", "email", "password_hash", "first_name", "last_name", "role", "created_at", "updated_at", "locked_until", "status", "deleted_at", "deleted_by", "row_version"}
+
+func TestUserStatusTransitions(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	users := repository.NewUserRepository(db)
+	revocations := NewRevocationService(db, repository.NewRevocationRepository(db), repository.NewRefreshTokenRepository(db), time.Minute)
+	svc := NewUserService(db, users, repository.NewPasswordResetRepository(db), nil, nil, nil, revocations)
+	ctx := utils.WithUserID(context.Background(), "admin-1")
+
+	userRow := func(status string) *sqlmock.Rows {
+		now := time.Now()
+		return sqlmock.NewRows(userColumns).AddRow("user-1", "alice", "alice@example.org", "hash", "Alice", "L", "viewer", now, now, nil, status, nil, nil, []byte{1})
+	}
+
+	// A stale If-Match is refused before anything is written.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow(entity.UserStatusActive))
+	mock.ExpectRollback()
+	var appErr *utils.AppError
+	if err := svc.DeleteUser(ctx, "user-1", `"02"`); !errors.As(err, &appErr) || appErr.Code != utils.ErrPrecondition.Code {
+		t.Fatalf("expected precondition failure, got %v", err)
+	}
+
+	// Deleting keeps the row, records who deleted it and revokes the tokens
+	// and password resets in the same transaction.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow(entity.UserStatusActive))
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE users SET status = @p1, deleted_at = @p2, deleted_by = @p3, updated_at = @p4 OUTPUT INSERTED.row_version WHERE id = @p5 AND row_version = @p6`)).
+		WithArgs(entity.UserStatusDeleted, sqlmock.AnyArg(), "admin-1", sqlmock.AnyArg(), "user-1", []byte{1}).
+		WillReturnRows(sqlmock.NewRows([]string{"row_version"}).AddRow([]byte{2}))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE password_reset_tokens SET used_at = @p1 WHERE user_id = @p2`)).
+		WithArgs(sqlmock.AnyArg(), "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`SAVE TRANSACTION sp_1`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`MERGE user_token_revocations`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM user_token_revocations`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "revoked_before", "generation"}).AddRow("user-1", time.Now(), 1))
+	mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := svc.DeleteUser(ctx, "user-1", `"01"`); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	// Only deleted users can be restored, and restoring clears the deletion.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow(entity.UserStatusDisabled))
+	mock.ExpectRollback()
+	if _, err := svc.RestoreUser(ctx, "user-1"); !errors.As(err, &appErr) || appErr.Code != utils.ErrBadRequest.Code {
+		t.Fatalf("expected restore of a disabled user to fail, got %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow(entity.UserStatusDeleted))
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE users SET status = @p1`)).
+		WithArgs(entity.UserStatusActive, nil, nil, sqlmock.AnyArg(), "user-1", []byte{1}).
+		WillReturnRows(sqlmock.NewRows([]string{"row_version"}).AddRow([]byte{2}))
+	mock.ExpectCommit()
+	resp, err := svc.RestoreUser(ctx, "user-1")
+	if err != nil || resp.Status != entity.UserStatusActive || resp.DeletedAt != nil || resp.ETag != `"02"` {
+		t.Fatalf("expected restored user, got %+v %v", resp, err)
+	}
+
+	if _, err := svc.DisableUser(ctx, "admin-1"); !errors.As(err, &appErr) || appErr.Code != utils.ErrBadRequest.Code {
+		t.Fatalf("expected self-disable to fail, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateUserReportsConflicts(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	hasher, err := auth.NewPasswordHasher(config.PasswordHashConfig{Algorithm: auth.HashArgon2id, Argon2: config.Argon2Config{Memory: 64, Time: 1}})
+	if err != nil {
+		t.Fatalf("hasher: %v", err)
+	}
+	policy, err := auth.NewPasswordPolicy(config.PasswordPolicyConfig{MinLength: 8}, hasher)
+	if err != nil {
+		t.Fatalf("policy: %v", err)
+	}
+	svc := NewUserService(db, repository.NewUserRepository(db), nil, policy, hasher, nil, nil)
+	req := dto.UserCreateRequest{Username: "alice", Email: "alice@example.org", Password: "Secret123!", Role: auth.RoleViewer}
+
+	// The existence check runs in the transaction of the insert.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM users WHERE username = @p1`).WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "password_hash", "first_name", "last_name", "role", "created_at", "updated_at", "locked_until", "status", "deleted_at", "deleted_by", "row_version"}).
+			AddRow("user-1", "alice", "alice@example.org", "hash", "", "", auth.RoleViewer, time.Now(), time.Now(), nil, entity.UserStatusActive, nil, nil, []byte{1}))
+	mock.ExpectRollback()
+	var appErr *utils.AppError
+	if _, err := svc.CreateUser(context.Background(), req); !errors.As(err, &appErr) || appErr.Code != utils.ErrConflict.Code {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+
+	// An insert racing past the check trips the unique index, also a conflict.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM users WHERE username = @p1`).WithArgs("alice").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`INSERT INTO users`).WillReturnError(mssql.Error{Number: 2601})
+	mock.ExpectRollback()
+	if _, err := svc.CreateUser(context.Background(), req); !errors.As(err, &appErr) || appErr.Code != utils.ErrConflict.Code {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateUserRejectsPasswordsTooLongForBcrypt(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	hasher, err := auth.NewPasswordHasher(config.PasswordHashConfig{})
+	if err != nil {
+		t.Fatalf("hasher: %v", err)
+	}
+	policy, err := auth.NewPasswordPolicy(config.PasswordPolicyConfig{MinLength: 8}, hasher)
+	if err != nil {
+		t.Fatalf("policy: %v", err)
+	}
+	svc := NewUserService(db, repository.NewUserRepository(db), nil, policy, hasher, nil, nil)
+
+	// 40 characters, but 80 bytes: refused before bcrypt sees it.
+	req := dto.UserCreateRequest{Username: "alice", Email: "alice@example.org", Password: strings.Repeat("é", 40), Role: auth.RoleViewer}
+	var appErr *utils.AppError
+	if _, err := svc.CreateUser(context.Background(), req); !errors.As(err, &appErr) || appErr.Code != utils.ErrBadRequest.Code {
+		t.Fatalf("expected a bad request, got %v", err)
+	}
+	if details, _ := appErr.Details.(map[string]string); details["password"] != "must be at most 72 bytes" {
+		t.Fatalf("expected a password field error, got %v", appErr.Details)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPatchUserReportsVanishedRows(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	svc := NewUserService(db, repository.NewUserRepository(db), nil, nil, nil, nil, nil)
+	ctx := utils.WithUserID(context.Background(), "admin-1")
+	userRow := func() *sqlmock.Rows {
+		now := time.Now()
+		return sqlmock.NewRows(userColumns).AddRow("user-1", "alice", "alice@example.org", "hash", "Alice", "L", auth.RoleViewer, now, now, nil, entity.UserStatusActive, nil, nil, []byte{1})
+	}
+	var appErr *utils.AppError
+
+	// Without If-Match, a patch matching no row means the row is gone.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow())
+	mock.ExpectQuery(`UPDATE users SET first_name`).WillReturnRows(sqlmock.NewRows([]string{"row_version"}))
+	mock.ExpectRollback()
+	if _, err := svc.PatchUser(ctx, "user-1", "", utils.MergePatchType, []byte(`{"firstName":"Alicia"}`)); !errors.As(err, &appErr) || appErr.Code != utils.ErrNotFound.Code {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	// With If-Match, the row changed since it was read.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow())
+	mock.ExpectQuery(`UPDATE users SET first_name`).WillReturnRows(sqlmock.NewRows([]string{"row_version"}))
+	mock.ExpectRollback()
+	if _, err := svc.PatchUser(ctx, "user-1", `"01"`, utils.MergePatchType, []byte(`{"firstName":"Alicia"}`)); !errors.As(err, &appErr) || appErr.Code != utils.ErrPrecondition.Code {
+		t.Fatalf("expected precondition failure, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestEditingDeletedUserIsNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	svc := NewUserService(db, repository.NewUserRepository(db), nil, nil, nil, nil, nil)
+	ctx := utils.WithUserID(context.Background(), "admin-1")
+	deletedRow := func() *sqlmock.Rows {
+		now := time.Now()
+		return sqlmock.NewRows(userColumns).AddRow("user-1", "alice", "alice@example.org", "hash", "Alice", "L", auth.RoleViewer, now, now, nil, entity.UserStatusDeleted, now, "admin-1", []byte{1})
+	}
+	var appErr *utils.AppError
+
+	// Nothing is written, with or without If-Match.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(deletedRow())
+	mock.ExpectRollback()
+	req := dto.UserUpdateRequest{Email: "alice@example.org", FirstName: "Alicia", LastName: "L", Role: auth.RoleViewer}
+	if _, err := svc.UpdateUser(ctx, "user-1", `"01"`, req); !errors.As(err, &appErr) || appErr.Code != utils.ErrNotFound.Code {
+		t.Fatalf("update: expected not found, got %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(deletedRow())
+	mock.ExpectRollback()
+	if _, err := svc.PatchUser(ctx, "user-1", "", utils.MergePatchType, []byte(`{"firstName":"Alicia"}`)); !errors.As(err, &appErr) || appErr.Code != utils.ErrNotFound.Code {
+		t.Fatalf("patch: expected not found, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	defer db.Close()
+
+	svc := NewUserService(db, repository.NewUserRepository(db), nil, nil, nil, nil, nil)
+	ctx := utils.WithRole(utils.WithUserID(context.Background(), "user-1"), auth.RoleOperator)
+	userRow := func() *sqlmock.Rows {
+		now := time.Now()
+		return sqlmock.NewRows(userColumns).AddRow("user-1", "alice", "alice@example.org", "hash", "Alice", "L", auth.RoleOperator, now, now, nil, entity.UserStatusActive, nil, nil, []byte{1})
+	}
+	var appErr *utils.AppError
+
+	// The role and permissions are those of the token.
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow())
+	profile, err := svc.GetProfile(ctx)
+	if err != nil || profile.Username != "alice" || profile.EffectiveRole != auth.RoleOperator || len(profile.Permissions) != len(auth.PermissionsForRole(auth.RoleOperator)) {
+		t.Fatalf("unexpected profile: %+v %v", profile, err)
+	}
+
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnError(sql.ErrNoRows)
+	if _, err := svc.GetProfile(ctx); !errors.As(err, &appErr) || appErr.Code != utils.ErrNotFound.Code {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	// Omitted fields keep their stored values.
+	firstName := "Alicia"
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow())
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE users SET email = @p1, first_name = @p2, last_name = @p3, role = @p4`)).
+		WithArgs("alice@example.org", "Alicia", "L", auth.RoleOperator, sqlmock.AnyArg(), "user-1", []byte{1}).
+		WillReturnRows(sqlmock.NewRows([]string{"row_version"}).AddRow([]byte{2}))
+	mock.ExpectCommit()
+	profile, err = svc.UpdateProfile(ctx, dto.ProfileUpdateRequest{FirstName: &firstName})
+	if err != nil || profile.FirstName != "Alicia" || profile.LastName != "L" || profile.Email != "alice@example.org" || profile.ETag != `"02"` {
+		t.Fatalf("unexpected profile: %+v %v", profile, err)
+	}
+
+	// A concurrent change between the read and the write is not overwritten.
+	email := "alicia@example.org"
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM users WHERE id = @p1`).WithArgs("user-1").WillReturnRows(userRow())
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE users SET email = @p1`)).
+		WithArgs(email, "Alice", "L", auth.RoleOperator, sqlmock.AnyArg(), "user-1", []byte{1}).
+		WillReturnRows(sqlmock.NewRows([]string{"row_version"}))
+	mock.ExpectRollback()
+	if _, err := svc.UpdateProfile(ctx, dto.ProfileUpdateRequest{Email: &email}); !errors.As(err, &appErr) || appErr.Code != utils.ErrPrecondition.Code {
+		t.Fatalf("expected precondition failure, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRoleChangeRevokesTokens(t *testing.T) {
+	db := openSQLite(t)
+	users := repository.NewUserRepository(db)
+	revocations := NewRevocationService(db, repository.NewRevocationRepository(db), repository.NewRefreshTokenRepository(db), time.Minute)
+	svc := NewUserService(db, users, repository.NewPasswordResetRepository(db), nil, nil, nil, revocations)
+	jwtManager, err := auth.NewJWTManager(config.AuthConfig{JWTSecret: "secret", Issuer: "test", Audience: "test", AccessTokenTTL: time.Minute, Algorithm: "HS256"})
+	if err != nil {
+		t.Fatalf("jwt manager: %v", err)
+	}
+	ctx := utils.WithUserID(context.Background(), "admin-1")
+
+	now := time.Now().UTC()
+	user := &entity.User{ID: "user-1", Username: "alice", Email: "alice@example.org", PasswordHash: "hash", Role: auth.RoleAdmin, CreatedAt: now, UpdatedAt: now, Status: entity.UserStatusActive}
+	if err := users.Create(ctx, user); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	issue := func(role string) *auth.Claims {
+		t.Helper()
+		generation, err := revocations.Generation(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("generation: %v", err)
+		}
+		token, _, err := jwtManager.Generate(auth.Identity{UserID: user.ID, Role: role, Generation: generation})
+		if err != nil {
+			t.Fatalf("generate: %v", err)
+		}
+		claims, err := jwtManager.Validate(token)
+		if err != nil {
+			t.Fatalf("validate: %v", err)
+		}
+		return claims
+	}
+	isRevoked := func(claims *auth.Claims) bool {
+		t.Helper()
+		revoked, err := revocations.IsRevoked(ctx, claims.ID, claims.Subject, claims.Generation, claims.IssuedAt.Time, claims.ExpiresAt.Time)
+		if err != nil {
+			t.Fatalf("is revoked: %v", err)
+		}
+		return revoked
+	}
+
+	// A profile edit keeps the tokens.
+	admin := issue(auth.RoleAdmin)
+	if _, err := svc.UpdateUser(ctx, user.ID, "", dto.UserUpdateRequest{Email: "alice@example.org", FirstName: "Alice", LastName: "Liddell", Role: auth.RoleAdmin}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if isRevoked(admin) {
+		t.Fatal("expected the token to survive an update that keeps the role")
+	}
+
+	// A demotion revokes the token carrying the admin role.
+	if _, err := svc.UpdateUser(ctx, user.ID, "", dto.UserUpdateRequest{Email: "alice@example.org", FirstName: "Alice", LastName: "Liddell", Role: auth.RoleViewer}); err != nil {
+		t.Fatalf("demote: %v", err)
+	}
+	if !isRevoked(admin) {
+		t.Fatal("expected the admin token to be revoked after the demotion")
+	}
+
+	// So does a role change by patch.
+	viewer := issue(auth.RoleViewer)
+	if _, err := svc.PatchUser(ctx, user.ID, "", utils.MergePatchType, []byte(`{"role":"operator"}`)); err != nil {
+		t.Fatalf("patch: %v", err)
+	}
+	if !isRevoked(viewer) {
+		t.Fatal("expected the viewer token to be revoked after the role patch")
+	}
+}
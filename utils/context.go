@@ -1,6 +1,9 @@
 package utils
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // ctxKey is a private type preventing key collisions.
 type ctxKey string
@@ -8,10 +11,24 @@ type ctxKey string
 const (
 	ctxKeyTraceID ctxKey = "trace_id"
 	ctxKeyUserID  ctxKey = "user_id"
+	ctxKeyRole    ctxKey = "role"
+	ctxKeyToken   ctxKey = "token"
+	ctxKeyMFA     ctxKey = "mfa_pending"
+	ctxKeyScopes  ctxKey = "scopes"
+	ctxKeyActor   ctxKey = "actor_id"
 	GinKeyTraceID        = "traceId"
 	GinKeyUserID         = "userId"
+	GinKeyRole           = "role"
+	GinKeyActorID        = "actorId"
 )
 
+// TokenInfo describes the access token that authenticated the request.
+type TokenInfo struct {
+	ID        string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
 // WithTraceID returns a new context carrying trace ID.
 func WithTraceID(ctx context.Context, traceID string) context.Context {
 	return context.WithValue(ctx, ctxKeyTraceID, traceID)
@@ -37,3 +54,69 @@ func UserIDFromContext(ctx context.Context) string {
 	}
 	return ""
 }
+
+// WithRole stores the authenticated caller's role.
+func WithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, ctxKeyRole, role)
+}
+
+// RoleFromContext fetches the caller's role.
+func RoleFromContext(ctx context.Context) string {
+	if v, ok := ctx.Value(ctxKeyRole).(string); ok {
+		return v
+	}
+	return ""
+}
+
+// WithTokenInfo stores metadata of the presented access token.
+func WithTokenInfo(ctx context.Context, info TokenInfo) context.Context {
+	return context.WithValue(ctx, ctxKeyToken, info)
+}
+
+// TokenInfoFromContext fetches access token metadata.
+func TokenInfoFromContext(ctx context.Context) (TokenInfo, bool) {
+	v, ok := ctx.Value(ctxKeyToken).(TokenInfo)
+	return v, ok
+}
+
+// WithMFAPending marks callers who must enrol in MFA before using protected endpoints.
+func WithMFAPending(ctx context.Context, pending bool) context.Context {
+	return context.WithValue(ctx, ctxKeyMFA, pending)
+}
+
+// MFAPendingFromContext reports whether the caller still has to enrol in MFA.
+func MFAPendingFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(ctxKeyMFA).(bool)
+	return v
+}
+
+// WithScopes stores the permissions granted to an API key caller.
+func WithScopes(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, ctxKeyScopes, scopes)
+}
+
+// ScopesFromContext fetches API key scopes. ok is false for user tokens.
+func ScopesFromContext(ctx context.Context) ([]string, bool) {
+	v, ok := ctx.Value(ctxKeyScopes).([]string)
+	return v, ok
+}
+
+// WithActorID stores the real caller of an impersonated request.
+func WithActorID(ctx context.Context, actorID string) context.Context {
+	return context.WithValue(ctx, ctxKeyActor, actorID)
+}
+
+// ActorIDFromContext returns the real caller: the impersonating admin for
+// impersonated requests, otherwise the authenticated user.
+func ActorIDFromContext(ctx context.Context) string {
+	if v, ok := ctx.Value(ctxKeyActor).(string); ok && v != "" {
+		return v
+	}
+	return UserIDFromContext(ctx)
+}
+
+// IsImpersonating reports whether the request runs on behalf of another user.
+func IsImpersonating(ctx context.Context) bool {
+	v, _ := ctx.Value(ctxKeyActor).(string)
+	return v != ""
+}
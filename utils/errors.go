@@ -42,8 +42,19 @@ var (
 	ErrBadRequest   = NewAppError(http.StatusBadRequest, 400001, "Bad Request", nil)
 	ErrUnauthorized = NewAppError(http.StatusUnauthorized, 401001, "Unauthorized", nil)
 	ErrForbidden    = NewAppError(http.StatusForbidden, 403001, "Forbidden", nil)
+	ErrMFARequired  = NewAppError(http.StatusForbidden, 403002, "MFA Enrollment Required", nil)
+	ErrImpersonated = NewAppError(http.StatusForbidden, 403003, "Not Allowed While Impersonating", nil)
+	ErrDisabled     = NewAppError(http.StatusForbidden, 403004, "Account Disabled", nil)
 	ErrNotFound     = NewAppError(http.StatusNotFound, 404001, "Resource Not Found", nil)
+	ErrConflict     = NewAppError(http.StatusConflict, 409001, "Conflict", nil)
+	ErrPrecondition = NewAppError(http.StatusPreconditionFailed, 412001, "Precondition Failed", nil)
+	ErrMediaType    = NewAppError(http.StatusUnsupportedMediaType, 415001, "Unsupported Media Type", nil)
+	ErrLocked       = NewAppError(http.StatusLocked, 423001, "Account Locked", nil)
+	ErrIfMatch      = NewAppError(http.StatusPreconditionRequired, 428001, "Precondition Required", nil)
+	ErrTooMany      = NewAppError(http.StatusTooManyRequests, 429001, "Too Many Requests", nil)
+	ErrTooManyLogin = NewAppError(http.StatusTooManyRequests, 429002, "Too Many Login Attempts", nil)
 	ErrInternal     = NewAppError(http.StatusInternalServerError, 500001, "Internal Server Error", nil)
+	ErrUnavailable  = NewAppError(http.StatusServiceUnavailable, 503001, "Service Unavailable", nil)
 )
 
 // Clone clones predefined errors while overriding details.
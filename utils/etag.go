@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// ETag renders a row version as a strong entity tag.
+func ETag(version []byte) string {
+	return `"` + hex.EncodeToString(version) + `"`
+}
+
+// ETagMatches reports whether an If-Match or If-None-Match header value is
+// "*" or lists etag. If-Match requires strong comparison, so weak ("W/")
+// candidates only match when weak is set, as If-None-Match allows.
+func ETagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
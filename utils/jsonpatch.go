@@ -0,0 +1,322 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Patch document media types accepted by PATCH endpoints.
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// ErrInvalidPatch reports a malformed patch document or one that cannot be
+// applied to the target document.
+var ErrInvalidPatch = errors.New("invalid patch")
+
+// PatchOperation is one RFC 6902 JSON Patch operation. Value is nil when the
+// member is absent, as opposed to a JSON null.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ApplyMergePatch applies an RFC 7396 JSON Merge Patch to doc: object
+// members of the patch are merged recursively, null members are removed and
+// any other value replaces the target outright.
+func ApplyMergePatch(doc, patch []byte) ([]byte, error) {
+	var target, p any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return json.Marshal(mergePatch(target, p))
+}
+
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+			continue
+		}
+		t[key] = mergePatch(t[key], value)
+	}
+	return t
+}
+
+// ApplyJSONPatch applies an RFC 6902 JSON Patch to doc. Operations are
+// applied in order and the patch fails as a whole if any of them fails,
+// including a failed "test".
+func ApplyJSONPatch(doc, patch []byte) ([]byte, error) {
+	var ops []PatchOperation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	var target any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	for i, op := range ops {
+		var err error
+		if target, err = applyOperation(target, op); err != nil {
+			return nil, fmt.Errorf("%w: operation %d (%s %s): %v", ErrInvalidPatch, i, op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(target)
+}
+
+func applyOperation(doc any, op PatchOperation) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, value)
+	case "remove":
+		doc, _, err := removeValue(doc, path)
+		return doc, err
+	case "replace":
+		value, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := getValue(doc, path); err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		return updateParent(doc, path, func(parent any, key string) (any, error) {
+			return setChild(parent, key, value)
+		})
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if len(path) > len(from) && reflect.DeepEqual(path[:len(from)], from) {
+				return nil, errors.New("cannot move a value into itself")
+			}
+			doc, value, err := removeValue(doc, from)
+			if err != nil {
+				return nil, err
+			}
+			return addValue(doc, path, value)
+		}
+		value, err := getValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, deepCopy(value))
+	case "test":
+		want, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		got, err := getValue(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(got, want) {
+			return nil, errors.New("test failed")
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("unsupported op %q", op.Op)
+	}
+}
+
+func (op PatchOperation) value() (any, error) {
+	if op.Value == nil {
+		return nil, errors.New("missing value")
+	}
+	var value any
+	err := json.Unmarshal(op.Value, &value)
+	return value, err
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped reference
+// tokens. The empty pointer refers to the whole document.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func getValue(doc any, path []string) (any, error) {
+	for _, key := range path {
+		var err error
+		if doc, err = getChild(doc, key); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func addValue(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return updateParent(doc, path, func(parent any, key string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			node[key] = value
+			return node, nil
+		case []any:
+			i := len(node)
+			if key != "-" {
+				var err error
+				if i, err = arrayIndex(key, len(node)); err != nil {
+					return nil, err
+				}
+			}
+			return append(node[:i], append([]any{value}, node[i:]...)...), nil
+		default:
+			return nil, fmt.Errorf("path %q not found", key)
+		}
+	})
+}
+
+func removeValue(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, errors.New("cannot remove the whole document")
+	}
+	var removed any
+	doc, err := updateParent(doc, path, func(parent any, key string) (any, error) {
+		var err error
+		if removed, err = getChild(parent, key); err != nil {
+			return nil, err
+		}
+		if node, ok := parent.(map[string]any); ok {
+			delete(node, key)
+			return node, nil
+		}
+		// getChild succeeded, so parent is an array and key a valid index.
+		node := parent.([]any)
+		i, _ := arrayIndex(key, len(node)-1)
+		return append(node[:i], node[i+1:]...), nil
+	})
+	return doc, removed, err
+}
+
+// updateParent descends to the container holding the last token of path and
+// replaces it with the result of apply, rebuilding the containers above it
+// since array updates may reallocate.
+func updateParent(doc any, path []string, apply func(parent any, key string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return apply(doc, path[0])
+	}
+	child, err := getChild(doc, path[0])
+	if err != nil {
+		return nil, err
+	}
+	if child, err = updateParent(child, path[1:], apply); err != nil {
+		return nil, err
+	}
+	return setChild(doc, path[0], child)
+}
+
+func getChild(doc any, key string) (any, error) {
+	switch node := doc.(type) {
+	case map[string]any:
+		child, ok := node[key]
+		if !ok {
+			return nil, fmt.Errorf("path %q not found", key)
+		}
+		return child, nil
+	case []any:
+		i, err := arrayIndex(key, len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+		return node[i], nil
+	default:
+		return nil, fmt.Errorf("path %q not found", key)
+	}
+}
+
+func setChild(doc any, key string, value any) (any, error) {
+	switch node := doc.(type) {
+	case map[string]any:
+		if _, ok := node[key]; !ok {
+			return nil, fmt.Errorf("path %q not found", key)
+		}
+		node[key] = value
+		return node, nil
+	case []any:
+		i, err := arrayIndex(key, len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = value
+		return node, nil
+	default:
+		return nil, fmt.Errorf("path %q not found", key)
+	}
+}
+
+// arrayIndex parses an array reference token, which must be a decimal
+// number without leading zeros no greater than max.
+func arrayIndex(key string, max int) (int, error) {
+	if key == "" || strings.Trim(key, "0123456789") != "" || (len(key) > 1 && key[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", key)
+	}
+	i, err := strconv.Atoi(key)
+	if err != nil {
+		return 0, fmt.Errorf("invalid array index %q", key)
+	}
+	if i > max {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+func deepCopy(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, child := range v {
+			out[key] = deepCopy(child)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, child := range v {
+			out[i] = deepCopy(child)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SortField is one key of a ?sort= parameter.
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// ParseSort parses a comma-separated sort parameter such as
+// "-createdAt,username": a leading "-" sorts descending, an optional "+"
+// ascending. Fields must belong to allowed and may appear once.
+func ParseSort(raw string, allowed []string) ([]SortField, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+
+	known := make(map[string]bool, len(allowed))
+	for _, field := range allowed {
+		known[field] = true
+	}
+
+	parts := strings.Split(raw, ",")
+	fields := make([]SortField, 0, len(parts))
+	seen := make(map[string]bool, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		f := SortField{Field: part}
+		switch {
+		case strings.HasPrefix(part, "-"):
+			f = SortField{Field: part[1:], Desc: true}
+		case strings.HasPrefix(part, "+"):
+			f.Field = part[1:]
+		}
+		if !known[f.Field] {
+			return nil, fmt.Errorf("unknown sort field %q, allowed: %s", f.Field, strings.Join(allowed, ", "))
+		}
+		if seen[f.Field] {
+			return nil, fmt.Errorf("duplicate sort field %q", f.Field)
+		}
+		seen[f.Field] = true
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// FormatSort renders sort fields in the ParseSort syntax.
+func FormatSort(fields []SortField) string {
+	parts := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f.Desc {
+			parts = append(parts, "-"+f.Field)
+			continue
+		}
+		parts = append(parts, f.Field)
+	}
+	return strings.Join(parts, ",")
+}
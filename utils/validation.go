@@ -0,0 +1,24 @@
+package utils
+
+import "github.com/go-playground/validator/v10"
+
+// ValidationDetails builds a readable field-to-message map from validator
+// errors, or a single "error" entry for any other error.
+func ValidationDetails(err error) map[string]string {
+	if err == nil {
+		return nil
+	}
+
+	details := make(map[string]string)
+	switch verr := err.(type) {
+	case validator.ValidationErrors:
+		for _, fe := range verr {
+			field := fe.Field()
+			details[field] = fe.Error()
+		}
+	default:
+		details["error"] = err.Error()
+	}
+
+	return details
+}